@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/golang/glog"
+)
+
+// BackfillProgressDir returns the consumer progress directory of a handler backfilling from the given height.
+//
+// The consumer replays STATE_CHANGE_DIR from the start when its progress directory is empty, and then follows the
+// file as new state changes are appended to it, so backfill and live consumption are one pass over the same
+// stream. Giving each backfill height its own progress directory starts that pass for a new downstream without
+// touching the progress of the existing one, and a restart resumes from the saved progress instead of replaying
+// the backfill again.
+func BackfillProgressDir(consumerProgressDir string, backfillFromHeight uint64) string {
+	return filepath.Join(consumerProgressDir, fmt.Sprintf("backfill-%d", backfillFromHeight))
+}
+
+// filterBackfillEntries returns the entries at or above BackfillFromHeight. Unlike MinBlockHeight, which skips
+// whole batches by the height of their first entry, entries are filtered one by one, so a batch that spans the
+// backfill height is neither skipped nor sent in full: every entry at or above it is sent exactly once, and no
+// entry below it is sent.
+func (wh *WebHandler) filterBackfillEntries(batchedEntries []*lib.StateChangeEntry) []*lib.StateChangeEntry {
+	if wh.BackfillFromHeight == 0 {
+		return batchedEntries
+	}
+
+	var backfillEntries []*lib.StateChangeEntry
+	for _, entry := range batchedEntries {
+		if entry.BlockHeight >= wh.BackfillFromHeight {
+			backfillEntries = append(backfillEntries, entry)
+		}
+	}
+	if len(backfillEntries) > 0 && !wh.backfillStarted.Swap(true) {
+		glog.Infof("WebHandler.filterBackfillEntries: reached backfill height %d, sending entries from height %d",
+			wh.BackfillFromHeight, backfillEntries[0].BlockHeight)
+	}
+	return backfillEntries
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/deso-protocol/core/lib"
+)
+
+// newTestHeightBatch returns a batch with one entry at each of the heights.
+func newTestHeightBatch(heights ...uint64) []*lib.StateChangeEntry {
+	var batch []*lib.StateChangeEntry
+	for _, height := range heights {
+		batch = append(batch, newTestEntries(1, height)...)
+	}
+	return batch
+}
+
+func TestBackfillHandoff(t *testing.T) {
+	tests := []struct {
+		name               string
+		backfillFromHeight uint64
+		batches            [][]uint64
+		wantHeights        [][]uint64
+	}{
+		{
+			name:        "disabled",
+			batches:     [][]uint64{{1, 2}, {3}},
+			wantHeights: [][]uint64{{1, 2}, {3}},
+		},
+		{
+			name:               "height inside a batch",
+			backfillFromHeight: 5,
+			batches:            [][]uint64{{1, 2, 3}, {4, 5, 6}, {7, 8}},
+			wantHeights:        [][]uint64{{5, 6}, {7, 8}},
+		},
+		{
+			name:               "height at the start of a batch",
+			backfillFromHeight: 4,
+			batches:            [][]uint64{{1, 2, 3}, {4, 5, 6}},
+			wantHeights:        [][]uint64{{4, 5, 6}},
+		},
+		{
+			name:               "height at the end of a batch",
+			backfillFromHeight: 3,
+			batches:            [][]uint64{{1, 2, 3}, {4, 5}},
+			wantHeights:        [][]uint64{{3}, {4, 5}},
+		},
+		{
+			name:               "several entries at the height",
+			backfillFromHeight: 2,
+			batches:            [][]uint64{{1, 1, 2}, {2, 2, 3}},
+			wantHeights:        [][]uint64{{2}, {2, 2, 3}},
+		},
+		{
+			name:               "height not reached",
+			backfillFromHeight: 10,
+			batches:            [][]uint64{{1, 2}, {3}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newRecordingServer(t)
+			wh := NewWebHandler(server.URL, false, "", 0)
+			wh.MaxDeliveryAttempts = 1
+			wh.BackfillFromHeight = tt.backfillFromHeight
+
+			for _, heights := range tt.batches {
+				if err := wh.HandleEntryBatch(newTestHeightBatch(heights...)); err != nil {
+					t.Fatalf("HandleEntryBatch(%v): %v", heights, err)
+				}
+			}
+			// Every entry at or above the backfill height is sent once, in order, and none below it.
+			if heights := server.batchHeights(); !reflect.DeepEqual(heights, tt.wantHeights) {
+				t.Errorf("server received %v, want %v", heights, tt.wantHeights)
+			}
+			if started := wh.backfillStarted.Load(); started != (tt.backfillFromHeight > 0 && tt.wantHeights != nil) {
+				t.Errorf("backfillStarted = %v", started)
+			}
+		})
+	}
+}
+
+func TestBackfillProgressDir(t *testing.T) {
+	if dir := BackfillProgressDir("/progress", 100); dir != "/progress/backfill-100" {
+		t.Errorf("BackfillProgressDir = %q, want /progress/backfill-100", dir)
+	}
+	if BackfillProgressDir("/progress", 100) == BackfillProgressDir("/progress", 200) {
+		t.Error("expected every backfill height to get its own progress directory")
+	}
+}
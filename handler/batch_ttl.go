@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"os"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// batchExpired returns whether a batch enqueued at enqueuedAt is older than BatchTTL.
+func (wh *WebHandler) batchExpired(enqueuedAt time.Time) bool {
+	return wh.BatchTTL > 0 && time.Since(enqueuedAt) > wh.BatchTTL
+}
+
+// dropExpiredPausedBatches drops the paused batches that are older than BatchTTL. The caller must hold pause.mtx.
+func (wh *WebHandler) dropExpiredPausedBatches() {
+	if wh.BatchTTL <= 0 {
+		return
+	}
+	var keptBatches []pausedBatch
+	var expiredCount int
+	for _, batch := range wh.pause.bufferedBatches {
+		if !wh.batchExpired(batch.enqueuedAt) {
+			keptBatches = append(keptBatches, batch)
+			continue
+		}
+		if batch.spillPath != "" {
+			if err := os.Remove(batch.spillPath); err != nil {
+				glog.Errorf("WebHandler.dropExpiredPausedBatches: failed to remove spill file: %v", err)
+			}
+		}
+		wh.metrics.memoryBytes.Add(-batch.memorySize)
+		expiredCount++
+	}
+	if expiredCount == 0 {
+		return
+	}
+	wh.pause.bufferedBatches = keptBatches
+	wh.metrics.batchesExpired.Add(uint64(expiredCount))
+	glog.Errorf("WebHandler.dropExpiredPausedBatches: dropped %d paused batches older than %v", expiredCount,
+		wh.BatchTTL)
+}
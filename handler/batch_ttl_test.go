@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBatchTTLPausedBatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		batchTTL time.Duration
+		// spill spills every paused batch to disk.
+		spill       bool
+		wantHeights [][]uint64
+		wantExpired uint64
+	}{
+		{name: "disabled", wantHeights: [][]uint64{{1}, {2}, {3}}},
+		{name: "in memory", batchTTL: 100 * time.Millisecond, wantHeights: [][]uint64{{3}}, wantExpired: 2},
+		{name: "spilled", batchTTL: 100 * time.Millisecond, spill: true, wantHeights: [][]uint64{{3}},
+			wantExpired: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newRecordingServer(t)
+			wh := NewWebHandler(server.URL, false, "", 0)
+			wh.MaxDeliveryAttempts = 1
+			wh.PauseMode = PauseModeBuffer
+			wh.BatchTTL = tt.batchTTL
+			if tt.spill {
+				wh.MaxMemoryBytes = 1
+				wh.MemoryShedPolicy = MemoryPolicySpill
+				wh.SpillDir = t.TempDir()
+			}
+			wh.Pause()
+
+			for height := uint64(1); height <= 2; height++ {
+				if err := wh.HandleEntryBatch(newTestEntries(1, height)); err != nil {
+					t.Fatalf("HandleEntryBatch(%d): %v", height, err)
+				}
+			}
+			time.Sleep(200 * time.Millisecond)
+			if err := wh.HandleEntryBatch(newTestEntries(1, 3)); err != nil {
+				t.Fatalf("HandleEntryBatch(3): %v", err)
+			}
+			if err := wh.Resume(); err != nil {
+				t.Fatalf("Resume: %v", err)
+			}
+
+			// The expired batches are dropped, and the fresh one survives.
+			if heights := server.batchHeights(); !reflect.DeepEqual(heights, tt.wantHeights) {
+				t.Errorf("server received %v, want %v", heights, tt.wantHeights)
+			}
+			if expired := wh.metrics.batchesExpired.Load(); expired != tt.wantExpired {
+				t.Errorf("batchesExpired = %d, want %d", expired, tt.wantExpired)
+			}
+			if memoryBytes := wh.metrics.memoryBytes.Load(); memoryBytes != 0 {
+				t.Errorf("memoryBytes = %d, want 0", memoryBytes)
+			}
+			if tt.spill {
+				if spillFiles, _ := os.ReadDir(wh.SpillDir); len(spillFiles) != 0 {
+					t.Errorf("%d spill files were left after Resume", len(spillFiles))
+				}
+			}
+		})
+	}
+}
+
+func TestBatchTTLFanOut(t *testing.T) {
+	var requests atomic.Int64
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		// Hold the first batch in flight until released.
+		if requests.Add(1) == 1 {
+			<-release
+		}
+	}))
+	t.Cleanup(server.Close)
+	wh := NewWebHandler("", false, "", 0)
+	wh.FanOutURLs = []string{server.URL}
+	wh.MaxDeliveryAttempts = 1
+	wh.BatchTTL = 100 * time.Millisecond
+
+	if err := wh.HandleEntryBatch(newTestEntries(1, 1)); err != nil {
+		t.Fatalf("HandleEntryBatch(1): %v", err)
+	}
+	waitFor(t, "the first batch to be in flight", func() bool { return requests.Load() == 1 })
+	// The next two batches wait in the queue until they expire, and the last is queued just before the release.
+	for height := uint64(2); height <= 3; height++ {
+		if err := wh.HandleEntryBatch(newTestEntries(1, height)); err != nil {
+			t.Fatalf("HandleEntryBatch(%d): %v", height, err)
+		}
+	}
+	time.Sleep(200 * time.Millisecond)
+	if err := wh.HandleEntryBatch(newTestEntries(1, 4)); err != nil {
+		t.Fatalf("HandleEntryBatch(4): %v", err)
+	}
+	close(release)
+
+	waitFor(t, "the fresh batch to be sent", func() bool { return wh.fanOutStatus()[0].BatchesSent == 2 })
+	if requests := requests.Load(); requests != 2 {
+		t.Errorf("subscriber received %d batches, want the first and the fresh one", requests)
+	}
+	if expired := wh.metrics.batchesExpired.Load(); expired != 2 {
+		t.Errorf("batchesExpired = %d, want 2", expired)
+	}
+}
@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// States of the circuit breaker of HTTP POSTs to EndpointURL.
+const (
+	// CircuitClosed lets every batch through.
+	CircuitClosed = "closed"
+	// CircuitOpen fails every batch with ErrCircuitOpen without sending it, until OpenDuration has passed.
+	CircuitOpen = "open"
+	// CircuitHalfOpen lets a single batch through to probe the endpoint, and fails the others with ErrCircuitOpen
+	// until it is known whether the probe was sent.
+	CircuitHalfOpen = "half_open"
+)
+
+// DefaultCircuitOpenDuration is how long the circuit stays open when no OpenDuration is configured.
+const DefaultCircuitOpenDuration = 30 * time.Second
+
+// ErrCircuitOpen is wrapped by the error of batches that aren't sent because the circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// circuitBreakerState tracks the consecutive failures of the HTTP POSTs to EndpointURL.
+type circuitBreakerState struct {
+	mtx   sync.Mutex
+	state string
+	// consecutiveFailures is the number of batches that failed with a retryable error since the last one that was
+	// sent.
+	consecutiveFailures int
+	// openedAt is when the circuit last opened.
+	openedAt time.Time
+	// now returns the current time. It is time.Now unless replaced to control the breaker's clock.
+	now func() time.Time
+}
+
+// circuitOpenDuration returns the configured open duration, defaulting to DefaultCircuitOpenDuration.
+func (wh *WebHandler) circuitOpenDuration() time.Duration {
+	if wh.OpenDuration <= 0 {
+		return DefaultCircuitOpenDuration
+	}
+	return wh.OpenDuration
+}
+
+// pushBatchThroughCircuitBreaker sends the batch with pushBatchToEndpoint unless the circuit is open.
+//
+// The circuit opens once FailureThreshold batches in a row have failed with a retryable error, i.e. a timeout,
+// a connection error or a 5xx or 429 status, after their retries. Other errors, such as a 400 status, show that the
+// endpoint is up, so they don't count. While the circuit is open, batches fail with ErrCircuitOpen right away. Once
+// OpenDuration has passed, the circuit half-opens and the next batch is sent as a probe: the circuit closes if
+// it is sent, and opens again for another OpenDuration if it fails.
+func (wh *WebHandler) pushBatchThroughCircuitBreaker(batchedEntries []*lib.StateChangeEntry) error {
+	if !wh.circuitBreaker.allow(wh.circuitOpenDuration()) {
+		return errors.Wrapf(ErrCircuitOpen, "WebHandler.pushBatchThroughCircuitBreaker: not sending batch to %s",
+			wh.EndpointURL)
+	}
+
+	err := wh.pushBatchToEndpoint(batchedEntries)
+	previousState, state := wh.circuitBreaker.record(err, wh.FailureThreshold)
+	if state != previousState {
+		if state == CircuitOpen {
+			wh.metrics.circuitOpened.Add(1)
+			glog.Errorf("WebHandler.pushBatchThroughCircuitBreaker: circuit to %s opened for %s: %v", wh.EndpointURL,
+				wh.circuitOpenDuration(), err)
+		} else {
+			glog.Infof("WebHandler.pushBatchThroughCircuitBreaker: circuit to %s closed", wh.EndpointURL)
+		}
+	}
+	return err
+}
+
+// getNow returns the breaker's clock.
+func (breaker *circuitBreakerState) getNow() time.Time {
+	if breaker.now == nil {
+		return time.Now()
+	}
+	return breaker.now()
+}
+
+// allow returns whether a batch may be sent, half-opening the circuit once it has been open for openDuration.
+func (breaker *circuitBreakerState) allow(openDuration time.Duration) bool {
+	breaker.mtx.Lock()
+	defer breaker.mtx.Unlock()
+
+	switch breaker.state {
+	case CircuitOpen:
+		if breaker.getNow().Sub(breaker.openedAt) < openDuration {
+			return false
+		}
+		breaker.state = CircuitHalfOpen
+		return true
+	case CircuitHalfOpen:
+		// A probe is already in flight.
+		return false
+	default:
+		return true
+	}
+}
+
+// record updates the breaker with the outcome of a batch that was let through, and returns its state before and
+// after.
+func (breaker *circuitBreakerState) record(err error, failureThreshold int) (string, string) {
+	breaker.mtx.Lock()
+	defer breaker.mtx.Unlock()
+
+	previousState := breaker.getState()
+	if err == nil || !isRetryableError(err) {
+		breaker.consecutiveFailures = 0
+		breaker.state = CircuitClosed
+		return previousState, breaker.state
+	}
+
+	breaker.consecutiveFailures++
+	if previousState == CircuitHalfOpen || breaker.consecutiveFailures >= failureThreshold {
+		breaker.state = CircuitOpen
+		breaker.openedAt = breaker.getNow()
+	}
+	return previousState, breaker.getState()
+}
+
+// getState returns the state of the breaker. The caller must hold mtx.
+func (breaker *circuitBreakerState) getState() string {
+	if breaker.state == "" {
+		return CircuitClosed
+	}
+	return breaker.state
+}
+
+// currentState returns the state of the breaker.
+func (breaker *circuitBreakerState) currentState() string {
+	breaker.mtx.Lock()
+	defer breaker.mtx.Unlock()
+	return breaker.getState()
+}
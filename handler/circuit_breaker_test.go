@@ -0,0 +1,95 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	server := newRecordingServer(t)
+	wh := NewWebHandler(server.URL, false, "", 0)
+	wh.FailureThreshold = 2
+	wh.OpenDuration = time.Minute
+	now := time.Unix(1700000000, 0)
+	wh.circuitBreaker.now = func() time.Time { return now }
+
+	// Every step sends a batch after advancing the clock, with the endpoint answering statusCode.
+	steps := []struct {
+		name       string
+		advance    time.Duration
+		statusCode int
+		// wantSent is whether the batch reaches the endpoint, and wantErr whether it fails.
+		wantSent  bool
+		wantErr   bool
+		wantState string
+	}{
+		{name: "first failure", statusCode: http.StatusServiceUnavailable, wantSent: true, wantErr: true,
+			wantState: CircuitClosed},
+		{name: "threshold reached", statusCode: http.StatusServiceUnavailable, wantSent: true, wantErr: true,
+			wantState: CircuitOpen},
+		{name: "open", statusCode: http.StatusOK, wantErr: true, wantState: CircuitOpen},
+		{name: "still open", advance: 59 * time.Second, statusCode: http.StatusOK, wantErr: true,
+			wantState: CircuitOpen},
+		{name: "failed probe", advance: time.Second, statusCode: http.StatusBadGateway, wantSent: true, wantErr: true,
+			wantState: CircuitOpen},
+		{name: "reopened", advance: 30 * time.Second, statusCode: http.StatusOK, wantErr: true, wantState: CircuitOpen},
+		{name: "probe", advance: 30 * time.Second, statusCode: http.StatusOK, wantSent: true, wantState: CircuitClosed},
+		{name: "closed", statusCode: http.StatusOK, wantSent: true, wantState: CircuitClosed},
+		{name: "client errors don't count", statusCode: http.StatusBadRequest, wantSent: true, wantErr: true,
+			wantState: CircuitClosed},
+		{name: "nor twice", statusCode: http.StatusBadRequest, wantSent: true, wantErr: true,
+			wantState: CircuitClosed},
+	}
+	for ii, step := range steps {
+		now = now.Add(step.advance)
+		server.statusCode.Store(int64(step.statusCode))
+		requests := server.requests.Load()
+
+		err := wh.pushBatchThroughCircuitBreaker(newTestEntries(1, uint64(ii)))
+		if (err != nil) != step.wantErr {
+			t.Errorf("%s: err = %v, wantErr %v", step.name, err, step.wantErr)
+		}
+		if sent := server.requests.Load() > requests; sent != step.wantSent {
+			t.Errorf("%s: sent = %v, want %v", step.name, sent, step.wantSent)
+		}
+		if isOpenErr := errors.Is(err, ErrCircuitOpen); isOpenErr == step.wantSent {
+			t.Errorf("%s: ErrCircuitOpen = %v for a batch that was sent = %v", step.name, isOpenErr, step.wantSent)
+		}
+		if state := wh.circuitBreaker.currentState(); state != step.wantState {
+			t.Errorf("%s: state = %s, want %s", step.name, state, step.wantState)
+		}
+	}
+	if opened := wh.metrics.circuitOpened.Load(); opened != 2 {
+		t.Errorf("circuitOpened = %d, want 2", opened)
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	breaker := &circuitBreakerState{now: func() time.Time { return now }}
+	retryableErr := &HTTPStatusError{StatusCode: http.StatusServiceUnavailable}
+
+	if _, state := breaker.record(retryableErr, 1); state != CircuitOpen {
+		t.Fatalf("state = %s after reaching the threshold, want open", state)
+	}
+	now = now.Add(time.Minute)
+	if !breaker.allow(time.Minute) {
+		t.Fatal("expected a probe once the open duration has passed")
+	}
+	if state := breaker.currentState(); state != CircuitHalfOpen {
+		t.Fatalf("state = %s during the probe, want half_open", state)
+	}
+	// Only one probe is in flight at a time.
+	if breaker.allow(time.Minute) {
+		t.Error("expected batches to be held back while the probe is in flight")
+	}
+	if previousState, state := breaker.record(nil, 1); previousState != CircuitHalfOpen || state != CircuitClosed {
+		t.Errorf("probe moved the breaker from %s to %s, want half_open to closed", previousState, state)
+	}
+	if !breaker.allow(time.Minute) {
+		t.Error("expected a closed breaker to let batches through")
+	}
+}
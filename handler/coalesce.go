@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/golang/glog"
+)
+
+// coalesceState holds the latest pending update of each key while CoalesceWindow is set.
+type coalesceState struct {
+	mtx sync.Mutex
+	// pendingEntries maps each key with a pending update to its latest entry.
+	pendingEntries map[string]*lib.StateChangeEntry
+	// pendingKeys are the keys of pendingEntries in the order they were first updated.
+	pendingKeys []string
+	// flusherOnce starts the flusher on first use.
+	flusherOnce sync.Once
+}
+
+// coalesceEntries holds the batch's updates until the next flush, replacing any pending update of the same key, and
+// returns the deletes, which must be sent immediately. A delete cancels the pending update of its key. If the
+// pending updates exceed MaxMemoryBytes, the oldest are dropped under MemoryPolicyDropOldest, and otherwise they are
+// all flushed right away.
+func (wh *WebHandler) coalesceEntries(batchedEntries []*lib.StateChangeEntry) []*lib.StateChangeEntry {
+	wh.coalesce.flusherOnce.Do(func() {
+		go wh.runCoalesceFlusher()
+	})
+
+	deletes, flushNow := wh.holdCoalescedEntries(batchedEntries)
+	if flushNow {
+		wh.flushCoalescedEntries()
+	}
+	return deletes
+}
+
+// holdCoalescedEntries adds the batch's updates to the pending updates and returns the batch's deletes, and whether
+// the pending updates must be flushed to stay within the memory budget.
+func (wh *WebHandler) holdCoalescedEntries(batchedEntries []*lib.StateChangeEntry) (
+	deletes []*lib.StateChangeEntry, flushNow bool) {
+
+	wh.coalesce.mtx.Lock()
+	defer wh.coalesce.mtx.Unlock()
+	if wh.coalesce.pendingEntries == nil {
+		wh.coalesce.pendingEntries = make(map[string]*lib.StateChangeEntry)
+	}
+
+	for _, entry := range batchedEntries {
+		key := string(entry.KeyBytes)
+		pendingEntry, exists := wh.coalesce.pendingEntries[key]
+		if exists {
+			wh.metrics.memoryBytes.Add(-entryMemorySize(pendingEntry))
+		}
+		if entry.OperationType == lib.DbOperationTypeDelete {
+			delete(wh.coalesce.pendingEntries, key)
+			deletes = append(deletes, entry)
+			continue
+		}
+		if !exists {
+			wh.coalesce.pendingKeys = append(wh.coalesce.pendingKeys, key)
+		}
+		wh.coalesce.pendingEntries[key] = entry
+		wh.metrics.memoryBytes.Add(entryMemorySize(entry))
+	}
+
+	if !wh.memoryBudgetExceeded(0) {
+		return deletes, false
+	}
+	if wh.memoryShedPolicy() != MemoryPolicyDropOldest {
+		return deletes, true
+	}
+	// Drop the updates of the keys that were first updated the longest ago.
+	var shedCount int
+	for _, key := range wh.coalesce.pendingKeys {
+		if !wh.memoryBudgetExceeded(0) {
+			break
+		}
+		if pendingEntry, exists := wh.coalesce.pendingEntries[key]; exists {
+			wh.metrics.memoryBytes.Add(-entryMemorySize(pendingEntry))
+			delete(wh.coalesce.pendingEntries, key)
+			shedCount++
+		}
+	}
+	wh.metrics.entriesShed.Add(uint64(shedCount))
+	glog.Errorf("WebHandler.holdCoalescedEntries: dropped %d coalesced updates to stay within the memory budget",
+		shedCount)
+	return deletes, false
+}
+
+// takeCoalescedEntries removes and returns the pending updates, in the order their keys were first updated.
+func (wh *WebHandler) takeCoalescedEntries() []*lib.StateChangeEntry {
+	wh.coalesce.mtx.Lock()
+	defer wh.coalesce.mtx.Unlock()
+
+	var entries []*lib.StateChangeEntry
+	for _, key := range wh.coalesce.pendingKeys {
+		// Keys whose update was cancelled by a delete are no longer pending, and keys updated again after a delete
+		// are listed twice.
+		if entry, exists := wh.coalesce.pendingEntries[key]; exists {
+			entries = append(entries, entry)
+			delete(wh.coalesce.pendingEntries, key)
+			wh.metrics.memoryBytes.Add(-entryMemorySize(entry))
+		}
+	}
+	wh.coalesce.pendingEntries = nil
+	wh.coalesce.pendingKeys = nil
+	return entries
+}
+
+// runCoalesceFlusher sends the pending updates once every CoalesceWindow, so that each key is sent at most once
+// per window.
+func (wh *WebHandler) runCoalesceFlusher() {
+	ticker := time.NewTicker(wh.CoalesceWindow)
+	defer ticker.Stop()
+	for range ticker.C {
+		wh.flushCoalescedEntries()
+	}
+}
+
+// flushCoalescedEntries sends the pending updates as a single batch. The consumer has already moved past them, so
+// updates that can't be delivered are logged and dropped.
+func (wh *WebHandler) flushCoalescedEntries() {
+	entries := wh.takeCoalescedEntries()
+	if len(entries) == 0 {
+		return
+	}
+	if err := wh.forwardBatch(entries); err != nil {
+		glog.Errorf("WebHandler.flushCoalescedEntries: dropping %d coalesced entries: %v", len(entries), err)
+	}
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+)
+
+// testKeyedEntry returns an upsert of the key at the block height, which tells its updates apart.
+func testKeyedEntry(key string, blockHeight uint64) *lib.StateChangeEntry {
+	entry := newTestEntries(1, blockHeight)[0]
+	entry.KeyBytes = []byte(key)
+	return entry
+}
+
+// testKeyedDelete returns a delete of the key at the block height.
+func testKeyedDelete(key string, blockHeight uint64) *lib.StateChangeEntry {
+	entry := testKeyedEntry(key, blockHeight)
+	entry.OperationType = lib.DbOperationTypeDelete
+	return entry
+}
+
+func TestCoalesceEntries(t *testing.T) {
+	tests := []struct {
+		name    string
+		batches [][]*lib.StateChangeEntry
+		// wantImmediate are the heights sent before the flush, and wantFlushed the heights sent by the flush.
+		wantImmediate [][]uint64
+		wantFlushed   []uint64
+	}{
+		{
+			name: "updates collapse to the last value",
+			batches: [][]*lib.StateChangeEntry{
+				{testKeyedEntry("a", 1)}, {testKeyedEntry("a", 2)}, {testKeyedEntry("a", 3)},
+			},
+			wantFlushed: []uint64{3},
+		},
+		{
+			name: "updates in one batch",
+			batches: [][]*lib.StateChangeEntry{
+				{testKeyedEntry("a", 1), testKeyedEntry("a", 2)},
+			},
+			wantFlushed: []uint64{2},
+		},
+		{
+			name: "keys keep the order of their first update",
+			batches: [][]*lib.StateChangeEntry{
+				{testKeyedEntry("a", 1), testKeyedEntry("b", 2)}, {testKeyedEntry("c", 3), testKeyedEntry("a", 4)},
+			},
+			wantFlushed: []uint64{4, 2, 3},
+		},
+		{
+			name: "delete is sent immediately and cancels the update",
+			batches: [][]*lib.StateChangeEntry{
+				{testKeyedEntry("a", 1), testKeyedEntry("b", 2)}, {testKeyedDelete("a", 3)},
+			},
+			wantImmediate: [][]uint64{{3}},
+			wantFlushed:   []uint64{2},
+		},
+		{
+			name: "update after a delete",
+			batches: [][]*lib.StateChangeEntry{
+				{testKeyedEntry("a", 1)}, {testKeyedDelete("a", 2)}, {testKeyedEntry("a", 3)},
+			},
+			wantImmediate: [][]uint64{{2}},
+			wantFlushed:   []uint64{3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newRecordingServer(t)
+			wh := NewWebHandler(server.URL, false, "", 0)
+			// Flushes are triggered by the test rather than by the window.
+			wh.CoalesceWindow = time.Hour
+
+			for _, batch := range tt.batches {
+				if err := wh.HandleEntryBatch(batch); err != nil {
+					t.Fatalf("HandleEntryBatch: %v", err)
+				}
+			}
+			if heights := server.batchHeights(); !reflect.DeepEqual(heights, tt.wantImmediate) {
+				t.Errorf("server received %v before the flush, want %v", heights, tt.wantImmediate)
+			}
+
+			wh.flushCoalescedEntries()
+			wantHeights := append(tt.wantImmediate, tt.wantFlushed)
+			if heights := server.batchHeights(); !reflect.DeepEqual(heights, wantHeights) {
+				t.Errorf("server received %v, want %v", heights, wantHeights)
+			}
+			if memoryBytes := wh.metrics.memoryBytes.Load(); memoryBytes != 0 {
+				t.Errorf("memoryBytes = %d after the flush, want 0", memoryBytes)
+			}
+		})
+	}
+}
+
+func TestCoalesceWindowFlushes(t *testing.T) {
+	server := newRecordingServer(t)
+	wh := NewWebHandler(server.URL, false, "", 0)
+	wh.CoalesceWindow = 20 * time.Millisecond
+
+	for height := uint64(1); height <= 5; height++ {
+		if err := wh.HandleEntryBatch([]*lib.StateChangeEntry{testKeyedEntry("a", height)}); err != nil {
+			t.Fatalf("HandleEntryBatch(%d): %v", height, err)
+		}
+	}
+	waitFor(t, "the coalesced update to be flushed", func() bool {
+		return len(server.batchHeights()) > 0
+	})
+	if heights := server.batchHeights(); !reflect.DeepEqual(heights, [][]uint64{{5}}) {
+		t.Errorf("server received %v, want only the last update", heights)
+	}
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+)
+
+// Compression algorithms of HTTP payloads. Each is sent with the Content-Encoding of the same name.
+const (
+	CompressionAlgorithmGzip = "gzip"
+	CompressionAlgorithmZstd = "zstd"
+)
+
+// PayloadCompressor compresses the payloads of HTTP POSTs.
+type PayloadCompressor interface {
+	// ContentEncoding is the Content-Encoding header of compressed payloads.
+	ContentEncoding() string
+	// Compress returns the compressed payload.
+	Compress(data []byte) ([]byte, error)
+}
+
+// payloadCompressors maps each compression algorithm to its compressor.
+var payloadCompressors = map[string]PayloadCompressor{
+	CompressionAlgorithmGzip: gzipCompressor{},
+	CompressionAlgorithmZstd: &zstdCompressor{},
+}
+
+// GetPayloadCompressor returns the compressor of the named algorithm. An empty name returns the gzip compressor.
+func GetPayloadCompressor(algorithm string) (PayloadCompressor, error) {
+	if algorithm == "" {
+		algorithm = CompressionAlgorithmGzip
+	}
+	compressor, exists := payloadCompressors[algorithm]
+	if !exists {
+		return nil, fmt.Errorf("GetPayloadCompressor: unknown compression algorithm %q", algorithm)
+	}
+	return compressor, nil
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) ContentEncoding() string {
+	return CompressionAlgorithmGzip
+}
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	if _, err := gzipWriter.Write(data); err != nil {
+		return nil, errors.Wrap(err, "gzipCompressor.Compress: failed to compress payload")
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return nil, errors.Wrap(err, "gzipCompressor.Compress: failed to compress payload")
+	}
+	return compressed.Bytes(), nil
+}
+
+// zstdCompressor shares a single encoder, which is safe for concurrent use by EncodeAll, between all payloads.
+type zstdCompressor struct {
+	encoderOnce sync.Once
+	encoder     *zstd.Encoder
+	encoderErr  error
+}
+
+func (*zstdCompressor) ContentEncoding() string {
+	return CompressionAlgorithmZstd
+}
+
+func (compressor *zstdCompressor) Compress(data []byte) ([]byte, error) {
+	compressor.encoderOnce.Do(func() {
+		compressor.encoder, compressor.encoderErr = zstd.NewWriter(nil)
+	})
+	if compressor.encoderErr != nil {
+		return nil, errors.Wrap(compressor.encoderErr, "zstdCompressor.Compress: failed to create encoder")
+	}
+	return compressor.encoder.EncodeAll(data, nil), nil
+}
+
+// compressPayload compresses the payload if CompressPayloads or CompressionEnabled is set and the payload is larger
+// than CompressionThreshold, returning the payload to send and its Content-Encoding, which is empty for uncompressed
+// payloads.
+func (wh *WebHandler) compressPayload(jsonData []byte) ([]byte, string, error) {
+	if !(wh.CompressPayloads || wh.CompressionEnabled) || len(jsonData) <= wh.CompressionThreshold {
+		return jsonData, "", nil
+	}
+	compressor, err := GetPayloadCompressor(wh.CompressionAlgorithm)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "WebHandler.compressPayload: invalid compression algorithm")
+	}
+	compressed, err := compressor.Compress(jsonData)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "WebHandler.compressPayload: failed to compress payload")
+	}
+	return compressed, compressor.ContentEncoding(), nil
+}
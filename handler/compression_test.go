@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// decompressPayload decompresses a payload sent with the Content-Encoding, as a receiver would.
+func decompressPayload(contentEncoding string, payload []byte) ([]byte, error) {
+	switch contentEncoding {
+	case "":
+		return payload, nil
+	case "gzip":
+		gzipReader, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(gzipReader)
+	case "zstd":
+		zstdReader, err := zstd.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		defer zstdReader.Close()
+		return io.ReadAll(zstdReader)
+	}
+	return nil, fmt.Errorf("unexpected Content-Encoding %q", contentEncoding)
+}
+
+func TestCompressPayload(t *testing.T) {
+	payload := []byte(strings.Repeat(`{"EncoderType":5,"KeyBytes":"a2V5"},`, 100))
+	tests := []struct {
+		name                 string
+		compressPayloads     bool
+		algorithm            string
+		threshold            int
+		wantContentEncoding  string
+		wantAlgorithmErr     bool
+		wantSmallerThanInput bool
+	}{
+		{name: "disabled"},
+		{name: "default", compressPayloads: true, wantContentEncoding: "gzip", wantSmallerThanInput: true},
+		{name: "gzip", compressPayloads: true, algorithm: CompressionAlgorithmGzip, wantContentEncoding: "gzip",
+			wantSmallerThanInput: true},
+		{name: "zstd", compressPayloads: true, algorithm: CompressionAlgorithmZstd, wantContentEncoding: "zstd",
+			wantSmallerThanInput: true},
+		{name: "below the threshold", compressPayloads: true, algorithm: CompressionAlgorithmZstd,
+			threshold: len(payload)},
+		{name: "unknown algorithm", compressPayloads: true, algorithm: "bzip2", wantAlgorithmErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh := NewWebHandler("", false, "", 0)
+			wh.CompressPayloads = tt.compressPayloads
+			wh.CompressionAlgorithm = tt.algorithm
+			wh.CompressionThreshold = tt.threshold
+
+			compressed, contentEncoding, err := wh.compressPayload(payload)
+			if tt.wantAlgorithmErr {
+				if err == nil || !strings.Contains(err.Error(), "unknown compression algorithm") {
+					t.Errorf("compressPayload = %v, want an unknown algorithm error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("compressPayload: %v", err)
+			}
+			if contentEncoding != tt.wantContentEncoding {
+				t.Errorf("Content-Encoding = %q, want %q", contentEncoding, tt.wantContentEncoding)
+			}
+			if tt.wantSmallerThanInput && len(compressed) >= len(payload) {
+				t.Errorf("compressed payload is %d bytes, not smaller than the %d byte input", len(compressed),
+					len(payload))
+			}
+			data, err := decompressPayload(contentEncoding, compressed)
+			if err != nil {
+				t.Fatalf("failed to decompress the payload: %v", err)
+			}
+			if !bytes.Equal(data, payload) {
+				t.Errorf("decompressed payload = %q, want %q", data, payload)
+			}
+		})
+	}
+}
+
+func TestCompressedBatchIsDecompressedByReceiver(t *testing.T) {
+	for _, algorithm := range []string{CompressionAlgorithmGzip, CompressionAlgorithmZstd} {
+		t.Run(algorithm, func(t *testing.T) {
+			var mtx sync.Mutex
+			var contentEncoding string
+			var entries []map[string]interface{}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				payload, err := io.ReadAll(r.Body)
+				if err == nil {
+					payload, err = decompressPayload(r.Header.Get("Content-Encoding"), payload)
+				}
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+				mtx.Lock()
+				defer mtx.Unlock()
+				contentEncoding = r.Header.Get("Content-Encoding")
+				if err = json.Unmarshal(payload, &entries); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+				}
+			}))
+			t.Cleanup(server.Close)
+			wh := NewWebHandler(server.URL, false, "", 0)
+			wh.MaxDeliveryAttempts = 1
+			wh.CompressPayloads = true
+			wh.CompressionAlgorithm = algorithm
+
+			if err := wh.HandleEntryBatch(newTestEntries(3, 5)); err != nil {
+				t.Fatalf("HandleEntryBatch: %v", err)
+			}
+			mtx.Lock()
+			defer mtx.Unlock()
+			if contentEncoding != algorithm {
+				t.Errorf("Content-Encoding = %q, want %q", contentEncoding, algorithm)
+			}
+			if len(entries) != 3 {
+				t.Errorf("receiver decoded %d entries, want 3", len(entries))
+			}
+		})
+	}
+}
+
+func TestCompressionThreshold(t *testing.T) {
+	// The payload of the batch, as it is sent uncompressed.
+	plainRoundTripper := &captureRoundTripper{statusCode: http.StatusOK}
+	wh := NewWebHandler("http://receiver", false, "", 0, WithRoundTripper(plainRoundTripper))
+	if err := wh.HandleEntryBatch(newTestEntries(3, 5)); err != nil {
+		t.Fatalf("HandleEntryBatch: %v", err)
+	}
+	payload := plainRoundTripper.bodies[0]
+
+	tests := []struct {
+		name                string
+		disabled            bool
+		threshold           int
+		wantContentEncoding string
+	}{
+		{name: "disabled", disabled: true},
+		{name: "no threshold", wantContentEncoding: "gzip"},
+		{name: "one byte below the payload", threshold: len(payload) - 1, wantContentEncoding: "gzip"},
+		{name: "payload size", threshold: len(payload)},
+		{name: "above the payload", threshold: len(payload) + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roundTripper := &captureRoundTripper{statusCode: http.StatusOK}
+			wh := NewWebHandler("http://receiver", false, "", 0, WithRoundTripper(roundTripper))
+			wh.CompressionEnabled = !tt.disabled
+			wh.CompressionThreshold = tt.threshold
+
+			if err := wh.HandleEntryBatch(newTestEntries(3, 5)); err != nil {
+				t.Fatalf("HandleEntryBatch: %v", err)
+			}
+			header := roundTripper.requests[0].Header
+			if contentEncoding := header.Get("Content-Encoding"); contentEncoding != tt.wantContentEncoding {
+				t.Errorf("Content-Encoding = %q, want %q", contentEncoding, tt.wantContentEncoding)
+			}
+			if _, exists := header["Content-Encoding"]; exists && tt.wantContentEncoding == "" {
+				t.Error("expected no Content-Encoding header on an uncompressed payload")
+			}
+			data, err := decompressPayload(header.Get("Content-Encoding"), roundTripper.bodies[0])
+			if err != nil {
+				t.Fatalf("failed to decompress the payload: %v", err)
+			}
+			if !bytes.Equal(data, payload) {
+				t.Errorf("received payload = %q, want %q", data, payload)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"io"
+	"net/http/httptrace"
+)
+
+// maxDrainBytes is the most that is read from a response body that isn't needed before closing it. The connection of
+// a response that is longer than that is closed rather than reused.
+const maxDrainBytes = 64 << 10
+
+// connectionTrace returns a trace that counts the HTTP POSTs sent over a new connection and over a reused one. Most
+// POSTs should reuse a kept-alive connection, so a count of new connections that grows with every batch means that
+// connections aren't being kept alive, e.g. because the endpoint closes them or because response bodies weren't read
+// to the end.
+func (wh *WebHandler) connectionTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				wh.metrics.httpConnsReused.Add(1)
+			} else {
+				wh.metrics.httpConnsNew.Add(1)
+			}
+		},
+	}
+}
+
+// drainAndClose reads what is left of a response body, up to maxDrainBytes, and closes it, so that the transport can
+// reuse the connection for the next request.
+func drainAndClose(body io.ReadCloser) {
+	io.Copy(io.Discard, io.LimitReader(body, maxDrainBytes))
+	body.Close()
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConnectionReuse(t *testing.T) {
+	const requests = 5
+	tests := []struct {
+		name          string
+		statusCode    int
+		responseBytes int
+		wantNew       uint64
+		wantReused    uint64
+	}{
+		{name: "empty responses", statusCode: http.StatusOK, wantNew: 1, wantReused: requests - 1},
+		{name: "unread responses", statusCode: http.StatusOK, responseBytes: 4 << 10, wantNew: 1,
+			wantReused: requests - 1},
+		{name: "error responses", statusCode: http.StatusBadRequest, responseBytes: 4 << 10, wantNew: 1,
+			wantReused: requests - 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statusCode)
+				w.Write([]byte(strings.Repeat("x", tt.responseBytes)))
+			}))
+			t.Cleanup(server.Close)
+			wh := NewWebHandler(server.URL, false, "", 0)
+			wh.MaxDeliveryAttempts = 1
+
+			for ii := 0; ii < requests; ii++ {
+				err := wh.HandleEntryBatch(newTestEntries(1, uint64(ii)))
+				if (err != nil) != (tt.statusCode != http.StatusOK) {
+					t.Errorf("HandleEntryBatch(%d) = %v with a %d response", ii, err, tt.statusCode)
+				}
+			}
+			if connsNew := wh.metrics.httpConnsNew.Load(); connsNew != tt.wantNew {
+				t.Errorf("httpConnsNew = %d, want %d", connsNew, tt.wantNew)
+			}
+			if connsReused := wh.metrics.httpConnsReused.Load(); connsReused != tt.wantReused {
+				t.Errorf("httpConnsReused = %d, want %d", connsReused, tt.wantReused)
+			}
+		})
+	}
+}
+
+// closeRecorder is a response body that records how much of it was read and whether it was closed.
+type closeRecorder struct {
+	io.Reader
+	bytesRead int
+	closed    bool
+}
+
+func (body *closeRecorder) Read(p []byte) (int, error) {
+	n, err := body.Reader.Read(p)
+	body.bytesRead += n
+	return n, err
+}
+
+func (body *closeRecorder) Close() error {
+	body.closed = true
+	return nil
+}
+
+func TestDrainAndClose(t *testing.T) {
+	tests := []struct {
+		name          string
+		bodyBytes     int
+		wantBytesRead int
+	}{
+		{name: "empty", bodyBytes: 0, wantBytesRead: 0},
+		{name: "short", bodyBytes: 100, wantBytesRead: 100},
+		{name: "at the limit", bodyBytes: maxDrainBytes, wantBytesRead: maxDrainBytes},
+		// A body too long to drain is closed after the limit rather than holding up the next request.
+		{name: "over the limit", bodyBytes: 4 * maxDrainBytes, wantBytesRead: maxDrainBytes},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := &closeRecorder{Reader: strings.NewReader(strings.Repeat("x", tt.bodyBytes))}
+			drainAndClose(body)
+			if body.bytesRead != tt.wantBytesRead {
+				t.Errorf("read %d bytes, want %d", body.bytesRead, tt.wantBytesRead)
+			}
+			if !body.closed {
+				t.Error("expected the body to be closed")
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// Extensions of the files written for every dead-lettered batch. They share the name of the batch file, which is
+// the height of the batch followed by the time it was dead-lettered.
+const (
+	// deadLetterBatchExt is the batch as JSON: the untransformed entries for batches that failed delivery, and the
+	// POST body for fan-out batches.
+	deadLetterBatchExt = ".json"
+	// deadLetterMetadataExt is the DeadLetterMetadata of the batch.
+	deadLetterMetadataExt = ".meta.json"
+	// deadLetterReplayExt is the binary encoding of the entries that ReplayDeadLetter sends. Fan-out batches,
+	// which are dead-lettered as sent, don't have one.
+	deadLetterReplayExt = ".bin"
+)
+
+// DeadLetterMetadata describes a dead-lettered batch and why it couldn't be delivered.
+type DeadLetterMetadata struct {
+	FirstBlockHeight uint64    `json:"first_block_height"`
+	LastBlockHeight  uint64    `json:"last_block_height"`
+	EntryCount       int       `json:"entry_count"`
+	Attempts         int       `json:"attempts"`
+	LastError        string    `json:"last_error"`
+	URL              string    `json:"url,omitempty"`
+	DeadLetteredAt   time.Time `json:"dead_lettered_at"`
+}
+
+// writeDeadLetter writes the batch, untransformed, to new files in DeadLetterDir so that it can be replayed with
+// ReplayDeadLetter.
+func (wh *WebHandler) writeDeadLetter(batchedEntries []*lib.StateChangeEntry, attempts int, lastErr error) error {
+	jsonData, err := json.Marshal(batchedEntries)
+	if err != nil {
+		return errors.Wrap(err, "WebHandler.writeDeadLetter: failed to marshal batch")
+	}
+	metadata := DeadLetterMetadata{
+		FirstBlockHeight: batchedEntries[0].BlockHeight,
+		LastBlockHeight:  batchedEntries[len(batchedEntries)-1].BlockHeight,
+		EntryCount:       len(batchedEntries),
+		Attempts:         attempts,
+	}
+	if lastErr != nil {
+		metadata.LastError = lastErr.Error()
+	}
+	path, err := writeDeadLetterFile(wh.DeadLetterDir, jsonData, metadata)
+	if err != nil {
+		return errors.Wrap(err, "WebHandler.writeDeadLetter: failed to write dead letter file")
+	}
+	// The replay file is written last, so that only batches whose files are all written are replayed.
+	replayPath := strings.TrimSuffix(path, deadLetterBatchExt) + deadLetterReplayExt
+	if err = os.WriteFile(replayPath, encodeBatch(batchedEntries), 0644); err != nil {
+		return errors.Wrapf(err, "WebHandler.writeDeadLetter: failed to write %s", replayPath)
+	}
+
+	wh.metrics.batchesDeadLettered.Add(1)
+	glog.Errorf("WebHandler.writeDeadLetter: wrote batch of %d entries to %s", len(batchedEntries), path)
+	return nil
+}
+
+// writeDeadLetterFile writes the data and its metadata to new files in dir, named after the height of the batch, and
+// returns the path of the data file.
+func writeDeadLetterFile(dir string, data []byte, metadata DeadLetterMetadata) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrapf(err, "writeDeadLetterFile: failed to create %s", dir)
+	}
+	metadata.DeadLetteredAt = time.Now().UTC()
+	basePath := filepath.Join(dir, fmt.Sprintf("%d-%d", metadata.FirstBlockHeight, metadata.DeadLetteredAt.UnixNano()))
+
+	metadataJSON, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return "", errors.Wrap(err, "writeDeadLetterFile: failed to marshal metadata")
+	}
+	if err = os.WriteFile(basePath+deadLetterMetadataExt, metadataJSON, 0644); err != nil {
+		return "", errors.Wrapf(err, "writeDeadLetterFile: failed to write %s", basePath+deadLetterMetadataExt)
+	}
+	path := basePath + deadLetterBatchExt
+	if err = os.WriteFile(path, data, 0644); err != nil {
+		return "", errors.Wrapf(err, "writeDeadLetterFile: failed to write %s", path)
+	}
+	return path, nil
+}
+
+// ReplayDeadLetter sends the batches dead-lettered in dir, oldest block height first, through the same middleware
+// and transport as new batches, making a single attempt each. The files of every batch that is sent are removed.
+// Replaying stops at the first batch that fails, which is left in dir with the ones after it, so that it can be
+// called again once the endpoint has recovered. Batches dead-lettered by fan-out subscribers, in the subdirectories
+// of dir, aren't replayed.
+func (wh *WebHandler) ReplayDeadLetter(dir string) error {
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return errors.Wrapf(err, "WebHandler.ReplayDeadLetter: failed to read %s", dir)
+	}
+
+	type replayFile struct {
+		basePath    string
+		blockHeight uint64
+	}
+	var replayFiles []replayFile
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if dirEntry.IsDir() || !strings.HasSuffix(name, deadLetterReplayExt) {
+			continue
+		}
+		file := replayFile{basePath: filepath.Join(dir, strings.TrimSuffix(name, deadLetterReplayExt))}
+		fmt.Sscanf(name, "%d-", &file.blockHeight)
+		replayFiles = append(replayFiles, file)
+	}
+	sort.Slice(replayFiles, func(ii, jj int) bool {
+		if replayFiles[ii].blockHeight != replayFiles[jj].blockHeight {
+			return replayFiles[ii].blockHeight < replayFiles[jj].blockHeight
+		}
+		return replayFiles[ii].basePath < replayFiles[jj].basePath
+	})
+
+	for ii, file := range replayFiles {
+		data, err := os.ReadFile(file.basePath + deadLetterReplayExt)
+		if err != nil {
+			return errors.Wrapf(err, "WebHandler.ReplayDeadLetter: failed to read %s", file.basePath+deadLetterReplayExt)
+		}
+		batchedEntries, err := decodeBatch(data)
+		if err != nil {
+			return errors.Wrapf(err, "WebHandler.ReplayDeadLetter: failed to decode %s", file.basePath+deadLetterReplayExt)
+		}
+		if len(batchedEntries) > 0 {
+			if err = wh.sendBatchOnce(batchedEntries); err != nil {
+				return errors.Wrapf(err, "WebHandler.ReplayDeadLetter: failed to replay %s, %d batches left",
+					file.basePath+deadLetterReplayExt, len(replayFiles)-ii)
+			}
+		}
+		for _, ext := range []string{deadLetterReplayExt, deadLetterBatchExt, deadLetterMetadataExt} {
+			if err = os.Remove(file.basePath + ext); err != nil && !os.IsNotExist(err) {
+				glog.Errorf("WebHandler.ReplayDeadLetter: failed to remove %s: %v", file.basePath+ext, err)
+			}
+		}
+	}
+	glog.Infof("WebHandler.ReplayDeadLetter: replayed %d batches from %s", len(replayFiles), dir)
+	return nil
+}
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// deadLetterFiles returns the names of the files in dir, sorted.
+func deadLetterFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", dir, err)
+	}
+	var names []string
+	for _, dirEntry := range dirEntries {
+		names = append(names, dirEntry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestDeadLetterAndReplay(t *testing.T) {
+	server := newRecordingServer(t)
+	server.statusCode.Store(http.StatusServiceUnavailable)
+	deadLetterDir := t.TempDir()
+	wh := NewWebHandler(server.URL, false, "", 0)
+	wh.MaxDeliveryAttempts = 1
+	wh.DeadLetterDir = deadLetterDir
+
+	batch := append(newTestEntries(2, 7), newTestEntries(1, 8)...)
+	if err := wh.HandleEntryBatch(batch); err != nil {
+		t.Fatalf("HandleEntryBatch: %v", err)
+	}
+
+	names := deadLetterFiles(t, deadLetterDir)
+	if len(names) != 3 {
+		t.Fatalf("dead letter files = %v, want a batch, metadata and replay file", names)
+	}
+	baseName := strings.SplitN(names[0], ".", 2)[0]
+	wantNames := []string{baseName + deadLetterReplayExt, baseName + deadLetterBatchExt, baseName + deadLetterMetadataExt}
+	sort.Strings(wantNames)
+	if !reflect.DeepEqual(names, wantNames) || !strings.HasPrefix(baseName, "7-") {
+		t.Errorf("dead letter files = %v, want %v named after height 7", names, wantNames)
+	}
+
+	metadataJSON, err := os.ReadFile(filepath.Join(deadLetterDir, baseName+deadLetterMetadataExt))
+	if err != nil {
+		t.Fatalf("failed to read metadata: %v", err)
+	}
+	var metadata DeadLetterMetadata
+	if err = json.Unmarshal(metadataJSON, &metadata); err != nil {
+		t.Fatalf("failed to decode metadata: %v", err)
+	}
+	if metadata.FirstBlockHeight != 7 || metadata.LastBlockHeight != 8 || metadata.EntryCount != 3 ||
+		metadata.Attempts != 1 || !strings.Contains(metadata.LastError, "503") || metadata.DeadLetteredAt.IsZero() {
+		t.Errorf("unexpected metadata %+v", metadata)
+	}
+	if deadLettered := wh.metrics.batchesDeadLettered.Load(); deadLettered != 1 {
+		t.Errorf("batchesDeadLettered = %d, want 1", deadLettered)
+	}
+
+	// Replaying while the endpoint is still down keeps the files.
+	if err = wh.ReplayDeadLetter(deadLetterDir); err == nil {
+		t.Fatal("expected replay to fail while the endpoint is down")
+	}
+	if remaining := deadLetterFiles(t, deadLetterDir); !reflect.DeepEqual(remaining, names) {
+		t.Errorf("files after failed replay = %v, want %v", remaining, names)
+	}
+
+	server.statusCode.Store(http.StatusOK)
+	if err = wh.ReplayDeadLetter(deadLetterDir); err != nil {
+		t.Fatalf("ReplayDeadLetter: %v", err)
+	}
+	if heights := server.batchHeights(); !reflect.DeepEqual(heights, [][]uint64{{7, 7, 8}}) {
+		t.Errorf("replayed batches = %v, want [[7 7 8]]", heights)
+	}
+	if remaining := deadLetterFiles(t, deadLetterDir); len(remaining) != 0 {
+		t.Errorf("files left after replay: %v", remaining)
+	}
+}
+
+func TestReplayDeadLetterOrder(t *testing.T) {
+	server := newRecordingServer(t)
+	server.statusCode.Store(http.StatusInternalServerError)
+	deadLetterDir := t.TempDir()
+	wh := NewWebHandler(server.URL, false, "", 0)
+	wh.MaxDeliveryAttempts = 1
+	wh.DeadLetterDir = deadLetterDir
+
+	// Batches are replayed by height, whatever order they were dead-lettered in.
+	for _, height := range []uint64{30, 10, 20} {
+		if err := wh.HandleEntryBatch(newTestEntries(1, height)); err != nil {
+			t.Fatalf("HandleEntryBatch(%d): %v", height, err)
+		}
+	}
+	server.statusCode.Store(http.StatusOK)
+	if err := wh.ReplayDeadLetter(deadLetterDir); err != nil {
+		t.Fatalf("ReplayDeadLetter: %v", err)
+	}
+	if heights := server.batchHeights(); !reflect.DeepEqual(heights, [][]uint64{{10}, {20}, {30}}) {
+		t.Errorf("replayed batches = %v, want [[10] [20] [30]]", heights)
+	}
+}
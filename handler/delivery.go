@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// Delivery semantics of a WebHandler. A batch is acknowledged by the endpoint when its HTTP POST returns 200 OK,
+// or when it is written to the WebSocket connection without error. The consumer only advances its progress cursor
+// past a batch once HandleEntryBatch returns nil for it.
+const (
+	// DeliveryAtMostOnce sends every batch once. A batch that isn't acknowledged is logged, counted as dropped and
+	// skipped, so the consumer keeps moving and never sends a batch twice, but entries can be lost.
+	DeliveryAtMostOnce = "at_most_once"
+	// DeliveryAtLeastOnce, the default, retries a batch that isn't acknowledged up to MaxDeliveryAttempts times.
+	// A batch that still isn't acknowledged is written to DeadLetterDir if one is configured, and otherwise fails
+	// HandleEntryBatch so that the consumer doesn't advance past it. No entry is lost, but the endpoint can receive
+	// a batch more than once, e.g. when it processed a batch but its response timed out, and must deduplicate.
+	DeliveryAtLeastOnce = "at_least_once"
+)
+
+const (
+	// DefaultMaxDeliveryAttempts is the number of times a batch is sent under DeliveryAtLeastOnce when no
+	// MaxDeliveryAttempts is configured.
+	DefaultMaxDeliveryAttempts = 3
+	// deliveryRetryBackoff is the wait before the first retry of a batch. It doubles after each attempt.
+	deliveryRetryBackoff = time.Second
+)
+
+// deliverySemantics returns the configured delivery semantics, defaulting to DeliveryAtLeastOnce.
+func (wh *WebHandler) deliverySemantics() string {
+	if wh.DeliverySemantics == "" {
+		return DeliveryAtLeastOnce
+	}
+	return wh.DeliverySemantics
+}
+
+// deliverBatch sends the batch according to the configured delivery semantics.
+func (wh *WebHandler) deliverBatch(batchedEntries []*lib.StateChangeEntry) error {
+	switch wh.deliverySemantics() {
+	case DeliveryAtMostOnce:
+		if err := wh.sendBatchOnce(batchedEntries); err != nil {
+			wh.metrics.batchesDropped.Add(1)
+			glog.Errorf("WebHandler.deliverBatch: dropping batch of %d entries at height %d: %v",
+				len(batchedEntries), batchedEntries[0].BlockHeight, err)
+		}
+		return nil
+	case DeliveryAtLeastOnce:
+		return wh.deliverBatchAtLeastOnce(batchedEntries)
+	default:
+		return fmt.Errorf("WebHandler.deliverBatch: unknown delivery semantics %q", wh.DeliverySemantics)
+	}
+}
+
+// deliverBatchAtLeastOnce sends the batch until it is acknowledged, giving up after MaxDeliveryAttempts attempts.
+func (wh *WebHandler) deliverBatchAtLeastOnce(batchedEntries []*lib.StateChangeEntry) error {
+	maxAttempts := wh.MaxDeliveryAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxDeliveryAttempts
+	}
+
+	var err error
+	attempts := 0
+	backoff := deliveryRetryBackoff
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if attempt > 1 {
+			wh.metrics.batchesRetried.Add(1)
+			if sleepErr := wh.sleep(backoff); sleepErr != nil {
+				err = errors.Wrapf(sleepErr, "WebHandler.deliverBatchAtLeastOnce: gave up after attempt %d (%v)", attempt-1, err)
+				break
+			}
+			backoff *= 2
+		}
+		attempts++
+		if err = wh.sendBatchOnce(batchedEntries); err == nil {
+			return nil
+		}
+		glog.Errorf("WebHandler.deliverBatchAtLeastOnce: attempt %d of %d failed: %v", attempt, maxAttempts, err)
+		// Batches can't be sent over WebSocket once the handler is closed, nor at all once the send context is
+		// cancelled, so retrying is pointless.
+		if wh.wsClosed.Load() || wh.context().Err() != nil {
+			break
+		}
+	}
+
+	if wh.DeadLetterDir == "" {
+		return errors.Wrapf(err, "WebHandler.deliverBatchAtLeastOnce: batch not acknowledged after %d attempts", maxAttempts)
+	}
+	if dlErr := wh.writeDeadLetter(batchedEntries, attempts, err); dlErr != nil {
+		return errors.Wrapf(dlErr, "WebHandler.deliverBatchAtLeastOnce: batch not acknowledged (%v) and could not be dead-lettered", err)
+	}
+	return nil
+}
+
+// sendBatchOnce makes a single attempt to send the batch through the middleware and records its outcome. Every
+// attempt of a batch is passed the same slice, so its first entry identifies the batch to the transport, even when
+// the middleware returns new entries.
+func (wh *WebHandler) sendBatchOnce(batchedEntries []*lib.StateChangeEntry) error {
+	sentEntries, err := wh.sendBatchThroughMiddleware(batchedEntries, func(entries []*lib.StateChangeEntry) error {
+		return wh.sendBatch(batchedEntries[0], entries)
+	})
+	if err == nil && len(sentEntries) == 0 {
+		// The middleware skipped the batch.
+		wh.metrics.batchesSkipped.Add(1)
+		return nil
+	}
+	wh.metrics.recordBatch(sentEntries, err)
+	if err != nil {
+		// The receiver may not have the states the patch cache holds, so send the next entries in full.
+		wh.resetPatchCache()
+		return err
+	}
+	wh.lastSentAt.Store(time.Now().UnixNano())
+	return nil
+}
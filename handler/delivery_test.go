@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/deso-protocol/core/lib"
+)
+
+func TestDeliverySemantics(t *testing.T) {
+	tests := []struct {
+		name              string
+		deliverySemantics string
+		wantErr           bool
+		wantRequests      int64
+		wantRetried       uint64
+		wantDropped       uint64
+	}{
+		{name: "at most once", deliverySemantics: DeliveryAtMostOnce, wantRequests: 1, wantDropped: 1},
+		{name: "at least once", deliverySemantics: DeliveryAtLeastOnce, wantErr: true, wantRequests: 3,
+			wantRetried: 2},
+		{name: "default", wantErr: true, wantRequests: 3, wantRetried: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Every retry waits out the delivery backoff, so the cases run in parallel.
+			t.Parallel()
+			server := newRecordingServer(t)
+			server.statusCode.Store(http.StatusServiceUnavailable)
+			wh := NewWebHandler(server.URL, false, "", 0)
+			wh.DeliverySemantics = tt.deliverySemantics
+			wh.MaxDeliveryAttempts = 3
+
+			err := wh.HandleEntryBatch(newTestEntries(1, 1))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("HandleEntryBatch = %v, wantErr %v", err, tt.wantErr)
+			}
+			if requests := server.requests.Load(); requests != tt.wantRequests {
+				t.Errorf("server received %d requests, want %d", requests, tt.wantRequests)
+			}
+			if retried := wh.metrics.batchesRetried.Load(); retried != tt.wantRetried {
+				t.Errorf("batchesRetried = %d, want %d", retried, tt.wantRetried)
+			}
+			if dropped := wh.metrics.batchesDropped.Load(); dropped != tt.wantDropped {
+				t.Errorf("batchesDropped = %d, want %d", dropped, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestDeliveryAtLeastOnceRecovers(t *testing.T) {
+	server := newRecordingServer(t)
+	server.statusCode.Store(http.StatusServiceUnavailable)
+	wh := NewWebHandler(server.URL, false, "", 0)
+	wh.DeliverySemantics = DeliveryAtLeastOnce
+	wh.MaxDeliveryAttempts = 3
+	// The endpoint comes back after the first attempt fails.
+	wh.Middleware = []SendMiddleware{&afterSendHook{hook: func(SendResult) {
+		server.statusCode.Store(http.StatusOK)
+	}}}
+
+	if err := wh.HandleEntryBatch(newTestEntries(1, 1)); err != nil {
+		t.Fatalf("HandleEntryBatch: %v", err)
+	}
+	if requests := server.requests.Load(); requests != 2 {
+		t.Errorf("server received %d requests, want 2", requests)
+	}
+	if heights := server.batchHeights(); len(heights) != 1 {
+		t.Errorf("server accepted %d batches, want 1", len(heights))
+	}
+}
+
+// afterSendHook is middleware that calls hook after every attempt.
+type afterSendHook struct {
+	hook func(SendResult)
+}
+
+func (middleware *afterSendHook) BeforeSend(batchedEntries []*lib.StateChangeEntry) ([]*lib.StateChangeEntry, error) {
+	return batchedEntries, nil
+}
+
+func (middleware *afterSendHook) AfterSend(result SendResult) {
+	middleware.hook(result)
+}
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/deso-protocol/core/lib"
+)
+
+// encoderTypeNames are the short names of the encoder types, used in routing keys, topics and URLs.
+var encoderTypeNames = map[lib.EncoderType]string{
+	lib.EncoderTypePostEntry:                 "posts",
+	lib.EncoderTypeProfileEntry:              "profiles",
+	lib.EncoderTypeLikeEntry:                 "likes",
+	lib.EncoderTypeDiamondEntry:              "diamonds",
+	lib.EncoderTypeFollowEntry:               "follows",
+	lib.EncoderTypeMessageEntry:              "messages",
+	lib.EncoderTypeBalanceEntry:              "balances",
+	lib.EncoderTypeNFTEntry:                  "nft",
+	lib.EncoderTypeNFTBidEntry:               "nft_bids",
+	lib.EncoderTypeDerivedKeyEntry:           "derived_keys",
+	lib.EncoderTypeAccessGroupEntry:          "access_groups",
+	lib.EncoderTypeAccessGroupMemberEntry:    "access_group_members",
+	lib.EncoderTypeNewMessageEntry:           "new_messages",
+	lib.EncoderTypeUserAssociationEntry:      "user_associations",
+	lib.EncoderTypePostAssociationEntry:      "post_associations",
+	lib.EncoderTypePKIDEntry:                 "pkids",
+	lib.EncoderTypeDeSoBalanceEntry:          "deso_balances",
+	lib.EncoderTypeDAOCoinLimitOrderEntry:    "dao_coin_limit_orders",
+	lib.EncoderTypeUtxoOperationBundle:       "utxo_operations",
+	lib.EncoderTypeBlock:                     "blocks",
+	lib.EncoderTypeTxn:                       "transactions",
+	lib.EncoderTypeStakeEntry:                "stakes",
+	lib.EncoderTypeValidatorEntry:            "validators",
+	lib.EncoderTypeLockedStakeEntry:          "locked_stakes",
+	lib.EncoderTypeLockedBalanceEntry:        "locked_balances",
+	lib.EncoderTypeLockupYieldCurvePoint:     "lockup_yield_curve_points",
+	lib.EncoderTypeEpochEntry:                "epochs",
+	lib.EncoderTypePKID:                      "pkid",
+	lib.EncoderTypeGlobalParamsEntry:         "global_params",
+	lib.EncoderTypeBLSPublicKeyPKIDPairEntry: "bls_public_key_pkid_pairs",
+	lib.EncoderTypeBlockNode:                 "block_nodes",
+}
+
+// EncoderTypeName returns the short name of the encoder type, e.g. posts for EncoderTypePostEntry. Unknown
+// encoder types are named after their number.
+func EncoderTypeName(encoderType lib.EncoderType) string {
+	if name, exists := encoderTypeNames[encoderType]; exists {
+		return name
+	}
+	return fmt.Sprintf("encoder_type_%d", encoderType)
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/pkg/errors"
+)
+
+// Placeholders of EndpointQueryParams, replaced with the values of the entries of each request.
+const (
+	QueryPlaceholderMinHeight = "{minHeight}"
+	QueryPlaceholderMaxHeight = "{maxHeight}"
+	QueryPlaceholderCount     = "{count}"
+)
+
+// batchEndpointURL returns EndpointURL with EndpointQueryParams added to its query string, their placeholders
+// replaced with the block height range and number of the entries. Params replace any param of the same name in
+// EndpointURL, and the others are kept.
+func (wh *WebHandler) batchEndpointURL(batchedEntries []*lib.StateChangeEntry) (string, error) {
+	if len(wh.EndpointQueryParams) == 0 {
+		return wh.EndpointURL, nil
+	}
+	endpointURL, err := url.Parse(wh.EndpointURL)
+	if err != nil {
+		return "", errors.Wrapf(err, "WebHandler.batchEndpointURL: invalid endpoint URL %s", wh.EndpointURL)
+	}
+
+	var minHeight, maxHeight uint64
+	for ii, entry := range batchedEntries {
+		if ii == 0 || entry.BlockHeight < minHeight {
+			minHeight = entry.BlockHeight
+		}
+		if entry.BlockHeight > maxHeight {
+			maxHeight = entry.BlockHeight
+		}
+	}
+	replacer := strings.NewReplacer(
+		QueryPlaceholderMinHeight, strconv.FormatUint(minHeight, 10),
+		QueryPlaceholderMaxHeight, strconv.FormatUint(maxHeight, 10),
+		QueryPlaceholderCount, strconv.Itoa(len(batchedEntries)),
+	)
+
+	query := endpointURL.Query()
+	for name, template := range wh.EndpointQueryParams {
+		query.Set(name, replacer.Replace(template))
+	}
+	endpointURL.RawQuery = query.Encode()
+	return endpointURL.String(), nil
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestBatchEndpointURL(t *testing.T) {
+	tests := []struct {
+		name        string
+		endpointURL string
+		queryParams map[string]string
+		heights     []uint64
+		wantURL     string
+		wantErr     bool
+	}{
+		{
+			name:        "no params",
+			endpointURL: "http://receiver/ingest?b=2&a=1",
+			heights:     []uint64{5},
+			wantURL:     "http://receiver/ingest?b=2&a=1",
+		},
+		{
+			name:        "placeholders",
+			endpointURL: "http://receiver/ingest",
+			queryParams: map[string]string{"from": "{minHeight}", "to": "{maxHeight}", "n": "{count}"},
+			heights:     []uint64{5, 3, 7},
+			wantURL:     "http://receiver/ingest?from=3&n=3&to=7",
+		},
+		{
+			name:        "placeholders within text",
+			endpointURL: "http://receiver/ingest",
+			queryParams: map[string]string{"range": "{minHeight}..{maxHeight}/{minHeight}", "source": "pdh"},
+			heights:     []uint64{4, 4},
+			wantURL:     "http://receiver/ingest?range=4..4%2F4&source=pdh",
+		},
+		{
+			name:        "merged with the existing query",
+			endpointURL: "http://receiver/ingest?token=abc&from=0",
+			queryParams: map[string]string{"from": "{minHeight}"},
+			heights:     []uint64{9},
+			wantURL:     "http://receiver/ingest?from=9&token=abc",
+		},
+		{
+			name:        "encoded names and values",
+			endpointURL: "http://receiver/ingest",
+			queryParams: map[string]string{"block range": "{minHeight} & {maxHeight}", "next": "a/b?c=d#e"},
+			heights:     []uint64{1, 2},
+			wantURL:     "http://receiver/ingest?block+range=1+%26+2&next=a%2Fb%3Fc%3Dd%23e",
+		},
+		{
+			name:        "invalid endpoint URL",
+			endpointURL: "http://receiver/%zz",
+			queryParams: map[string]string{"n": "{count}"},
+			heights:     []uint64{1},
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh := NewWebHandler(tt.endpointURL, false, "", 0)
+			wh.EndpointQueryParams = tt.queryParams
+
+			url, err := wh.batchEndpointURL(newTestHeightBatch(tt.heights...))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("batchEndpointURL = %v, wantErr %v", err, tt.wantErr)
+			}
+			if url != tt.wantURL {
+				t.Errorf("batchEndpointURL = %s, want %s", url, tt.wantURL)
+			}
+		})
+	}
+}
+
+func TestEndpointQueryParamsPerRequest(t *testing.T) {
+	roundTripper := &captureRoundTripper{statusCode: http.StatusOK}
+	wh := NewWebHandler("http://receiver/ingest", false, "", 0, WithRoundTripper(roundTripper))
+	wh.MaxDeliveryAttempts = 1
+	wh.MaxArrayItems = 2
+	wh.EndpointQueryParams = map[string]string{"min": "{minHeight}", "max": "{maxHeight}", "count": "{count}"}
+
+	if err := wh.HandleEntryBatch(newTestHeightBatch(1, 2, 3)); err != nil {
+		t.Fatalf("HandleEntryBatch: %v", err)
+	}
+	// Every request carries the values of its own entries.
+	var queries []string
+	for _, req := range roundTripper.requests {
+		queries = append(queries, req.URL.RawQuery)
+	}
+	if want := []string{"count=2&max=2&min=1", "count=1&max=3&min=3"}; !reflect.DeepEqual(queries, want) {
+		t.Errorf("queries = %v, want %v", queries, want)
+	}
+}
@@ -0,0 +1,239 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// RedactedPlaceholder replaces the values of fields listed in RedactFields.
+const RedactedPlaceholder = "[redacted]"
+
+// extraDataKeys are the keys under which an entry's extra data map is nested, which is PostExtraData for posts
+// and ExtraData for every other entry. Field transforms apply to the keys inside these maps as well as to the
+// entry's own fields.
+var extraDataKeys = map[string]struct{}{
+	"ExtraData":       {},
+	"extra_data":      {},
+	"PostExtraData":   {},
+	"post_extra_data": {},
+}
+
+// entryEncoderKey is the key under which a marshaled StateChangeEntry nests the entry itself.
+const entryEncoderKey = "Encoder"
+
+// marshalBatch marshals the batch of entries to JSON, applying the configured field transforms to every entry.
+func (wh *WebHandler) marshalBatch(batchedEntries []*lib.StateChangeEntry) ([]byte, error) {
+	jsonData, err := json.Marshal(batchedEntries)
+	if err != nil {
+		return nil, errors.Wrap(err, "WebHandler.marshalBatch: failed to marshal batch")
+	}
+	if !wh.hasEntryTransforms() {
+		return jsonData, nil
+	}
+	renameField, err := getFieldRenamer(wh.FieldNameStyle)
+	if err != nil {
+		return nil, errors.Wrap(err, "WebHandler.marshalBatch: invalid field name style")
+	}
+
+	// Decode into generic maps so that fields can be transformed regardless of the entry type. Numbers are kept
+	// as json.Number so that large integers round trip without losing precision.
+	var entries []map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(jsonData))
+	decoder.UseNumber()
+	if err = decoder.Decode(&entries); err != nil {
+		return nil, errors.Wrap(err, "WebHandler.marshalBatch: failed to decode batch")
+	}
+
+	redactFields := toFieldSet(wh.RedactFields)
+	passthroughFields := toFieldSet(wh.PassthroughFields)
+	projection := newFieldProjection(wh.ProjectFields)
+	for ii, entry := range entries {
+		redactEntryFields(entry, redactFields)
+		passthroughEntryFields(entry, passthroughFields)
+		if projection != nil {
+			entry = projectEntryFields(entry, projection)
+			entries[ii] = entry
+		}
+		if err = nameEntryEnums(entry, wh.EnumFormat); err != nil {
+			return nil, errors.Wrap(err, "WebHandler.marshalBatch: failed to name enums")
+		}
+		if renameField != nil {
+			renameEntryFields(entry, renameField)
+		}
+	}
+	if wh.ValidationSchema != nil {
+		if batchedEntries, entries, err = wh.validateEntries(batchedEntries, entries); err != nil {
+			return nil, errors.Wrap(err, "WebHandler.marshalBatch: batch failed validation")
+		}
+	}
+	if wh.PatchCacheSize > 0 {
+		if err = wh.patchEntries(batchedEntries, entries, renameField); err != nil {
+			return nil, errors.Wrap(err, "WebHandler.marshalBatch: failed to patch entries")
+		}
+	}
+	if wh.WrapWithOpMetadata {
+		if err = wh.wrapEntriesWithOpMetadata(batchedEntries, entries); err != nil {
+			return nil, errors.Wrap(err, "WebHandler.marshalBatch: failed to wrap entries")
+		}
+	}
+
+	// Don't escape HTML characters, so that passthrough fields are emitted exactly as they were stored.
+	var transformedData bytes.Buffer
+	encoder := json.NewEncoder(&transformedData)
+	encoder.SetEscapeHTML(false)
+	if err = encoder.Encode(entries); err != nil {
+		return nil, errors.Wrap(err, "WebHandler.marshalBatch: failed to marshal transformed batch")
+	}
+	return bytes.TrimSuffix(transformedData.Bytes(), []byte("\n")), nil
+}
+
+// hasEntryTransforms returns whether any field transform is configured, in which case entries are decoded into
+// generic maps before being sent.
+func (wh *WebHandler) hasEntryTransforms() bool {
+	return len(wh.RedactFields) > 0 || len(wh.PassthroughFields) > 0 || len(wh.ProjectFields) > 0 ||
+		wh.PatchCacheSize > 0 || wh.ValidationSchema != nil || wh.WrapWithOpMetadata ||
+		(wh.EnumFormat != "" && wh.EnumFormat != EnumFormatNumeric) ||
+		(wh.FieldNameStyle != "" && wh.FieldNameStyle != FieldNameStylePascal)
+}
+
+// validateEntries validates every entry against the validation schema. Under ValidationPolicyDrop, entries that
+// don't conform are logged and removed from both the batch and the decoded entries; otherwise the first entry that
+// doesn't conform fails the batch.
+func (wh *WebHandler) validateEntries(batchedEntries []*lib.StateChangeEntry, entries []map[string]interface{}) (
+	[]*lib.StateChangeEntry, []map[string]interface{}, error) {
+
+	var validBatchedEntries []*lib.StateChangeEntry
+	var validEntries []map[string]interface{}
+	for ii, entry := range entries {
+		err := wh.ValidationSchema.Validate(entry)
+		if err == nil {
+			validBatchedEntries = append(validBatchedEntries, batchedEntries[ii])
+			validEntries = append(validEntries, entry)
+			continue
+		}
+
+		wh.metrics.entriesInvalid.Add(1)
+		if wh.ValidationPolicy != ValidationPolicyDrop {
+			return nil, nil, errors.Wrapf(err, "WebHandler.validateEntries: entry %d does not conform to schema", ii)
+		}
+		glog.Errorf("WebHandler.validateEntries: dropping entry %d with encoder type %d: %v",
+			ii, batchedEntries[ii].EncoderType, err)
+	}
+	return validBatchedEntries, validEntries, nil
+}
+
+func toFieldSet(fields []string) map[string]struct{} {
+	fieldSet := make(map[string]struct{}, len(fields))
+	for _, field := range fields {
+		fieldSet[field] = struct{}{}
+	}
+	return fieldSet
+}
+
+// redactEntryFields replaces the values of the listed fields with RedactedPlaceholder, keeping the keys in place
+// so that downstream schemas stay stable. Fields are redacted at the top level, inside the nested entry and
+// inside its extra data.
+func redactEntryFields(fields map[string]interface{}, redactFields map[string]struct{}) {
+	for key, value := range fields {
+		if _, exists := redactFields[key]; exists {
+			fields[key] = RedactedPlaceholder
+			continue
+		}
+		nestedFields, isMap := value.(map[string]interface{})
+		if !isMap {
+			continue
+		}
+		if _, isExtraData := extraDataKeys[key]; isExtraData || key == entryEncoderKey {
+			redactEntryFields(nestedFields, redactFields)
+		}
+	}
+}
+
+// passthroughEntryFields emits the listed byte fields as the text they hold rather than base64 encoding them
+// again. Fields holding data that isn't valid UTF-8 can't be represented in a JSON string, so they are left
+// base64 encoded. Fields are passed through at the top level, inside the nested entry and inside its extra data.
+func passthroughEntryFields(fields map[string]interface{}, passthroughFields map[string]struct{}) {
+	if len(passthroughFields) == 0 {
+		return
+	}
+	for key, value := range fields {
+		if nestedFields, isMap := value.(map[string]interface{}); isMap {
+			if _, isExtraData := extraDataKeys[key]; isExtraData || key == entryEncoderKey {
+				passthroughEntryFields(nestedFields, passthroughFields)
+			}
+			continue
+		}
+		if _, exists := passthroughFields[key]; !exists {
+			continue
+		}
+		encodedValue, isString := value.(string)
+		if !isString {
+			continue
+		}
+		rawValue, err := base64.StdEncoding.DecodeString(encodedValue)
+		if err != nil || !utf8.Valid(rawValue) {
+			continue
+		}
+		fields[key] = string(rawValue)
+	}
+}
+
+// fieldProjection is a tree of the field paths kept by ProjectFields. A field mapped to nil is kept whole, and a
+// field mapped to a projection keeps only the nested fields in it.
+type fieldProjection map[string]fieldProjection
+
+// newFieldProjection builds the projection of the dot separated field paths. It returns nil if no paths are given.
+func newFieldProjection(paths []string) fieldProjection {
+	if len(paths) == 0 {
+		return nil
+	}
+	projection := fieldProjection{}
+	for _, path := range paths {
+		node := projection
+		parts := strings.Split(path, ".")
+		for ii, part := range parts {
+			child, exists := node[part]
+			// A parent field kept whole already includes every nested path.
+			if exists && child == nil {
+				break
+			}
+			if ii == len(parts)-1 {
+				node[part] = nil
+				break
+			}
+			if !exists {
+				child = fieldProjection{}
+				node[part] = child
+			}
+			node = child
+		}
+	}
+	return projection
+}
+
+// projectEntryFields returns a copy of the fields holding only the projected fields. Nested paths into values that
+// aren't objects are dropped.
+func projectEntryFields(fields map[string]interface{}, projection fieldProjection) map[string]interface{} {
+	projectedFields := make(map[string]interface{}, len(projection))
+	for key, nestedProjection := range projection {
+		value, exists := fields[key]
+		if !exists {
+			continue
+		}
+		if nestedProjection == nil {
+			projectedFields[key] = value
+			continue
+		}
+		if nestedFields, isMap := value.(map[string]interface{}); isMap {
+			projectedFields[key] = projectEntryFields(nestedFields, nestedProjection)
+		}
+	}
+	return projectedFields
+}
@@ -0,0 +1,269 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/deso-protocol/core/lib"
+)
+
+// newTestPostEntry returns a post upsert with extra data.
+func newTestPostEntry() *lib.StateChangeEntry {
+	return &lib.StateChangeEntry{
+		OperationType: lib.DbOperationTypeUpsert,
+		KeyBytes:      []byte("post-key"),
+		Encoder: &lib.PostEntry{
+			PosterPublicKey: []byte("poster"),
+			Body:            []byte(`{"Body":"hello"}`),
+			TimestampNanos:  1,
+			PostExtraData:   map[string][]byte{"email": []byte("a@b.c"), "app": []byte("web")},
+		},
+		EncoderType: lib.EncoderTypePostEntry,
+		BlockHeight: 1,
+	}
+}
+
+// newTestProfileEntry returns a profile upsert with extra data.
+func newTestProfileEntry() *lib.StateChangeEntry {
+	return &lib.StateChangeEntry{
+		OperationType: lib.DbOperationTypeUpsert,
+		KeyBytes:      []byte("profile-key"),
+		Encoder: &lib.ProfileEntry{
+			Username:  []byte("alice"),
+			ExtraData: map[string][]byte{"email": []byte("a@b.c"), "app": []byte("web")},
+		},
+		EncoderType: lib.EncoderTypeProfileEntry,
+		BlockHeight: 1,
+	}
+}
+
+// decodeTestBatch decodes a marshaled batch into generic entries, keeping numbers as json.Number.
+func decodeTestBatch(t *testing.T, data []byte) []map[string]interface{} {
+	t.Helper()
+	var entries []map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&entries); err != nil {
+		t.Fatalf("failed to decode batch %s: %v", data, err)
+	}
+	return entries
+}
+
+// fieldAt returns the value at the path of nested keys, and whether it exists.
+func fieldAt(fields map[string]interface{}, path ...string) (interface{}, bool) {
+	var value interface{} = fields
+	for _, key := range path {
+		nestedFields, isMap := value.(map[string]interface{})
+		if !isMap {
+			return nil, false
+		}
+		if value, isMap = nestedFields[key]; !isMap {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func TestRedactFields(t *testing.T) {
+	encodedWeb := base64.StdEncoding.EncodeToString([]byte("web"))
+	encodedPoster := base64.StdEncoding.EncodeToString([]byte("poster"))
+	tests := []struct {
+		name         string
+		entry        *lib.StateChangeEntry
+		redactFields []string
+		// wantRedacted are the paths whose values are replaced, wantKept the values of paths left as they were,
+		// and wantAbsent paths that aren't added.
+		wantRedacted [][]string
+		wantKept     map[string][]string
+		wantAbsent   [][]string
+	}{
+		{
+			name:         "top level field",
+			entry:        newTestPostEntry(),
+			redactFields: []string{"KeyBytes"},
+			wantRedacted: [][]string{{"KeyBytes"}},
+			wantKept:     map[string][]string{encodedPoster: {"Encoder", "PosterPublicKey"}},
+		},
+		{
+			name:         "entry field",
+			entry:        newTestPostEntry(),
+			redactFields: []string{"PosterPublicKey"},
+			wantRedacted: [][]string{{"Encoder", "PosterPublicKey"}},
+			wantKept:     map[string][]string{encodedWeb: {"Encoder", "PostExtraData", "app"}},
+		},
+		{
+			name:         "post extra data",
+			entry:        newTestPostEntry(),
+			redactFields: []string{"email"},
+			wantRedacted: [][]string{{"Encoder", "PostExtraData", "email"}},
+			wantKept:     map[string][]string{encodedWeb: {"Encoder", "PostExtraData", "app"}},
+		},
+		{
+			name:         "profile extra data",
+			entry:        newTestProfileEntry(),
+			redactFields: []string{"email"},
+			wantRedacted: [][]string{{"Encoder", "ExtraData", "email"}},
+			wantKept:     map[string][]string{encodedWeb: {"Encoder", "ExtraData", "app"}},
+		},
+		{
+			name:         "several fields",
+			entry:        newTestPostEntry(),
+			redactFields: []string{"email", "app", "Body"},
+			wantRedacted: [][]string{
+				{"Encoder", "PostExtraData", "email"}, {"Encoder", "PostExtraData", "app"}, {"Encoder", "Body"},
+			},
+		},
+		{
+			name:         "missing field",
+			entry:        newTestProfileEntry(),
+			redactFields: []string{"phone"},
+			wantKept:     map[string][]string{encodedWeb: {"Encoder", "ExtraData", "app"}},
+			wantAbsent:   [][]string{{"phone"}, {"Encoder", "phone"}, {"Encoder", "ExtraData", "phone"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh := NewWebHandler("", false, "", 0)
+			wh.RedactFields = tt.redactFields
+			data, err := wh.marshalBatch([]*lib.StateChangeEntry{tt.entry})
+			if err != nil {
+				t.Fatalf("marshalBatch: %v", err)
+			}
+			entry := decodeTestBatch(t, data)[0]
+
+			// Redacted keys stay present, with only their value masked.
+			for _, path := range tt.wantRedacted {
+				if value, exists := fieldAt(entry, path...); !exists || value != RedactedPlaceholder {
+					t.Errorf("%v = %v (present %v), want %q", path, value, exists, RedactedPlaceholder)
+				}
+			}
+			for want, path := range tt.wantKept {
+				if value, exists := fieldAt(entry, path...); !exists || value != want {
+					t.Errorf("%v = %v (present %v), want %q", path, value, exists, want)
+				}
+			}
+			for _, path := range tt.wantAbsent {
+				if value, exists := fieldAt(entry, path...); exists {
+					t.Errorf("%v = %v, want it absent", path, value)
+				}
+			}
+		})
+	}
+}
+
+func TestPassthroughFields(t *testing.T) {
+	const compressedText = `H4sIAAAAAAAA/<b>&amp;</b>==`
+	tests := []struct {
+		name              string
+		body              []byte
+		extraData         map[string][]byte
+		passthroughFields []string
+		// wantValues are the values expected at each path, as text.
+		wantValues map[string][]string
+	}{
+		{
+			name:              "entry field",
+			body:              []byte(compressedText),
+			passthroughFields: []string{"Body"},
+			wantValues:        map[string][]string{compressedText: {"Encoder", "Body"}},
+		},
+		{
+			name:              "extra data",
+			extraData:         map[string][]byte{"blob": []byte(compressedText)},
+			passthroughFields: []string{"blob"},
+			wantValues:        map[string][]string{compressedText: {"Encoder", "PostExtraData", "blob"}},
+		},
+		{
+			name:              "field not listed",
+			body:              []byte(compressedText),
+			passthroughFields: []string{"blob"},
+			wantValues: map[string][]string{
+				base64.StdEncoding.EncodeToString([]byte(compressedText)): {"Encoder", "Body"},
+			},
+		},
+		{
+			name:              "invalid UTF-8",
+			body:              []byte{0x1f, 0x8b, 0xff, 0xfe},
+			passthroughFields: []string{"Body"},
+			wantValues: map[string][]string{
+				base64.StdEncoding.EncodeToString([]byte{0x1f, 0x8b, 0xff, 0xfe}): {"Encoder", "Body"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := newTestPostEntry()
+			entry.Encoder.(*lib.PostEntry).Body = tt.body
+			entry.Encoder.(*lib.PostEntry).PostExtraData = tt.extraData
+			wh := NewWebHandler("", false, "", 0)
+			wh.PassthroughFields = tt.passthroughFields
+			data, err := wh.marshalBatch([]*lib.StateChangeEntry{entry})
+			if err != nil {
+				t.Fatalf("marshalBatch: %v", err)
+			}
+
+			fields := decodeTestBatch(t, data)[0]
+			for want, path := range tt.wantValues {
+				if value, _ := fieldAt(fields, path...); value != want {
+					t.Errorf("%v = %v, want %q", path, value, want)
+				}
+				// Passed through text is emitted byte for byte, without escaping HTML characters.
+				if want == compressedText && !bytes.Contains(data, []byte(`"`+compressedText+`"`)) {
+					t.Errorf("batch %s doesn't hold %s verbatim", data, compressedText)
+				}
+			}
+		})
+	}
+}
+
+func TestProjectFields(t *testing.T) {
+	tests := []struct {
+		name          string
+		projectFields []string
+		// wantEntry is the projected entry, as JSON.
+		wantEntry string
+	}{
+		{
+			name:          "top-level fields",
+			projectFields: []string{"KeyBytes", "BlockHeight"},
+			wantEntry:     `{"KeyBytes":"cG9zdC1rZXk=","BlockHeight":1}`,
+		},
+		{
+			name:          "nested path",
+			projectFields: []string{"BlockHeight", "Encoder.TimestampNanos", "Encoder.PostExtraData.app"},
+			wantEntry:     `{"BlockHeight":1,"Encoder":{"TimestampNanos":1,"PostExtraData":{"app":"d2Vi"}}}`,
+		},
+		{
+			name:          "parent kept whole",
+			projectFields: []string{"Encoder.PostExtraData.app", "Encoder.PostExtraData"},
+			wantEntry:     `{"Encoder":{"PostExtraData":{"app":"d2Vi","email":"YUBiLmM="}}}`,
+		},
+		{
+			name:          "nested path into a value that isn't an object",
+			projectFields: []string{"BlockHeight.Height", "KeyBytes"},
+			wantEntry:     `{"KeyBytes":"cG9zdC1rZXk="}`,
+		},
+		{
+			name:          "missing fields",
+			projectFields: []string{"Missing", "Encoder.Missing"},
+			wantEntry:     `{"Encoder":{}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh := NewWebHandler("", false, "", 0)
+			wh.ProjectFields = tt.projectFields
+			data, err := wh.marshalBatch([]*lib.StateChangeEntry{newTestPostEntry()})
+			if err != nil {
+				t.Fatalf("marshalBatch: %v", err)
+			}
+			wantFields := decodeTestBatch(t, []byte("["+tt.wantEntry+"]"))[0]
+			if fields := decodeTestBatch(t, data)[0]; !reflect.DeepEqual(fields, wantFields) {
+				t.Errorf("projected entry = %v, want %s", fields, tt.wantEntry)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/deso-protocol/core/lib"
+)
+
+// Ways of serializing the OperationType and EncoderType enums of entries.
+const (
+	// EnumFormatNumeric, the default, sends the enums as their numeric values, as emitted by the core encoders.
+	EnumFormatNumeric = "numeric"
+	// EnumFormatName replaces the numeric values with their names, e.g. UPSERT and PostEntry.
+	EnumFormatName = "name"
+	// EnumFormatBoth keeps the numeric values and adds their names under OperationTypeName and EncoderTypeName.
+	EnumFormatBoth = "both"
+)
+
+const (
+	entryOperationTypeKey = "OperationType"
+	entryEncoderTypeKey   = "EncoderType"
+	// enumNameKeySuffix is appended to the key of an enum to get the key of its name under EnumFormatBoth.
+	enumNameKeySuffix = "Name"
+)
+
+// operationTypeNames are the names of the state syncer operation types.
+var operationTypeNames = map[lib.StateSyncerOperationType]string{
+	lib.DbOperationTypeInsert: "INSERT",
+	lib.DbOperationTypeDelete: "DELETE",
+	lib.DbOperationTypeUpdate: "UPDATE",
+	lib.DbOperationTypeUpsert: "UPSERT",
+}
+
+// encoderTypeEnumNames are the names of the encoder types, which are the names of their constants in the core
+// library without the EncoderType prefix. They differ from the short names of encoderTypeNames, which are meant
+// for routing.
+var encoderTypeEnumNames = map[lib.EncoderType]string{
+	lib.EncoderTypePostEntry:                 "PostEntry",
+	lib.EncoderTypeProfileEntry:              "ProfileEntry",
+	lib.EncoderTypeLikeEntry:                 "LikeEntry",
+	lib.EncoderTypeDiamondEntry:              "DiamondEntry",
+	lib.EncoderTypeFollowEntry:               "FollowEntry",
+	lib.EncoderTypeMessageEntry:              "MessageEntry",
+	lib.EncoderTypeBalanceEntry:              "BalanceEntry",
+	lib.EncoderTypeNFTEntry:                  "NFTEntry",
+	lib.EncoderTypeNFTBidEntry:               "NFTBidEntry",
+	lib.EncoderTypeDerivedKeyEntry:           "DerivedKeyEntry",
+	lib.EncoderTypeAccessGroupEntry:          "AccessGroupEntry",
+	lib.EncoderTypeAccessGroupMemberEntry:    "AccessGroupMemberEntry",
+	lib.EncoderTypeNewMessageEntry:           "NewMessageEntry",
+	lib.EncoderTypeUserAssociationEntry:      "UserAssociationEntry",
+	lib.EncoderTypePostAssociationEntry:      "PostAssociationEntry",
+	lib.EncoderTypePKIDEntry:                 "PKIDEntry",
+	lib.EncoderTypeDeSoBalanceEntry:          "DeSoBalanceEntry",
+	lib.EncoderTypeDAOCoinLimitOrderEntry:    "DAOCoinLimitOrderEntry",
+	lib.EncoderTypeUtxoOperationBundle:       "UtxoOperationBundle",
+	lib.EncoderTypeBlock:                     "Block",
+	lib.EncoderTypeTxn:                       "Txn",
+	lib.EncoderTypeStakeEntry:                "StakeEntry",
+	lib.EncoderTypeValidatorEntry:            "ValidatorEntry",
+	lib.EncoderTypeLockedStakeEntry:          "LockedStakeEntry",
+	lib.EncoderTypeLockedBalanceEntry:        "LockedBalanceEntry",
+	lib.EncoderTypeLockupYieldCurvePoint:     "LockupYieldCurvePoint",
+	lib.EncoderTypeEpochEntry:                "EpochEntry",
+	lib.EncoderTypePKID:                      "PKID",
+	lib.EncoderTypeGlobalParamsEntry:         "GlobalParamsEntry",
+	lib.EncoderTypeBLSPublicKeyPKIDPairEntry: "BLSPublicKeyPKIDPairEntry",
+	lib.EncoderTypeBlockNode:                 "BlockNode",
+}
+
+// OperationTypeName returns the name of the operation type, e.g. UPSERT. Unknown operation types are named after
+// their number.
+func OperationTypeName(operationType lib.StateSyncerOperationType) string {
+	if name, exists := operationTypeNames[operationType]; exists {
+		return name
+	}
+	return fmt.Sprintf("OPERATION_TYPE_%d", operationType)
+}
+
+// EncoderTypeEnumName returns the name of the encoder type, e.g. PostEntry. Unknown encoder types are named after
+// their number.
+func EncoderTypeEnumName(encoderType lib.EncoderType) string {
+	if name, exists := encoderTypeEnumNames[encoderType]; exists {
+		return name
+	}
+	return fmt.Sprintf("EncoderType%d", encoderType)
+}
+
+// nameEntryEnums serializes the entry's OperationType and EncoderType as configured by enumFormat.
+func nameEntryEnums(fields map[string]interface{}, enumFormat string) error {
+	if enumFormat == "" || enumFormat == EnumFormatNumeric {
+		return nil
+	}
+	if enumFormat != EnumFormatName && enumFormat != EnumFormatBoth {
+		return fmt.Errorf("nameEntryEnums: unknown enum format %q", enumFormat)
+	}
+
+	enumNamers := map[string]func(value uint64) string{
+		entryOperationTypeKey: func(value uint64) string {
+			return OperationTypeName(lib.StateSyncerOperationType(value))
+		},
+		entryEncoderTypeKey: func(value uint64) string {
+			return EncoderTypeEnumName(lib.EncoderType(value))
+		},
+	}
+	for key, enumName := range enumNamers {
+		number, isNumber := fields[key].(json.Number)
+		if !isNumber {
+			continue
+		}
+		value, err := strconv.ParseUint(number.String(), 10, 64)
+		if err != nil {
+			return fmt.Errorf("nameEntryEnums: invalid %s %s", key, number)
+		}
+		if enumFormat == EnumFormatName {
+			fields[key] = enumName(value)
+		} else {
+			fields[key+enumNameKeySuffix] = enumName(value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/deso-protocol/core/lib"
+)
+
+func TestEnumFormat(t *testing.T) {
+	deleteEntry := newTestEntries(1, 1)[0]
+	deleteEntry.OperationType = lib.DbOperationTypeDelete
+	entries := []*lib.StateChangeEntry{newTestPostEntry(), newTestProfileEntry(), deleteEntry}
+	// The numeric and named OperationType and EncoderType of each entry.
+	numbers := []string{
+		fmt.Sprint(uint64(lib.DbOperationTypeUpsert)), fmt.Sprint(uint64(lib.EncoderTypePostEntry)),
+		fmt.Sprint(uint64(lib.DbOperationTypeUpsert)), fmt.Sprint(uint64(lib.EncoderTypeProfileEntry)),
+		fmt.Sprint(uint64(lib.DbOperationTypeDelete)), fmt.Sprint(uint64(lib.EncoderTypeLikeEntry)),
+	}
+	names := []string{"UPSERT", "PostEntry", "UPSERT", "ProfileEntry", "DELETE", "LikeEntry"}
+
+	tests := []struct {
+		name       string
+		enumFormat string
+		// wantEnums are the OperationType and EncoderType of each entry, and wantNames their names under the keys
+		// with the Name suffix, which are absent when nil.
+		wantEnums []string
+		wantNames []string
+	}{
+		{name: "default", wantEnums: numbers},
+		{name: "numeric", enumFormat: EnumFormatNumeric, wantEnums: numbers},
+		{name: "name", enumFormat: EnumFormatName, wantEnums: names},
+		{name: "both", enumFormat: EnumFormatBoth, wantEnums: numbers, wantNames: names},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh := NewWebHandler("", false, "", 0)
+			wh.EnumFormat = tt.enumFormat
+			data, err := wh.marshalBatch(entries)
+			if err != nil {
+				t.Fatalf("marshalBatch: %v", err)
+			}
+
+			for ii, fields := range decodeTestBatch(t, data) {
+				for jj, key := range []string{entryOperationTypeKey, entryEncoderTypeKey} {
+					if value := fmt.Sprint(fields[key]); value != tt.wantEnums[2*ii+jj] {
+						t.Errorf("entry %d %s = %s, want %s", ii, key, value, tt.wantEnums[2*ii+jj])
+					}
+					name, exists := fields[key+enumNameKeySuffix]
+					if tt.wantNames == nil {
+						if exists {
+							t.Errorf("entry %d has %s %v, want none", ii, key+enumNameKeySuffix, name)
+						}
+					} else if name != tt.wantNames[2*ii+jj] {
+						t.Errorf("entry %d %s = %v, want %s", ii, key+enumNameKeySuffix, name, tt.wantNames[2*ii+jj])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEnumFormatUnknown(t *testing.T) {
+	wh := NewWebHandler("", false, "", 0)
+	wh.EnumFormat = "roman"
+	if _, err := wh.marshalBatch(newTestEntries(1, 1)); err == nil || !strings.Contains(err.Error(), "roman") {
+		t.Errorf("marshalBatch = %v, want an unknown enum format error", err)
+	}
+}
+
+func TestEnumNames(t *testing.T) {
+	tests := []struct {
+		name     string
+		gotName  string
+		wantName string
+	}{
+		{name: "insert", gotName: OperationTypeName(lib.DbOperationTypeInsert), wantName: "INSERT"},
+		{name: "update", gotName: OperationTypeName(lib.DbOperationTypeUpdate), wantName: "UPDATE"},
+		{name: "unknown operation type", gotName: OperationTypeName(200), wantName: "OPERATION_TYPE_200"},
+		{name: "NFT entry", gotName: EncoderTypeEnumName(lib.EncoderTypeNFTEntry), wantName: "NFTEntry"},
+		{name: "block", gotName: EncoderTypeEnumName(lib.EncoderTypeBlock), wantName: "Block"},
+		{name: "unknown encoder type", gotName: EncoderTypeEnumName(60000), wantName: "EncoderType60000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.gotName != tt.wantName {
+				t.Errorf("name = %q, want %q", tt.gotName, tt.wantName)
+			}
+		})
+	}
+	// The names are unique, so that they identify the enum values.
+	seen := map[string]bool{}
+	for encoderType, name := range encoderTypeEnumNames {
+		if seen[name] {
+			t.Errorf("encoder type %d has the name %s of another encoder type", encoderType, name)
+		}
+		seen[name] = true
+	}
+}
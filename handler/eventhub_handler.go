@@ -0,0 +1,249 @@
+package handler
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/deso-protocol/state-consumer/consumer"
+	"github.com/pkg/errors"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+const (
+	// HandlerTypeEventHub is the handler type of EventHubHandler.
+	HandlerTypeEventHub = "eventhub"
+
+	// eventHubKafkaPort is the port of the Kafka endpoint of an Event Hubs namespace.
+	eventHubKafkaPort = "9093"
+	// eventHubSASLUsername is the SASL PLAIN username that authenticates with the connection string as the password.
+	eventHubSASLUsername = "$ConnectionString"
+	// eventHubMaxBatchBytes keeps every produce request below the 1MB Event Hubs request size limit.
+	eventHubMaxBatchBytes = 1000 * 1000
+	// eventHubBatchTimeout is how long the writer waits for more events before sending a batch that isn't full.
+	// WriteMessages returns once its last batch is sent, so it's kept short.
+	eventHubBatchTimeout = 10 * time.Millisecond
+	// eventHubWriteTimeout is how long a produce request may take before it is retried.
+	eventHubWriteTimeout = 30 * time.Second
+)
+
+// EventHubHandler is a handler that sends every entry as an event to an Azure Event Hub through the namespace's
+// Kafka endpoint, with kafka-go. Batches are sent synchronously and every partition leader must acknowledge its
+// events, so an entry batch is only acknowledged once Event Hubs has accepted it.
+//
+// The handler authenticates with SASL PLAIN over TLS, using the connection string as the password. Azure AD
+// credentials aren't supported. The writer splits an entry batch into produce requests below the 1MB request size
+// limit from the size of every event, and an event over the limit fails the batch. Events are assigned to
+// partitions by hashing their partition key, so the events of a key stay in order.
+type EventHubHandler struct {
+	// Endpoint is the host and port of the namespace's Kafka endpoint, e.g. my-namespace.servicebus.windows.net:9093.
+	Endpoint string
+	// EventHubName is the name of the Event Hub events are sent to, which is the Kafka topic.
+	EventHubName string
+	// ConnectionString is the connection string of the shared access policy used to authenticate.
+	ConnectionString string
+
+	// Params are the params of the network the handler is consuming. They default to mainnet.
+	Params *lib.DeSoParams
+	// NetworkPrefix overrides the network name attached to every event. When empty, the name of the network
+	// from Params is used.
+	NetworkPrefix string
+
+	// MinBlockHeight is the minimum block height required before sending any data.
+	MinBlockHeight uint64
+
+	// PartitionKey returns the partition key of an entry's event. It defaults to KeyByEncoderType.
+	PartitionKey KeyExtractor
+
+	// writer produces the events. It is a kafka.Writer unless replaced to fake Event Hubs.
+	writer eventHubWriter
+
+	// txnErr is the first send error since the current transaction was initiated.
+	txnErr error
+}
+
+var _ DataHandler = (*EventHubHandler)(nil)
+
+// eventHubWriter is the part of kafka.Writer used by EventHubHandler.
+type eventHubWriter interface {
+	WriteMessages(ctx context.Context, messages ...kafka.Message) error
+	Close() error
+}
+
+// NewEventHubHandler returns a handler for the Event Hub described by the connection string. The eventHubName
+// may be empty if the connection string has an EntityPath. The connection is made on the first batch.
+func NewEventHubHandler(connectionString, eventHubName string, params *lib.DeSoParams) (*EventHubHandler, error) {
+	eh := &EventHubHandler{
+		EventHubName:     eventHubName,
+		ConnectionString: connectionString,
+		Params:           params,
+	}
+	hasKey := map[string]bool{}
+	for _, part := range strings.Split(connectionString, ";") {
+		keyValue := strings.SplitN(part, "=", 2)
+		if len(keyValue) != 2 {
+			continue
+		}
+		hasKey[keyValue[0]] = keyValue[1] != ""
+		switch keyValue[0] {
+		case "Endpoint":
+			endpoint, err := url.Parse(keyValue[1])
+			if err != nil || endpoint.Hostname() == "" {
+				return nil, fmt.Errorf("NewEventHubHandler: invalid Endpoint %q in connection string", keyValue[1])
+			}
+			eh.Endpoint = net.JoinHostPort(endpoint.Hostname(), eventHubKafkaPort)
+		case "EntityPath":
+			if eh.EventHubName == "" {
+				eh.EventHubName = keyValue[1]
+			}
+		}
+	}
+
+	if eh.Endpoint == "" || !hasKey["SharedAccessKeyName"] || !hasKey["SharedAccessKey"] {
+		return nil, fmt.Errorf(
+			"NewEventHubHandler: connection string must have an Endpoint, SharedAccessKeyName and SharedAccessKey")
+	}
+	if eh.EventHubName == "" {
+		return nil, fmt.Errorf("NewEventHubHandler: no Event Hub name configured")
+	}
+	eh.writer = eh.newWriter()
+	return eh, nil
+}
+
+// newWriter returns a writer that produces to the Event Hub through the Kafka endpoint.
+func (eh *EventHubHandler) newWriter() *kafka.Writer {
+	return &kafka.Writer{
+		Addr:         kafka.TCP(eh.Endpoint),
+		Topic:        eh.EventHubName,
+		Balancer:     &kafka.Hash{},
+		BatchBytes:   eventHubMaxBatchBytes,
+		BatchTimeout: eventHubBatchTimeout,
+		WriteTimeout: eventHubWriteTimeout,
+		RequiredAcks: kafka.RequireAll,
+		Transport: &kafka.Transport{
+			TLS: &tls.Config{MinVersion: tls.VersionTLS12},
+			SASL: plain.Mechanism{
+				Username: eventHubSASLUsername,
+				Password: eh.ConnectionString,
+			},
+		},
+	}
+}
+
+// newEventHubHandlerFromConfig creates an EventHubHandler from the EVENTHUB_CONNECTION_STRING and EVENTHUB_NAME config.
+func newEventHubHandlerFromConfig(params *lib.DeSoParams, getConfig ConfigGetter) (DataHandler, error) {
+	eh, err := NewEventHubHandler(getConfig("EVENTHUB_CONNECTION_STRING"), getConfig("EVENTHUB_NAME"), params)
+	if err != nil {
+		return nil, err
+	}
+	eh.NetworkPrefix = getConfig("NETWORK_PREFIX")
+	if eh.PartitionKey, err = GetKeyExtractor(getConfig("EVENTHUB_PARTITION_KEY")); err != nil {
+		return nil, err
+	}
+	if eh.MinBlockHeight, err = getMinBlockHeightConfig(getConfig); err != nil {
+		return nil, err
+	}
+	return eh, nil
+}
+
+func (eh *EventHubHandler) CommitTransaction() error {
+	// Every batch is sent synchronously, so all that's left is to surface a failed send so the caller rolls back.
+	err := eh.txnErr
+	eh.txnErr = nil
+	if err != nil {
+		return errors.Wrap(err, "EventHubHandler.CommitTransaction: batch was not delivered")
+	}
+	return nil
+}
+
+func (eh *EventHubHandler) GetParams() *lib.DeSoParams {
+	// Default to mainnet if no params were configured.
+	if eh.Params == nil {
+		return &lib.DeSoMainnetParams
+	}
+	return eh.Params
+}
+
+func (eh *EventHubHandler) HandleSyncEvent(syncEvent consumer.SyncEvent) error {
+	// No sync event handling needed.
+	return nil
+}
+
+func (eh *EventHubHandler) InitiateTransaction() error {
+	eh.txnErr = nil
+	return nil
+}
+
+func (eh *EventHubHandler) RollbackTransaction() error {
+	// Events that Event Hubs has accepted can't be recalled, so just reset the transaction.
+	eh.txnErr = nil
+	return nil
+}
+
+// HandleEntryBatch sends every entry in the batch as an event to the Event Hub.
+// If the block height of the first entry is below MinBlockHeight, the batch is skipped.
+func (eh *EventHubHandler) HandleEntryBatch(batchedEntries []*lib.StateChangeEntry) error {
+	if len(batchedEntries) == 0 {
+		return fmt.Errorf("EventHubHandler.HandleEntryBatch: no entries to send")
+	}
+	if batchedEntries[0].BlockHeight < eh.MinBlockHeight {
+		return nil
+	}
+
+	err := eh.sendEntries(batchedEntries)
+	if err != nil && eh.txnErr == nil {
+		eh.txnErr = err
+	}
+	return err
+}
+
+// Close closes the writer and its connections to Event Hubs.
+func (eh *EventHubHandler) Close() error {
+	if err := eh.writer.Close(); err != nil {
+		return errors.Wrap(err, "EventHubHandler.Close: failed to close writer")
+	}
+	return nil
+}
+
+// sendEntries converts the entries to events and sends them, waiting for Event Hubs to accept every one of them.
+func (eh *EventHubHandler) sendEntries(batchedEntries []*lib.StateChangeEntry) error {
+	partitionKey := eh.PartitionKey
+	if partitionKey == nil {
+		partitionKey = KeyByEncoderType
+	}
+	network := networkPrefix(eh.GetParams(), eh.NetworkPrefix)
+
+	messages := make([]kafka.Message, 0, len(batchedEntries))
+	for _, entry := range batchedEntries {
+		body, err := json.Marshal(entry)
+		if err != nil {
+			return errors.Wrap(err, "EventHubHandler.sendEntries: failed to marshal entry")
+		}
+		messages = append(messages, kafka.Message{
+			Key:   []byte(partitionKey(entry)),
+			Value: body,
+			Headers: []kafka.Header{
+				{Key: "network", Value: []byte(network)},
+				{Key: "encoder_type", Value: []byte(strconv.FormatUint(uint64(entry.EncoderType), 10))},
+			},
+		})
+	}
+
+	if err := eh.writer.WriteMessages(context.Background(), messages...); err != nil {
+		var tooLarge kafka.MessageTooLargeError
+		if errors.As(err, &tooLarge) {
+			return errors.Wrapf(err, "EventHubHandler.sendEntries: event with key %s is over the %d byte limit",
+				tooLarge.Message.Key, eventHubMaxBatchBytes)
+		}
+		return errors.Wrapf(err, "EventHubHandler.sendEntries: failed to send events to %s", eh.EventHubName)
+	}
+	return nil
+}
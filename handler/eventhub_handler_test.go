@@ -0,0 +1,248 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/segmentio/kafka-go"
+	"github.com/segmentio/kafka-go/sasl/plain"
+)
+
+const testEventHubConnectionString = "Endpoint=sb://deso.servicebus.windows.net/;SharedAccessKeyName=send;" +
+	"SharedAccessKey=c2VjcmV0"
+
+// fakeEventHubWriter records the events written to it, and fails the writes while err is set.
+type fakeEventHubWriter struct {
+	mtx      sync.Mutex
+	err      error
+	messages []kafka.Message
+	closed   bool
+}
+
+func (writer *fakeEventHubWriter) WriteMessages(ctx context.Context, messages ...kafka.Message) error {
+	writer.mtx.Lock()
+	defer writer.mtx.Unlock()
+	if writer.err != nil {
+		return writer.err
+	}
+	writer.messages = append(writer.messages, messages...)
+	return nil
+}
+
+func (writer *fakeEventHubWriter) Close() error {
+	writer.mtx.Lock()
+	defer writer.mtx.Unlock()
+	writer.closed = true
+	return nil
+}
+
+func TestNewEventHubHandler(t *testing.T) {
+	tests := []struct {
+		name             string
+		connectionString string
+		eventHubName     string
+		wantEndpoint     string
+		wantEventHubName string
+		wantErr          string
+	}{
+		{
+			name:             "event hub name",
+			connectionString: testEventHubConnectionString,
+			eventHubName:     "deso",
+			wantEndpoint:     "deso.servicebus.windows.net:9093",
+			wantEventHubName: "deso",
+		},
+		{
+			name:             "entity path",
+			connectionString: testEventHubConnectionString + ";EntityPath=entries",
+			wantEndpoint:     "deso.servicebus.windows.net:9093",
+			wantEventHubName: "entries",
+		},
+		{
+			name:             "event hub name overrides entity path",
+			connectionString: testEventHubConnectionString + ";EntityPath=entries",
+			eventHubName:     "deso",
+			wantEndpoint:     "deso.servicebus.windows.net:9093",
+			wantEventHubName: "deso",
+		},
+		{
+			name:             "no event hub name",
+			connectionString: testEventHubConnectionString,
+			wantErr:          "no Event Hub name",
+		},
+		{
+			name:             "no key",
+			connectionString: "Endpoint=sb://deso.servicebus.windows.net/;SharedAccessKeyName=send",
+			eventHubName:     "deso",
+			wantErr:          "must have an Endpoint, SharedAccessKeyName and SharedAccessKey",
+		},
+		{
+			name:             "no endpoint",
+			connectionString: "SharedAccessKeyName=send;SharedAccessKey=c2VjcmV0",
+			eventHubName:     "deso",
+			wantErr:          "must have an Endpoint, SharedAccessKeyName and SharedAccessKey",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eh, err := NewEventHubHandler(tt.connectionString, tt.eventHubName, nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("NewEventHubHandler = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("NewEventHubHandler: %v", err)
+			}
+			if eh.Endpoint != tt.wantEndpoint || eh.EventHubName != tt.wantEventHubName {
+				t.Errorf("endpoint, event hub = %s, %s, want %s, %s", eh.Endpoint, eh.EventHubName,
+					tt.wantEndpoint, tt.wantEventHubName)
+			}
+
+			// The writer produces to the Event Hub's topic, authenticating with the connection string.
+			writer := eh.writer.(*kafka.Writer)
+			if writer.Addr.String() != tt.wantEndpoint || writer.Topic != tt.wantEventHubName {
+				t.Errorf("writer address, topic = %s, %s, want %s, %s", writer.Addr, writer.Topic,
+					tt.wantEndpoint, tt.wantEventHubName)
+			}
+			transport := writer.Transport.(*kafka.Transport)
+			wantSASL := plain.Mechanism{Username: "$ConnectionString", Password: tt.connectionString}
+			if transport.SASL != wantSASL || transport.TLS == nil {
+				t.Errorf("writer SASL = %+v with TLS %v, want %+v over TLS", transport.SASL, transport.TLS != nil,
+					wantSASL)
+			}
+			if writer.RequiredAcks != kafka.RequireAll {
+				t.Errorf("writer RequiredAcks = %v, want RequireAll", writer.RequiredAcks)
+			}
+		})
+	}
+}
+
+func TestEventHubHandlerSendsEvents(t *testing.T) {
+	eh, err := NewEventHubHandler(testEventHubConnectionString, "deso", nil)
+	if err != nil {
+		t.Fatalf("NewEventHubHandler: %v", err)
+	}
+	eh.NetworkPrefix = "test"
+	writer := &fakeEventHubWriter{}
+	eh.writer = writer
+
+	eh.InitiateTransaction()
+	if err = eh.HandleEntryBatch(newTestEntries(2, 1)); err != nil {
+		t.Fatalf("HandleEntryBatch: %v", err)
+	}
+	if err = eh.CommitTransaction(); err != nil {
+		t.Fatalf("CommitTransaction: %v", err)
+	}
+
+	if len(writer.messages) != 2 {
+		t.Fatalf("writer received %d events, want 2", len(writer.messages))
+	}
+	likeType := strconv.FormatUint(uint64(lib.EncoderTypeLikeEntry), 10)
+	wantHeaders := []kafka.Header{
+		{Key: "network", Value: []byte("test")},
+		{Key: "encoder_type", Value: []byte(likeType)},
+	}
+	for _, message := range writer.messages {
+		// The events are keyed by encoder type by default.
+		if string(message.Key) != likeType {
+			t.Errorf("event key = %s, want %s", message.Key, likeType)
+		}
+		if !reflect.DeepEqual(message.Headers, wantHeaders) {
+			t.Errorf("event headers = %v, want %v", message.Headers, wantHeaders)
+		}
+	}
+
+	if err = eh.Close(); err != nil || !writer.closed {
+		t.Errorf("Close = %v with the writer closed %v, want the writer closed", err, writer.closed)
+	}
+}
+
+func TestEventHubHandlerSendError(t *testing.T) {
+	eh, err := NewEventHubHandler(testEventHubConnectionString, "deso", nil)
+	if err != nil {
+		t.Fatalf("NewEventHubHandler: %v", err)
+	}
+	writer := &fakeEventHubWriter{err: kafka.WriteErrors{nil, kafka.NotLeaderForPartition}}
+	eh.writer = writer
+
+	eh.InitiateTransaction()
+	err = eh.HandleEntryBatch(newTestEntries(2, 1))
+	if err == nil || !strings.Contains(err.Error(), "failed to send events to deso") {
+		t.Errorf("HandleEntryBatch = %v, want the write error", err)
+	}
+	if err = eh.CommitTransaction(); err == nil || !strings.Contains(err.Error(), "batch was not delivered") {
+		t.Errorf("CommitTransaction = %v, want the batch to fail", err)
+	}
+	eh.RollbackTransaction()
+
+	// The next transaction starts afresh.
+	writer.err = nil
+	eh.InitiateTransaction()
+	if err = eh.HandleEntryBatch(newTestEntries(1, 2)); err != nil {
+		t.Fatalf("HandleEntryBatch(2): %v", err)
+	}
+	if err = eh.CommitTransaction(); err != nil {
+		t.Errorf("CommitTransaction of the next transaction: %v", err)
+	}
+}
+
+func TestEventHubHandlerEventTooLarge(t *testing.T) {
+	tests := []struct {
+		name         string
+		encoderBytes int
+		wantErr      bool
+	}{
+		{name: "below the limit", encoderBytes: eventHubMaxBatchBytes / 2},
+		// The event's JSON encodes the encoder bytes in base64, which makes it larger than the limit even though
+		// the raw bytes are below it.
+		{name: "over the limit once marshaled", encoderBytes: eventHubMaxBatchBytes * 9 / 10, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eh, err := NewEventHubHandler(testEventHubConnectionString, "deso", nil)
+			if err != nil {
+				t.Fatalf("NewEventHubHandler: %v", err)
+			}
+			defer eh.Close()
+			writer := eh.writer.(*kafka.Writer)
+			// Only check the size against the real writer, which it does before connecting.
+			eh.writer = &sizeCheckingEventHubWriter{Writer: writer}
+
+			entries := newTestEntries(1, 1)
+			entries[0].EncoderBytes = make([]byte, tt.encoderBytes)
+			err = eh.HandleEntryBatch(entries)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("HandleEntryBatch = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr && !strings.Contains(err.Error(), "is over the 1000000 byte limit") {
+				t.Errorf("HandleEntryBatch = %v, want the event to be over the limit", err)
+			}
+		})
+	}
+}
+
+// sizeCheckingEventHubWriter rejects the events the kafka.Writer rejects as too large, and accepts the others
+// without sending them.
+type sizeCheckingEventHubWriter struct {
+	*kafka.Writer
+}
+
+func (writer *sizeCheckingEventHubWriter) WriteMessages(ctx context.Context, messages ...kafka.Message) error {
+	// A cancelled context fails the write as soon as it needs the broker, after the size check.
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	err := writer.Writer.WriteMessages(cancelled, messages...)
+	var tooLarge kafka.MessageTooLargeError
+	if errors.As(err, &tooLarge) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/deso-protocol/state-consumer/consumer"
+	"github.com/pkg/errors"
+)
+
+// DataHandler is the interface every handler in the package implements, so that it can be run by the state
+// consumer. It is an alias of consumer.StateSyncerDataHandler, and each handler asserts that it implements it, so
+// that a change to the consumer's interface breaks the build rather than the wiring in main.
+type DataHandler = consumer.StateSyncerDataHandler
+
+// HandlerTypeWeb is the default handler type, served by WebHandler.
+const HandlerTypeWeb = "web"
+
+// ConfigGetter returns the string value of a config key, e.g. viper.GetString.
+type ConfigGetter func(key string) string
+
+// HandlerFactory creates a data handler for the network described by params, reading its own settings with
+// getConfig.
+type HandlerFactory func(params *lib.DeSoParams, getConfig ConfigGetter) (DataHandler, error)
+
+// handlerFactories maps handler types to the factories that create them.
+var handlerFactories = map[string]HandlerFactory{
+	HandlerTypeEventHub: newEventHubHandlerFromConfig,
+	HandlerTypeRabbitMQ: newRabbitMQHandlerFromConfig,
+	HandlerTypePulsar:   newPulsarHandlerFromConfig,
+	HandlerTypeTCP:      newTCPHandlerFromConfig,
+}
+
+// NewDataHandler creates a data handler of the given type.
+func NewDataHandler(handlerType string, params *lib.DeSoParams, getConfig ConfigGetter) (DataHandler, error) {
+	factory, exists := handlerFactories[handlerType]
+	if !exists {
+		return nil, fmt.Errorf("NewDataHandler: unknown handler type %s", handlerType)
+	}
+	return factory(params, getConfig)
+}
+
+// getMinBlockHeightConfig returns the MIN_BLOCK_HEIGHT config value, or zero if it is not set.
+func getMinBlockHeightConfig(getConfig ConfigGetter) (uint64, error) {
+	minBlockHeight := getConfig("MIN_BLOCK_HEIGHT")
+	if minBlockHeight == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseUint(minBlockHeight, 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "getMinBlockHeightConfig: invalid MIN_BLOCK_HEIGHT %s", minBlockHeight)
+	}
+	return value, nil
+}
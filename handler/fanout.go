@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// DefaultFanOutQueueSize is the number of batches queued for each fan-out subscriber when no FanOutQueueSize is
+// configured.
+const DefaultFanOutQueueSize = 100
+
+// fanOutBatch is a transformed batch queued for a fan-out subscriber.
+type fanOutBatch struct {
+	jsonData    []byte
+	entryCount  int
+	blockHeight uint64
+	lastHeight  uint64
+	// enqueuedAt is when the batch was queued, to expire it after BatchTTL.
+	enqueuedAt time.Time
+}
+
+// fanOutTarget is a fan-out subscriber with its own queue and delivery state.
+type fanOutTarget struct {
+	url   string
+	index int
+	queue chan fanOutBatch
+
+	batchesSent     atomic.Uint64
+	batchesFailed   atomic.Uint64
+	batchesRejected atomic.Uint64
+}
+
+// FanOutTargetStatus is the state of a fan-out subscriber reported by the admin /status endpoint.
+type FanOutTargetStatus struct {
+	URL             string `json:"url"`
+	Queued          int    `json:"queued"`
+	BatchesSent     uint64 `json:"batches_sent"`
+	BatchesFailed   uint64 `json:"batches_failed"`
+	BatchesRejected uint64 `json:"batches_rejected"`
+}
+
+// getFanOutTargets returns the fan-out subscribers, starting their workers on first use.
+func (wh *WebHandler) getFanOutTargets() []*fanOutTarget {
+	wh.fanOutTargetsOnce.Do(func() {
+		queueSize := wh.FanOutQueueSize
+		if queueSize <= 0 {
+			queueSize = DefaultFanOutQueueSize
+		}
+		for ii, url := range wh.FanOutURLs {
+			target := &fanOutTarget{url: url, index: ii, queue: make(chan fanOutBatch, queueSize)}
+			wh.fanOutTargets = append(wh.fanOutTargets, target)
+			go wh.runFanOutTarget(target)
+		}
+	})
+	return wh.fanOutTargets
+}
+
+// fanOutBatch queues the batch for every fan-out subscriber without waiting for it to be sent. A subscriber whose
+// queue is full rejects the batch, which is dead-lettered for it. The batch succeeds if at least one subscriber
+// queued it, and fails only if every subscriber rejected it, in which case no subscriber received it and it can
+// safely be retried.
+func (wh *WebHandler) fanOutBatch(batchedEntries []*lib.StateChangeEntry) error {
+	jsonData, err := wh.marshalBatch(batchedEntries)
+	if err != nil {
+		return errors.Wrap(err, "WebHandler.fanOutBatch: failed to marshal batch")
+	}
+	batch := fanOutBatch{
+		jsonData:    jsonData,
+		entryCount:  len(batchedEntries),
+		blockHeight: batchedEntries[0].BlockHeight,
+		lastHeight:  batchedEntries[len(batchedEntries)-1].BlockHeight,
+		enqueuedAt:  time.Now(),
+	}
+
+	var rejectedTargets []*fanOutTarget
+	targets := wh.getFanOutTargets()
+	for _, target := range targets {
+		select {
+		case target.queue <- batch:
+		default:
+			rejectedTargets = append(rejectedTargets, target)
+		}
+	}
+	if len(rejectedTargets) == len(targets) {
+		return fmt.Errorf("WebHandler.fanOutBatch: queues of all %d fan-out subscribers are full", len(targets))
+	}
+	for _, target := range rejectedTargets {
+		target.batchesRejected.Add(1)
+		wh.failFanOutBatch(target, batch, 0, fmt.Errorf("queue is full"))
+	}
+	return nil
+}
+
+// runFanOutTarget sends the batches queued for the subscriber, retrying each up to MaxDeliveryAttempts times.
+// Batches that were queued longer than BatchTTL are dropped instead of sent.
+func (wh *WebHandler) runFanOutTarget(target *fanOutTarget) {
+	maxAttempts := wh.MaxDeliveryAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxDeliveryAttempts
+	}
+
+	var expiredCount int
+	for batch := range target.queue {
+		if wh.batchExpired(batch.enqueuedAt) {
+			expiredCount++
+			wh.metrics.batchesExpired.Add(1)
+			// Log the count once the queued expired batches are drained rather than once per batch.
+			if len(target.queue) == 0 {
+				glog.Errorf("WebHandler.runFanOutTarget: dropped %d batches for %s older than %v", expiredCount,
+					target.url, wh.BatchTTL)
+				expiredCount = 0
+			}
+			continue
+		}
+		if expiredCount > 0 {
+			glog.Errorf("WebHandler.runFanOutTarget: dropped %d batches for %s older than %v", expiredCount,
+				target.url, wh.BatchTTL)
+			expiredCount = 0
+		}
+
+		var err error
+		attempts := 0
+		backoff := deliveryRetryBackoff
+		for attempt := 1; attempt <= maxAttempts; attempt++ {
+			if attempt > 1 {
+				if err = wh.sleep(backoff); err != nil {
+					break
+				}
+				backoff *= 2
+			}
+			attempts++
+			if err = wh.postToURL(target.url, batch.jsonData, wh.batchTimeout(batch.entryCount)); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			target.batchesFailed.Add(1)
+			wh.failFanOutBatch(target, batch, attempts, err)
+			continue
+		}
+		target.batchesSent.Add(1)
+	}
+}
+
+// failFanOutBatch dead-letters a batch that couldn't be delivered to the subscriber, in a subdirectory of
+// DeadLetterDir named after the subscriber's index in FanOutURLs, or drops it if no DeadLetterDir is configured.
+func (wh *WebHandler) failFanOutBatch(target *fanOutTarget, batch fanOutBatch, attempts int, err error) {
+	if wh.DeadLetterDir == "" {
+		glog.Errorf("WebHandler.failFanOutBatch: dropping batch of %d entries at height %d for %s: %v",
+			batch.entryCount, batch.blockHeight, target.url, err)
+		return
+	}
+	dir := filepath.Join(wh.DeadLetterDir, fmt.Sprintf("fanout-%d", target.index))
+	path, dlErr := writeDeadLetterFile(dir, batch.jsonData, DeadLetterMetadata{
+		FirstBlockHeight: batch.blockHeight,
+		LastBlockHeight:  batch.lastHeight,
+		EntryCount:       batch.entryCount,
+		Attempts:         attempts,
+		LastError:        err.Error(),
+		URL:              target.url,
+	})
+	if dlErr != nil {
+		glog.Errorf("WebHandler.failFanOutBatch: dropping batch of %d entries at height %d for %s: %v, and %v",
+			batch.entryCount, batch.blockHeight, target.url, err, dlErr)
+		return
+	}
+	wh.metrics.batchesDeadLettered.Add(1)
+	glog.Errorf("WebHandler.failFanOutBatch: wrote batch of %d entries for %s to %s: %v",
+		batch.entryCount, target.url, path, err)
+}
+
+// fanOutStatus returns the state of every fan-out subscriber.
+func (wh *WebHandler) fanOutStatus() []FanOutTargetStatus {
+	if len(wh.FanOutURLs) == 0 {
+		return nil
+	}
+	var statuses []FanOutTargetStatus
+	for _, target := range wh.getFanOutTargets() {
+		statuses = append(statuses, FanOutTargetStatus{
+			URL:             target.url,
+			Queued:          len(target.queue),
+			BatchesSent:     target.batchesSent.Load(),
+			BatchesFailed:   target.batchesFailed.Load(),
+			BatchesRejected: target.batchesRejected.Load(),
+		})
+	}
+	return statuses
+}
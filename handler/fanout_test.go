@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// Behaviours of the fan-out subscribers of TestFanOutFailureIsolation.
+const (
+	subscriberHealthy = "healthy"
+	subscriberFailing = "failing"
+	// subscriberStuck never responds, so its worker is stuck on the first batch.
+	subscriberStuck = "stuck"
+)
+
+// newFanOutSubscriber starts a subscriber with the behaviour and returns its URL and request count.
+func newFanOutSubscriber(t *testing.T, behaviour string) (string, *atomic.Int64) {
+	t.Helper()
+	var requests atomic.Int64
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		requests.Add(1)
+		switch behaviour {
+		case subscriberFailing:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case subscriberStuck:
+			<-release
+		}
+	}))
+	// Release the stuck requests before the server waits for them to close.
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(release) })
+	return server.URL, &requests
+}
+
+func TestFanOutFailureIsolation(t *testing.T) {
+	tests := []struct {
+		name        string
+		subscribers []string
+		// withoutDeadLetterDir leaves DeadLetterDir unset, so that a batch no subscriber queued fails instead of being
+		// dead-lettered.
+		withoutDeadLetterDir bool
+		// wantErrs are whether each of the three batches fails.
+		wantErrs []bool
+		// wantStatuses are the sent, failed and rejected batch counts of each subscriber.
+		wantStatuses [][3]uint64
+	}{
+		{
+			name:         "all healthy",
+			subscribers:  []string{subscriberHealthy, subscriberHealthy, subscriberHealthy},
+			wantErrs:     []bool{false, false, false},
+			wantStatuses: [][3]uint64{{3, 0, 0}, {3, 0, 0}, {3, 0, 0}},
+		},
+		{
+			name:         "failing subscriber",
+			subscribers:  []string{subscriberHealthy, subscriberFailing, subscriberHealthy},
+			wantErrs:     []bool{false, false, false},
+			wantStatuses: [][3]uint64{{3, 0, 0}, {0, 3, 0}, {3, 0, 0}},
+		},
+		{
+			// The stuck subscriber holds the first batch in flight and the second in its queue, and rejects the third.
+			name:         "stuck subscriber",
+			subscribers:  []string{subscriberHealthy, subscriberStuck, subscriberFailing},
+			wantErrs:     []bool{false, false, false},
+			wantStatuses: [][3]uint64{{3, 0, 0}, {0, 0, 1}, {0, 3, 0}},
+		},
+		{
+			name:                 "every subscriber stuck",
+			subscribers:          []string{subscriberStuck, subscriberStuck},
+			withoutDeadLetterDir: true,
+			wantErrs:             []bool{false, false, true},
+			wantStatuses:         [][3]uint64{{0, 0, 0}, {0, 0, 0}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requestCounts []*atomic.Int64
+			wh := NewWebHandler("", false, "", 0)
+			for _, behaviour := range tt.subscribers {
+				url, requests := newFanOutSubscriber(t, behaviour)
+				wh.FanOutURLs = append(wh.FanOutURLs, url)
+				requestCounts = append(requestCounts, requests)
+			}
+			wh.FanOutQueueSize = 1
+			wh.MaxDeliveryAttempts = 1
+			wh.MaxRetries = 0
+			if !tt.withoutDeadLetterDir {
+				wh.DeadLetterDir = t.TempDir()
+			}
+
+			for ii, wantErr := range tt.wantErrs {
+				err := wh.HandleEntryBatch(newTestEntries(1, uint64(ii+1)))
+				if (err != nil) != wantErr {
+					t.Fatalf("HandleEntryBatch(%d) = %v, wantErr %v", ii+1, err, wantErr)
+				}
+				// Wait for every worker to pick up the batch, or get stuck on the first one, so that the queues are
+				// in a known state for the next batch.
+				for jj, behaviour := range tt.subscribers {
+					wantRequests := int64(ii + 1)
+					if behaviour == subscriberStuck {
+						wantRequests = 1
+					}
+					waitFor(t, fmt.Sprintf("subscriber %d to receive batch %d", jj, ii+1), func() bool {
+						return requestCounts[jj].Load() == wantRequests
+					})
+				}
+			}
+
+			for ii, wantStatus := range tt.wantStatuses {
+				waitFor(t, fmt.Sprintf("subscriber %d to finish its batches", ii), func() bool {
+					status := wh.fanOutStatus()[ii]
+					return [3]uint64{status.BatchesSent, status.BatchesFailed, status.BatchesRejected} == wantStatus
+				})
+				if wh.DeadLetterDir == "" {
+					continue
+				}
+				// Every failed or rejected batch is dead-lettered for its subscriber only.
+				var deadLetterCount uint64
+				files, _ := os.ReadDir(filepath.Join(wh.DeadLetterDir, fmt.Sprintf("fanout-%d", ii)))
+				for _, file := range files {
+					if strings.HasSuffix(file.Name(), deadLetterMetadataExt) {
+						deadLetterCount++
+					}
+				}
+				if wantDeadLetters := wantStatus[1] + wantStatus[2]; deadLetterCount != wantDeadLetters {
+					t.Errorf("subscriber %d has %d dead-lettered batches, want %d", ii, deadLetterCount,
+						wantDeadLetters)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const (
+	FieldNameStylePascal = "pascal"
+	FieldNameStyleSnake  = "snake"
+	FieldNameStyleCamel  = "camel"
+)
+
+// getFieldRenamer returns the function that converts PascalCase field names to the style, or nil if field names
+// are left as they are.
+func getFieldRenamer(style string) (func(string) string, error) {
+	switch style {
+	case "", FieldNameStylePascal:
+		return nil, nil
+	case FieldNameStyleSnake:
+		return toSnakeCase, nil
+	case FieldNameStyleCamel:
+		return toCamelCase, nil
+	default:
+		return nil, fmt.Errorf("getFieldRenamer: unknown field name style %s", style)
+	}
+}
+
+// renameEntryFields renames the fields of the entry and of every object nested in it. The keys of extra data maps
+// are user defined rather than field names, so they are left as they are.
+func renameEntryFields(fields map[string]interface{}, renameField func(string) string) {
+	// Collect the keys first, so that renamed keys aren't visited again.
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		value := fields[key]
+		if _, isExtraData := extraDataKeys[key]; !isExtraData {
+			renameNestedFields(value, renameField)
+		}
+		if renamedKey := renameField(key); renamedKey != key {
+			delete(fields, key)
+			fields[renamedKey] = value
+		}
+	}
+}
+
+func renameNestedFields(value interface{}, renameField func(string) string) {
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		renameEntryFields(typedValue, renameField)
+	case []interface{}:
+		for _, item := range typedValue {
+			renameNestedFields(item, renameField)
+		}
+	}
+}
+
+// splitFieldName splits a PascalCase field name into its words, keeping acronyms such as PKID or NFT together,
+// e.g. NFTPostHash is split into NFT, Post and Hash.
+func splitFieldName(name string) []string {
+	runes := []rune(name)
+	var words []string
+	start := 0
+	for ii := 1; ii < len(runes); ii++ {
+		previous, current := runes[ii-1], runes[ii]
+		startsWord := unicode.IsUpper(current) && (unicode.IsLower(previous) || unicode.IsDigit(previous) ||
+			(unicode.IsUpper(previous) && ii+1 < len(runes) && unicode.IsLower(runes[ii+1])))
+		if current == '_' || startsWord {
+			if ii > start {
+				words = append(words, string(runes[start:ii]))
+			}
+			start = ii
+			if current == '_' {
+				start = ii + 1
+			}
+		}
+	}
+	if start < len(runes) {
+		words = append(words, string(runes[start:]))
+	}
+	return words
+}
+
+// toSnakeCase converts a PascalCase field name to snake_case, e.g. PosterPublicKey to poster_public_key.
+func toSnakeCase(name string) string {
+	words := splitFieldName(name)
+	for ii := range words {
+		words[ii] = strings.ToLower(words[ii])
+	}
+	return strings.Join(words, "_")
+}
+
+// toCamelCase converts a PascalCase field name to camelCase, e.g. PosterPublicKey to posterPublicKey.
+func toCamelCase(name string) string {
+	words := splitFieldName(name)
+	for ii := range words {
+		if ii == 0 {
+			words[ii] = strings.ToLower(words[ii])
+			continue
+		}
+		runes := []rune(strings.ToLower(words[ii]))
+		runes[0] = unicode.ToUpper(runes[0])
+		words[ii] = string(runes)
+	}
+	return strings.Join(words, "")
+}
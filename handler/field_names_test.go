@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/deso-protocol/core/lib"
+)
+
+func TestFieldRenamers(t *testing.T) {
+	tests := []struct {
+		name      string
+		wantSnake string
+		wantCamel string
+	}{
+		{name: "PosterPublicKey", wantSnake: "poster_public_key", wantCamel: "posterPublicKey"},
+		{name: "NFTPostHash", wantSnake: "nft_post_hash", wantCamel: "nftPostHash"},
+		{name: "PKID", wantSnake: "pkid", wantCamel: "pkid"},
+		{name: "Body", wantSnake: "body", wantCamel: "body"},
+		{name: "Entry2Hash", wantSnake: "entry2_hash", wantCamel: "entry2Hash"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for style, want := range map[string]string{
+				FieldNameStylePascal: tt.name,
+				FieldNameStyleSnake:  tt.wantSnake,
+				FieldNameStyleCamel:  tt.wantCamel,
+			} {
+				renameField, err := getFieldRenamer(style)
+				if err != nil {
+					t.Fatalf("getFieldRenamer(%s): %v", style, err)
+				}
+				renamed := tt.name
+				if renameField != nil {
+					renamed = renameField(tt.name)
+				}
+				if renamed != want {
+					t.Errorf("%s name = %q, want %q", style, renamed, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFieldNameStyleBatch(t *testing.T) {
+	entry := newTestPostEntry()
+	entry.Encoder.(*lib.PostEntry).PostExtraData = map[string][]byte{"AppName": []byte("web")}
+	tests := []struct {
+		style string
+		// wantPaths must be present in the marshaled entry, and unwantedPaths absent.
+		wantPaths     [][]string
+		unwantedPaths [][]string
+	}{
+		{
+			style:     FieldNameStylePascal,
+			wantPaths: [][]string{{"BlockHeight"}, {"Encoder", "PosterPublicKey"}, {"Encoder", "PostExtraData", "AppName"}},
+		},
+		{
+			style: FieldNameStyleSnake,
+			wantPaths: [][]string{
+				{"block_height"}, {"encoder", "poster_public_key"}, {"encoder", "post_extra_data", "AppName"},
+			},
+			unwantedPaths: [][]string{{"BlockHeight"}, {"encoder", "post_extra_data", "app_name"}},
+		},
+		{
+			style: FieldNameStyleCamel,
+			wantPaths: [][]string{
+				{"blockHeight"}, {"encoder", "posterPublicKey"}, {"encoder", "postExtraData", "AppName"},
+			},
+			unwantedPaths: [][]string{{"BlockHeight"}, {"encoder", "postExtraData", "appName"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.style, func(t *testing.T) {
+			wh := NewWebHandler("", false, "", 0)
+			wh.FieldNameStyle = tt.style
+			data, err := wh.marshalBatch([]*lib.StateChangeEntry{entry})
+			if err != nil {
+				t.Fatalf("marshalBatch: %v", err)
+			}
+			fields := decodeTestBatch(t, data)[0]
+			for _, path := range tt.wantPaths {
+				if _, exists := fieldAt(fields, path...); !exists {
+					t.Errorf("%v is missing from %s", path, data)
+				}
+			}
+			// Extra data keys are user defined, so they are never renamed.
+			for _, path := range tt.unwantedPaths {
+				if _, exists := fieldAt(fields, path...); exists {
+					t.Errorf("%v is present in %s", path, data)
+				}
+			}
+		})
+	}
+}
+
+func TestFieldNameStyleUnknown(t *testing.T) {
+	wh := NewWebHandler("", false, "", 0)
+	wh.FieldNameStyle = "kebab"
+	if _, err := wh.marshalBatch(newTestEntries(1, 1)); err == nil {
+		t.Error("expected an unknown field name style to fail")
+	}
+}
@@ -0,0 +1,185 @@
+// Package handlertest provides a mock data handler for testing code built on the state consumer interface.
+package handlertest
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/deso-protocol/state-consumer/consumer"
+)
+
+// Names of the StateSyncerDataHandler methods, as recorded in Call.Method and accepted by SetError.
+const (
+	MethodHandleEntryBatch    = "HandleEntryBatch"
+	MethodHandleSyncEvent     = "HandleSyncEvent"
+	MethodInitiateTransaction = "InitiateTransaction"
+	MethodCommitTransaction   = "CommitTransaction"
+	MethodRollbackTransaction = "RollbackTransaction"
+	MethodGetParams           = "GetParams"
+)
+
+// Call is a single recorded call to the mock handler.
+type Call struct {
+	Method string
+	// Entries is the batch passed to HandleEntryBatch.
+	Entries []*lib.StateChangeEntry
+	// SyncEvent is the event passed to HandleSyncEvent.
+	SyncEvent consumer.SyncEvent
+	// Err is the error the call returned.
+	Err error
+}
+
+// MockHandler is a StateSyncerDataHandler that records every call and returns the errors injected with SetError.
+// It is safe for concurrent use.
+type MockHandler struct {
+	// Params are returned by GetParams. They default to mainnet.
+	Params *lib.DeSoParams
+
+	mtx   sync.Mutex
+	calls []Call
+	errs  map[string]error
+}
+
+var _ consumer.StateSyncerDataHandler = (*MockHandler)(nil)
+
+// NewMockHandler returns a mock handler for mainnet that succeeds on every call.
+func NewMockHandler() *MockHandler {
+	return &MockHandler{
+		Params: &lib.DeSoMainnetParams,
+		errs:   make(map[string]error),
+	}
+}
+
+// SetError makes every following call to the method return err. Passing a nil err clears the injected error.
+func (mh *MockHandler) SetError(method string, err error) {
+	mh.mtx.Lock()
+	defer mh.mtx.Unlock()
+	if mh.errs == nil {
+		mh.errs = make(map[string]error)
+	}
+	mh.errs[method] = err
+}
+
+// Reset clears the recorded calls and injected errors.
+func (mh *MockHandler) Reset() {
+	mh.mtx.Lock()
+	defer mh.mtx.Unlock()
+	mh.calls = nil
+	mh.errs = make(map[string]error)
+}
+
+func (mh *MockHandler) record(call Call) error {
+	mh.mtx.Lock()
+	defer mh.mtx.Unlock()
+	call.Err = mh.errs[call.Method]
+	mh.calls = append(mh.calls, call)
+	return call.Err
+}
+
+func (mh *MockHandler) HandleEntryBatch(batchedEntries []*lib.StateChangeEntry) error {
+	return mh.record(Call{Method: MethodHandleEntryBatch, Entries: batchedEntries})
+}
+
+func (mh *MockHandler) HandleSyncEvent(syncEvent consumer.SyncEvent) error {
+	return mh.record(Call{Method: MethodHandleSyncEvent, SyncEvent: syncEvent})
+}
+
+func (mh *MockHandler) InitiateTransaction() error {
+	return mh.record(Call{Method: MethodInitiateTransaction})
+}
+
+func (mh *MockHandler) CommitTransaction() error {
+	return mh.record(Call{Method: MethodCommitTransaction})
+}
+
+func (mh *MockHandler) RollbackTransaction() error {
+	return mh.record(Call{Method: MethodRollbackTransaction})
+}
+
+func (mh *MockHandler) GetParams() *lib.DeSoParams {
+	mh.record(Call{Method: MethodGetParams})
+	if mh.Params == nil {
+		return &lib.DeSoMainnetParams
+	}
+	return mh.Params
+}
+
+// Calls returns every recorded call, in order.
+func (mh *MockHandler) Calls() []Call {
+	mh.mtx.Lock()
+	defer mh.mtx.Unlock()
+	return append([]Call{}, mh.calls...)
+}
+
+// CallCount returns the number of recorded calls to the method.
+func (mh *MockHandler) CallCount(method string) int {
+	count := 0
+	for _, call := range mh.Calls() {
+		if call.Method == method {
+			count++
+		}
+	}
+	return count
+}
+
+// BatchesReceived returns every batch passed to HandleEntryBatch, in order, including batches that were
+// answered with an injected error.
+func (mh *MockHandler) BatchesReceived() [][]*lib.StateChangeEntry {
+	var batches [][]*lib.StateChangeEntry
+	for _, call := range mh.Calls() {
+		if call.Method == MethodHandleEntryBatch {
+			batches = append(batches, call.Entries)
+		}
+	}
+	return batches
+}
+
+// EntriesReceived returns the entries of every batch passed to HandleEntryBatch, in order.
+func (mh *MockHandler) EntriesReceived() []*lib.StateChangeEntry {
+	var entries []*lib.StateChangeEntry
+	for _, batch := range mh.BatchesReceived() {
+		entries = append(entries, batch...)
+	}
+	return entries
+}
+
+// SyncEventsReceived returns every event passed to HandleSyncEvent, in order.
+func (mh *MockHandler) SyncEventsReceived() []consumer.SyncEvent {
+	var syncEvents []consumer.SyncEvent
+	for _, call := range mh.Calls() {
+		if call.Method == MethodHandleSyncEvent {
+			syncEvents = append(syncEvents, call.SyncEvent)
+		}
+	}
+	return syncEvents
+}
+
+// AssertCallCount fails the test if the method wasn't called exactly expected times.
+func (mh *MockHandler) AssertCallCount(t testing.TB, method string, expected int) {
+	t.Helper()
+	if actual := mh.CallCount(method); actual != expected {
+		t.Errorf("MockHandler: expected %d calls to %s, got %d", expected, method, actual)
+	}
+}
+
+// AssertBatchCount fails the test if HandleEntryBatch wasn't called exactly expected times.
+func (mh *MockHandler) AssertBatchCount(t testing.TB, expected int) {
+	t.Helper()
+	mh.AssertCallCount(t, MethodHandleEntryBatch, expected)
+}
+
+// AssertMethodOrder fails the test if the recorded calls, ignoring GetParams, don't match the methods in order.
+func (mh *MockHandler) AssertMethodOrder(t testing.TB, methods ...string) {
+	t.Helper()
+	var actual []string
+	for _, call := range mh.Calls() {
+		if call.Method != MethodGetParams {
+			actual = append(actual, call.Method)
+		}
+	}
+	if !reflect.DeepEqual(actual, methods) {
+		t.Errorf("MockHandler: expected calls %v, got %v", methods, actual)
+	}
+}
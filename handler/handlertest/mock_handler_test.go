@@ -0,0 +1,150 @@
+package handlertest_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/deso-protocol/postgres-data-handler/handler/handlertest"
+	"github.com/deso-protocol/state-consumer/consumer"
+)
+
+func newEntries(keys ...string) []*lib.StateChangeEntry {
+	entries := make([]*lib.StateChangeEntry, len(keys))
+	for ii, key := range keys {
+		entries[ii] = &lib.StateChangeEntry{OperationType: lib.DbOperationTypeUpsert, KeyBytes: []byte(key)}
+	}
+	return entries
+}
+
+func TestMockHandlerRecordsCalls(t *testing.T) {
+	mh := handlertest.NewMockHandler()
+	firstBatch, secondBatch := newEntries("a", "b"), newEntries("c")
+
+	mh.HandleSyncEvent(consumer.SyncEventStart)
+	mh.InitiateTransaction()
+	mh.HandleEntryBatch(firstBatch)
+	mh.HandleEntryBatch(secondBatch)
+	mh.CommitTransaction()
+	mh.RollbackTransaction()
+	if params := mh.GetParams(); params != &lib.DeSoMainnetParams {
+		t.Errorf("GetParams = %v, want mainnet", params)
+	}
+
+	wantMethods := []string{
+		handlertest.MethodHandleSyncEvent,
+		handlertest.MethodInitiateTransaction,
+		handlertest.MethodHandleEntryBatch,
+		handlertest.MethodHandleEntryBatch,
+		handlertest.MethodCommitTransaction,
+		handlertest.MethodRollbackTransaction,
+		handlertest.MethodGetParams,
+	}
+	var methods []string
+	for _, call := range mh.Calls() {
+		methods = append(methods, call.Method)
+		if call.Err != nil {
+			t.Errorf("%s returned %v without an injected error", call.Method, call.Err)
+		}
+	}
+	if !reflect.DeepEqual(methods, wantMethods) {
+		t.Errorf("calls = %v, want %v", methods, wantMethods)
+	}
+	mh.AssertMethodOrder(t, wantMethods[:len(wantMethods)-1]...)
+	mh.AssertBatchCount(t, 2)
+	mh.AssertCallCount(t, handlertest.MethodGetParams, 1)
+
+	if batches := mh.BatchesReceived(); !reflect.DeepEqual(batches, [][]*lib.StateChangeEntry{firstBatch, secondBatch}) {
+		t.Errorf("BatchesReceived = %v, want both batches in order", batches)
+	}
+	if entries := mh.EntriesReceived(); !reflect.DeepEqual(entries, append(firstBatch, secondBatch...)) {
+		t.Errorf("EntriesReceived = %v, want the entries of both batches in order", entries)
+	}
+	wantSyncEvents := []consumer.SyncEvent{consumer.SyncEventStart}
+	if syncEvents := mh.SyncEventsReceived(); !reflect.DeepEqual(syncEvents, wantSyncEvents) {
+		t.Errorf("SyncEventsReceived = %v, want [SyncEventStart]", syncEvents)
+	}
+}
+
+func TestMockHandlerSetError(t *testing.T) {
+	injectedErr := errors.New("injected")
+	tests := []struct {
+		method string
+		call   func(mh *handlertest.MockHandler) error
+	}{
+		{handlertest.MethodHandleEntryBatch, func(mh *handlertest.MockHandler) error {
+			return mh.HandleEntryBatch(newEntries("a"))
+		}},
+		{handlertest.MethodHandleSyncEvent, func(mh *handlertest.MockHandler) error {
+			return mh.HandleSyncEvent(consumer.SyncEventHypersyncStart)
+		}},
+		{handlertest.MethodInitiateTransaction, (*handlertest.MockHandler).InitiateTransaction},
+		{handlertest.MethodCommitTransaction, (*handlertest.MockHandler).CommitTransaction},
+		{handlertest.MethodRollbackTransaction, (*handlertest.MockHandler).RollbackTransaction},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			mh := handlertest.NewMockHandler()
+			mh.SetError(tt.method, injectedErr)
+
+			// Only the method the error is injected into fails, on every call.
+			for _, other := range tests {
+				wantErr := error(nil)
+				if other.method == tt.method {
+					wantErr = injectedErr
+				}
+				for attempt := 0; attempt < 2; attempt++ {
+					if err := other.call(mh); err != wantErr {
+						t.Errorf("%s = %v, want %v", other.method, err, wantErr)
+					}
+				}
+			}
+			for _, call := range mh.Calls() {
+				if (call.Err != nil) != (call.Method == tt.method) {
+					t.Errorf("recorded %s with error %v", call.Method, call.Err)
+				}
+			}
+
+			mh.SetError(tt.method, nil)
+			if err := tt.call(mh); err != nil {
+				t.Errorf("%s = %v after clearing the error", tt.method, err)
+			}
+		})
+	}
+}
+
+func TestMockHandlerBatchesReceivedIncludesFailedBatches(t *testing.T) {
+	mh := handlertest.NewMockHandler()
+	mh.HandleEntryBatch(newEntries("a"))
+	mh.SetError(handlertest.MethodHandleEntryBatch, errors.New("injected"))
+	mh.HandleEntryBatch(newEntries("b"))
+
+	batches := mh.BatchesReceived()
+	if len(batches) != 2 || string(batches[1][0].KeyBytes) != "b" {
+		t.Errorf("BatchesReceived = %v, want both batches", batches)
+	}
+	if calls := mh.Calls(); calls[0].Err != nil || calls[1].Err == nil {
+		t.Errorf("expected only the second batch to record the injected error, got %v and %v", calls[0].Err,
+			calls[1].Err)
+	}
+}
+
+func TestMockHandlerReset(t *testing.T) {
+	mh := handlertest.NewMockHandler()
+	mh.SetError(handlertest.MethodCommitTransaction, errors.New("injected"))
+	mh.HandleEntryBatch(newEntries("a"))
+	mh.CommitTransaction()
+
+	mh.Reset()
+	if calls := mh.Calls(); len(calls) != 0 {
+		t.Errorf("Calls = %v after Reset, want none", calls)
+	}
+	if batches := mh.BatchesReceived(); len(batches) != 0 {
+		t.Errorf("BatchesReceived = %v after Reset, want none", batches)
+	}
+	if err := mh.CommitTransaction(); err != nil {
+		t.Errorf("CommitTransaction = %v after Reset, want the injected error cleared", err)
+	}
+	mh.AssertCallCount(t, handlertest.MethodCommitTransaction, 1)
+}
@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// HeartbeatEvent is the event of heartbeat messages.
+const HeartbeatEvent = "heartbeat"
+
+// HeartbeatMessage is sent to the endpoint during quiet periods, so that it can tell an idle stream from a dead one.
+type HeartbeatMessage struct {
+	Event string `json:"event"`
+	// Height is the block height of the last batch sent.
+	Height uint64 `json:"height"`
+}
+
+// RunHeartbeat sends a heartbeat every HeartbeatInterval until ctx is done. Unless HeartbeatAlways is set, the
+// heartbeat is skipped if a batch was sent during the last interval. No heartbeats are sent while the handler is
+// paused. It returns immediately if heartbeats are disabled.
+func (wh *WebHandler) RunHeartbeat(ctx context.Context) {
+	if wh.HeartbeatInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(wh.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			// The endpoint is likely down for maintenance while sending is paused.
+			if wh.IsPaused() {
+				continue
+			}
+			if !wh.HeartbeatAlways && now.Sub(time.Unix(0, wh.lastSentAt.Load())) < wh.HeartbeatInterval {
+				continue
+			}
+			if err := wh.sendHeartbeat(); err != nil {
+				glog.Errorf("WebHandler.RunHeartbeat: %v", err)
+			}
+		}
+	}
+}
+
+// sendHeartbeat sends a heartbeat over the configured transport.
+func (wh *WebHandler) sendHeartbeat() error {
+	jsonData, err := json.Marshal(&HeartbeatMessage{
+		Event:  HeartbeatEvent,
+		Height: wh.metrics.lastBlockHeight.Load(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "WebHandler.sendHeartbeat: failed to marshal heartbeat")
+	}
+
+	if wh.EndpointURL != "" {
+		return wh.postToEndpoint(jsonData, DefaultBatchTimeout)
+	}
+	if wh.UseWebSocket {
+		return wh.sendHeartbeatOverWebSocket(jsonData)
+	}
+	return fmt.Errorf("WebHandler.sendHeartbeat: no endpoint configured")
+}
+
+// sendHeartbeatOverWebSocket sends the heartbeat to every subscriber and, if connected, to the WSURL peer.
+// Heartbeats are not buffered for replay and don't open a connection to the peer.
+func (wh *WebHandler) sendHeartbeatOverWebSocket(jsonData []byte) error {
+	if wh.wsClosed.Load() {
+		return nil
+	}
+	wh.wsPeerMtx.Lock()
+	defer wh.wsPeerMtx.Unlock()
+
+	wh.wsStreamMtx.Lock()
+	wh.broadcast(jsonData)
+	wh.wsStreamMtx.Unlock()
+	if wh.wsPeer == nil {
+		return nil
+	}
+	if err := wh.wsPeer.write(jsonData); err != nil {
+		// Drop the connection so that the next batch reconnects.
+		wh.dropWSPeer()
+		return errors.Wrap(err, "WebHandler.sendHeartbeatOverWebSocket: failed to write heartbeat")
+	}
+	return nil
+}
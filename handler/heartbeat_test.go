@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// heartbeatServer records the heights of the heartbeats it receives, and counts the batches.
+type heartbeatServer struct {
+	*httptest.Server
+
+	mtx        sync.Mutex
+	heartbeats []uint64
+	batches    int
+}
+
+func newHeartbeatServer(t *testing.T) *heartbeatServer {
+	server := &heartbeatServer{}
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		server.mtx.Lock()
+		defer server.mtx.Unlock()
+		var heartbeat HeartbeatMessage
+		if json.Unmarshal(body, &heartbeat) == nil && heartbeat.Event == HeartbeatEvent {
+			server.heartbeats = append(server.heartbeats, heartbeat.Height)
+			return
+		}
+		server.batches++
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func (server *heartbeatServer) counts() (heartbeats []uint64, batches int) {
+	server.mtx.Lock()
+	defer server.mtx.Unlock()
+	return append([]uint64(nil), server.heartbeats...), server.batches
+}
+
+func TestRunHeartbeat(t *testing.T) {
+	const interval = 40 * time.Millisecond
+	tests := []struct {
+		name            string
+		interval        time.Duration
+		heartbeatAlways bool
+		sendBatches     bool
+		paused          bool
+		wantHeartbeats  bool
+	}{
+		{name: "quiet", interval: interval, wantHeartbeats: true},
+		{name: "data flowing", interval: interval, sendBatches: true},
+		{name: "data flowing with HeartbeatAlways", interval: interval, heartbeatAlways: true, sendBatches: true,
+			wantHeartbeats: true},
+		{name: "paused", interval: interval, paused: true},
+		{name: "disabled"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			server := newHeartbeatServer(t)
+			wh := NewWebHandler(server.URL, false, "", 0)
+			wh.HeartbeatInterval = tt.interval
+			wh.HeartbeatAlways = tt.heartbeatAlways
+			if err := wh.HandleEntryBatch(newTestEntries(1, 3)); err != nil {
+				t.Fatalf("HandleEntryBatch: %v", err)
+			}
+			if tt.paused {
+				wh.Pause()
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			stopped := make(chan struct{})
+			go func() {
+				wh.RunHeartbeat(ctx)
+				close(stopped)
+			}()
+			// Run for several intervals, sending a batch far more often than the interval if data is flowing.
+			for deadline := time.Now().Add(10 * interval); time.Now().Before(deadline); {
+				if tt.sendBatches {
+					if err := wh.HandleEntryBatch(newTestEntries(1, 3)); err != nil {
+						t.Fatalf("HandleEntryBatch: %v", err)
+					}
+				}
+				time.Sleep(interval / 8)
+			}
+			cancel()
+			<-stopped
+
+			heartbeats, batches := server.counts()
+			if tt.wantHeartbeats && len(heartbeats) < 3 {
+				t.Errorf("server received %d heartbeats in 10 intervals, want at least 3", len(heartbeats))
+			}
+			if !tt.wantHeartbeats && len(heartbeats) != 0 {
+				t.Errorf("server received %d heartbeats, want none", len(heartbeats))
+			}
+			for _, height := range heartbeats {
+				if height != 3 {
+					t.Errorf("heartbeat height = %d, want the height of the last batch", height)
+				}
+			}
+			if tt.sendBatches && batches < 10 {
+				t.Errorf("server received %d batches, want the heartbeats to be tested while data flows", batches)
+			}
+		})
+	}
+}
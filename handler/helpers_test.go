@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/deso-protocol/postgres-data-handler/handler/testserver"
+	"github.com/gorilla/websocket"
+)
+
+// testReadTimeout bounds every wait of the tests for a message or a batch.
+const testReadTimeout = 5 * time.Second
+
+// newTestEntries returns count like entry upserts at the block height, each with its own key.
+func newTestEntries(count int, blockHeight uint64) []*lib.StateChangeEntry {
+	entries := make([]*lib.StateChangeEntry, count)
+	for ii := range entries {
+		entries[ii] = &lib.StateChangeEntry{
+			OperationType: lib.DbOperationTypeUpsert,
+			KeyBytes:      []byte(fmt.Sprintf("key-%d-%d", blockHeight, ii)),
+			EncoderBytes:  []byte(fmt.Sprintf("like-%d-%d", blockHeight, ii)),
+			Encoder:       &lib.LikeEntry{LikerPubKey: []byte(fmt.Sprintf("liker-%d", ii))},
+			EncoderType:   lib.EncoderTypeLikeEntry,
+			BlockHeight:   blockHeight,
+		}
+	}
+	return entries
+}
+
+// newTestServer starts a receiver that is closed when the test ends.
+func newTestServer(t *testing.T) *testserver.Server {
+	t.Helper()
+	server := testserver.New()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newTestWebHandler returns a handler that POSTs to the server, with the delivery semantics left at their default.
+func newTestWebHandler(server *testserver.Server, opts ...WebHandlerOption) *WebHandler {
+	return NewWebHandler(server.URL(), false, "", 0, opts...)
+}
+
+// closedWSURL returns a WebSocket URL that refuses connections.
+func closedWSURL(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	return "ws://" + addr + "/stream"
+}
+
+// dialTestStream serves the handler's WebSocket stream and connects to it with the query, waiting until the
+// connection is subscribed.
+func dialTestStream(t *testing.T, wh *WebHandler, query string) *websocket.Conn {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(wh.ServeWebSocketStream))
+	t.Cleanup(server.Close)
+
+	wh.wsStreamMtx.Lock()
+	subscriberCount := len(wh.wsSubscribers)
+	wh.wsStreamMtx.Unlock()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/stream"
+	if query != "" {
+		url += "?" + query
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("failed to dial stream: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	waitFor(t, "stream subscription", func() bool {
+		wh.wsStreamMtx.Lock()
+		defer wh.wsStreamMtx.Unlock()
+		return len(wh.wsSubscribers) > subscriberCount
+	})
+	return conn
+}
+
+// testStreamMessage holds the fields of every message type sent on the stream.
+type testStreamMessage struct {
+	Type         string          `json:"type"`
+	Seq          uint64          `json:"seq"`
+	Entries      json.RawMessage `json:"entries"`
+	RequestedSeq uint64          `json:"requested_seq"`
+	OldestSeq    uint64          `json:"oldest_seq"`
+	NextSeq      uint64          `json:"next_seq"`
+}
+
+// readStreamMessage reads the next message from the stream.
+func readStreamMessage(t *testing.T, conn *websocket.Conn) testStreamMessage {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(testReadTimeout))
+	_, data, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("failed to read stream message: %v", err)
+	}
+	var message testStreamMessage
+	if err = json.Unmarshal(data, &message); err != nil {
+		t.Fatalf("failed to decode stream message %s: %v", data, err)
+	}
+	return message
+}
+
+// waitFor polls the condition until it holds, failing the test if it doesn't within testReadTimeout.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(testReadTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// recordingServer answers every HTTP POST with statusCode, recording the block heights of the batches it accepts.
+type recordingServer struct {
+	*httptest.Server
+	// statusCode is the status of the responses. It is 200 OK unless set.
+	statusCode atomic.Int64
+	// requests counts every request, accepted or not.
+	requests atomic.Int64
+
+	mtx     sync.Mutex
+	heights [][]uint64
+}
+
+// newRecordingServer starts a recordingServer that is closed when the test ends.
+func newRecordingServer(t *testing.T) *recordingServer {
+	t.Helper()
+	server := &recordingServer{}
+	server.statusCode.Store(http.StatusOK)
+	server.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.requests.Add(1)
+		statusCode := int(server.statusCode.Load())
+		if statusCode != http.StatusOK {
+			w.WriteHeader(statusCode)
+			return
+		}
+		var entries []struct{ BlockHeight uint64 }
+		if err := json.NewDecoder(r.Body).Decode(&entries); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var heights []uint64
+		for _, entry := range entries {
+			heights = append(heights, entry.BlockHeight)
+		}
+		server.mtx.Lock()
+		server.heights = append(server.heights, heights)
+		server.mtx.Unlock()
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// batchHeights returns the block heights of the entries of every accepted batch, in order.
+func (server *recordingServer) batchHeights() [][]uint64 {
+	server.mtx.Lock()
+	defer server.mtx.Unlock()
+	return append([][]uint64(nil), server.heights...)
+}
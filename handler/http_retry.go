@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultBaseBackoff is the wait before the first retry of an HTTP POST when no BaseBackoff is configured.
+	DefaultBaseBackoff = 500 * time.Millisecond
+	// maxRetryBackoff caps the wait before a retry of an HTTP POST, however many retries came before it.
+	maxRetryBackoff = 30 * time.Second
+)
+
+// isRetryableError returns whether an HTTP POST that failed with the error may succeed if it is sent again: when it
+// timed out, when it couldn't reach the endpoint, or when the endpoint answered with a 5xx or 429 status. Other
+// statuses, such as 400 or 401, mean the endpoint rejected the batch, and errors that occurred before the request was
+// sent, such as an invalid URL, can't be fixed by sending it again. Neither can a POST that was cancelled.
+func isRetryableError(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, ErrHTTPTimeout) {
+		return true
+	}
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode >= http.StatusInternalServerError || statusErr.StatusCode == http.StatusTooManyRequests
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) && urlErr.Op != "parse"
+}
+
+// retryBackoff returns the wait before the given retry, counting from zero: BaseBackoff doubled for every retry
+// before it, up to maxRetryBackoff, of which a random half is waited so that handlers retrying at the same time
+// spread out.
+func (wh *WebHandler) retryBackoff(retry int) time.Duration {
+	backoff := wh.BaseBackoff
+	if backoff <= 0 {
+		backoff = DefaultBaseBackoff
+	}
+	for ii := 0; ii < retry && backoff < maxRetryBackoff; ii++ {
+		backoff *= 2
+	}
+	backoff = min(backoff, maxRetryBackoff)
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// postToURLWithRetries sends the JSON payload to url via an HTTP POST, retrying it up to MaxRetries times with
+// exponential backoff while it fails with a retryable error. It returns the error of the last attempt.
+//
+// These retries happen within a single delivery attempt, so a batch that still fails is then retried as configured by
+// DeliverySemantics.
+func (wh *WebHandler) postToURLWithRetries(url string, jsonData []byte, timeout time.Duration) error {
+	for retry := 0; ; retry++ {
+		err := wh.postToURL(url, jsonData, timeout)
+		if err == nil {
+			return nil
+		}
+		if !isRetryableError(err) {
+			return err
+		}
+		if retry >= wh.MaxRetries {
+			if retry == 0 {
+				return err
+			}
+			return errors.Wrapf(err, "WebHandler.postToURLWithRetries: failed after %d retries", retry)
+		}
+
+		backoff := wh.retryBackoff(retry)
+		glog.Errorf("WebHandler.postToURLWithRetries: retrying in %s after attempt %d failed: %v", backoff, retry+1, err)
+		wh.metrics.httpRetries.Add(1)
+		if sleepErr := wh.sleep(backoff); sleepErr != nil {
+			return errors.Wrapf(sleepErr, "WebHandler.postToURLWithRetries: gave up retrying (%v)", err)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	tests := []struct {
+		name        string
+		baseBackoff time.Duration
+		retry       int
+		// wantMax is the backoff before jitter, of which at least half is waited.
+		wantMax time.Duration
+	}{
+		{name: "first retry", baseBackoff: 100 * time.Millisecond, retry: 0, wantMax: 100 * time.Millisecond},
+		{name: "second retry", baseBackoff: 100 * time.Millisecond, retry: 1, wantMax: 200 * time.Millisecond},
+		{name: "fifth retry", baseBackoff: 100 * time.Millisecond, retry: 4, wantMax: 1600 * time.Millisecond},
+		{name: "capped", baseBackoff: 100 * time.Millisecond, retry: 20, wantMax: maxRetryBackoff},
+		{name: "far past the cap", baseBackoff: time.Second, retry: 1000, wantMax: maxRetryBackoff},
+		{name: "default base", retry: 1, wantMax: 2 * DefaultBaseBackoff},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh := NewWebHandler("", false, "", 0)
+			wh.BaseBackoff = tt.baseBackoff
+			distinct := map[time.Duration]bool{}
+			for ii := 0; ii < 100; ii++ {
+				backoff := wh.retryBackoff(tt.retry)
+				if backoff < tt.wantMax/2 || backoff > tt.wantMax {
+					t.Fatalf("retryBackoff(%d) = %s, want between %s and %s", tt.retry, backoff, tt.wantMax/2,
+						tt.wantMax)
+				}
+				distinct[backoff] = true
+			}
+			// The jitter spreads out the retries of handlers that fail at the same time.
+			if len(distinct) < 2 {
+				t.Errorf("retryBackoff(%d) returned %d distinct backoffs, want jitter", tt.retry, len(distinct))
+			}
+		})
+	}
+}
+
+func TestIsRetryableError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "timeout", err: ErrHTTPTimeout, want: true},
+		{name: "503", err: &HTTPStatusError{StatusCode: http.StatusServiceUnavailable}, want: true},
+		{name: "500", err: &HTTPStatusError{StatusCode: http.StatusInternalServerError}, want: true},
+		{name: "429", err: &HTTPStatusError{StatusCode: http.StatusTooManyRequests}, want: true},
+		{name: "400", err: &HTTPStatusError{StatusCode: http.StatusBadRequest}},
+		{name: "401", err: &HTTPStatusError{StatusCode: http.StatusUnauthorized}},
+		{name: "wrapped 503", err: errors.Wrap(&HTTPStatusError{StatusCode: http.StatusServiceUnavailable}, "post"),
+			want: true},
+		{name: "connection refused", err: &url.Error{Op: "Post", Err: errors.New("connection refused")}, want: true},
+		{name: "invalid URL", err: &url.Error{Op: "parse", Err: errors.New("invalid")}},
+		{name: "cancelled", err: errors.Wrap(context.Canceled, "post")},
+		{name: "other", err: errors.New("marshal failed")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isRetryableError(tt.err); got != tt.want {
+				t.Errorf("isRetryableError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPostToURLWithRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statusCode   int64
+		maxRetries   int
+		wantErr      bool
+		wantRequests int64
+	}{
+		{name: "success", statusCode: http.StatusOK, maxRetries: 3, wantRequests: 1},
+		{name: "503 retried", statusCode: http.StatusServiceUnavailable, maxRetries: 3, wantErr: true,
+			wantRequests: 4},
+		{name: "429 retried", statusCode: http.StatusTooManyRequests, maxRetries: 2, wantErr: true, wantRequests: 3},
+		{name: "400 not retried", statusCode: http.StatusBadRequest, maxRetries: 3, wantErr: true, wantRequests: 1},
+		{name: "401 not retried", statusCode: http.StatusUnauthorized, maxRetries: 3, wantErr: true, wantRequests: 1},
+		{name: "retries disabled", statusCode: http.StatusServiceUnavailable, wantErr: true, wantRequests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newRecordingServer(t)
+			server.statusCode.Store(tt.statusCode)
+			wh := NewWebHandler(server.URL, false, "", 0)
+			wh.MaxRetries = tt.maxRetries
+			wh.BaseBackoff = time.Millisecond
+
+			err := wh.postToURLWithRetries(server.URL, []byte("[]"), time.Second)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("postToURLWithRetries = %v, wantErr %v", err, tt.wantErr)
+			}
+			if requests := server.requests.Load(); requests != tt.wantRequests {
+				t.Errorf("server received %d requests, want %d", requests, tt.wantRequests)
+			}
+			if retries := wh.metrics.httpRetries.Load(); retries != uint64(tt.wantRequests-1) {
+				t.Errorf("httpRetries = %d, want %d", retries, tt.wantRequests-1)
+			}
+			var statusErr *HTTPStatusError
+			if tt.wantErr && (!errors.As(err, &statusErr) || int64(statusErr.StatusCode) != tt.statusCode) {
+				t.Errorf("postToURLWithRetries = %v, want the last attempt's %d status", err, tt.statusCode)
+			}
+		})
+	}
+}
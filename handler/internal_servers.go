@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+const (
+	// internalServerShutdownTimeout is how long internal servers are given to finish in-flight requests.
+	internalServerShutdownTimeout = 5 * time.Second
+)
+
+// InternalServer is one of the handler's internal HTTP servers. A server with an empty Addr is disabled.
+type InternalServer struct {
+	Name    string
+	Addr    string
+	Handler http.Handler
+}
+
+// StartInternalServers binds every enabled server and serves it in the background until ctx is done, at which
+// point the servers are shut down gracefully. All addresses are bound before any server starts serving, so a
+// binding failure is returned before anything is running.
+func StartInternalServers(ctx context.Context, servers ...InternalServer) error {
+	var listeners []net.Listener
+	var enabledServers []InternalServer
+	for _, server := range servers {
+		if server.Addr == "" {
+			glog.Infof("StartInternalServers: %s server disabled", server.Name)
+			continue
+		}
+		listener, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			return errors.Wrapf(err, "StartInternalServers: failed to bind %s server to %s", server.Name, server.Addr)
+		}
+		listeners = append(listeners, listener)
+		enabledServers = append(enabledServers, server)
+	}
+
+	for ii, server := range enabledServers {
+		httpServer := &http.Server{Handler: server.Handler}
+		listener := listeners[ii]
+		glog.Infof("StartInternalServers: %s server listening on %s", server.Name, listener.Addr())
+
+		go func(name string) {
+			if err := httpServer.Serve(listener); err != nil && err != http.ErrServerClosed {
+				glog.Errorf("StartInternalServers: %s server stopped: %v", name, err)
+			}
+		}(server.Name)
+
+		go func(name string) {
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), internalServerShutdownTimeout)
+			defer cancel()
+			if err := httpServer.Shutdown(shutdownCtx); err != nil {
+				glog.Errorf("StartInternalServers: failed to shut down %s server: %v", name, err)
+			}
+		}(server.Name)
+	}
+
+	return nil
+}
+
+// WebHandlerStatus is the state of a WebHandler reported by the admin /status endpoint.
+type WebHandlerStatus struct {
+	EndpointURL       string `json:"endpoint_url"`
+	UseWebSocket      bool   `json:"use_websocket"`
+	WSURL             string `json:"ws_url"`
+	ActiveTransport   string `json:"active_transport"`
+	WSFailures        int    `json:"ws_failures"`
+	CircuitState      string `json:"circuit_state"`
+	MinBlockHeight    uint64 `json:"min_block_height"`
+	Network           string `json:"network"`
+	DeliverySemantics string `json:"delivery_semantics"`
+	Paused            bool   `json:"paused"`
+	PausedBatches     int    `json:"paused_batches"`
+	RetryQueued       int    `json:"retry_queued"`
+	BatchesSent       uint64 `json:"batches_sent"`
+	EntriesSent       uint64 `json:"entries_sent"`
+	BatchesFailed     uint64 `json:"batches_failed"`
+	BatchesSkipped    uint64 `json:"batches_skipped"`
+	LastBlockHeight   uint64 `json:"last_block_height"`
+	HTTPConnsNew      uint64 `json:"http_connections_new"`
+	HTTPConnsReused   uint64 `json:"http_connections_reused"`
+
+	FanOut []FanOutTargetStatus `json:"fan_out,omitempty"`
+}
+
+// Status returns a snapshot of the handler's configuration and counters.
+func (wh *WebHandler) Status() WebHandlerStatus {
+	return WebHandlerStatus{
+		EndpointURL:       wh.EndpointURL,
+		UseWebSocket:      wh.UseWebSocket,
+		WSURL:             wh.WSURL,
+		ActiveTransport:   wh.activeTransport(),
+		WSFailures:        wh.wsFailover.failures(),
+		CircuitState:      wh.circuitBreaker.currentState(),
+		MinBlockHeight:    wh.MinBlockHeight,
+		Network:           networkPrefix(wh.GetParams(), wh.NetworkPrefix),
+		DeliverySemantics: wh.deliverySemantics(),
+		Paused:            wh.IsPaused(),
+		PausedBatches:     wh.pausedBatchCount(),
+		RetryQueued:       wh.retryQueueLength(),
+		BatchesSent:       wh.metrics.batchesSent.Load(),
+		EntriesSent:       wh.metrics.entriesSent.Load(),
+		BatchesFailed:     wh.metrics.batchesFailed.Load(),
+		BatchesSkipped:    wh.metrics.batchesSkipped.Load(),
+		LastBlockHeight:   wh.metrics.lastBlockHeight.Load(),
+		HTTPConnsNew:      wh.metrics.httpConnsNew.Load(),
+		HTTPConnsReused:   wh.metrics.httpConnsReused.Load(),
+		FanOut:            wh.fanOutStatus(),
+	}
+}
+
+// MetricsHandler serves the handler's counters in the Prometheus text exposition format on /metrics.
+func (wh *WebHandler) MetricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		for _, metric := range wh.metrics.samples() {
+			fmt.Fprintf(w, "# TYPE %s %s\n%s %d\n", metric.name, metric.kind, metric.name, metric.value)
+		}
+	})
+	return mux
+}
+
+// HealthHandler serves a liveness check on /healthz.
+func (wh *WebHandler) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok"}`))
+	})
+	return mux
+}
+
+// AdminHandler serves the admin endpoints. It should only be bound to internal addresses.
+func (wh *WebHandler) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(wh.Status()); err != nil {
+			glog.Errorf("WebHandler.AdminHandler: failed to encode status: %v", err)
+		}
+	})
+	mux.HandleFunc("/pause", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		wh.Pause()
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/resume", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := wh.Resume(); err != nil {
+			glog.Errorf("WebHandler.AdminHandler: %v", err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// freeAddr returns a local address that nothing is listening on.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	return addr
+}
+
+// namedHandler answers every request with the name.
+func namedHandler(name string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, name)
+	})
+}
+
+// getServerName returns the name served at the address, or an error if nothing is serving it.
+func getServerName(addr string) (string, error) {
+	client := &http.Client{Timeout: time.Second}
+	resp, err := client.Get("http://" + addr + "/")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestStartInternalServers(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled map[string]bool
+	}{
+		{name: "all enabled", enabled: map[string]bool{"metrics": true, "health": true, "admin": true}},
+		{name: "only health", enabled: map[string]bool{"health": true}},
+		{name: "admin disabled", enabled: map[string]bool{"metrics": true, "health": true}},
+		{name: "all disabled", enabled: map[string]bool{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// Every server gets an address, which is only passed on when the server is enabled.
+			addrs := map[string]string{}
+			var servers []InternalServer
+			for _, name := range []string{"metrics", "health", "admin"} {
+				addrs[name] = freeAddr(t)
+				server := InternalServer{Name: name, Handler: namedHandler(name)}
+				if tt.enabled[name] {
+					server.Addr = addrs[name]
+				}
+				servers = append(servers, server)
+			}
+			if err := StartInternalServers(ctx, servers...); err != nil {
+				t.Fatalf("StartInternalServers: %v", err)
+			}
+
+			for name, addr := range addrs {
+				servedName, err := getServerName(addr)
+				if tt.enabled[name] && (err != nil || servedName != name) {
+					t.Errorf("%s server at %s served %q, %v", name, addr, servedName, err)
+				}
+				if !tt.enabled[name] && err == nil {
+					t.Errorf("disabled %s server is serving %q at %s", name, servedName, addr)
+				}
+			}
+		})
+	}
+}
+
+func TestStartInternalServersBindFailure(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer taken.Close()
+
+	metricsAddr := freeAddr(t)
+	err = StartInternalServers(context.Background(),
+		InternalServer{Name: "metrics", Addr: metricsAddr, Handler: namedHandler("metrics")},
+		InternalServer{Name: "admin", Addr: taken.Addr().String(), Handler: namedHandler("admin")},
+	)
+	if err == nil {
+		t.Fatal("expected binding an address in use to fail")
+	}
+	// The servers bound before the failure are released rather than left serving.
+	if servedName, err := getServerName(metricsAddr); err == nil {
+		t.Errorf("metrics server is serving %q after the admin server failed to bind", servedName)
+	}
+}
+
+func TestStartInternalServersShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	addr := freeAddr(t)
+	err := StartInternalServers(ctx, InternalServer{Name: "health", Addr: addr, Handler: namedHandler("health")})
+	if err != nil {
+		t.Fatalf("StartInternalServers: %v", err)
+	}
+	if _, err := getServerName(addr); err != nil {
+		t.Fatalf("health server isn't serving: %v", err)
+	}
+
+	cancel()
+	waitFor(t, "the health server to shut down", func() bool {
+		_, err := getServerName(addr)
+		return err != nil
+	})
+}
@@ -0,0 +1,128 @@
+package handler
+
+import (
+	"encoding/hex"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/deso-protocol/core/lib"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/pkg/errors"
+)
+
+// entryPatchKey is the key under which a patched entry carries its RFC 6902 JSON Patch, in place of the entry
+// itself under entryEncoderKey.
+const entryPatchKey = "EncoderPatch"
+
+// JSONPatchOperation is a single RFC 6902 JSON Patch operation. Only add, remove and replace are emitted.
+type JSONPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// getPatchCache returns the cache of the last sent state of every key, creating it on first use.
+func (wh *WebHandler) getPatchCache() (*lru.Cache[string, map[string]interface{}], error) {
+	if wh.patchCache == nil {
+		patchCache, err := lru.New[string, map[string]interface{}](wh.PatchCacheSize)
+		if err != nil {
+			return nil, errors.Wrap(err, "WebHandler.getPatchCache: failed to create cache")
+		}
+		wh.patchCache = patchCache
+	}
+	return wh.patchCache, nil
+}
+
+// resetPatchCache forgets every cached state, so that the next entry of every key is sent in full. It's called
+// when a batch fails to send, as the receiver may not have the states the cache holds.
+func (wh *WebHandler) resetPatchCache() {
+	if wh.patchCache != nil {
+		wh.patchCache.Purge()
+	}
+}
+
+// patchEntries replaces the entry of every upsert whose key was sent before with a JSON Patch from the previously
+// sent state to the new one. Deletes, first sightings and keys that were evicted from the cache (cache misses)
+// are sent in full. The entries must be in the same order as the batch, with their field names already renamed.
+func (wh *WebHandler) patchEntries(batchedEntries []*lib.StateChangeEntry, entries []map[string]interface{},
+	renameField func(string) string) error {
+
+	patchCache, err := wh.getPatchCache()
+	if err != nil {
+		return err
+	}
+	encoderKey, patchKey := entryEncoderKey, entryPatchKey
+	if renameField != nil {
+		encoderKey, patchKey = renameField(entryEncoderKey), renameField(entryPatchKey)
+	}
+
+	for ii, entry := range entries {
+		stateChangeEntry := batchedEntries[ii]
+		cacheKey := hex.EncodeToString(stateChangeEntry.KeyBytes)
+		if stateChangeEntry.OperationType == lib.DbOperationTypeDelete {
+			patchCache.Remove(cacheKey)
+			continue
+		}
+
+		newState, isMap := entry[encoderKey].(map[string]interface{})
+		if !isMap {
+			continue
+		}
+		previousState, cached := patchCache.Get(cacheKey)
+		patchCache.Add(cacheKey, newState)
+		if !cached || (stateChangeEntry.OperationType != lib.DbOperationTypeUpsert &&
+			stateChangeEntry.OperationType != lib.DbOperationTypeUpdate) {
+			continue
+		}
+
+		delete(entry, encoderKey)
+		entry[patchKey] = diffJSON(previousState, newState, "", nil)
+	}
+	return nil
+}
+
+// diffJSON appends the operations that turn the previous value into the current one to patch. Objects are diffed key
+// by key, while any other changed value, including arrays, is replaced as a whole.
+func diffJSON(previous interface{}, current interface{}, path string, patch []JSONPatchOperation) []JSONPatchOperation {
+	previousObject, previousIsObject := previous.(map[string]interface{})
+	newObject, newIsObject := current.(map[string]interface{})
+	if !previousIsObject || !newIsObject {
+		if !reflect.DeepEqual(previous, current) {
+			patch = append(patch, JSONPatchOperation{Op: "replace", Path: path, Value: current})
+		}
+		return patch
+	}
+
+	// Sort the keys so that the same change always produces the same patch.
+	keys := make([]string, 0, len(previousObject)+len(newObject))
+	for key := range previousObject {
+		keys = append(keys, key)
+	}
+	for key := range newObject {
+		if _, exists := previousObject[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		keyPath := path + "/" + escapeJSONPointer(key)
+		previousValue, previousExists := previousObject[key]
+		newValue, newExists := newObject[key]
+		switch {
+		case !newExists:
+			patch = append(patch, JSONPatchOperation{Op: "remove", Path: keyPath})
+		case !previousExists:
+			patch = append(patch, JSONPatchOperation{Op: "add", Path: keyPath, Value: newValue})
+		default:
+			patch = diffJSON(previousValue, newValue, keyPath, patch)
+		}
+	}
+	return patch
+}
+
+// escapeJSONPointer escapes a key for use in an RFC 6901 JSON Pointer.
+func escapeJSONPointer(key string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/deso-protocol/core/lib"
+)
+
+func TestDiffJSON(t *testing.T) {
+	tests := []struct {
+		name      string
+		previous  map[string]interface{}
+		current   map[string]interface{}
+		wantPatch []JSONPatchOperation
+	}{
+		{
+			name:     "unchanged",
+			previous: map[string]interface{}{"Body": "a", "LikeCount": json.Number("1")},
+			current:  map[string]interface{}{"Body": "a", "LikeCount": json.Number("1")},
+		},
+		{
+			name:      "replaced value",
+			previous:  map[string]interface{}{"Body": "a", "LikeCount": json.Number("1")},
+			current:   map[string]interface{}{"Body": "a", "LikeCount": json.Number("2")},
+			wantPatch: []JSONPatchOperation{{Op: "replace", Path: "/LikeCount", Value: json.Number("2")}},
+		},
+		{
+			name:     "added and removed keys",
+			previous: map[string]interface{}{"Body": "a", "IsHidden": false},
+			current:  map[string]interface{}{"Body": "a", "IsPinned": true},
+			wantPatch: []JSONPatchOperation{
+				{Op: "remove", Path: "/IsHidden"},
+				{Op: "add", Path: "/IsPinned", Value: true},
+			},
+		},
+		{
+			name:     "nested object",
+			previous: map[string]interface{}{"PostExtraData": map[string]interface{}{"app": "web", "lang": "en"}},
+			current:  map[string]interface{}{"PostExtraData": map[string]interface{}{"app": "ios", "lang": "en"}},
+			wantPatch: []JSONPatchOperation{
+				{Op: "replace", Path: "/PostExtraData/app", Value: "ios"},
+			},
+		},
+		{
+			name:      "array replaced whole",
+			previous:  map[string]interface{}{"Tags": []interface{}{"a", "b"}},
+			current:   map[string]interface{}{"Tags": []interface{}{"a", "c"}},
+			wantPatch: []JSONPatchOperation{{Op: "replace", Path: "/Tags", Value: []interface{}{"a", "c"}}},
+		},
+		{
+			name:     "escaped keys",
+			previous: map[string]interface{}{"a/b": "1", "c~d": "1"},
+			current:  map[string]interface{}{"a/b": "2", "c~d": "2"},
+			wantPatch: []JSONPatchOperation{
+				{Op: "replace", Path: "/a~1b", Value: "2"},
+				{Op: "replace", Path: "/c~0d", Value: "2"},
+			},
+		},
+		{
+			name:      "object replaced by a value",
+			previous:  map[string]interface{}{"PostExtraData": map[string]interface{}{"app": "web"}},
+			current:   map[string]interface{}{"PostExtraData": nil},
+			wantPatch: []JSONPatchOperation{{Op: "replace", Path: "/PostExtraData", Value: nil}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if patch := diffJSON(tt.previous, tt.current, "", nil); !reflect.DeepEqual(patch, tt.wantPatch) {
+				t.Errorf("patch = %+v, want %+v", patch, tt.wantPatch)
+			}
+		})
+	}
+}
+
+func TestPatchEntries(t *testing.T) {
+	tests := []struct {
+		name           string
+		fieldNameStyle string
+		encoderKey     string
+		patchKey       string
+	}{
+		{name: "pascal", encoderKey: "Encoder", patchKey: "EncoderPatch"},
+		{name: "snake", fieldNameStyle: FieldNameStyleSnake, encoderKey: "encoder", patchKey: "encoder_patch"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh := NewWebHandler("", false, "", 0)
+			wh.PatchCacheSize = 10
+			wh.FieldNameStyle = tt.fieldNameStyle
+			marshalEntry := func(entry *lib.StateChangeEntry) map[string]interface{} {
+				data, err := wh.marshalBatch([]*lib.StateChangeEntry{entry})
+				if err != nil {
+					t.Fatalf("marshalBatch: %v", err)
+				}
+				return decodeTestBatch(t, data)[0]
+			}
+
+			// The first sighting of a key is sent in full.
+			if fields := marshalEntry(newTestPostEntry()); fields[tt.encoderKey] == nil || fields[tt.patchKey] != nil {
+				t.Fatalf("first entry = %v, want it in full", fields)
+			}
+
+			// A later upsert of the key is sent as a patch from the state sent before.
+			updated := newTestPostEntry()
+			updated.Encoder.(*lib.PostEntry).Body = []byte("edited")
+			fields := marshalEntry(updated)
+			if fields[tt.encoderKey] != nil {
+				t.Errorf("patched entry still holds %s", tt.encoderKey)
+			}
+			bodyKey := "Body"
+			if tt.fieldNameStyle == FieldNameStyleSnake {
+				bodyKey = "body"
+			}
+			wantPatch := []interface{}{map[string]interface{}{
+				"op": "replace", "path": "/" + bodyKey, "value": base64.StdEncoding.EncodeToString([]byte("edited")),
+			}}
+			if !reflect.DeepEqual(fields[tt.patchKey], wantPatch) {
+				t.Errorf("%s = %v, want %v", tt.patchKey, fields[tt.patchKey], wantPatch)
+			}
+
+			// A delete is sent in full and forgets the key, so that the next upsert is sent in full too.
+			deleted := newTestPostEntry()
+			deleted.OperationType = lib.DbOperationTypeDelete
+			if fields = marshalEntry(deleted); fields[tt.patchKey] != nil {
+				t.Errorf("delete = %v, want it in full", fields)
+			}
+			if fields = marshalEntry(updated); fields[tt.encoderKey] == nil || fields[tt.patchKey] != nil {
+				t.Errorf("upsert after a delete = %v, want it in full", fields)
+			}
+		})
+	}
+}
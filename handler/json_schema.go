@@ -0,0 +1,248 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// ValidationPolicyFail fails the whole batch if any entry doesn't conform to the validation schema.
+	ValidationPolicyFail = "fail"
+	// ValidationPolicyDrop logs and drops the entries that don't conform, and sends the rest of the batch.
+	ValidationPolicyDrop = "drop"
+)
+
+// JSONSchema validates entries against a JSON Schema. It supports the type, enum, const, properties, required,
+// additionalProperties, items, minimum, maximum, minLength, maxLength, pattern, minItems and maxItems keywords,
+// which cover the shape of serialized entries. Other keywords are ignored.
+type JSONSchema struct {
+	schema map[string]interface{}
+	// patterns caches the compiled pattern keywords.
+	patterns map[string]*regexp.Regexp
+}
+
+// LoadJSONSchema reads and parses the JSON Schema at path.
+func LoadJSONSchema(path string) (*JSONSchema, error) {
+	schemaData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "LoadJSONSchema: failed to read %s", path)
+	}
+	return ParseJSONSchema(schemaData)
+}
+
+// ParseJSONSchema parses a JSON Schema document and compiles its patterns.
+func ParseJSONSchema(schemaData []byte) (*JSONSchema, error) {
+	var schema map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(schemaData))
+	decoder.UseNumber()
+	if err := decoder.Decode(&schema); err != nil {
+		return nil, errors.Wrap(err, "ParseJSONSchema: schema is not a JSON object")
+	}
+
+	jsonSchema := &JSONSchema{schema: schema, patterns: make(map[string]*regexp.Regexp)}
+	if err := jsonSchema.compilePatterns(schema); err != nil {
+		return nil, err
+	}
+	return jsonSchema, nil
+}
+
+func (js *JSONSchema) compilePatterns(schema interface{}) error {
+	switch typedSchema := schema.(type) {
+	case map[string]interface{}:
+		for key, value := range typedSchema {
+			if pattern, isString := value.(string); key == "pattern" && isString {
+				compiledPattern, err := regexp.Compile(pattern)
+				if err != nil {
+					return errors.Wrapf(err, "JSONSchema.compilePatterns: invalid pattern %s", pattern)
+				}
+				js.patterns[pattern] = compiledPattern
+				continue
+			}
+			if err := js.compilePatterns(value); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, value := range typedSchema {
+			if err := js.compilePatterns(value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Validate returns an error describing the first way the decoded JSON value doesn't conform to the schema.
+// Numbers must be decoded as json.Number.
+func (js *JSONSchema) Validate(value interface{}) error {
+	return js.validate(js.schema, value, "")
+}
+
+func (js *JSONSchema) validate(schema map[string]interface{}, value interface{}, path string) error {
+	location := path
+	if location == "" {
+		location = "/"
+	}
+
+	if schemaType, exists := schema["type"]; exists && !matchesSchemaType(schemaType, value) {
+		return fmt.Errorf("%s: expected type %v, got %s", location, schemaType, jsonTypeName(value))
+	}
+	if enum, isArray := schema["enum"].([]interface{}); isArray {
+		matches := false
+		for _, allowedValue := range enum {
+			matches = matches || reflect.DeepEqual(allowedValue, value)
+		}
+		if !matches {
+			return fmt.Errorf("%s: %v is not one of %v", location, value, enum)
+		}
+	}
+	if constValue, exists := schema["const"]; exists && !reflect.DeepEqual(constValue, value) {
+		return fmt.Errorf("%s: expected %v, got %v", location, constValue, value)
+	}
+
+	switch typedValue := value.(type) {
+	case map[string]interface{}:
+		return js.validateObject(schema, typedValue, path)
+	case []interface{}:
+		return js.validateArray(schema, typedValue, path)
+	case string:
+		length := float64(utf8.RuneCountInString(typedValue))
+		if minLength, exists := schemaNumber(schema, "minLength"); exists && length < minLength {
+			return fmt.Errorf("%s: shorter than %v characters", location, minLength)
+		}
+		if maxLength, exists := schemaNumber(schema, "maxLength"); exists && length > maxLength {
+			return fmt.Errorf("%s: longer than %v characters", location, maxLength)
+		}
+		if pattern, isString := schema["pattern"].(string); isString && !js.patterns[pattern].MatchString(typedValue) {
+			return fmt.Errorf("%s: does not match pattern %s", location, pattern)
+		}
+	case json.Number:
+		number, err := typedValue.Float64()
+		if err != nil {
+			return fmt.Errorf("%s: invalid number %s", location, typedValue)
+		}
+		if minimum, exists := schemaNumber(schema, "minimum"); exists && number < minimum {
+			return fmt.Errorf("%s: %v is less than %v", location, typedValue, minimum)
+		}
+		if maximum, exists := schemaNumber(schema, "maximum"); exists && number > maximum {
+			return fmt.Errorf("%s: %v is greater than %v", location, typedValue, maximum)
+		}
+	}
+	return nil
+}
+
+func (js *JSONSchema) validateObject(schema map[string]interface{}, value map[string]interface{}, path string) error {
+	if required, isArray := schema["required"].([]interface{}); isArray {
+		for _, field := range required {
+			if fieldName, isString := field.(string); isString {
+				if _, exists := value[fieldName]; !exists {
+					return fmt.Errorf("%s/%s: required field is missing", path, escapeJSONPointer(fieldName))
+				}
+			}
+		}
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	for field, fieldValue := range value {
+		fieldPath := path + "/" + escapeJSONPointer(field)
+		if propertySchema, isObject := properties[field].(map[string]interface{}); isObject {
+			if err := js.validate(propertySchema, fieldValue, fieldPath); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, isDefined := properties[field]; isDefined {
+			continue
+		}
+		switch additionalProperties := schema["additionalProperties"].(type) {
+		case bool:
+			if !additionalProperties {
+				return fmt.Errorf("%s: field is not allowed", fieldPath)
+			}
+		case map[string]interface{}:
+			if err := js.validate(additionalProperties, fieldValue, fieldPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (js *JSONSchema) validateArray(schema map[string]interface{}, value []interface{}, path string) error {
+	location := path
+	if location == "" {
+		location = "/"
+	}
+	if minItems, exists := schemaNumber(schema, "minItems"); exists && float64(len(value)) < minItems {
+		return fmt.Errorf("%s: fewer than %v items", location, minItems)
+	}
+	if maxItems, exists := schemaNumber(schema, "maxItems"); exists && float64(len(value)) > maxItems {
+		return fmt.Errorf("%s: more than %v items", location, maxItems)
+	}
+	if itemSchema, isObject := schema["items"].(map[string]interface{}); isObject {
+		for ii, item := range value {
+			if err := js.validate(itemSchema, item, fmt.Sprintf("%s/%d", path, ii)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// schemaNumber returns the numeric value of a schema keyword.
+func schemaNumber(schema map[string]interface{}, keyword string) (float64, bool) {
+	number, isNumber := schema[keyword].(json.Number)
+	if !isNumber {
+		return 0, false
+	}
+	value, err := number.Float64()
+	return value, err == nil
+}
+
+// matchesSchemaType returns whether the value has the schema type, which is a type name or a list of them.
+func matchesSchemaType(schemaType interface{}, value interface{}) bool {
+	switch typedSchemaType := schemaType.(type) {
+	case string:
+		valueType := jsonTypeName(value)
+		if typedSchemaType == "integer" {
+			number, isNumber := value.(json.Number)
+			return isNumber && !strings.ContainsAny(number.String(), ".eE")
+		}
+		return valueType == typedSchemaType
+	case []interface{}:
+		for _, allowedType := range typedSchemaType {
+			if matchesSchemaType(allowedType, value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jsonTypeName returns the JSON Schema type name of a decoded JSON value.
+func jsonTypeName(value interface{}) string {
+	switch value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number, float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
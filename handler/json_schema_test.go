@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testEntrySchema requires entries to be at block height 2 or above, with the nested entry as an object.
+const testEntrySchema = `{
+	"type": "object",
+	"required": ["BlockHeight", "Encoder"],
+	"properties": {
+		"BlockHeight": {"type": "integer", "minimum": 2},
+		"Encoder": {"type": "object"}
+	}
+}`
+
+func TestJSONSchemaValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		schema    string
+		value     string
+		wantError string
+	}{
+		{name: "conforms", schema: testEntrySchema, value: `{"BlockHeight": 5, "Encoder": {}}`},
+		{name: "missing required", schema: testEntrySchema, value: `{"BlockHeight": 5}`, wantError: "Encoder"},
+		{name: "wrong type", schema: testEntrySchema, value: `{"BlockHeight": "5", "Encoder": {}}`,
+			wantError: "/BlockHeight: expected type integer"},
+		{name: "below minimum", schema: testEntrySchema, value: `{"BlockHeight": 1, "Encoder": {}}`,
+			wantError: "/BlockHeight"},
+		{name: "enum", schema: `{"enum": ["posts", "likes"]}`, value: `"follows"`, wantError: "not one of"},
+		{name: "pattern", schema: `{"type": "string", "pattern": "^[a-z]+$"}`, value: `"Posts"`,
+			wantError: "pattern"},
+		{name: "max length", schema: `{"type": "string", "maxLength": 3}`, value: `"posts"`,
+			wantError: "longer than 3 characters"},
+		{name: "array items", schema: `{"type": "array", "items": {"type": "integer"}}`, value: `[1, "2"]`,
+			wantError: "/1: expected type integer"},
+		{name: "additional properties", schema: `{"type": "object", "additionalProperties": false}`,
+			value: `{"Extra": 1}`, wantError: "Extra"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := ParseJSONSchema([]byte(tt.schema))
+			if err != nil {
+				t.Fatalf("ParseJSONSchema: %v", err)
+			}
+			var value interface{}
+			decoder := json.NewDecoder(bytes.NewReader([]byte(tt.value)))
+			decoder.UseNumber()
+			if err = decoder.Decode(&value); err != nil {
+				t.Fatalf("failed to decode %s: %v", tt.value, err)
+			}
+
+			err = schema.Validate(value)
+			if tt.wantError == "" && err != nil {
+				t.Errorf("Validate = %v, want no error", err)
+			}
+			if tt.wantError != "" && (err == nil || !strings.Contains(err.Error(), tt.wantError)) {
+				t.Errorf("Validate = %v, want an error containing %q", err, tt.wantError)
+			}
+		})
+	}
+}
+
+func TestValidationPolicy(t *testing.T) {
+	schema, err := ParseJSONSchema([]byte(testEntrySchema))
+	if err != nil {
+		t.Fatalf("ParseJSONSchema: %v", err)
+	}
+	tests := []struct {
+		name             string
+		validationPolicy string
+		wantErr          bool
+		wantHeights      [][]uint64
+	}{
+		{name: "default", wantErr: true},
+		{name: "fail", validationPolicy: ValidationPolicyFail, wantErr: true},
+		{name: "drop", validationPolicy: ValidationPolicyDrop, wantHeights: [][]uint64{{5, 5}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newRecordingServer(t)
+			wh := NewWebHandler(server.URL, false, "", 0)
+			wh.MaxDeliveryAttempts = 1
+			wh.ValidationSchema = schema
+			wh.ValidationPolicy = tt.validationPolicy
+
+			// The entry at height 1 is below the schema's minimum.
+			batch := newTestEntries(3, 5)
+			batch[1].BlockHeight = 1
+			err = wh.HandleEntryBatch(batch)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("HandleEntryBatch = %v, wantErr %v", err, tt.wantErr)
+			}
+			if heights := server.batchHeights(); !reflect.DeepEqual(heights, tt.wantHeights) {
+				t.Errorf("server received %v, want %v", heights, tt.wantHeights)
+			}
+			if invalid := wh.metrics.entriesInvalid.Load(); invalid != 1 {
+				t.Errorf("entriesInvalid = %d, want 1", invalid)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/deso-protocol/core/lib"
+)
+
+// KeyExtractor returns the routing key of an entry, such as a partition key, an ordering key or part of a URL.
+// Entries with the same key are routed together, so handlers that preserve order per key deliver them in order.
+type KeyExtractor func(entry *lib.StateChangeEntry) string
+
+const (
+	KeyExtractorNameEncoderType = "encoder_type"
+	KeyExtractorNamePublicKey   = "public_key"
+	KeyExtractorNameBlockHeight = "block_height"
+)
+
+// keyExtractors maps the names accepted by GetKeyExtractor to the built-in extractors.
+var keyExtractors = map[string]KeyExtractor{
+	KeyExtractorNameEncoderType: KeyByEncoderType,
+	KeyExtractorNamePublicKey:   KeyByPublicKey,
+	KeyExtractorNameBlockHeight: KeyByBlockHeight,
+}
+
+// GetKeyExtractor returns the built-in extractor with the given name. An empty name returns KeyByEncoderType.
+func GetKeyExtractor(name string) (KeyExtractor, error) {
+	if name == "" {
+		return KeyByEncoderType, nil
+	}
+	keyExtractor, exists := keyExtractors[name]
+	if !exists {
+		return nil, fmt.Errorf("GetKeyExtractor: unknown key extractor %s", name)
+	}
+	return keyExtractor, nil
+}
+
+// KeyByEncoderType routes entries by their type, so that all entries of a type are routed together.
+func KeyByEncoderType(entry *lib.StateChangeEntry) string {
+	return strconv.FormatUint(uint64(entry.EncoderType), 10)
+}
+
+// KeyByBlockHeight routes entries by the block height they were flushed at.
+func KeyByBlockHeight(entry *lib.StateChangeEntry) string {
+	return strconv.FormatUint(entry.BlockHeight, 10)
+}
+
+// publicKeyFields are the entry fields checked, in order, by KeyByPublicKey.
+var publicKeyFields = []string{"PublicKey", "PosterPublicKey", "OwnerPKID", "HODLerPKID", "PKID"}
+
+// KeyByPublicKey routes entries by the public key or PKID of the account they belong to, so that all entries of
+// an account are routed together. Entries without one fall back to KeyByEncoderType.
+func KeyByPublicKey(entry *lib.StateChangeEntry) string {
+	if entry.Encoder != nil {
+		encoderJSON, err := json.Marshal(entry.Encoder)
+		if err == nil {
+			var fields map[string]interface{}
+			if err = json.Unmarshal(encoderJSON, &fields); err == nil {
+				for _, field := range publicKeyFields {
+					if value, exists := fields[field]; exists && value != nil {
+						return fmt.Sprint(value)
+					}
+				}
+			}
+		}
+	}
+	return KeyByEncoderType(entry)
+}
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"encoding/base64"
+	"strconv"
+	"testing"
+
+	"github.com/deso-protocol/core/lib"
+)
+
+func TestKeyExtractors(t *testing.T) {
+	likeType := strconv.FormatUint(uint64(lib.EncoderTypeLikeEntry), 10)
+	postType := strconv.FormatUint(uint64(lib.EncoderTypePostEntry), 10)
+	deletedPost := &lib.StateChangeEntry{
+		OperationType: lib.DbOperationTypeDelete,
+		KeyBytes:      []byte("post-key"),
+		EncoderType:   lib.EncoderTypePostEntry,
+		BlockHeight:   9,
+	}
+	profile := &lib.StateChangeEntry{
+		OperationType: lib.DbOperationTypeUpsert,
+		Encoder:       &lib.ProfileEntry{PublicKey: []byte("alice")},
+		EncoderType:   lib.EncoderTypeProfileEntry,
+	}
+	tests := []struct {
+		name          string
+		extractorName string
+		entry         *lib.StateChangeEntry
+		wantKey       string
+	}{
+		{name: "encoder type", extractorName: KeyExtractorNameEncoderType, entry: newTestEntries(1, 7)[0],
+			wantKey: likeType},
+		{name: "default", entry: newTestEntries(1, 7)[0], wantKey: likeType},
+		{name: "block height", extractorName: KeyExtractorNameBlockHeight, entry: newTestEntries(1, 7)[0],
+			wantKey: "7"},
+		{name: "poster public key", extractorName: KeyExtractorNamePublicKey, entry: newTestPostEntry(),
+			wantKey: base64.StdEncoding.EncodeToString([]byte("poster"))},
+		{name: "profile public key", extractorName: KeyExtractorNamePublicKey, entry: profile,
+			wantKey: base64.StdEncoding.EncodeToString([]byte("alice"))},
+		{name: "no public key field", extractorName: KeyExtractorNamePublicKey, entry: newTestEntries(1, 7)[0],
+			wantKey: likeType},
+		{name: "public key of a delete", extractorName: KeyExtractorNamePublicKey, entry: deletedPost,
+			wantKey: postType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keyExtractor, err := GetKeyExtractor(tt.extractorName)
+			if err != nil {
+				t.Fatalf("GetKeyExtractor(%q): %v", tt.extractorName, err)
+			}
+			if key := keyExtractor(tt.entry); key != tt.wantKey {
+				t.Errorf("key = %q, want %q", key, tt.wantKey)
+			}
+		})
+	}
+}
+
+func TestGetKeyExtractorUnknown(t *testing.T) {
+	if _, err := GetKeyExtractor("username"); err == nil {
+		t.Error("expected an unknown key extractor to fail")
+	}
+}
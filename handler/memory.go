@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/pkg/errors"
+)
+
+// Policies for shedding memory once the handler's buffers reach MaxMemoryBytes.
+const (
+	// MemoryPolicyBlock, the default, stops buffering: HandleEntryBatch blocks until the handler is resumed
+	// rather than buffering more paused batches, and coalesced updates are flushed early.
+	MemoryPolicyBlock = "block"
+	// MemoryPolicyDropOldest drops the oldest paused batches and coalesced updates to make room. Dropped entries
+	// are counted in the shed metric and are lost.
+	MemoryPolicyDropOldest = "drop_oldest"
+	// MemoryPolicySpill writes paused batches to files in SpillDir, which are read back when the handler is
+	// resumed. Coalesced updates can't be spilled, so they are flushed early as under MemoryPolicyBlock.
+	MemoryPolicySpill = "spill"
+)
+
+// entryMemoryOverhead approximates the memory taken by a StateChangeEntry besides its byte slices, including its
+// decoded encoders.
+const entryMemoryOverhead = 512
+
+// entriesMemorySize approximates the memory taken by the entries. It errs on the side of overestimating.
+func entriesMemorySize(entries []*lib.StateChangeEntry) int64 {
+	var size int64
+	for _, entry := range entries {
+		size += entryMemorySize(entry)
+	}
+	return size
+}
+
+func entryMemorySize(entry *lib.StateChangeEntry) int64 {
+	// The decoded encoders hold roughly as much data again as their encoded bytes.
+	return entryMemoryOverhead + int64(len(entry.KeyBytes)) +
+		2*int64(len(entry.EncoderBytes)) + 2*int64(len(entry.AncestralRecordBytes))
+}
+
+// memoryShedPolicy returns the configured memory shedding policy, defaulting to MemoryPolicyBlock.
+func (wh *WebHandler) memoryShedPolicy() string {
+	if wh.MemoryShedPolicy == "" {
+		return MemoryPolicyBlock
+	}
+	return wh.MemoryShedPolicy
+}
+
+// memoryBudgetExceeded returns whether buffering another size bytes would exceed MaxMemoryBytes. The budget is
+// unlimited when MaxMemoryBytes is zero.
+func (wh *WebHandler) memoryBudgetExceeded(size int64) bool {
+	return exceedsMemoryBudget(&wh.metrics.memoryBytes, wh.MaxMemoryBytes, size)
+}
+
+func exceedsMemoryBudget(memoryBytes *atomic.Int64, maxMemoryBytes int64, size int64) bool {
+	return maxMemoryBytes > 0 && memoryBytes.Load()+size > maxMemoryBytes
+}
+
+// spillBatch writes the batch to a new file in SpillDir and returns its path.
+func (wh *WebHandler) spillBatch(batchedEntries []*lib.StateChangeEntry) (string, error) {
+	if wh.SpillDir == "" {
+		return "", fmt.Errorf("WebHandler.spillBatch: no spill directory configured")
+	}
+	if err := os.MkdirAll(wh.SpillDir, 0755); err != nil {
+		return "", errors.Wrapf(err, "WebHandler.spillBatch: failed to create %s", wh.SpillDir)
+	}
+	fileName := fmt.Sprintf("%d-%d.bin", batchedEntries[0].BlockHeight, time.Now().UnixNano())
+	path := filepath.Join(wh.SpillDir, fileName)
+
+	if err := os.WriteFile(path, encodeBatch(batchedEntries), 0644); err != nil {
+		return "", errors.Wrapf(err, "WebHandler.spillBatch: failed to write %s", path)
+	}
+	wh.metrics.batchesSpilled.Add(1)
+	return path, nil
+}
+
+// loadSpilledBatch reads back a batch written by spillBatch.
+func loadSpilledBatch(path string) ([]*lib.StateChangeEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "loadSpilledBatch: failed to read %s", path)
+	}
+	batchedEntries, err := decodeBatch(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "loadSpilledBatch: failed to decode %s", path)
+	}
+	return batchedEntries, nil
+}
+
+// encodeBatch encodes the entries for spill and dead letter files, as their binary encoding, each prefixed with its
+// length.
+func encodeBatch(batchedEntries []*lib.StateChangeEntry) []byte {
+	var data []byte
+	for _, entry := range batchedEntries {
+		entryBytes := lib.EncodeToBytes(entry.BlockHeight, entry)
+		data = binary.AppendUvarint(data, uint64(len(entryBytes)))
+		data = append(data, entryBytes...)
+	}
+	return data
+}
+
+// decodeBatch decodes the entries encoded by encodeBatch.
+func decodeBatch(data []byte) ([]*lib.StateChangeEntry, error) {
+	var batchedEntries []*lib.StateChangeEntry
+	reader := bufio.NewReader(bytes.NewReader(data))
+	for {
+		entryLength, err := binary.ReadUvarint(reader)
+		if err == io.EOF {
+			return batchedEntries, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "decodeBatch: failed to read entry length")
+		}
+		entryBytes := make([]byte, entryLength)
+		if _, err = io.ReadFull(reader, entryBytes); err != nil {
+			return nil, errors.Wrap(err, "decodeBatch: failed to read entry")
+		}
+		entry := &lib.StateChangeEntry{}
+		if _, err = lib.DecodeFromBytes(entry, bytes.NewReader(entryBytes)); err != nil {
+			return nil, errors.Wrap(err, "decodeBatch: failed to decode entry")
+		}
+		batchedEntries = append(batchedEntries, entry)
+	}
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+)
+
+func TestMemoryPolicyPausedBatches(t *testing.T) {
+	batchSize := entriesMemorySize(newTestEntries(1, 1))
+	tests := []struct {
+		name   string
+		policy string
+		// wantBlocked is whether the batch over the budget blocks until the handler is resumed.
+		wantBlocked bool
+		wantShed    uint64
+		wantSpilled uint64
+		wantHeights [][]uint64
+	}{
+		{name: "block", policy: MemoryPolicyBlock, wantBlocked: true, wantHeights: [][]uint64{{1}, {2}, {3}}},
+		{name: "default", wantBlocked: true, wantHeights: [][]uint64{{1}, {2}, {3}}},
+		{name: "drop oldest", policy: MemoryPolicyDropOldest, wantShed: 1, wantHeights: [][]uint64{{2}, {3}}},
+		{name: "spill", policy: MemoryPolicySpill, wantSpilled: 1, wantHeights: [][]uint64{{1}, {2}, {3}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newRecordingServer(t)
+			wh := NewWebHandler(server.URL, false, "", 0)
+			wh.MaxDeliveryAttempts = 1
+			wh.PauseMode = PauseModeBuffer
+			wh.MaxMemoryBytes = 2 * batchSize
+			wh.MemoryShedPolicy = tt.policy
+			wh.SpillDir = t.TempDir()
+			wh.Pause()
+
+			// The first two batches fit in the budget, and the third activates the policy.
+			for height := uint64(1); height <= 2; height++ {
+				if err := wh.HandleEntryBatch(newTestEntries(1, height)); err != nil {
+					t.Fatalf("HandleEntryBatch(%d): %v", height, err)
+				}
+			}
+			if wh.metrics.entriesShed.Load() != 0 || wh.metrics.batchesSpilled.Load() != 0 {
+				t.Fatal("expected no memory to be shed below the budget")
+			}
+			done := make(chan error, 1)
+			go func() {
+				done <- wh.HandleEntryBatch(newTestEntries(1, 3))
+			}()
+			select {
+			case err := <-done:
+				if tt.wantBlocked {
+					t.Fatalf("HandleEntryBatch returned %v over the budget, want it to block", err)
+				}
+				if err != nil {
+					t.Fatalf("HandleEntryBatch(3): %v", err)
+				}
+			case <-time.After(100 * time.Millisecond):
+				if !tt.wantBlocked {
+					t.Fatal("HandleEntryBatch blocked over the budget")
+				}
+			}
+
+			// Whatever the policy, the batches held in memory stay within the budget.
+			if memoryBytes := wh.metrics.memoryBytes.Load(); memoryBytes != 2*batchSize {
+				t.Errorf("memoryBytes = %d, want %d", memoryBytes, 2*batchSize)
+			}
+			if shed := wh.metrics.entriesShed.Load(); shed != tt.wantShed {
+				t.Errorf("entriesShed = %d, want %d", shed, tt.wantShed)
+			}
+			if spilled := wh.metrics.batchesSpilled.Load(); spilled != tt.wantSpilled {
+				t.Errorf("batchesSpilled = %d, want %d", spilled, tt.wantSpilled)
+			}
+
+			if err := wh.Resume(); err != nil {
+				t.Fatalf("Resume: %v", err)
+			}
+			if tt.wantBlocked {
+				if err := <-done; err != nil {
+					t.Fatalf("HandleEntryBatch(3): %v", err)
+				}
+			}
+			if heights := server.batchHeights(); !reflect.DeepEqual(heights, tt.wantHeights) {
+				t.Errorf("server received %v, want %v", heights, tt.wantHeights)
+			}
+			if memoryBytes := wh.metrics.memoryBytes.Load(); memoryBytes != 0 {
+				t.Errorf("memoryBytes = %d after Resume, want 0", memoryBytes)
+			}
+			if spillFiles, _ := os.ReadDir(wh.SpillDir); len(spillFiles) != 0 {
+				t.Errorf("%d spill files were left after Resume", len(spillFiles))
+			}
+		})
+	}
+}
+
+func TestMemoryPolicyCoalescedUpdates(t *testing.T) {
+	entrySize := entryMemorySize(testKeyedEntry("a", 1))
+	tests := []struct {
+		name   string
+		policy string
+		// wantImmediate are the heights sent as soon as the budget is exceeded, and wantFlushed the heights sent
+		// by the next flush.
+		wantImmediate [][]uint64
+		wantFlushed   []uint64
+		wantShed      uint64
+	}{
+		{name: "block flushes early", policy: MemoryPolicyBlock, wantImmediate: [][]uint64{{1, 2, 3}}},
+		{name: "spill flushes early", policy: MemoryPolicySpill, wantImmediate: [][]uint64{{1, 2, 3}}},
+		{name: "drop oldest", policy: MemoryPolicyDropOldest, wantFlushed: []uint64{2, 3}, wantShed: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newRecordingServer(t)
+			wh := NewWebHandler(server.URL, false, "", 0)
+			wh.MaxDeliveryAttempts = 1
+			wh.CoalesceWindow = time.Hour
+			wh.MaxMemoryBytes = 2 * entrySize
+			wh.MemoryShedPolicy = tt.policy
+
+			// The updates of the first two keys fit in the budget, and the third activates the policy.
+			for height, key := range []string{"a", "b"} {
+				entry := testKeyedEntry(key, uint64(height+1))
+				if err := wh.HandleEntryBatch([]*lib.StateChangeEntry{entry}); err != nil {
+					t.Fatalf("HandleEntryBatch(%s): %v", key, err)
+				}
+			}
+			if heights := server.batchHeights(); heights != nil {
+				t.Fatalf("server received %v below the budget, want nothing", heights)
+			}
+			if err := wh.HandleEntryBatch([]*lib.StateChangeEntry{testKeyedEntry("c", 3)}); err != nil {
+				t.Fatalf("HandleEntryBatch(c): %v", err)
+			}
+			if heights := server.batchHeights(); !reflect.DeepEqual(heights, tt.wantImmediate) {
+				t.Errorf("server received %v over the budget, want %v", heights, tt.wantImmediate)
+			}
+			if shed := wh.metrics.entriesShed.Load(); shed != tt.wantShed {
+				t.Errorf("entriesShed = %d, want %d", shed, tt.wantShed)
+			}
+
+			wh.flushCoalescedEntries()
+			wantHeights := tt.wantImmediate
+			if tt.wantFlushed != nil {
+				wantHeights = append(wantHeights, tt.wantFlushed)
+			}
+			if heights := server.batchHeights(); !reflect.DeepEqual(heights, wantHeights) {
+				t.Errorf("server received %v, want %v", heights, wantHeights)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"sync/atomic"
+
+	"github.com/deso-protocol/core/lib"
+)
+
+// webHandlerMetrics holds the counters exposed by the metrics server and the admin /status endpoint.
+type webHandlerMetrics struct {
+	batchesSent     atomic.Uint64
+	entriesSent     atomic.Uint64
+	batchesFailed   atomic.Uint64
+	batchesSkipped  atomic.Uint64
+	lastBlockHeight atomic.Uint64
+
+	// batchesRetried, batchesDropped and batchesDeadLettered count the outcomes of the delivery semantics.
+	batchesRetried      atomic.Uint64
+	batchesDropped      atomic.Uint64
+	batchesDeadLettered atomic.Uint64
+
+	// memoryBytes approximates the memory held by the handler's buffers, and entriesShed and batchesSpilled count
+	// the entries dropped and the batches spilled to disk to stay within MaxMemoryBytes.
+	memoryBytes    atomic.Int64
+	entriesShed    atomic.Uint64
+	batchesSpilled atomic.Uint64
+
+	// batchesQueued counts the batches that failed delivery and were queued for retry.
+	batchesQueued atomic.Uint64
+
+	// batchesExpired counts the buffered batches dropped for being older than BatchTTL.
+	batchesExpired atomic.Uint64
+
+	// entriesOversizeSent, entriesOversizeDropped and entriesOversizeTruncated count the outcomes of the oversize
+	// entry policy.
+	entriesOversizeSent      atomic.Uint64
+	entriesOversizeDropped   atomic.Uint64
+	entriesOversizeTruncated atomic.Uint64
+
+	// entriesInvalid counts the entries that didn't conform to the validation schema.
+	entriesInvalid atomic.Uint64
+
+	// httpConnsNew and httpConnsReused count the HTTP POSTs sent over a new connection and over a kept-alive one.
+	httpConnsNew    atomic.Uint64
+	httpConnsReused atomic.Uint64
+
+	// circuitOpened counts the times the circuit breaker opened.
+	circuitOpened atomic.Uint64
+
+	// httpRetries counts the HTTP POSTs sent again after a transient error.
+	httpRetries atomic.Uint64
+
+	// wsFailovers and wsRestores count the switches from WebSocket to HTTP and back.
+	wsFailovers atomic.Uint64
+	wsRestores  atomic.Uint64
+
+	// batchesDroppedOnShutdown counts the WebSocket batches rejected after Close was called.
+	batchesDroppedOnShutdown atomic.Uint64
+}
+
+// metricSample is a single value reported by the metrics server.
+type metricSample struct {
+	name  string
+	kind  string
+	value uint64
+}
+
+// recordBatch updates the counters after an attempt to send a batch.
+func (metrics *webHandlerMetrics) recordBatch(batchedEntries []*lib.StateChangeEntry, err error) {
+	if err != nil {
+		metrics.batchesFailed.Add(1)
+		return
+	}
+	metrics.batchesSent.Add(1)
+	metrics.entriesSent.Add(uint64(len(batchedEntries)))
+	metrics.lastBlockHeight.Store(batchedEntries[len(batchedEntries)-1].BlockHeight)
+}
+
+func (metrics *webHandlerMetrics) samples() []metricSample {
+	return []metricSample{
+		{name: "web_handler_batches_sent_total", kind: "counter", value: metrics.batchesSent.Load()},
+		{name: "web_handler_entries_sent_total", kind: "counter", value: metrics.entriesSent.Load()},
+		{name: "web_handler_batches_failed_total", kind: "counter", value: metrics.batchesFailed.Load()},
+		{name: "web_handler_batches_skipped_total", kind: "counter", value: metrics.batchesSkipped.Load()},
+		{name: "web_handler_last_block_height", kind: "gauge", value: metrics.lastBlockHeight.Load()},
+		{name: "web_handler_batches_retried_total", kind: "counter", value: metrics.batchesRetried.Load()},
+		{name: "web_handler_batches_dropped_total", kind: "counter", value: metrics.batchesDropped.Load()},
+		{name: "web_handler_batches_dead_lettered_total", kind: "counter", value: metrics.batchesDeadLettered.Load()},
+		{name: "web_handler_memory_bytes", kind: "gauge", value: uint64(max(metrics.memoryBytes.Load(), 0))},
+		{name: "web_handler_entries_shed_total", kind: "counter", value: metrics.entriesShed.Load()},
+		{name: "web_handler_batches_spilled_total", kind: "counter", value: metrics.batchesSpilled.Load()},
+		{name: "web_handler_batches_queued_total", kind: "counter", value: metrics.batchesQueued.Load()},
+		{name: "web_handler_batches_expired_total", kind: "counter", value: metrics.batchesExpired.Load()},
+		{name: "web_handler_entries_oversize_sent_total", kind: "counter", value: metrics.entriesOversizeSent.Load()},
+		{name: "web_handler_entries_oversize_dropped_total", kind: "counter", value: metrics.entriesOversizeDropped.Load()},
+		{name: "web_handler_entries_oversize_truncated_total", kind: "counter", value: metrics.entriesOversizeTruncated.Load()},
+		{name: "web_handler_entries_invalid_total", kind: "counter", value: metrics.entriesInvalid.Load()},
+		{name: "web_handler_http_connections_new_total", kind: "counter", value: metrics.httpConnsNew.Load()},
+		{name: "web_handler_http_connections_reused_total", kind: "counter", value: metrics.httpConnsReused.Load()},
+		{name: "web_handler_circuit_opened_total", kind: "counter", value: metrics.circuitOpened.Load()},
+		{name: "web_handler_http_retries_total", kind: "counter", value: metrics.httpRetries.Load()},
+		{name: "web_handler_ws_failovers_total", kind: "counter", value: metrics.wsFailovers.Load()},
+		{name: "web_handler_ws_restores_total", kind: "counter", value: metrics.wsRestores.Load()},
+		{name: "web_handler_batches_dropped_on_shutdown_total", kind: "counter", value: metrics.batchesDroppedOnShutdown.Load()},
+	}
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/pkg/errors"
+)
+
+// SendMiddleware is custom behavior run around every attempt to send a batch, such as enrichment, logging or
+// metrics, set in WebHandler.Middleware.
+//
+// The BeforeSend hooks run in the order of Middleware, after the batch has been filtered, coalesced and released by
+// the pause and delivery semantics, and each is passed the entries returned by the one before it. The entries
+// returned by the last one are sent. A hook that returns an error fails the attempt without sending it, and one that
+// returns no entries skips the batch, which counts as sent. The AfterSend hooks then run in reverse order, for every
+// middleware whose BeforeSend returned without an error, so that each observes the outcome of what it let through.
+//
+// A batch that fails is attempted again from the entries before the first BeforeSend, and is dead-lettered as such,
+// so middleware that changes entries must return new entries rather than modify the ones it is passed.
+type SendMiddleware interface {
+	BeforeSend(batchedEntries []*lib.StateChangeEntry) ([]*lib.StateChangeEntry, error)
+	AfterSend(result SendResult)
+}
+
+// SendResult is the outcome of an attempt to send a batch, passed to SendMiddleware.AfterSend.
+type SendResult struct {
+	// Entries are the entries returned by the BeforeSend of the middleware, which were sent unless Err is set.
+	Entries []*lib.StateChangeEntry
+	// Err is why the attempt failed, or nil if the entries were sent.
+	Err error
+	// Duration is how long the attempt took, from the first BeforeSend until the entries were sent or failed.
+	Duration time.Duration
+}
+
+// sendBatchThroughMiddleware runs the BeforeSend hooks of the middleware, sends the entries they return with send,
+// and runs the AfterSend hooks with the outcome. It returns the entries that were sent.
+func (wh *WebHandler) sendBatchThroughMiddleware(batchedEntries []*lib.StateChangeEntry,
+	send func([]*lib.StateChangeEntry) error) ([]*lib.StateChangeEntry, error) {
+
+	if len(wh.Middleware) == 0 {
+		return batchedEntries, send(batchedEntries)
+	}
+
+	startTime := time.Now()
+	var err error
+	ranCount := 0
+	for _, middleware := range wh.Middleware {
+		var entries []*lib.StateChangeEntry
+		if entries, err = middleware.BeforeSend(batchedEntries); err != nil {
+			err = errors.Wrapf(err, "WebHandler.sendBatchThroughMiddleware: middleware %d failed", ranCount)
+			break
+		}
+		batchedEntries = entries
+		ranCount++
+		if len(batchedEntries) == 0 {
+			break
+		}
+	}
+	if err == nil && len(batchedEntries) > 0 {
+		err = send(batchedEntries)
+	}
+
+	result := SendResult{
+		Entries:  batchedEntries,
+		Err:      err,
+		Duration: time.Since(startTime),
+	}
+	for ii := ranCount - 1; ii >= 0; ii-- {
+		wh.Middleware[ii].AfterSend(result)
+	}
+	return batchedEntries, err
+}
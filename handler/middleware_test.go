@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/pkg/errors"
+)
+
+// testMiddleware logs its hooks to calls, and transforms the entries with transform, if set.
+type testMiddleware struct {
+	name      string
+	calls     *[]string
+	transform func(batchedEntries []*lib.StateChangeEntry) ([]*lib.StateChangeEntry, error)
+}
+
+func (middleware *testMiddleware) BeforeSend(batchedEntries []*lib.StateChangeEntry) ([]*lib.StateChangeEntry, error) {
+	*middleware.calls = append(*middleware.calls, fmt.Sprintf("%s before %v", middleware.name,
+		entryHeights(batchedEntries)))
+	if middleware.transform == nil {
+		return batchedEntries, nil
+	}
+	return middleware.transform(batchedEntries)
+}
+
+func (middleware *testMiddleware) AfterSend(result SendResult) {
+	*middleware.calls = append(*middleware.calls, fmt.Sprintf("%s after %v %v", middleware.name,
+		entryHeights(result.Entries), result.Err != nil))
+}
+
+// entryHeights returns the block heights of the entries.
+func entryHeights(batchedEntries []*lib.StateChangeEntry) []uint64 {
+	heights := []uint64{}
+	for _, entry := range batchedEntries {
+		heights = append(heights, entry.BlockHeight)
+	}
+	return heights
+}
+
+// raiseHeights returns copies of the entries at 100 blocks higher, leaving the entries it is passed unchanged.
+func raiseHeights(batchedEntries []*lib.StateChangeEntry) ([]*lib.StateChangeEntry, error) {
+	raisedEntries := make([]*lib.StateChangeEntry, len(batchedEntries))
+	for ii, entry := range batchedEntries {
+		raisedEntry := *entry
+		raisedEntry.BlockHeight += 100
+		raisedEntries[ii] = &raisedEntry
+	}
+	return raisedEntries, nil
+}
+
+func TestSendMiddleware(t *testing.T) {
+	tests := []struct {
+		name string
+		// transforms are the transforms of the middleware named a, b and so on, in order.
+		transforms  []func([]*lib.StateChangeEntry) ([]*lib.StateChangeEntry, error)
+		statusCode  int64
+		wantErr     bool
+		wantCalls   []string
+		wantHeights [][]uint64
+	}{
+		{
+			name:       "mutating then observing",
+			transforms: []func([]*lib.StateChangeEntry) ([]*lib.StateChangeEntry, error){raiseHeights, nil},
+			wantCalls: []string{"a before [1 2]", "b before [101 102]", "b after [101 102] false",
+				"a after [101 102] false"},
+			wantHeights: [][]uint64{{101, 102}},
+		},
+		{
+			name:       "observing then mutating",
+			transforms: []func([]*lib.StateChangeEntry) ([]*lib.StateChangeEntry, error){nil, raiseHeights},
+			wantCalls: []string{"a before [1 2]", "b before [1 2]", "b after [101 102] false",
+				"a after [101 102] false"},
+			wantHeights: [][]uint64{{101, 102}},
+		},
+		{
+			name:       "send fails",
+			transforms: []func([]*lib.StateChangeEntry) ([]*lib.StateChangeEntry, error){nil},
+			statusCode: http.StatusBadRequest,
+			wantErr:    true,
+			wantCalls:  []string{"a before [1 2]", "a after [1 2] true"},
+		},
+		{
+			// Only the middleware whose BeforeSend succeeded observe the failure.
+			name: "before send fails",
+			transforms: []func([]*lib.StateChangeEntry) ([]*lib.StateChangeEntry, error){nil,
+				func([]*lib.StateChangeEntry) ([]*lib.StateChangeEntry, error) {
+					return nil, errors.New("rejected")
+				}, nil},
+			wantErr:   true,
+			wantCalls: []string{"a before [1 2]", "b before [1 2]", "a after [1 2] true"},
+		},
+		{
+			name: "batch skipped",
+			transforms: []func([]*lib.StateChangeEntry) ([]*lib.StateChangeEntry, error){
+				func([]*lib.StateChangeEntry) ([]*lib.StateChangeEntry, error) {
+					return nil, nil
+				}, nil},
+			wantCalls: []string{"a before [1 2]", "a after [] false"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newRecordingServer(t)
+			if tt.statusCode != 0 {
+				server.statusCode.Store(tt.statusCode)
+			}
+			wh := NewWebHandler(server.URL, false, "", 0)
+			wh.MaxDeliveryAttempts = 1
+			var calls []string
+			for ii, transform := range tt.transforms {
+				wh.Middleware = append(wh.Middleware, &testMiddleware{
+					name:      string(rune('a' + ii)),
+					calls:     &calls,
+					transform: transform,
+				})
+			}
+
+			err := wh.HandleEntryBatch(newTestHeightBatch(1, 2))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("HandleEntryBatch = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("calls = %q, want %q", calls, tt.wantCalls)
+			}
+			if heights := server.batchHeights(); !reflect.DeepEqual(heights, tt.wantHeights) {
+				t.Errorf("server received %v, want %v", heights, tt.wantHeights)
+			}
+		})
+	}
+}
+
+func TestSendMiddlewareRetriesFromOriginalEntries(t *testing.T) {
+	server := newRecordingServer(t)
+	server.statusCode.Store(http.StatusServiceUnavailable)
+	wh := NewWebHandler(server.URL, false, "", 0)
+	wh.MaxDeliveryAttempts = 2
+	wh.MaxRetries = 0
+	var calls []string
+	wh.Middleware = []SendMiddleware{
+		&testMiddleware{name: "a", calls: &calls, transform: raiseHeights},
+		// The endpoint comes back after the first attempt fails.
+		&afterSendHook{hook: func(SendResult) { server.statusCode.Store(http.StatusOK) }},
+	}
+
+	if err := wh.HandleEntryBatch(newTestHeightBatch(1, 2)); err != nil {
+		t.Fatalf("HandleEntryBatch: %v", err)
+	}
+	// The second attempt is transformed from the original entries again, rather than raised twice.
+	wantCalls := []string{"a before [1 2]", "a after [101 102] true", "a before [1 2]", "a after [101 102] false"}
+	if !reflect.DeepEqual(calls, wantCalls) {
+		t.Errorf("calls = %q, want %q", calls, wantCalls)
+	}
+	if heights := server.batchHeights(); !reflect.DeepEqual(heights, [][]uint64{{101, 102}}) {
+		t.Errorf("server received %v, want [[101 102]]", heights)
+	}
+}
@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"github.com/deso-protocol/core/lib"
+)
+
+const (
+	NetworkNameMainnet = "mainnet"
+	NetworkNameTestnet = "testnet"
+	NetworkNameRegtest = "regtest"
+)
+
+// NetworkName returns the name of the network the params are for. Regtest params are testnet params with extra
+// regtest param updater keys, so they are checked before the network type.
+func NetworkName(params *lib.DeSoParams) string {
+	if len(params.ExtraRegtestParamUpdaterKeys) > 0 {
+		return NetworkNameRegtest
+	}
+	if params.NetworkType == lib.NetworkType_MAINNET {
+		return NetworkNameMainnet
+	}
+	return NetworkNameTestnet
+}
+
+// networkPrefix returns the prefix of the keys, topics and stream names written to external systems. It is the
+// override when set, and the name of the network the params are for otherwise, so that processes running
+// against different networks never write to the same destination.
+func networkPrefix(params *lib.DeSoParams, override string) string {
+	if override != "" {
+		return override
+	}
+	return NetworkName(params)
+}
+
+// networkScopedName prefixes name with the network prefix, joined by separator.
+func networkScopedName(params *lib.DeSoParams, override string, name string, separator string) string {
+	return networkPrefix(params, override) + separator + name
+}
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/deso-protocol/core/lib"
+)
+
+// newTestRegtestParams returns testnet params with a regtest param updater key, as regtest nodes run with.
+func newTestRegtestParams() *lib.DeSoParams {
+	params := lib.DeSoTestnetParams
+	params.ExtraRegtestParamUpdaterKeys = map[lib.PkMapKey]bool{{}: true}
+	return &params
+}
+
+func TestNetworkPrefix(t *testing.T) {
+	tests := []struct {
+		name       string
+		params     *lib.DeSoParams
+		override   string
+		wantPrefix string
+	}{
+		{name: "mainnet", params: &lib.DeSoMainnetParams, wantPrefix: NetworkNameMainnet},
+		{name: "testnet", params: &lib.DeSoTestnetParams, wantPrefix: NetworkNameTestnet},
+		{name: "regtest", params: newTestRegtestParams(), wantPrefix: NetworkNameRegtest},
+		{name: "default params", wantPrefix: NetworkNameMainnet},
+		{name: "override", params: &lib.DeSoTestnetParams, override: "staging", wantPrefix: "staging"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh := NewWebHandler("", false, "", 0)
+			wh.Params = tt.params
+			wh.NetworkPrefix = tt.override
+			if prefix := wh.Status().Network; prefix != tt.wantPrefix {
+				t.Errorf("WebHandler status network = %q, want %q", prefix, tt.wantPrefix)
+			}
+
+			// Keys written to external systems are scoped by the same prefix.
+			rh, err := NewRabbitMQHandler("amqp://localhost/", "deso", tt.params)
+			if err != nil {
+				t.Fatalf("NewRabbitMQHandler: %v", err)
+			}
+			rh.NetworkPrefix = tt.override
+			wantKey := tt.wantPrefix + ".deso.likes"
+			if key := rh.routingKey(newTestEntries(1, 1)[0]); key != wantKey {
+				t.Errorf("routing key = %q, want %q", key, wantKey)
+			}
+			wantName := tt.wantPrefix + ":entries"
+			if name := networkScopedName(wh.GetParams(), tt.override, "entries", ":"); name != wantName {
+				t.Errorf("scoped name = %q, want %q", name, wantName)
+			}
+		})
+	}
+}
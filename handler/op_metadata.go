@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/pkg/errors"
+)
+
+// Operations of entries wrapped with WrapWithOpMetadata.
+const (
+	OpInsert = "insert"
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+// opSequence assigns the sequence numbers of entries wrapped with WrapWithOpMetadata.
+type opSequence struct {
+	mtx sync.Mutex
+	// loaded is set once the last sequence number has been read from SeqStatePath.
+	loaded bool
+	// lastSeq is the last sequence number assigned.
+	lastSeq uint64
+}
+
+// opMetadataName returns the operation of the entry for WrapWithOpMetadata. Upserts, which is what the core emits
+// for most changes, are inserts if the entry didn't exist before, i.e. if it has no ancestral record, and updates
+// otherwise.
+func opMetadataName(entry *lib.StateChangeEntry) string {
+	switch entry.OperationType {
+	case lib.DbOperationTypeDelete:
+		return OpDelete
+	case lib.DbOperationTypeInsert:
+		return OpInsert
+	case lib.DbOperationTypeUpdate:
+		return OpUpdate
+	default:
+		if entry.AncestralRecord == nil && len(entry.AncestralRecordBytes) == 0 {
+			return OpInsert
+		}
+		return OpUpdate
+	}
+}
+
+// wrapEntriesWithOpMetadata replaces every entry with an envelope holding its operation, sequence number and the
+// time it was processed.
+func (wh *WebHandler) wrapEntriesWithOpMetadata(batchedEntries []*lib.StateChangeEntry,
+	entries []map[string]interface{}) error {
+
+	firstSeq, err := wh.reserveOpSeqs(len(entries))
+	if err != nil {
+		return err
+	}
+	processedAt := time.Now().UTC().Format(time.RFC3339Nano)
+	for ii, entry := range entries {
+		entries[ii] = map[string]interface{}{
+			"op":           opMetadataName(batchedEntries[ii]),
+			"seq":          firstSeq + uint64(ii),
+			"processed_at": processedAt,
+			"entry":        entry,
+		}
+	}
+	return nil
+}
+
+// reserveOpSeqs reserves count consecutive sequence numbers and returns the first. When SeqStatePath is set, the
+// last reserved number is persisted there before it is used, so that numbers keep increasing across restarts.
+func (wh *WebHandler) reserveOpSeqs(count int) (uint64, error) {
+	wh.opSeq.mtx.Lock()
+	defer wh.opSeq.mtx.Unlock()
+
+	if !wh.opSeq.loaded && wh.SeqStatePath != "" {
+		lastSeq, err := readSeqState(wh.SeqStatePath)
+		if err != nil {
+			return 0, errors.Wrap(err, "WebHandler.reserveOpSeqs: failed to read sequence state")
+		}
+		wh.opSeq.lastSeq = lastSeq
+	}
+	wh.opSeq.loaded = true
+
+	lastSeq := wh.opSeq.lastSeq + uint64(count)
+	if wh.SeqStatePath != "" {
+		if err := writeSeqState(wh.SeqStatePath, lastSeq); err != nil {
+			return 0, errors.Wrap(err, "WebHandler.reserveOpSeqs: failed to persist sequence state")
+		}
+	}
+	firstSeq := wh.opSeq.lastSeq + 1
+	wh.opSeq.lastSeq = lastSeq
+	return firstSeq, nil
+}
+
+// readSeqState returns the sequence number stored at path, or zero if there is no file yet.
+func readSeqState(path string) (uint64, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, errors.Wrapf(err, "readSeqState: failed to read %s", path)
+	}
+	lastSeq, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "readSeqState: invalid sequence number in %s", path)
+	}
+	return lastSeq, nil
+}
+
+// writeSeqState stores the sequence number at path. The number is written and synced to a temporary file that
+// replaces path, and the directory is synced after the rename, so that a crash never leaves a partially written
+// number behind, nor brings back an earlier one.
+func writeSeqState(path string, lastSeq uint64) error {
+	tempPath := fmt.Sprintf("%s.tmp", path)
+	file, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "writeSeqState: failed to create %s", tempPath)
+	}
+	if _, err = file.WriteString(strconv.FormatUint(lastSeq, 10)); err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "writeSeqState: failed to write %s", tempPath)
+	}
+	if err = os.Rename(tempPath, path); err != nil {
+		return errors.Wrapf(err, "writeSeqState: failed to replace %s", path)
+	}
+	if err = syncDir(filepath.Dir(path)); err != nil {
+		return errors.Wrapf(err, "writeSeqState: failed to sync the directory of %s", path)
+	}
+	return nil
+}
+
+// syncDir flushes the entries of the directory, such as a file renamed into it, to disk.
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/deso-protocol/core/lib"
+)
+
+func TestOpMetadataName(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry *lib.StateChangeEntry
+		want  string
+	}{
+		{name: "insert", entry: &lib.StateChangeEntry{OperationType: lib.DbOperationTypeInsert}, want: OpInsert},
+		{name: "update", entry: &lib.StateChangeEntry{OperationType: lib.DbOperationTypeUpdate}, want: OpUpdate},
+		{name: "delete", entry: &lib.StateChangeEntry{OperationType: lib.DbOperationTypeDelete}, want: OpDelete},
+		{name: "upsert of a new entry", entry: &lib.StateChangeEntry{OperationType: lib.DbOperationTypeUpsert},
+			want: OpInsert},
+		{name: "upsert of an existing entry", entry: &lib.StateChangeEntry{OperationType: lib.DbOperationTypeUpsert,
+			AncestralRecordBytes: []byte("before")}, want: OpUpdate},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := opMetadataName(tt.entry); got != tt.want {
+				t.Errorf("opMetadataName = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// marshalOpSeqs marshals the batch with the handler and returns the sequence numbers of its envelopes.
+func marshalOpSeqs(t *testing.T, wh *WebHandler, batchedEntries []*lib.StateChangeEntry) []uint64 {
+	t.Helper()
+	jsonData, err := wh.marshalBatch(batchedEntries)
+	if err != nil {
+		t.Fatalf("marshalBatch: %v", err)
+	}
+	var envelopes []struct {
+		Op          string          `json:"op"`
+		Seq         uint64          `json:"seq"`
+		ProcessedAt string          `json:"processed_at"`
+		Entry       json.RawMessage `json:"entry"`
+	}
+	if err = json.Unmarshal(jsonData, &envelopes); err != nil {
+		t.Fatalf("failed to decode envelopes: %v", err)
+	}
+	var seqs []uint64
+	for _, envelope := range envelopes {
+		if envelope.Op != OpInsert || envelope.ProcessedAt == "" || len(envelope.Entry) == 0 {
+			t.Errorf("unexpected envelope %+v", envelope)
+		}
+		seqs = append(seqs, envelope.Seq)
+	}
+	return seqs
+}
+
+func TestOpSeqSurvivesRestart(t *testing.T) {
+	seqStatePath := filepath.Join(t.TempDir(), "seq")
+	newHandler := func() *WebHandler {
+		wh := NewWebHandler("", false, "", 0)
+		wh.WrapWithOpMetadata = true
+		wh.SeqStatePath = seqStatePath
+		return wh
+	}
+
+	// Every handler stands for a restart of the process, which must continue where the last one stopped.
+	restarts := []struct {
+		batchSizes []int
+		wantSeqs   [][]uint64
+	}{
+		{batchSizes: []int{3, 2}, wantSeqs: [][]uint64{{1, 2, 3}, {4, 5}}},
+		{batchSizes: []int{1}, wantSeqs: [][]uint64{{6}}},
+		{batchSizes: []int{2, 1}, wantSeqs: [][]uint64{{7, 8}, {9}}},
+	}
+	for ii, restart := range restarts {
+		wh := newHandler()
+		for jj, batchSize := range restart.batchSizes {
+			seqs := marshalOpSeqs(t, wh, newTestEntries(batchSize, uint64(jj)))
+			if !reflect.DeepEqual(seqs, restart.wantSeqs[jj]) {
+				t.Errorf("run %d batch %d: seqs = %v, want %v", ii, jj, seqs, restart.wantSeqs[jj])
+			}
+		}
+	}
+
+	lastSeq, err := readSeqState(seqStatePath)
+	if err != nil || lastSeq != 9 {
+		t.Errorf("readSeqState = %d, %v, want 9", lastSeq, err)
+	}
+	if _, err = os.Stat(seqStatePath + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary state file was left behind: %v", err)
+	}
+}
+
+func TestOpSeqWithoutStateRestarts(t *testing.T) {
+	for run := 0; run < 2; run++ {
+		wh := NewWebHandler("", false, "", 0)
+		wh.WrapWithOpMetadata = true
+		if seqs := marshalOpSeqs(t, wh, newTestEntries(2, 1)); !reflect.DeepEqual(seqs, []uint64{1, 2}) {
+			t.Errorf("run %d: seqs = %v, want [1 2]", run, seqs)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// Modes of handling batches while the handler is paused.
+const (
+	// PauseModeBlock, the default, blocks HandleEntryBatch until the handler is resumed, so the consumer stops
+	// making progress.
+	PauseModeBlock = "block"
+	// PauseModeBuffer holds up to PauseBufferSize batches while paused and lets the consumer move on. Once the
+	// buffer is full, or MaxMemoryBytes is reached and MemoryShedPolicy can't make room, HandleEntryBatch blocks
+	// as under PauseModeBlock. Buffered batches are sent by Resume.
+	PauseModeBuffer = "buffer"
+)
+
+// DefaultPauseBufferSize is the number of batches held while paused under PauseModeBuffer when no PauseBufferSize
+// is configured.
+const DefaultPauseBufferSize = 100
+
+// pauseState holds whether the handler is paused and the batches buffered while it is.
+type pauseState struct {
+	mtx    sync.Mutex
+	paused bool
+	// resumed is closed when the handler is resumed.
+	resumed chan struct{}
+	// bufferedBatches are the batches held under PauseModeBuffer, in the order they were received.
+	bufferedBatches []pausedBatch
+}
+
+// pausedBatch is a batch held while paused, either in memory or, under MemoryPolicySpill, in a spill file.
+type pausedBatch struct {
+	entries []*lib.StateChangeEntry
+	// memorySize is the memory the entries are accounted for in the memory budget.
+	memorySize int64
+	// spillPath is the file the entries were spilled to, if they aren't held in memory.
+	spillPath string
+	// enqueuedAt is when the batch was buffered, to expire it after BatchTTL.
+	enqueuedAt time.Time
+}
+
+// Pause stops the handler from sending batches until Resume is called.
+func (wh *WebHandler) Pause() {
+	wh.pause.mtx.Lock()
+	defer wh.pause.mtx.Unlock()
+	if wh.pause.paused {
+		return
+	}
+	wh.pause.paused = true
+	wh.pause.resumed = make(chan struct{})
+	glog.Infof("WebHandler.Pause: paused sending in %s mode", wh.pauseMode())
+}
+
+// Resume sends the batches buffered while paused and resumes sending. Batches older than BatchTTL are dropped
+// instead of sent. If a buffered batch can't be delivered, the handler stays paused with that batch and the ones
+// after it still buffered, and the error is returned.
+func (wh *WebHandler) Resume() error {
+	wh.pause.mtx.Lock()
+	defer wh.pause.mtx.Unlock()
+	if !wh.pause.paused {
+		return nil
+	}
+	wh.dropExpiredPausedBatches()
+	for len(wh.pause.bufferedBatches) > 0 {
+		batch := wh.pause.bufferedBatches[0]
+		batchedEntries := batch.entries
+		if batch.spillPath != "" {
+			var err error
+			if batchedEntries, err = loadSpilledBatch(batch.spillPath); err != nil {
+				return errors.Wrap(err, "WebHandler.Resume: failed to load spilled batch")
+			}
+		}
+		if err := wh.deliverBatch(batchedEntries); err != nil {
+			return errors.Wrapf(err, "WebHandler.Resume: failed to send buffered batch, %d batches still buffered",
+				len(wh.pause.bufferedBatches))
+		}
+		if batch.spillPath != "" {
+			if err := os.Remove(batch.spillPath); err != nil {
+				glog.Errorf("WebHandler.Resume: failed to remove spill file: %v", err)
+			}
+		}
+		wh.metrics.memoryBytes.Add(-batch.memorySize)
+		wh.pause.bufferedBatches[0] = pausedBatch{}
+		wh.pause.bufferedBatches = wh.pause.bufferedBatches[1:]
+	}
+	wh.pause.bufferedBatches = nil
+	wh.pause.paused = false
+	close(wh.pause.resumed)
+	glog.Infof("WebHandler.Resume: resumed sending")
+	return nil
+}
+
+// IsPaused returns whether the handler is paused.
+func (wh *WebHandler) IsPaused() bool {
+	wh.pause.mtx.Lock()
+	defer wh.pause.mtx.Unlock()
+	return wh.pause.paused
+}
+
+// pausedBatchCount returns the number of batches buffered while paused.
+func (wh *WebHandler) pausedBatchCount() int {
+	wh.pause.mtx.Lock()
+	defer wh.pause.mtx.Unlock()
+	return len(wh.pause.bufferedBatches)
+}
+
+// holdWhilePaused buffers the batch or blocks while the handler is paused. It returns whether the batch was
+// buffered, in which case the caller must not send it.
+func (wh *WebHandler) holdWhilePaused(batchedEntries []*lib.StateChangeEntry) bool {
+	for {
+		wh.pause.mtx.Lock()
+		if !wh.pause.paused {
+			wh.pause.mtx.Unlock()
+			return false
+		}
+		if wh.pauseMode() == PauseModeBuffer && wh.bufferPausedBatch(batchedEntries) {
+			wh.pause.mtx.Unlock()
+			return true
+		}
+		resumed := wh.pause.resumed
+		wh.pause.mtx.Unlock()
+		<-resumed
+	}
+}
+
+// bufferPausedBatch buffers the batch if there is room for it in the buffer and within the memory budget, shedding
+// memory as configured by MemoryShedPolicy if the batch doesn't fit. It returns whether the batch was buffered. The
+// caller must hold pause.mtx.
+func (wh *WebHandler) bufferPausedBatch(batchedEntries []*lib.StateChangeEntry) bool {
+	// Expired batches would be dropped on resume anyway, so free their room first.
+	wh.dropExpiredPausedBatches()
+	if len(wh.pause.bufferedBatches) >= wh.pauseBufferSize() {
+		return false
+	}
+	memorySize := entriesMemorySize(batchedEntries)
+	if wh.memoryBudgetExceeded(memorySize) {
+		switch wh.memoryShedPolicy() {
+		case MemoryPolicyDropOldest:
+			wh.shedPausedBatches(memorySize)
+		case MemoryPolicySpill:
+			spillPath, err := wh.spillBatch(batchedEntries)
+			if err != nil {
+				glog.Errorf("WebHandler.bufferPausedBatch: %v", err)
+				return false
+			}
+			wh.pause.bufferedBatches = append(wh.pause.bufferedBatches, pausedBatch{
+				spillPath:  spillPath,
+				enqueuedAt: time.Now(),
+			})
+			return true
+		}
+		if wh.memoryBudgetExceeded(memorySize) {
+			return false
+		}
+	}
+
+	wh.metrics.memoryBytes.Add(memorySize)
+	wh.pause.bufferedBatches = append(wh.pause.bufferedBatches, pausedBatch{
+		entries:    batchedEntries,
+		memorySize: memorySize,
+		enqueuedAt: time.Now(),
+	})
+	return true
+}
+
+// shedPausedBatches drops the oldest batches held in memory until another memorySize bytes fit in the memory
+// budget, or no batch is left in memory. The caller must hold pause.mtx.
+func (wh *WebHandler) shedPausedBatches(memorySize int64) {
+	var keptBatches []pausedBatch
+	for _, batch := range wh.pause.bufferedBatches {
+		if batch.spillPath == "" && wh.memoryBudgetExceeded(memorySize) {
+			wh.metrics.memoryBytes.Add(-batch.memorySize)
+			wh.metrics.entriesShed.Add(uint64(len(batch.entries)))
+			glog.Errorf("WebHandler.shedPausedBatches: dropping paused batch of %d entries at height %d",
+				len(batch.entries), batch.entries[0].BlockHeight)
+			continue
+		}
+		keptBatches = append(keptBatches, batch)
+	}
+	wh.pause.bufferedBatches = keptBatches
+}
+
+// pauseMode returns the configured pause mode, defaulting to PauseModeBlock.
+func (wh *WebHandler) pauseMode() string {
+	if wh.PauseMode == "" {
+		return PauseModeBlock
+	}
+	return wh.PauseMode
+}
+
+// pauseBufferSize returns the configured pause buffer size, defaulting to DefaultPauseBufferSize.
+func (wh *WebHandler) pauseBufferSize() int {
+	if wh.PauseBufferSize <= 0 {
+		return DefaultPauseBufferSize
+	}
+	return wh.PauseBufferSize
+}
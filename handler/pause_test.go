@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// postAdmin sends a POST to the admin endpoint at path and returns the response status.
+func postAdmin(t *testing.T, admin *httptest.Server, path string) int {
+	t.Helper()
+	resp, err := http.Post(admin.URL+path, "application/json", nil)
+	if err != nil {
+		t.Fatalf("POST %s: %v", path, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestPauseResume(t *testing.T) {
+	tests := []struct {
+		name      string
+		pauseMode string
+		// wantBlocked is whether HandleEntryBatch blocks while paused rather than buffering the batch.
+		wantBlocked bool
+	}{
+		{name: "default", wantBlocked: true},
+		{name: "block", pauseMode: PauseModeBlock, wantBlocked: true},
+		{name: "buffer", pauseMode: PauseModeBuffer},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newRecordingServer(t)
+			wh := NewWebHandler(server.URL, false, "", 0)
+			wh.PauseMode = tt.pauseMode
+			admin := httptest.NewServer(wh.AdminHandler())
+			defer admin.Close()
+
+			if err := wh.HandleEntryBatch(newTestEntries(1, 1)); err != nil {
+				t.Fatalf("HandleEntryBatch(1): %v", err)
+			}
+			if status := postAdmin(t, admin, "/pause"); status != http.StatusNoContent || !wh.IsPaused() {
+				t.Fatalf("POST /pause = %d, paused %v", status, wh.IsPaused())
+			}
+
+			// Batches sent while paused are held back, in order.
+			handled := make(chan error)
+			go func() {
+				for height := uint64(2); height <= 3; height++ {
+					if err := wh.HandleEntryBatch(newTestEntries(1, height)); err != nil {
+						handled <- err
+						return
+					}
+				}
+				handled <- nil
+			}()
+			select {
+			case err := <-handled:
+				if tt.wantBlocked {
+					t.Fatalf("HandleEntryBatch returned %v while paused, want it to block", err)
+				}
+				if count := wh.pausedBatchCount(); count != 2 {
+					t.Errorf("%d batches buffered, want 2", count)
+				}
+			case <-time.After(100 * time.Millisecond):
+				if !tt.wantBlocked {
+					t.Fatal("HandleEntryBatch blocked while paused, want the batch buffered")
+				}
+			}
+			if heights := server.batchHeights(); !reflect.DeepEqual(heights, [][]uint64{{1}}) {
+				t.Errorf("server received %v while paused, want only the batch before the pause", heights)
+			}
+
+			if status := postAdmin(t, admin, "/resume"); status != http.StatusNoContent || wh.IsPaused() {
+				t.Fatalf("POST /resume = %d, paused %v", status, wh.IsPaused())
+			}
+			if tt.wantBlocked {
+				if err := <-handled; err != nil {
+					t.Fatalf("HandleEntryBatch: %v", err)
+				}
+			}
+			if heights := server.batchHeights(); !reflect.DeepEqual(heights, [][]uint64{{1}, {2}, {3}}) {
+				t.Errorf("server received %v after resuming, want every batch in order", heights)
+			}
+		})
+	}
+}
+
+func TestResumeFailureStaysPaused(t *testing.T) {
+	server := newRecordingServer(t)
+	wh := NewWebHandler(server.URL, false, "", 0)
+	wh.PauseMode = PauseModeBuffer
+	wh.MaxDeliveryAttempts = 1
+	wh.Pause()
+	for height := uint64(1); height <= 2; height++ {
+		if err := wh.HandleEntryBatch(newTestEntries(1, height)); err != nil {
+			t.Fatalf("HandleEntryBatch(%d): %v", height, err)
+		}
+	}
+
+	server.statusCode.Store(http.StatusBadRequest)
+	if err := wh.Resume(); err == nil {
+		t.Fatal("expected Resume to fail while the endpoint rejects batches")
+	}
+	if !wh.IsPaused() || wh.pausedBatchCount() != 2 {
+		t.Errorf("paused %v with %d batches after a failed resume, want paused with both batches",
+			wh.IsPaused(), wh.pausedBatchCount())
+	}
+
+	server.statusCode.Store(http.StatusOK)
+	if err := wh.Resume(); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	if heights := server.batchHeights(); !reflect.DeepEqual(heights, [][]uint64{{1}, {2}}) {
+		t.Errorf("server received %v, want both batches in order", heights)
+	}
+}
@@ -0,0 +1,296 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+	"github.com/deso-protocol/core/lib"
+	"github.com/deso-protocol/state-consumer/consumer"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+const (
+	// HandlerTypePulsar is the handler type of PulsarHandler.
+	HandlerTypePulsar = "pulsar"
+
+	// pulsarOperationTimeout is how long connecting to the broker and creating the producer may take.
+	pulsarOperationTimeout = 30 * time.Second
+	// pulsarSendTimeout is how long a message may wait to be acknowledged by the broker, including while the
+	// producer reconnects, before its send fails.
+	pulsarSendTimeout = 30 * time.Second
+)
+
+// PulsarHandler is a handler that produces every entry as a message to an Apache Pulsar topic with the Pulsar Go
+// client. Messages are sent asynchronously with SendAsync, so that the consumer can read the next batch while the
+// previous ones are being acknowledged, and CommitTransaction flushes the producer and waits for the callbacks of
+// every message of the transaction before reporting the first send error.
+//
+// The client reconnects the producer to the broker on its own when the connection is lost, and resends the
+// messages that weren't acknowledged yet. A producer that failed a send, e.g. because it was closed or fenced, is
+// closed at the end of the transaction and a new one is created for the next batch.
+type PulsarHandler struct {
+	// URL is the service URL of the Pulsar cluster, e.g. pulsar://localhost:6650.
+	URL string
+	// Topic is the topic messages are produced to, e.g. persistent://public/default/deso.
+	Topic string
+	// Token, when set, is used to authenticate with the broker.
+	Token string
+
+	// Params are the params of the network the handler is consuming. They default to mainnet.
+	Params *lib.DeSoParams
+	// NetworkPrefix overrides the network name attached to every message. When empty, the name of the network
+	// from Params is used.
+	NetworkPrefix string
+
+	// MinBlockHeight is the minimum block height required before sending any data.
+	MinBlockHeight uint64
+
+	// MessageKey returns the key of an entry's message, which Pulsar uses for routing and key-shared ordering.
+	// It defaults to KeyByEncoderType.
+	MessageKey KeyExtractor
+
+	// mtx guards the client and producer, which Close may close while a batch is being sent, and txnErr, which is
+	// updated by the send callbacks.
+	mtx      sync.Mutex
+	client   pulsar.Client
+	producer pulsar.Producer
+	// sends tracks the messages of the current transaction whose send callback hasn't been called yet.
+	sends sync.WaitGroup
+	// txnErr is the first send error since the current transaction was initiated.
+	txnErr error
+}
+
+var _ DataHandler = (*PulsarHandler)(nil)
+
+// NewPulsarHandler returns a handler that produces to the topic on the cluster at serviceURL. The connection is
+// made on the first batch.
+func NewPulsarHandler(serviceURL string, topic string, params *lib.DeSoParams) (*PulsarHandler, error) {
+	if serviceURL == "" {
+		return nil, fmt.Errorf("NewPulsarHandler: no service URL configured")
+	}
+	if topic == "" {
+		return nil, fmt.Errorf("NewPulsarHandler: no topic configured")
+	}
+	return &PulsarHandler{
+		URL:    serviceURL,
+		Topic:  topic,
+		Params: params,
+	}, nil
+}
+
+// newPulsarHandlerFromConfig creates a PulsarHandler from the PULSAR_URL, PULSAR_TOPIC, PULSAR_TOKEN and
+// PULSAR_MESSAGE_KEY config.
+func newPulsarHandlerFromConfig(params *lib.DeSoParams, getConfig ConfigGetter) (DataHandler, error) {
+	ph, err := NewPulsarHandler(getConfig("PULSAR_URL"), getConfig("PULSAR_TOPIC"), params)
+	if err != nil {
+		return nil, err
+	}
+	ph.Token = getConfig("PULSAR_TOKEN")
+	ph.NetworkPrefix = getConfig("NETWORK_PREFIX")
+	if ph.MessageKey, err = GetKeyExtractor(getConfig("PULSAR_MESSAGE_KEY")); err != nil {
+		return nil, err
+	}
+	if ph.MinBlockHeight, err = getMinBlockHeightConfig(getConfig); err != nil {
+		return nil, err
+	}
+	return ph, nil
+}
+
+func (ph *PulsarHandler) CommitTransaction() error {
+	// Wait for the broker to acknowledge every message of the transaction, and surface a failed send so the
+	// caller rolls back.
+	err := ph.awaitSends()
+	if err != nil {
+		return errors.Wrap(err, "PulsarHandler.CommitTransaction: batch was not delivered")
+	}
+	return nil
+}
+
+func (ph *PulsarHandler) GetParams() *lib.DeSoParams {
+	// Default to mainnet if no params were configured.
+	if ph.Params == nil {
+		return &lib.DeSoMainnetParams
+	}
+	return ph.Params
+}
+
+func (ph *PulsarHandler) HandleSyncEvent(syncEvent consumer.SyncEvent) error {
+	// No sync event handling needed.
+	return nil
+}
+
+func (ph *PulsarHandler) InitiateTransaction() error {
+	// Sends of an earlier transaction have already been awaited by its commit or rollback.
+	ph.mtx.Lock()
+	defer ph.mtx.Unlock()
+	ph.txnErr = nil
+	return nil
+}
+
+func (ph *PulsarHandler) RollbackTransaction() error {
+	// Produced messages can't be recalled, so just wait for the sends in progress and reset the transaction.
+	ph.awaitSends()
+	return nil
+}
+
+// HandleEntryBatch sends every entry in the batch to the topic without waiting for it to be acknowledged. A send
+// that failed earlier in the transaction is returned, and no more batches are sent. If the block height of the
+// first entry is below MinBlockHeight, the batch is skipped.
+func (ph *PulsarHandler) HandleEntryBatch(batchedEntries []*lib.StateChangeEntry) error {
+	if len(batchedEntries) == 0 {
+		return fmt.Errorf("PulsarHandler.HandleEntryBatch: no entries to send")
+	}
+	if batchedEntries[0].BlockHeight < ph.MinBlockHeight {
+		return nil
+	}
+
+	messages, err := ph.newMessages(batchedEntries)
+	if err != nil {
+		return err
+	}
+
+	ph.mtx.Lock()
+	if err = ph.txnErr; err != nil {
+		ph.mtx.Unlock()
+		return err
+	}
+	producer, err := ph.getProducer()
+	if err != nil {
+		ph.txnErr = err
+		ph.mtx.Unlock()
+		return err
+	}
+	ph.mtx.Unlock()
+
+	// The callback may be called before SendAsync returns, e.g. when the producer is closed, so the mutex isn't
+	// held while sending.
+	for _, message := range messages {
+		ph.sends.Add(1)
+		producer.SendAsync(context.Background(), message, ph.sendCallback)
+	}
+	return nil
+}
+
+// Close closes the producer and the client. Messages that haven't been acknowledged yet are failed.
+func (ph *PulsarHandler) Close() error {
+	ph.mtx.Lock()
+	defer ph.mtx.Unlock()
+	ph.closeProducer()
+	if ph.client != nil {
+		ph.client.Close()
+		ph.client = nil
+	}
+	return nil
+}
+
+// newMessages converts the entries to producer messages.
+func (ph *PulsarHandler) newMessages(batchedEntries []*lib.StateChangeEntry) ([]*pulsar.ProducerMessage, error) {
+	messageKey := ph.MessageKey
+	if messageKey == nil {
+		messageKey = KeyByEncoderType
+	}
+	network := networkPrefix(ph.GetParams(), ph.NetworkPrefix)
+
+	messages := make([]*pulsar.ProducerMessage, 0, len(batchedEntries))
+	for _, entry := range batchedEntries {
+		payload, err := json.Marshal(entry)
+		if err != nil {
+			return nil, errors.Wrap(err, "PulsarHandler.newMessages: failed to marshal entry")
+		}
+		messages = append(messages, &pulsar.ProducerMessage{
+			Key:     messageKey(entry),
+			Payload: payload,
+			Properties: map[string]string{
+				"network":      network,
+				"encoder_type": strconv.FormatUint(uint64(entry.EncoderType), 10),
+			},
+		})
+	}
+	return messages, nil
+}
+
+// getProducer returns the producer, creating the client and the producer if they don't exist yet. The caller must
+// hold mtx.
+func (ph *PulsarHandler) getProducer() (pulsar.Producer, error) {
+	if ph.producer != nil {
+		return ph.producer, nil
+	}
+	if ph.client == nil {
+		options := pulsar.ClientOptions{
+			URL:               ph.URL,
+			ConnectionTimeout: pulsarOperationTimeout,
+			OperationTimeout:  pulsarOperationTimeout,
+		}
+		if ph.Token != "" {
+			options.Authentication = pulsar.NewAuthenticationToken(ph.Token)
+		}
+		client, err := pulsar.NewClient(options)
+		if err != nil {
+			return nil, errors.Wrap(err, "PulsarHandler.getProducer: failed to create client")
+		}
+		ph.client = client
+	}
+	producer, err := ph.client.CreateProducer(pulsar.ProducerOptions{
+		Topic:       ph.Topic,
+		SendTimeout: pulsarSendTimeout,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "PulsarHandler.getProducer: failed to create producer for %s", ph.Topic)
+	}
+	ph.producer = producer
+	return producer, nil
+}
+
+// closeProducer closes the producer, if one was created, so that the next batch creates a new one. The caller
+// must hold mtx.
+func (ph *PulsarHandler) closeProducer() {
+	if ph.producer == nil {
+		return
+	}
+	ph.producer.Close()
+	ph.producer = nil
+}
+
+// sendCallback records a failed send as the transaction's error.
+func (ph *PulsarHandler) sendCallback(_ pulsar.MessageID, _ *pulsar.ProducerMessage, err error) {
+	defer ph.sends.Done()
+	if err == nil {
+		return
+	}
+	ph.mtx.Lock()
+	defer ph.mtx.Unlock()
+	if ph.txnErr == nil {
+		ph.txnErr = errors.Wrapf(err, "PulsarHandler.sendCallback: message was not acknowledged by %s", ph.Topic)
+	}
+}
+
+// awaitSends flushes the producer and waits for the callback of every message sent in the current transaction,
+// then returns and resets the first send error. A producer that failed a send is closed.
+func (ph *PulsarHandler) awaitSends() error {
+	ph.mtx.Lock()
+	producer := ph.producer
+	ph.mtx.Unlock()
+	if producer != nil {
+		// Send the messages the producer is still batching rather than waiting out its batching delay. A failed
+		// flush fails the callbacks of the messages it couldn't send.
+		if err := producer.Flush(); err != nil {
+			glog.Errorf("PulsarHandler.awaitSends: failed to flush producer: %v", err)
+		}
+	}
+	ph.sends.Wait()
+
+	ph.mtx.Lock()
+	defer ph.mtx.Unlock()
+	err := ph.txnErr
+	ph.txnErr = nil
+	if err != nil {
+		ph.closeProducer()
+	}
+	return err
+}
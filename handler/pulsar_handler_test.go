@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/pulsar-client-go/pulsar"
+)
+
+// fakePulsarProducer is a producer that batches the messages sent to it until it is flushed, like the client
+// does until its batching delay runs out. The methods the handler doesn't use are left to the nil Producer.
+type fakePulsarProducer struct {
+	pulsar.Producer
+
+	mtx sync.Mutex
+	// sendErr, when set, fails every message sent.
+	sendErr error
+	// failImmediately calls the callback of a failed send before SendAsync returns, as the client does when the
+	// producer is closed.
+	failImmediately bool
+
+	pending []func()
+	sent    int
+	flushes int
+	closed  bool
+}
+
+func (producer *fakePulsarProducer) SendAsync(ctx context.Context, message *pulsar.ProducerMessage,
+	callback func(pulsar.MessageID, *pulsar.ProducerMessage, error)) {
+	producer.mtx.Lock()
+	err := producer.sendErr
+	if err != nil && producer.failImmediately {
+		producer.mtx.Unlock()
+		callback(nil, message, err)
+		return
+	}
+	producer.pending = append(producer.pending, func() { callback(nil, message, err) })
+	producer.mtx.Unlock()
+}
+
+func (producer *fakePulsarProducer) Flush() error {
+	producer.mtx.Lock()
+	pending := producer.pending
+	producer.pending = nil
+	producer.sent += len(pending)
+	producer.flushes++
+	producer.mtx.Unlock()
+	for _, callback := range pending {
+		callback()
+	}
+	return nil
+}
+
+func (producer *fakePulsarProducer) Close() {
+	producer.mtx.Lock()
+	defer producer.mtx.Unlock()
+	producer.closed = true
+}
+
+func TestPulsarHandlerAwaitSends(t *testing.T) {
+	sendErr := errors.New("producer is fenced")
+	tests := []struct {
+		name            string
+		sendErr         error
+		failImmediately bool
+		wantSent        int
+	}{
+		{name: "acknowledged", wantSent: 3},
+		{name: "failed", sendErr: sendErr, wantSent: 3},
+		{name: "failed before SendAsync returned", sendErr: sendErr, failImmediately: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ph, err := NewPulsarHandler("pulsar://localhost:6650", "deso", nil)
+			if err != nil {
+				t.Fatalf("NewPulsarHandler: %v", err)
+			}
+			producer := &fakePulsarProducer{sendErr: tt.sendErr, failImmediately: tt.failImmediately}
+			ph.producer = producer
+
+			ph.InitiateTransaction()
+			if err = ph.HandleEntryBatch(newTestEntries(3, 1)); err != nil {
+				t.Fatalf("HandleEntryBatch: %v", err)
+			}
+
+			// The callbacks only run once the producer is flushed, so the commit hangs unless it flushes.
+			committed := make(chan error, 1)
+			go func() { committed <- ph.CommitTransaction() }()
+			select {
+			case err = <-committed:
+			case <-time.After(testReadTimeout):
+				t.Fatal("CommitTransaction didn't return, the producer was not flushed")
+			}
+
+			if tt.sendErr == nil && err != nil {
+				t.Errorf("CommitTransaction: %v", err)
+			}
+			if tt.sendErr != nil && (err == nil || !strings.Contains(err.Error(), tt.sendErr.Error())) {
+				t.Errorf("CommitTransaction = %v, want an error containing %q", err, tt.sendErr)
+			}
+
+			producer.mtx.Lock()
+			defer producer.mtx.Unlock()
+			if producer.sent != tt.wantSent || len(producer.pending) != 0 {
+				t.Errorf("producer sent %d messages with %d pending, want %d sent", producer.sent,
+					len(producer.pending), tt.wantSent)
+			}
+			if producer.flushes != 1 {
+				t.Errorf("producer was flushed %d times, want 1", producer.flushes)
+			}
+			// A producer that failed a send is replaced on the next batch.
+			wantClosed := tt.sendErr != nil
+			if producer.closed != wantClosed || (ph.producer == nil) != wantClosed {
+				t.Errorf("producer closed = %v, want %v", producer.closed, wantClosed)
+			}
+		})
+	}
+}
+
+func TestPulsarHandlerSendErrorStopsBatches(t *testing.T) {
+	ph, err := NewPulsarHandler("pulsar://localhost:6650", "deso", nil)
+	if err != nil {
+		t.Fatalf("NewPulsarHandler: %v", err)
+	}
+	sendErr := errors.New("producer is closed")
+	producer := &fakePulsarProducer{sendErr: sendErr, failImmediately: true}
+	ph.producer = producer
+
+	ph.InitiateTransaction()
+	if err = ph.HandleEntryBatch(newTestEntries(1, 1)); err != nil {
+		t.Fatalf("HandleEntryBatch(1): %v", err)
+	}
+	// The failed send is returned by the next batch, which isn't sent.
+	if err = ph.HandleEntryBatch(newTestEntries(1, 2)); err == nil || !strings.Contains(err.Error(), sendErr.Error()) {
+		t.Errorf("HandleEntryBatch(2) = %v, want an error containing %q", err, sendErr)
+	}
+	if err = ph.RollbackTransaction(); err != nil {
+		t.Errorf("RollbackTransaction: %v", err)
+	}
+	if !producer.closed {
+		t.Error("producer that failed a send was not closed by the rollback")
+	}
+}
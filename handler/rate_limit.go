@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"math"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+)
+
+// rateLimiterState holds the limiter of HTTP POSTs, created on first use when MaxRequestsPerSecond is set.
+type rateLimiterState struct {
+	once    sync.Once
+	limiter *rate.Limiter
+}
+
+// getRateLimiter returns the limiter of HTTP POSTs. The burst defaults to one second's worth of requests, and to at
+// least one request.
+func (wh *WebHandler) getRateLimiter() *rate.Limiter {
+	wh.rateLimiter.once.Do(func() {
+		burst := wh.Burst
+		if burst <= 0 {
+			burst = max(int(math.Ceil(wh.MaxRequestsPerSecond)), 1)
+		}
+		wh.rateLimiter.limiter = rate.NewLimiter(rate.Limit(wh.MaxRequestsPerSecond), burst)
+	})
+	return wh.rateLimiter.limiter
+}
+
+// waitForRateLimit waits until an HTTP POST may be sent under MaxRequestsPerSecond, returning early with an error if
+// the context of the handler's sends is done first. It returns right away if no rate is configured.
+func (wh *WebHandler) waitForRateLimit() error {
+	if wh.MaxRequestsPerSecond <= 0 {
+		return nil
+	}
+	if err := wh.getRateLimiter().Wait(wh.context()); err != nil {
+		return errors.Wrap(err, "WebHandler.waitForRateLimit: failed to wait for rate limit")
+	}
+	return nil
+}
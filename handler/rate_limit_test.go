@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestRateLimitCapsThroughput(t *testing.T) {
+	tests := []struct {
+		name          string
+		ratePerSecond float64
+		burst         int
+		requests      int
+	}{
+		{name: "no burst", ratePerSecond: 50, burst: 1, requests: 26},
+		{name: "burst", ratePerSecond: 100, burst: 10, requests: 40},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newRecordingServer(t)
+			wh := NewWebHandler(server.URL, false, "", 0)
+			wh.MaxRequestsPerSecond = tt.ratePerSecond
+			wh.Burst = tt.burst
+
+			startTime := time.Now()
+			for ii := 0; ii < tt.requests; ii++ {
+				if err := wh.HandleEntryBatch(newTestEntries(1, uint64(ii))); err != nil {
+					t.Fatalf("HandleEntryBatch(%d): %v", ii, err)
+				}
+			}
+			elapsed := time.Since(startTime)
+
+			// The burst is sent right away, and the rest at the configured rate.
+			minElapsed := time.Duration(float64(tt.requests-tt.burst) / tt.ratePerSecond * float64(time.Second))
+			if elapsed < minElapsed*9/10 {
+				t.Errorf("sent %d requests in %s, faster than the %s the rate allows", tt.requests, elapsed, minElapsed)
+			}
+			if elapsed > minElapsed*3 {
+				t.Errorf("sent %d requests in %s, far slower than the %s the rate allows", tt.requests, elapsed,
+					minElapsed)
+			}
+			if requests := server.requests.Load(); requests != int64(tt.requests) {
+				t.Errorf("server received %d requests, want %d", requests, tt.requests)
+			}
+		})
+	}
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	server := newRecordingServer(t)
+	wh := NewWebHandler(server.URL, false, "", 0)
+	for ii := 0; ii < 20; ii++ {
+		if err := wh.HandleEntryBatch(newTestEntries(1, uint64(ii))); err != nil {
+			t.Fatalf("HandleEntryBatch(%d): %v", ii, err)
+		}
+	}
+	if wh.rateLimiter.limiter != nil {
+		t.Error("expected no limiter to be created without MaxRequestsPerSecond")
+	}
+}
+
+func TestRateLimitRespectsContext(t *testing.T) {
+	server := newRecordingServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	wh := NewWebHandler(server.URL, false, "", 0, WithContext(ctx))
+	wh.MaxRequestsPerSecond = 0.1
+
+	// The first request uses the burst, and the second waits ten seconds for its turn unless cancelled.
+	if err := wh.waitForRateLimit(); err != nil {
+		t.Fatalf("waitForRateLimit: %v", err)
+	}
+	time.AfterFunc(50*time.Millisecond, cancel)
+	startTime := time.Now()
+	err := wh.waitForRateLimit()
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("waitForRateLimit = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(startTime); elapsed > time.Second {
+		t.Errorf("waitForRateLimit returned after %s, long after the context was cancelled", elapsed)
+	}
+}
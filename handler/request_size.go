@@ -0,0 +1,243 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// Policies for a single entry that is larger than MaxRequestBytes or MaxPayloadBytes on its own.
+const (
+	// OversizeEntryPolicyFail, the default, fails the batch with an error that names the entry, so that it is retried
+	// or dead-lettered as configured by DeliverySemantics rather than sent.
+	OversizeEntryPolicyFail = "fail"
+	// OversizeEntryPolicySendAnyway sends the entry in a request of its own, even though it exceeds the limit.
+	OversizeEntryPolicySendAnyway = "send_anyway"
+	// OversizeEntryPolicyDrop drops the entry. It is lost.
+	OversizeEntryPolicyDrop = "drop"
+	// OversizeEntryPolicyTruncateFields removes the largest fields of the entry, at any depth, until it fits, and
+	// lists the removed fields under TruncatedFieldsKey so that receivers know the entry is incomplete. If the
+	// entry still doesn't fit once no field is left to remove, it is dropped.
+	OversizeEntryPolicyTruncateFields = "truncate_fields"
+)
+
+// TruncatedFieldsKey is the key of the dot separated paths of the fields removed from an entry under
+// OversizeEntryPolicyTruncateFields.
+const TruncatedFieldsKey = "TruncatedFields"
+
+// oversizeEntryPolicy returns the configured oversize entry policy, defaulting to OversizeEntryPolicyFail.
+func (wh *WebHandler) oversizeEntryPolicy() string {
+	if wh.OversizeEntryPolicy == "" {
+		return OversizeEntryPolicyFail
+	}
+	return wh.OversizeEntryPolicy
+}
+
+// maxRequestBytes returns the size limit of the JSON array of a single HTTP POST: the smaller of MaxRequestBytes and
+// MaxPayloadBytes, ignoring the ones that aren't set, or zero if neither is.
+func (wh *WebHandler) maxRequestBytes() int {
+	if wh.MaxPayloadBytes > 0 && (wh.MaxRequestBytes <= 0 || wh.MaxPayloadBytes < wh.MaxRequestBytes) {
+		return wh.MaxPayloadBytes
+	}
+	return max(wh.MaxRequestBytes, 0)
+}
+
+// splitRequest splits a marshaled batch into JSON arrays of at most maxRequestBytes each, keeping the entries in
+// order. Entries that don't fit in a request on their own are handled as configured by OversizeEntryPolicy, and
+// are sent in a request of their own if they still don't fit.
+func (wh *WebHandler) splitRequest(jsonData []byte) ([][]byte, error) {
+	maxRequestBytes := wh.maxRequestBytes()
+	if maxRequestBytes == 0 || len(jsonData) <= maxRequestBytes {
+		return [][]byte{jsonData}, nil
+	}
+	var entries []json.RawMessage
+	if err := json.Unmarshal(jsonData, &entries); err != nil {
+		return nil, errors.Wrap(err, "WebHandler.splitRequest: failed to decode batch")
+	}
+
+	var requests [][]byte
+	var request bytes.Buffer
+	flushRequest := func() {
+		if request.Len() > 0 {
+			request.WriteByte(']')
+			requests = append(requests, append([]byte{}, request.Bytes()...))
+			request.Reset()
+		}
+	}
+	for ii, entry := range entries {
+		// A request of just this entry takes two more bytes for the brackets.
+		if len(entry)+2 > maxRequestBytes {
+			var err error
+			if entry, err = wh.handleOversizeEntry(ii, entry); err != nil {
+				return nil, err
+			}
+			if entry == nil {
+				continue
+			}
+		}
+		if request.Len() > 0 && request.Len()+1+len(entry)+1 > maxRequestBytes {
+			flushRequest()
+		}
+
+		if request.Len() == 0 {
+			request.WriteByte('[')
+		} else {
+			request.WriteByte(',')
+		}
+		request.Write(entry)
+		if request.Len()+1 > maxRequestBytes {
+			// Only an oversize entry sent anyway overflows, and it goes in a request of its own.
+			flushRequest()
+		}
+	}
+	flushRequest()
+	return requests, nil
+}
+
+// handleOversizeEntry applies OversizeEntryPolicy to the entry at the index of the batch, which doesn't fit in a
+// request. It returns the entry to send, or nil if the entry is dropped.
+func (wh *WebHandler) handleOversizeEntry(index int, entry json.RawMessage) (json.RawMessage, error) {
+	maxRequestBytes := wh.maxRequestBytes()
+	switch wh.oversizeEntryPolicy() {
+	case OversizeEntryPolicySendAnyway:
+		wh.metrics.entriesOversizeSent.Add(1)
+		glog.Errorf("WebHandler.handleOversizeEntry: sending entry of %d bytes, over the %d byte limit", len(entry),
+			maxRequestBytes)
+		return entry, nil
+	case OversizeEntryPolicyDrop:
+		wh.metrics.entriesOversizeDropped.Add(1)
+		glog.Errorf("WebHandler.handleOversizeEntry: dropping entry of %d bytes, over the %d byte limit", len(entry),
+			maxRequestBytes)
+		return nil, nil
+	case OversizeEntryPolicyTruncateFields:
+		truncatedEntry, truncatedFields, err := truncateEntryFields(entry, maxRequestBytes-2)
+		if err != nil {
+			return nil, errors.Wrap(err, "WebHandler.handleOversizeEntry: failed to truncate entry")
+		}
+		if truncatedEntry == nil {
+			wh.metrics.entriesOversizeDropped.Add(1)
+			glog.Errorf("WebHandler.handleOversizeEntry: dropping entry of %d bytes, which can't be truncated "+
+				"below the %d byte limit", len(entry), maxRequestBytes)
+			return nil, nil
+		}
+		wh.metrics.entriesOversizeTruncated.Add(1)
+		glog.Errorf("WebHandler.handleOversizeEntry: truncated entry of %d bytes to %d bytes by removing %s",
+			len(entry), len(truncatedEntry), strings.Join(truncatedFields, ", "))
+		return truncatedEntry, nil
+	case OversizeEntryPolicyFail:
+		return nil, fmt.Errorf("WebHandler.handleOversizeEntry: %s is %d bytes, over the %d byte limit",
+			describeEntry(index, entry), len(entry), maxRequestBytes)
+	default:
+		return nil, fmt.Errorf("WebHandler.handleOversizeEntry: unknown oversize entry policy %q", wh.OversizeEntryPolicy)
+	}
+}
+
+// describedKeyBytesLength is the most of the KeyBytes of an entry that describeEntry includes.
+const describedKeyBytesLength = 64
+
+// describeEntry names the entry at the index of the batch by its EncoderType and KeyBytes, whatever FieldNameStyle
+// they were sent with and whether or not the entry is wrapped with WrapWithOpMetadata.
+func describeEntry(index int, entry json.RawMessage) string {
+	var fields map[string]interface{}
+	if err := json.Unmarshal(entry, &fields); err != nil {
+		return fmt.Sprintf("entry %d", index)
+	}
+	if wrappedFields, isWrapped := fields["entry"].(map[string]interface{}); isWrapped {
+		fields = wrappedFields
+	}
+
+	var encoderType, keyBytes interface{}
+	for key, value := range fields {
+		switch strings.ToLower(strings.ReplaceAll(key, "_", "")) {
+		case "encodertype":
+			encoderType = value
+		case "keybytes":
+			keyBytes = value
+		}
+	}
+	keyBytesText := fmt.Sprint(keyBytes)
+	if len(keyBytesText) > describedKeyBytesLength {
+		keyBytesText = keyBytesText[:describedKeyBytesLength] + "..."
+	}
+	return fmt.Sprintf("entry %d (EncoderType %v, KeyBytes %s)", index, encoderType, keyBytesText)
+}
+
+// truncateEntryFields removes the largest fields of the entry until it is at most maxBytes long, and returns the
+// truncated entry and the paths of the removed fields. It returns a nil entry if the entry can't be made to fit.
+func truncateEntryFields(entry json.RawMessage, maxBytes int) (json.RawMessage, []string, error) {
+	var fields map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(entry))
+	decoder.UseNumber()
+	if err := decoder.Decode(&fields); err != nil {
+		return nil, nil, errors.Wrap(err, "truncateEntryFields: entry is not an object")
+	}
+
+	var truncatedFields []string
+	for {
+		if len(truncatedFields) > 0 {
+			fields[TruncatedFieldsKey] = truncatedFields
+		}
+		truncatedEntry, err := marshalTransformedEntry(fields)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(truncatedEntry) <= maxBytes {
+			return truncatedEntry, truncatedFields, nil
+		}
+
+		delete(fields, TruncatedFieldsKey)
+		path, found := removeLargestField(fields, "")
+		if !found {
+			return nil, truncatedFields, nil
+		}
+		truncatedFields = append(truncatedFields, path)
+	}
+}
+
+// removeLargestField removes the largest leaf field of the object, descending into nested objects, and returns its
+// dot separated path. Arrays count as leaves. It returns false if the object has no field left.
+func removeLargestField(fields map[string]interface{}, prefix string) (string, bool) {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	// Visit keys in a fixed order so that ties are broken the same way every time.
+	sort.Strings(keys)
+
+	var largestKey string
+	largestSize := -1
+	for _, key := range keys {
+		data, err := json.Marshal(fields[key])
+		if err == nil && len(data) > largestSize {
+			largestKey, largestSize = key, len(data)
+		}
+	}
+	if largestSize < 0 {
+		return "", false
+	}
+
+	path := prefix + largestKey
+	if nestedFields, isObject := fields[largestKey].(map[string]interface{}); isObject {
+		if nestedPath, found := removeLargestField(nestedFields, path+"."); found {
+			return nestedPath, true
+		}
+	}
+	delete(fields, largestKey)
+	return path, true
+}
+
+// marshalTransformedEntry marshals a decoded entry as marshalBatch does, without escaping HTML characters.
+func marshalTransformedEntry(entry map[string]interface{}) ([]byte, error) {
+	var data bytes.Buffer
+	encoder := json.NewEncoder(&data)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(entry); err != nil {
+		return nil, errors.Wrap(err, "marshalTransformedEntry: failed to marshal entry")
+	}
+	return bytes.TrimSuffix(data.Bytes(), []byte("\n")), nil
+}
@@ -0,0 +1,237 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/deso-protocol/core/lib"
+)
+
+// testSizedEntry returns an entry that marshals to exactly size bytes, with the index in its KeyBytes.
+func testSizedEntry(index int, size int) string {
+	entry := fmt.Sprintf(`{"EncoderType":5,"KeyBytes":"%d-"}`, index)
+	return strings.Replace(entry, "-", "-"+strings.Repeat("x", size-len(entry)), 1)
+}
+
+// testSizedBatch returns a JSON array of count entries of entrySize bytes each.
+func testSizedBatch(count int, entrySize int) []byte {
+	entries := make([]string, count)
+	for ii := range entries {
+		entries[ii] = testSizedEntry(ii, entrySize)
+	}
+	return []byte("[" + strings.Join(entries, ",") + "]")
+}
+
+func TestSplitRequest(t *testing.T) {
+	tests := []struct {
+		name            string
+		count           int
+		entrySize       int
+		maxRequestBytes int
+		maxPayloadBytes int
+		wantCounts      []int
+	}{
+		{name: "disabled", count: 5, entrySize: 40, wantCounts: []int{5}},
+		{name: "under the limit", count: 5, entrySize: 40, maxRequestBytes: 1000, wantCounts: []int{5}},
+		{name: "exactly at the limit", count: 2, entrySize: 40, maxRequestBytes: 83, wantCounts: []int{2}},
+		{name: "two per request", count: 5, entrySize: 40, maxRequestBytes: 83, wantCounts: []int{2, 2, 1}},
+		{name: "one byte short of two", count: 5, entrySize: 40, maxRequestBytes: 82, wantCounts: []int{1, 1, 1, 1, 1}},
+		{name: "three per request", count: 7, entrySize: 40, maxRequestBytes: 130, wantCounts: []int{3, 3, 1}},
+		{name: "payload limit", count: 5, entrySize: 40, maxPayloadBytes: 83, wantCounts: []int{2, 2, 1}},
+		{name: "payload limit below the request limit", count: 5, entrySize: 40, maxRequestBytes: 1000,
+			maxPayloadBytes: 83, wantCounts: []int{2, 2, 1}},
+		{name: "request limit below the payload limit", count: 7, entrySize: 40, maxRequestBytes: 130,
+			maxPayloadBytes: 1000, wantCounts: []int{3, 3, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh := NewWebHandler("", false, "", 0)
+			wh.MaxRequestBytes = tt.maxRequestBytes
+			wh.MaxPayloadBytes = tt.maxPayloadBytes
+			requests, err := wh.splitRequest(testSizedBatch(tt.count, tt.entrySize))
+			if err != nil {
+				t.Fatalf("splitRequest: %v", err)
+			}
+
+			var counts []int
+			index := 0
+			for ii, request := range requests {
+				if limit := wh.maxRequestBytes(); limit > 0 && len(request) > limit {
+					t.Errorf("request %d is %d bytes, over the %d byte limit", ii, len(request), limit)
+				}
+				// Every request is a standalone array of whole entries, in order.
+				var entries []json.RawMessage
+				if err = json.Unmarshal(request, &entries); err != nil {
+					t.Fatalf("request %d is not a JSON array: %v", ii, err)
+				}
+				for _, entry := range entries {
+					if string(entry) != testSizedEntry(index, tt.entrySize) {
+						t.Errorf("request %d holds %s, want entry %d", ii, entry, index)
+					}
+					index++
+				}
+				counts = append(counts, len(entries))
+			}
+			if !reflect.DeepEqual(counts, tt.wantCounts) {
+				t.Errorf("entries per request = %v, want %v", counts, tt.wantCounts)
+			}
+		})
+	}
+}
+
+func TestSplitRequestOversizeEntryFails(t *testing.T) {
+	tests := []struct {
+		name            string
+		policy          string
+		maxRequestBytes int
+		maxPayloadBytes int
+	}{
+		{name: "default policy", maxRequestBytes: 100},
+		{name: "fail policy", policy: OversizeEntryPolicyFail, maxRequestBytes: 100},
+		{name: "payload limit", maxPayloadBytes: 100},
+		{name: "payload limit below the request limit", maxRequestBytes: 1000, maxPayloadBytes: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh := NewWebHandler("", false, "", 0)
+			wh.MaxRequestBytes = tt.maxRequestBytes
+			wh.MaxPayloadBytes = tt.maxPayloadBytes
+			wh.OversizeEntryPolicy = tt.policy
+			batch := []byte("[" + testSizedEntry(0, 40) + "," + testSizedEntry(1, 200) + "]")
+
+			requests, err := wh.splitRequest(batch)
+			if err == nil {
+				t.Fatalf("expected an error, got %d requests", len(requests))
+			}
+			for _, want := range []string{"entry 1", "EncoderType 5", "KeyBytes 1-xxx", "200 bytes", "100 byte limit"} {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q doesn't contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestHandleEntryBatchSplitsByRequestBytes(t *testing.T) {
+	server := newRecordingServer(t)
+	wh := NewWebHandler(server.URL, false, "", 0)
+	wh.MaxDeliveryAttempts = 1
+
+	entryData, err := json.Marshal(newTestEntries(1, 1))
+	if err != nil {
+		t.Fatalf("failed to marshal entry: %v", err)
+	}
+	// Fit two entries, with their brackets and separating comma, in every request.
+	entrySize := len(entryData) - 2
+	wh.MaxRequestBytes = 2*entrySize + 3
+
+	if err = wh.HandleEntryBatch(newTestEntries(5, 1)); err != nil {
+		t.Fatalf("HandleEntryBatch: %v", err)
+	}
+	if heights := server.batchHeights(); !reflect.DeepEqual(heights, [][]uint64{{1, 1}, {1, 1}, {1}}) {
+		t.Errorf("requests = %v, want [[1 1] [1 1] [1]]", heights)
+	}
+
+	// A batch with an entry that can't fit fails without sending any of it.
+	wh.MaxRequestBytes = entrySize
+	requests := server.requests.Load()
+	if err = wh.HandleEntryBatch(newTestEntries(2, 2)); err == nil || !strings.Contains(err.Error(), "entry 0") {
+		t.Errorf("HandleEntryBatch = %v, want an error naming entry 0", err)
+	}
+	if server.requests.Load() != requests {
+		t.Error("expected no request for a batch with an oversize entry")
+	}
+}
+
+func TestOversizeEntryPolicy(t *testing.T) {
+	const maxRequestBytes = 4096
+	tests := []struct {
+		name            string
+		policy          string
+		maxRequestBytes int
+		wantErr         string
+		// wantHeights are the heights of the entries in each request.
+		wantHeights   [][]uint64
+		wantSent      uint64
+		wantDropped   uint64
+		wantTruncated uint64
+	}{
+		{name: "fail", policy: OversizeEntryPolicyFail, wantErr: "entry 1"},
+		{name: "drop", policy: OversizeEntryPolicyDrop, wantHeights: [][]uint64{{1, 3}}, wantDropped: 1},
+		{name: "send anyway", policy: OversizeEntryPolicySendAnyway, wantHeights: [][]uint64{{1}, {2}, {3}},
+			wantSent: 1},
+		{name: "truncate fields", policy: OversizeEntryPolicyTruncateFields, wantHeights: [][]uint64{{1, 2, 3}},
+			wantTruncated: 1},
+		{name: "truncate fields below what any entry fits in", policy: OversizeEntryPolicyTruncateFields,
+			maxRequestBytes: 20, wantDropped: 3},
+		{name: "unknown policy", policy: "shrink", wantErr: "unknown oversize entry policy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roundTripper := &captureRoundTripper{statusCode: http.StatusOK}
+			wh := NewWebHandler("http://receiver", false, "", 0, WithRoundTripper(roundTripper))
+			wh.MaxDeliveryAttempts = 1
+			wh.OversizeEntryPolicy = tt.policy
+			wh.MaxRequestBytes = maxRequestBytes
+			if tt.maxRequestBytes != 0 {
+				wh.MaxRequestBytes = tt.maxRequestBytes
+			}
+			// The post in the middle of the batch is far over the limit because of its body.
+			hugeEntry := newTestPostEntry()
+			hugeEntry.Encoder.(*lib.PostEntry).Body = bytes.Repeat([]byte("x"), 100*maxRequestBytes)
+			hugeEntry.BlockHeight = 2
+			batch := append(append(newTestEntries(1, 1), hugeEntry), newTestEntries(1, 3)...)
+
+			err := wh.HandleEntryBatch(batch)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("HandleEntryBatch = %v, want an error containing %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("HandleEntryBatch: %v", err)
+			}
+
+			var heights [][]uint64
+			for ii, body := range roundTripper.bodies {
+				if tt.policy != OversizeEntryPolicySendAnyway && len(body) > wh.MaxRequestBytes {
+					t.Errorf("request %d is %d bytes, over the %d byte limit", ii, len(body), wh.MaxRequestBytes)
+				}
+				var requestHeights []uint64
+				for _, fields := range decodeTestBatch(t, body) {
+					height, _ := fields["BlockHeight"].(json.Number).Int64()
+					requestHeights = append(requestHeights, uint64(height))
+					// Only the huge entry is truncated, and it lists the body it lost.
+					var wantTruncatedFields []interface{}
+					if tt.wantTruncated > 0 && height == 2 {
+						wantTruncatedFields = []interface{}{"Encoder.Body"}
+					}
+					truncatedFields, _ := fields[TruncatedFieldsKey].([]interface{})
+					if !reflect.DeepEqual(truncatedFields, wantTruncatedFields) {
+						t.Errorf("entry at height %d has %s %v, want %v", height, TruncatedFieldsKey, truncatedFields,
+							wantTruncatedFields)
+					}
+				}
+				heights = append(heights, requestHeights)
+			}
+			if !reflect.DeepEqual(heights, tt.wantHeights) {
+				t.Errorf("requests = %v, want %v", heights, tt.wantHeights)
+			}
+
+			metrics := &wh.metrics
+			if sent := metrics.entriesOversizeSent.Load(); sent != tt.wantSent {
+				t.Errorf("entriesOversizeSent = %d, want %d", sent, tt.wantSent)
+			}
+			if dropped := metrics.entriesOversizeDropped.Load(); dropped != tt.wantDropped {
+				t.Errorf("entriesOversizeDropped = %d, want %d", dropped, tt.wantDropped)
+			}
+			if truncated := metrics.entriesOversizeTruncated.Load(); truncated != tt.wantTruncated {
+				t.Errorf("entriesOversizeTruncated = %d, want %d", truncated, tt.wantTruncated)
+			}
+		})
+	}
+}
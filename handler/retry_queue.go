@@ -0,0 +1,238 @@
+package handler
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// retryQueueState holds the batches that couldn't be delivered, which a background goroutine retries in order.
+type retryQueueState struct {
+	mtx sync.Mutex
+	// changed is signalled whenever a batch is queued or dequeued, and when the queue is stopped.
+	changed *sync.Cond
+	// batches are the queued batches, oldest first. The first one is the one being retried.
+	batches []queuedBatch
+	// started is set while the retry goroutine runs. It is started on the first queued batch, and restarted on the
+	// next one after it returned because the context of the handler's sends was done.
+	started bool
+	// stop is closed by Close to stop the retry goroutine, and done is closed once it has stopped.
+	stop    chan struct{}
+	done    chan struct{}
+	stopped bool
+}
+
+// queuedBatch is a batch held in the retry queue.
+type queuedBatch struct {
+	entries []*lib.StateChangeEntry
+	// memorySize is the memory the entries are accounted for in the memory budget.
+	memorySize int64
+	// enqueuedAt is when the batch was queued, to expire it after BatchTTL.
+	enqueuedAt time.Time
+}
+
+// deliverOrQueueBatch delivers the batch, or queues it to be retried in the background if it can't be delivered.
+// While batches are queued, new batches are queued behind them without being sent, so that batches are delivered
+// in the order they were received.
+//
+// A batch is only queued if delivering it fails, i.e. under DeliveryAtLeastOnce without a DeadLetterDir, once
+// MaxDeliveryAttempts attempts have failed. When the queue holds RetryQueueSize batches, or MaxMemoryBytes is
+// reached, the batch fails if DropOnFull is set, and waits for room otherwise, so that the consumer stops
+// making progress until the endpoint is back. Queued batches that are older than BatchTTL are dropped instead of
+// retried. While the context of the handler's sends is done, the retries stop and batches fail instead of being
+// queued. The retries resume with the next batch queued after SetContext.
+func (wh *WebHandler) deliverOrQueueBatch(batchedEntries []*lib.StateChangeEntry) error {
+	wh.retryQueue.mtx.Lock()
+	queueEmpty := len(wh.retryQueue.batches) == 0
+	wh.retryQueue.mtx.Unlock()
+
+	if queueEmpty {
+		err := wh.deliverBatch(batchedEntries)
+		if err == nil {
+			return nil
+		}
+		glog.Errorf("WebHandler.deliverOrQueueBatch: queueing batch for retry: %v", err)
+	}
+	return wh.queueBatchForRetry(batchedEntries)
+}
+
+// queueBatchForRetry adds the batch to the retry queue, starting the retry goroutine if needed.
+func (wh *WebHandler) queueBatchForRetry(batchedEntries []*lib.StateChangeEntry) error {
+	queue := &wh.retryQueue
+	queue.mtx.Lock()
+	defer queue.mtx.Unlock()
+
+	if queue.changed == nil {
+		queue.changed = sync.NewCond(&queue.mtx)
+	}
+	memorySize := entriesMemorySize(batchedEntries)
+	for !queue.stopped && (len(queue.batches) >= wh.RetryQueueSize || wh.memoryBudgetExceeded(memorySize)) {
+		if wh.DropOnFull {
+			wh.metrics.batchesDropped.Add(1)
+			return fmt.Errorf("WebHandler.queueBatchForRetry: retry queue is full with %d batches", len(queue.batches))
+		}
+		// The retry goroutine returns once the context of the handler's sends is done, so nothing would make room.
+		if err := wh.context().Err(); err != nil {
+			return errors.Wrap(err, "WebHandler.queueBatchForRetry: retry queue is full and its retries stopped")
+		}
+		wh.startRetryQueue()
+		queue.changed.Wait()
+	}
+	if queue.stopped {
+		return fmt.Errorf("WebHandler.queueBatchForRetry: handler is closed")
+	}
+	if err := wh.context().Err(); err != nil {
+		return errors.Wrap(err, "WebHandler.queueBatchForRetry: retries are stopped")
+	}
+
+	queue.batches = append(queue.batches, queuedBatch{
+		entries:    batchedEntries,
+		memorySize: memorySize,
+		enqueuedAt: time.Now(),
+	})
+	wh.metrics.memoryBytes.Add(memorySize)
+	wh.metrics.batchesQueued.Add(1)
+	queue.changed.Broadcast()
+	wh.startRetryQueue()
+	return nil
+}
+
+// startRetryQueue starts the retry goroutine unless it is running. The caller must hold retryQueue.mtx.
+func (wh *WebHandler) startRetryQueue() {
+	queue := &wh.retryQueue
+	if queue.started {
+		return
+	}
+	queue.started = true
+	queue.stop = make(chan struct{})
+	queue.done = make(chan struct{})
+	go wh.runRetryQueue(queue.stop, queue.done)
+}
+
+// runRetryQueue sends the first queued batch until it is delivered, waiting with exponential backoff between
+// attempts, and then moves on to the next one. Batches that expire while queued are dropped. It returns once the
+// queue is stopped by Close, or once the context of the handler's sends is done, leaving the batches queued.
+func (wh *WebHandler) runRetryQueue(stop chan struct{}, done chan struct{}) {
+	queue := &wh.retryQueue
+	defer close(done)
+	defer func() {
+		queue.mtx.Lock()
+		defer queue.mtx.Unlock()
+		queue.started = false
+		// Wake the batches waiting for room, so that they fail rather than wait for a goroutine that is gone.
+		queue.changed.Broadcast()
+	}()
+
+	failures := 0
+	for {
+		queue.mtx.Lock()
+		for {
+			if wh.dropExpiredRetryBatches() > 0 {
+				failures = 0
+			}
+			if len(queue.batches) > 0 || queue.stopped {
+				break
+			}
+			queue.changed.Wait()
+		}
+		if queue.stopped {
+			queue.mtx.Unlock()
+			return
+		}
+		batch := queue.batches[0]
+		queue.mtx.Unlock()
+
+		if err := wh.sendBatchOnce(batch.entries); err != nil {
+			backoff := wh.retryBackoff(failures)
+			failures++
+			glog.Errorf("WebHandler.runRetryQueue: retrying queued batch in %s after attempt %d failed: %v", backoff,
+				failures, err)
+			select {
+			case <-time.After(backoff):
+			case <-stop:
+				return
+			case <-wh.context().Done():
+				return
+			}
+			continue
+		}
+		failures = 0
+		wh.dequeueRetryBatch()
+	}
+}
+
+// dequeueRetryBatch removes the first queued batch once it has been delivered.
+func (wh *WebHandler) dequeueRetryBatch() {
+	queue := &wh.retryQueue
+	queue.mtx.Lock()
+	defer queue.mtx.Unlock()
+	wh.metrics.memoryBytes.Add(-queue.batches[0].memorySize)
+	queue.batches[0] = queuedBatch{}
+	queue.batches = queue.batches[1:]
+	queue.changed.Broadcast()
+}
+
+// drainRetryQueue stops the retry goroutine and makes a last attempt to send the queued batches, in order, stopping
+// at the first one that fails. It returns an error if any batch is left undelivered.
+func (wh *WebHandler) drainRetryQueue() error {
+	queue := &wh.retryQueue
+	queue.mtx.Lock()
+	queue.stopped = true
+	started, stop, done := queue.started, queue.stop, queue.done
+	if queue.changed != nil {
+		// Fail the batches waiting for room, and wake the retry goroutine so that it returns.
+		queue.changed.Broadcast()
+	}
+	queue.mtx.Unlock()
+	if started {
+		close(stop)
+		<-done
+	}
+
+	for {
+		queue.mtx.Lock()
+		wh.dropExpiredRetryBatches()
+		queue.mtx.Unlock()
+		if len(queue.batches) == 0 {
+			return nil
+		}
+		if err := wh.sendBatchOnce(queue.batches[0].entries); err != nil {
+			wh.metrics.batchesDropped.Add(uint64(len(queue.batches)))
+			return errors.Wrapf(err, "WebHandler.drainRetryQueue: dropped %d queued batches", len(queue.batches))
+		}
+		wh.dequeueRetryBatch()
+	}
+}
+
+// dropExpiredRetryBatches drops the queued batches that are older than BatchTTL and returns how many were dropped.
+// Batches are queued in order, so the expired ones are at the front. Only the retry goroutine, or drainRetryQueue once
+// it has stopped, may call it, since the first batch may be in flight otherwise. The caller must hold retryQueue.mtx.
+func (wh *WebHandler) dropExpiredRetryBatches() int {
+	queue := &wh.retryQueue
+	expiredCount := 0
+	for len(queue.batches) > 0 && wh.batchExpired(queue.batches[0].enqueuedAt) {
+		wh.metrics.memoryBytes.Add(-queue.batches[0].memorySize)
+		queue.batches[0] = queuedBatch{}
+		queue.batches = queue.batches[1:]
+		expiredCount++
+	}
+	if expiredCount == 0 {
+		return 0
+	}
+	wh.metrics.batchesExpired.Add(uint64(expiredCount))
+	queue.changed.Broadcast()
+	glog.Errorf("WebHandler.dropExpiredRetryBatches: dropped %d queued batches older than %v", expiredCount,
+		wh.BatchTTL)
+	return expiredCount
+}
+
+// retryQueueLength returns the number of batches in the retry queue.
+func (wh *WebHandler) retryQueueLength() int {
+	wh.retryQueue.mtx.Lock()
+	defer wh.retryQueue.mtx.Unlock()
+	return len(wh.retryQueue.batches)
+}
@@ -0,0 +1,264 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// newRetryQueueHandler returns a handler that queues every batch that fails its single delivery attempt.
+func newRetryQueueHandler(server *recordingServer, queueSize int, baseBackoff time.Duration) *WebHandler {
+	wh := NewWebHandler(server.URL, false, "", 0)
+	wh.MaxDeliveryAttempts = 1
+	wh.RetryQueueSize = queueSize
+	wh.BaseBackoff = baseBackoff
+	return wh
+}
+
+func TestRetryQueueDeliversInOrder(t *testing.T) {
+	server := newRecordingServer(t)
+	server.statusCode.Store(http.StatusServiceUnavailable)
+	wh := newRetryQueueHandler(server, 10, time.Millisecond)
+
+	for height := uint64(1); height <= 5; height++ {
+		if err := wh.HandleEntryBatch(newTestEntries(1, height)); err != nil {
+			t.Fatalf("HandleEntryBatch(%d): %v", height, err)
+		}
+	}
+	if length := wh.retryQueueLength(); length != 5 {
+		t.Fatalf("retry queue holds %d batches, want 5", length)
+	}
+
+	server.statusCode.Store(http.StatusOK)
+	waitFor(t, "queued batches", func() bool { return wh.retryQueueLength() == 0 })
+	// New batches are sent right away once the queue is empty.
+	if err := wh.HandleEntryBatch(newTestEntries(1, 6)); err != nil {
+		t.Fatalf("HandleEntryBatch(6): %v", err)
+	}
+
+	want := [][]uint64{{1}, {2}, {3}, {4}, {5}, {6}}
+	if heights := server.batchHeights(); !reflect.DeepEqual(heights, want) {
+		t.Errorf("batches = %v, want %v", heights, want)
+	}
+	if queued := wh.metrics.batchesQueued.Load(); queued != 5 {
+		t.Errorf("batchesQueued = %d, want 5", queued)
+	}
+	if err := wh.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func TestRetryQueueFull(t *testing.T) {
+	tests := []struct {
+		name       string
+		dropOnFull bool
+	}{
+		{name: "blocks", dropOnFull: false},
+		{name: "drops", dropOnFull: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newRecordingServer(t)
+			server.statusCode.Store(http.StatusServiceUnavailable)
+			wh := newRetryQueueHandler(server, 1, time.Millisecond)
+			wh.DropOnFull = tt.dropOnFull
+
+			if err := wh.HandleEntryBatch(newTestEntries(1, 1)); err != nil {
+				t.Fatalf("HandleEntryBatch(1): %v", err)
+			}
+			result := make(chan error, 1)
+			go func() { result <- wh.HandleEntryBatch(newTestEntries(1, 2)) }()
+
+			if tt.dropOnFull {
+				if err := <-result; err == nil {
+					t.Fatal("expected the batch that doesn't fit to fail")
+				}
+				if dropped := wh.metrics.batchesDropped.Load(); dropped != 1 {
+					t.Errorf("batchesDropped = %d, want 1", dropped)
+				}
+			} else {
+				select {
+				case err := <-result:
+					t.Fatalf("HandleEntryBatch returned %v while the queue was full", err)
+				case <-time.After(100 * time.Millisecond):
+				}
+			}
+
+			// Once the endpoint is back, the waiting batch is queued behind the first one.
+			server.statusCode.Store(http.StatusOK)
+			want := [][]uint64{{1}}
+			if !tt.dropOnFull {
+				if err := <-result; err != nil {
+					t.Fatalf("HandleEntryBatch(2): %v", err)
+				}
+				want = append(want, []uint64{2})
+			}
+			waitFor(t, "queued batches", func() bool { return wh.retryQueueLength() == 0 })
+			if heights := server.batchHeights(); !reflect.DeepEqual(heights, want) {
+				t.Errorf("batches = %v, want %v", heights, want)
+			}
+			if err := wh.Close(); err != nil {
+				t.Errorf("Close: %v", err)
+			}
+		})
+	}
+}
+
+func TestRetryQueueDrainOnClose(t *testing.T) {
+	tests := []struct {
+		name string
+		// recovered is whether the endpoint is back when Close is called.
+		recovered   bool
+		wantErr     bool
+		wantHeights [][]uint64
+	}{
+		{name: "endpoint back", recovered: true, wantHeights: [][]uint64{{1}, {2}, {3}}},
+		{name: "endpoint down", recovered: false, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newRecordingServer(t)
+			server.statusCode.Store(http.StatusServiceUnavailable)
+			// The retry goroutine waits out its backoff, so only Close retries the batches.
+			wh := newRetryQueueHandler(server, 10, time.Hour)
+			for height := uint64(1); height <= 3; height++ {
+				if err := wh.HandleEntryBatch(newTestEntries(1, height)); err != nil {
+					t.Fatalf("HandleEntryBatch(%d): %v", height, err)
+				}
+			}
+
+			if tt.recovered {
+				server.statusCode.Store(http.StatusOK)
+			}
+			err := wh.Close()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Close = %v, wantErr %v", err, tt.wantErr)
+			}
+			if heights := server.batchHeights(); !reflect.DeepEqual(heights, tt.wantHeights) {
+				t.Errorf("batches = %v, want %v", heights, tt.wantHeights)
+			}
+			if tt.wantErr {
+				if dropped := wh.metrics.batchesDropped.Load(); dropped != 3 {
+					t.Errorf("batchesDropped = %d, want 3", dropped)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryQueueDropsExpiredBatches(t *testing.T) {
+	tests := []struct {
+		name string
+		// drainOnClose leaves the batches to Close rather than to the retry goroutine.
+		drainOnClose bool
+	}{
+		{name: "retry goroutine", drainOnClose: false},
+		{name: "close", drainOnClose: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newRecordingServer(t)
+			server.statusCode.Store(http.StatusServiceUnavailable)
+			baseBackoff := 20 * time.Millisecond
+			if tt.drainOnClose {
+				baseBackoff = time.Hour
+			}
+			wh := newRetryQueueHandler(server, 10, baseBackoff)
+			wh.BatchTTL = 100 * time.Millisecond
+
+			for height := uint64(1); height <= 2; height++ {
+				if err := wh.HandleEntryBatch(newTestEntries(1, height)); err != nil {
+					t.Fatalf("HandleEntryBatch(%d): %v", height, err)
+				}
+			}
+			time.Sleep(2 * wh.BatchTTL)
+			if err := wh.HandleEntryBatch(newTestEntries(1, 3)); err != nil {
+				t.Fatalf("HandleEntryBatch(3): %v", err)
+			}
+			server.statusCode.Store(http.StatusOK)
+
+			if !tt.drainOnClose {
+				waitFor(t, "queued batches", func() bool { return wh.retryQueueLength() == 0 })
+			}
+			if err := wh.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			// Only the fresh batch is sent.
+			if heights := server.batchHeights(); !reflect.DeepEqual(heights, [][]uint64{{3}}) {
+				t.Errorf("batches = %v, want [[3]]", heights)
+			}
+			if expired := wh.metrics.batchesExpired.Load(); expired != 2 {
+				t.Errorf("batchesExpired = %d, want 2", expired)
+			}
+			if memoryBytes := wh.metrics.memoryBytes.Load(); memoryBytes != 0 {
+				t.Errorf("memoryBytes = %d, want 0", memoryBytes)
+			}
+		})
+	}
+}
+
+func TestRetryQueueContextCancelled(t *testing.T) {
+	tests := []struct {
+		name string
+		// waiting is whether the second batch is already waiting for room when the context is cancelled, rather than
+		// queued after it.
+		waiting bool
+	}{
+		{name: "queued after the cancel", waiting: false},
+		{name: "waiting for room", waiting: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newRecordingServer(t)
+			server.statusCode.Store(http.StatusServiceUnavailable)
+			ctx, cancel := context.WithCancel(context.Background())
+			wh := newRetryQueueHandler(server, 1, time.Millisecond)
+			wh.SetContext(ctx)
+			if err := wh.HandleEntryBatch(newTestEntries(1, 1)); err != nil {
+				t.Fatalf("HandleEntryBatch(1): %v", err)
+			}
+
+			result := make(chan error, 1)
+			if tt.waiting {
+				go func() { result <- wh.HandleEntryBatch(newTestEntries(1, 2)) }()
+				time.Sleep(50 * time.Millisecond)
+				cancel()
+			} else {
+				cancel()
+				waitFor(t, "the retry goroutine to stop", func() bool {
+					wh.retryQueue.mtx.Lock()
+					defer wh.retryQueue.mtx.Unlock()
+					return !wh.retryQueue.started
+				})
+				go func() { result <- wh.HandleEntryBatch(newTestEntries(1, 2)) }()
+			}
+			// The batch fails rather than waiting for retries that have stopped.
+			select {
+			case err := <-result:
+				if !errors.Is(err, context.Canceled) {
+					t.Errorf("HandleEntryBatch(2) = %v, want context.Canceled", err)
+				}
+			case <-time.After(testReadTimeout):
+				t.Fatal("HandleEntryBatch(2) blocked after the context was cancelled")
+			}
+
+			// The retries resume under a new context, starting with the batch still queued.
+			wh.SetContext(context.Background())
+			server.statusCode.Store(http.StatusOK)
+			if err := wh.HandleEntryBatch(newTestEntries(1, 3)); err != nil {
+				t.Fatalf("HandleEntryBatch(3): %v", err)
+			}
+			waitFor(t, "queued batches", func() bool { return wh.retryQueueLength() == 0 })
+			if heights := server.batchHeights(); !reflect.DeepEqual(heights, [][]uint64{{1}, {3}}) {
+				t.Errorf("batches = %v, want [[1] [3]]", heights)
+			}
+			if err := wh.Close(); err != nil {
+				t.Errorf("Close: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"crypto/rand"
+	"fmt"
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+)
+
+// SelfTestBody is the body of the post in the self-test batch, so that receivers can recognize and ignore it.
+const SelfTestBody = `{"Body":"postgres-data-handler self-test"}`
+
+// SelfTestResult is the outcome of RunSelfTest.
+type SelfTestResult struct {
+	// EntryCount is the number of entries in the synthetic batch.
+	EntryCount int
+	// Duration is how long the handler took to deliver the batch, or to fail.
+	Duration time.Duration
+	// Err is why the batch wasn't delivered, or nil if it was.
+	Err error
+}
+
+// selfTestSender is implemented by handlers whose HandleEntryBatch may hide a failed delivery, e.g. because of
+// their delivery semantics, so that RunSelfTest can send the batch directly over their transport instead.
+type selfTestSender interface {
+	sendSelfTestBatch(batchedEntries []*lib.StateChangeEntry) error
+}
+
+// SelfTestBatch returns a batch of fabricated entries at the given block height: a new post, a like of it and the
+// removal of that like. Their keys and hashes are random, so they don't collide with real entries.
+func SelfTestBatch(blockHeight uint64) []*lib.StateChangeEntry {
+	flushId := uuid.New()
+	posterPublicKey := randomSelfTestBytes(33)
+	likerPublicKey := randomSelfTestBytes(33)
+	postHash := &lib.BlockHash{}
+	copy(postHash[:], randomSelfTestBytes(len(postHash)))
+
+	postEntry := &lib.PostEntry{
+		PostHash:        postHash,
+		PosterPublicKey: posterPublicKey,
+		Body:            []byte(SelfTestBody),
+		TimestampNanos:  uint64(time.Now().UnixNano()),
+	}
+	likeEntry := &lib.LikeEntry{
+		LikerPubKey:   likerPublicKey,
+		LikedPostHash: postHash,
+	}
+	likeKey := append(append([]byte("selftest-like-"), likerPublicKey...), postHash[:]...)
+
+	return []*lib.StateChangeEntry{
+		{
+			OperationType: lib.DbOperationTypeUpsert,
+			KeyBytes:      append([]byte("selftest-post-"), postHash[:]...),
+			Encoder:       postEntry,
+			EncoderType:   lib.EncoderTypePostEntry,
+			FlushId:       flushId,
+			BlockHeight:   blockHeight,
+		},
+		{
+			OperationType: lib.DbOperationTypeUpsert,
+			KeyBytes:      likeKey,
+			Encoder:       likeEntry,
+			EncoderType:   lib.EncoderTypeLikeEntry,
+			FlushId:       flushId,
+			BlockHeight:   blockHeight,
+		},
+		{
+			OperationType:   lib.DbOperationTypeDelete,
+			KeyBytes:        likeKey,
+			AncestralRecord: likeEntry,
+			EncoderType:     lib.EncoderTypeLikeEntry,
+			FlushId:         flushId,
+			BlockHeight:     blockHeight,
+		},
+	}
+}
+
+// randomSelfTestBytes returns n random bytes.
+func randomSelfTestBytes(n int) []byte {
+	randomBytes := make([]byte, n)
+	rand.Read(randomBytes)
+	return randomBytes
+}
+
+// RunSelfTest sends a SelfTestBatch at the given block height through the handler in its own transaction, and
+// reports whether it was delivered. The block height must be at least the handler's MinBlockHeight, or the batch
+// is skipped and reported as delivered. The handler is left ready for use, but receivers get the synthetic entries.
+func RunSelfTest(dataHandler DataHandler, blockHeight uint64) SelfTestResult {
+	batchedEntries := SelfTestBatch(blockHeight)
+	startTime := time.Now()
+	err := runSelfTestBatch(dataHandler, batchedEntries)
+	return SelfTestResult{
+		EntryCount: len(batchedEntries),
+		Duration:   time.Since(startTime),
+		Err:        err,
+	}
+}
+
+// runSelfTestBatch delivers the batch, rolling back the transaction if it fails.
+func runSelfTestBatch(dataHandler DataHandler, batchedEntries []*lib.StateChangeEntry) error {
+	if sender, ok := dataHandler.(selfTestSender); ok {
+		return sender.sendSelfTestBatch(batchedEntries)
+	}
+
+	if err := dataHandler.InitiateTransaction(); err != nil {
+		return errors.Wrap(err, "RunSelfTest: failed to initiate transaction")
+	}
+	err := dataHandler.HandleEntryBatch(batchedEntries)
+	if err == nil {
+		err = dataHandler.CommitTransaction()
+	}
+	if err != nil {
+		if rollbackErr := dataHandler.RollbackTransaction(); rollbackErr != nil {
+			return fmt.Errorf("RunSelfTest: %v, and failed to roll back: %v", err, rollbackErr)
+		}
+		return errors.Wrap(err, "RunSelfTest: batch was not delivered")
+	}
+	return nil
+}
+
+// sendSelfTestBatch makes a single attempt to send the batch over the configured transport, bypassing the
+// filters, pausing, coalescing and delivery semantics of HandleEntryBatch, which could hold or drop it. Fan-out
+// subscribers are sent the batch one after the other, rather than through their queues, so that every one of them
+// is checked.
+func (wh *WebHandler) sendSelfTestBatch(batchedEntries []*lib.StateChangeEntry) error {
+	if len(wh.FanOutURLs) == 0 {
+		return wh.sendBatch(nil, batchedEntries)
+	}
+
+	jsonData, err := wh.marshalBatch(batchedEntries)
+	if err != nil {
+		return errors.Wrap(err, "WebHandler.sendSelfTestBatch: failed to marshal batch")
+	}
+	for _, url := range wh.FanOutURLs {
+		if err = wh.postToURL(url, jsonData, wh.batchTimeout(len(batchedEntries))); err != nil {
+			return errors.Wrapf(err, "WebHandler.sendSelfTestBatch: fan-out subscriber %s failed", url)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/deso-protocol/postgres-data-handler/handler/handlertest"
+)
+
+func TestSelfTestBatch(t *testing.T) {
+	batch := SelfTestBatch(7)
+	wantOperations := []lib.StateSyncerOperationType{
+		lib.DbOperationTypeUpsert, lib.DbOperationTypeUpsert, lib.DbOperationTypeDelete,
+	}
+	wantEncoderTypes := []lib.EncoderType{lib.EncoderTypePostEntry, lib.EncoderTypeLikeEntry, lib.EncoderTypeLikeEntry}
+	if len(batch) != len(wantOperations) {
+		t.Fatalf("batch has %d entries, want %d", len(batch), len(wantOperations))
+	}
+	for ii, entry := range batch {
+		if entry.OperationType != wantOperations[ii] || entry.EncoderType != wantEncoderTypes[ii] ||
+			entry.BlockHeight != 7 {
+			t.Errorf("entry %d is operation %d of encoder type %d at height %d, want operation %d of %d at 7", ii,
+				entry.OperationType, entry.EncoderType, entry.BlockHeight, wantOperations[ii], wantEncoderTypes[ii])
+		}
+		if entry.FlushId != batch[0].FlushId {
+			t.Errorf("entry %d has flush %s, want the flush of the batch", ii, entry.FlushId)
+		}
+	}
+	if body := string(batch[0].Encoder.(*lib.PostEntry).Body); body != SelfTestBody {
+		t.Errorf("post body = %s, want %s", body, SelfTestBody)
+	}
+	// The like deletes the upserted like of the post.
+	if !reflect.DeepEqual(batch[1].KeyBytes, batch[2].KeyBytes) {
+		t.Error("expected the delete to have the key of the like")
+	}
+	if likedPostHash := batch[1].Encoder.(*lib.LikeEntry).LikedPostHash; *likedPostHash !=
+		*batch[0].Encoder.(*lib.PostEntry).PostHash {
+		t.Error("expected the like to be of the post")
+	}
+
+	// Every batch gets new keys, so that it doesn't collide with real entries or earlier self-tests.
+	if reflect.DeepEqual(SelfTestBatch(7)[0].KeyBytes, batch[0].KeyBytes) {
+		t.Error("expected two self-test batches to have different keys")
+	}
+}
+
+func TestRunSelfTestWebHandler(t *testing.T) {
+	tests := []struct {
+		name string
+		// configure sets up the handler for the case, given the URL of a healthy and a failing endpoint.
+		configure    func(wh *WebHandler, healthyURL string, failingURL string)
+		wantErr      string
+		wantReceived bool
+	}{
+		{
+			name:         "delivered",
+			configure:    func(wh *WebHandler, healthyURL string, failingURL string) {},
+			wantReceived: true,
+		},
+		{
+			name: "rejected",
+			configure: func(wh *WebHandler, healthyURL string, failingURL string) {
+				wh.EndpointURL = failingURL
+			},
+			wantErr: "401",
+		},
+		{
+			// The batch isn't dropped quietly as it would be by HandleEntryBatch.
+			name: "rejected at most once",
+			configure: func(wh *WebHandler, healthyURL string, failingURL string) {
+				wh.EndpointURL = failingURL
+				wh.DeliverySemantics = DeliveryAtMostOnce
+			},
+			wantErr: "401",
+		},
+		{
+			name: "paused",
+			configure: func(wh *WebHandler, healthyURL string, failingURL string) {
+				wh.PauseMode = PauseModeBuffer
+				wh.Pause()
+			},
+			wantReceived: true,
+		},
+		{
+			name: "below the min block height",
+			configure: func(wh *WebHandler, healthyURL string, failingURL string) {
+				wh.MinBlockHeight = 100
+			},
+			wantReceived: true,
+		},
+		{
+			name: "fan-out",
+			configure: func(wh *WebHandler, healthyURL string, failingURL string) {
+				wh.FanOutURLs = []string{healthyURL}
+			},
+			wantReceived: true,
+		},
+		{
+			name: "failing fan-out subscriber",
+			configure: func(wh *WebHandler, healthyURL string, failingURL string) {
+				wh.FanOutURLs = []string{healthyURL, failingURL}
+			},
+			wantErr:      "fan-out subscriber http",
+			wantReceived: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			healthyServer := newRecordingServer(t)
+			failingServer := newRecordingServer(t)
+			failingServer.statusCode.Store(http.StatusUnauthorized)
+			wh := NewWebHandler(healthyServer.URL, false, "", 0)
+			wh.MaxRetries = 0
+			tt.configure(wh, healthyServer.URL, failingServer.URL)
+
+			result := RunSelfTest(wh, 1)
+			if result.EntryCount != 3 {
+				t.Errorf("EntryCount = %d, want 3", result.EntryCount)
+			}
+			if result.Duration <= 0 {
+				t.Errorf("Duration = %s, want the time taken", result.Duration)
+			}
+			var errText string
+			if result.Err != nil {
+				errText = result.Err.Error()
+			}
+			if (result.Err != nil) != (tt.wantErr != "") || !strings.Contains(errText, tt.wantErr) {
+				t.Errorf("Err = %v, want an error containing %q", result.Err, tt.wantErr)
+			}
+			wantHeights := [][]uint64(nil)
+			if tt.wantReceived {
+				wantHeights = [][]uint64{{1, 1, 1}}
+			}
+			if heights := healthyServer.batchHeights(); !reflect.DeepEqual(heights, wantHeights) {
+				t.Errorf("endpoint received %v, want %v", heights, wantHeights)
+			}
+		})
+	}
+}
+
+func TestRunSelfTestTransaction(t *testing.T) {
+	injectedErr := errors.New("injected")
+	tests := []struct {
+		name        string
+		failMethod  string
+		wantMethods []string
+		wantBatches int
+	}{
+		{
+			name: "committed",
+			wantMethods: []string{handlertest.MethodInitiateTransaction, handlertest.MethodHandleEntryBatch,
+				handlertest.MethodCommitTransaction},
+			wantBatches: 1,
+		},
+		{
+			name:       "batch fails",
+			failMethod: handlertest.MethodHandleEntryBatch,
+			wantMethods: []string{handlertest.MethodInitiateTransaction, handlertest.MethodHandleEntryBatch,
+				handlertest.MethodRollbackTransaction},
+			wantBatches: 1,
+		},
+		{
+			name:       "commit fails",
+			failMethod: handlertest.MethodCommitTransaction,
+			wantMethods: []string{handlertest.MethodInitiateTransaction, handlertest.MethodHandleEntryBatch,
+				handlertest.MethodCommitTransaction, handlertest.MethodRollbackTransaction},
+			wantBatches: 1,
+		},
+		{
+			name:        "initiate fails",
+			failMethod:  handlertest.MethodInitiateTransaction,
+			wantMethods: []string{handlertest.MethodInitiateTransaction},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mh := handlertest.NewMockHandler()
+			if tt.failMethod != "" {
+				mh.SetError(tt.failMethod, injectedErr)
+			}
+
+			result := RunSelfTest(mh, 1)
+			if (tt.failMethod != "") != errors.Is(result.Err, injectedErr) {
+				t.Errorf("Err = %v, want the error injected into %q", result.Err, tt.failMethod)
+			}
+			mh.AssertMethodOrder(t, tt.wantMethods...)
+			mh.AssertBatchCount(t, tt.wantBatches)
+		})
+	}
+}
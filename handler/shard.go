@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"github.com/deso-protocol/core/lib"
+)
+
+// entryShard returns the shard of the entry's key. Keys are hashed with FNV-1a, which is stable across processes
+// and restarts, so every entry is always handled by the same shard.
+func entryShard(entry *lib.StateChangeEntry, shardCount uint32) uint32 {
+	hasher := fnv.New32a()
+	hasher.Write(entry.KeyBytes)
+	return hasher.Sum32() % shardCount
+}
+
+// filterShardEntries returns the entries whose key hashes into ShardIndex. Every entry is kept when ShardCount is
+// zero or one.
+func (wh *WebHandler) filterShardEntries(batchedEntries []*lib.StateChangeEntry) ([]*lib.StateChangeEntry, error) {
+	if wh.ShardCount <= 1 {
+		return batchedEntries, nil
+	}
+	if wh.ShardIndex >= wh.ShardCount {
+		return nil, fmt.Errorf("WebHandler.filterShardEntries: shard index %d is out of range for %d shards",
+			wh.ShardIndex, wh.ShardCount)
+	}
+
+	var shardEntries []*lib.StateChangeEntry
+	for _, entry := range batchedEntries {
+		if entryShard(entry, wh.ShardCount) == wh.ShardIndex {
+			shardEntries = append(shardEntries, entry)
+		}
+	}
+	return shardEntries, nil
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"testing"
+)
+
+func TestFilterShardEntries(t *testing.T) {
+	const entryCount = 2000
+	entries := newTestEntries(entryCount, 1)
+	tests := []struct {
+		name       string
+		shardCount uint32
+	}{
+		{name: "unsharded", shardCount: 0},
+		{name: "one shard", shardCount: 1},
+		{name: "two shards", shardCount: 2},
+		{name: "three shards", shardCount: 3},
+		{name: "eight shards", shardCount: 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shardIndexes := max(tt.shardCount, 1)
+			handledBy := make(map[string]uint32, entryCount)
+			for shardIndex := uint32(0); shardIndex < shardIndexes; shardIndex++ {
+				wh := NewWebHandler("", false, "", 0)
+				wh.ShardCount = tt.shardCount
+				wh.ShardIndex = shardIndex
+				shardEntries, err := wh.filterShardEntries(entries)
+				if err != nil {
+					t.Fatalf("filterShardEntries(%d): %v", shardIndex, err)
+				}
+
+				// The shards are balanced to within a third of their fair share.
+				fairShare := entryCount / int(shardIndexes)
+				if len(shardEntries) < fairShare*2/3 || len(shardEntries) > fairShare*4/3 {
+					t.Errorf("shard %d has %d entries, far from its fair share of %d", shardIndex, len(shardEntries),
+						fairShare)
+				}
+				for _, entry := range shardEntries {
+					if otherIndex, handled := handledBy[string(entry.KeyBytes)]; handled {
+						t.Errorf("entry %s is in shards %d and %d", entry.KeyBytes, otherIndex, shardIndex)
+					}
+					handledBy[string(entry.KeyBytes)] = shardIndex
+				}
+			}
+
+			// Every entry is handled by exactly one shard.
+			if len(handledBy) != entryCount {
+				t.Errorf("shards handle %d entries, want all %d", len(handledBy), entryCount)
+			}
+		})
+	}
+}
+
+func TestEntryShardIsStable(t *testing.T) {
+	// The same key maps to the same shard regardless of the rest of the entry.
+	for ii, entry := range newTestEntries(100, 1) {
+		sameKey := newTestEntries(100, 2)[ii]
+		sameKey.KeyBytes = entry.KeyBytes
+		if entryShard(entry, 8) != entryShard(sameKey, 8) {
+			t.Errorf("entries with key %s are in different shards", entry.KeyBytes)
+		}
+	}
+}
+
+func TestFilterShardEntriesOutOfRange(t *testing.T) {
+	wh := NewWebHandler("", false, "", 0)
+	wh.ShardCount = 4
+	wh.ShardIndex = 4
+	if _, err := wh.filterShardEntries(newTestEntries(1, 1)); err == nil {
+		t.Error("expected a shard index out of range to fail")
+	}
+}
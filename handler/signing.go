@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// SignatureHeader is the header carrying the signature of every HTTP POST when SigningSecret is set. The signature is
+// SignaturePrefix followed by the hex encoded HMAC-SHA256 of the body as sent, i.e. after compression.
+const SignatureHeader = "X-Signature"
+
+// SignaturePrefix names the algorithm of the signature in SignatureHeader.
+const SignaturePrefix = "sha256="
+
+// SignBatch returns the value of SignatureHeader for a request with the given body.
+func SignBatch(body []byte, secret []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return SignaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyBatchSignature returns whether the value of SignatureHeader is the signature of the body with the secret.
+// Receivers must pass the body as received, before decompressing it.
+func VerifyBatchSignature(body []byte, header string, secret []byte) bool {
+	if !strings.HasPrefix(header, SignaturePrefix) {
+		return false
+	}
+	return hmac.Equal([]byte(header), []byte(SignBatch(body, secret)))
+}
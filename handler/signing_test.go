@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSignBatch(t *testing.T) {
+	// The HMAC-SHA256 test case 2 of RFC 4231.
+	signature := SignBatch([]byte("what do ya want for nothing?"), []byte("Jefe"))
+	want := "sha256=5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"
+	if signature != want {
+		t.Errorf("SignBatch = %q, want %q", signature, want)
+	}
+}
+
+func TestVerifyBatchSignature(t *testing.T) {
+	body := []byte(`[{"EncoderType":5,"KeyBytes":"a2V5"}]`)
+	secret := []byte("secret")
+	signature := SignBatch(body, secret)
+	tests := []struct {
+		name   string
+		body   []byte
+		header string
+		secret []byte
+		want   bool
+	}{
+		{name: "valid", body: body, header: signature, secret: secret, want: true},
+		{name: "wrong secret", body: body, header: signature, secret: []byte("other secret")},
+		{name: "tampered body", body: []byte(`[{"EncoderType":5,"KeyBytes":"b3RoZXI="}]`), header: signature,
+			secret: secret},
+		{name: "without the prefix", body: body, header: strings.TrimPrefix(signature, SignaturePrefix),
+			secret: secret},
+		{name: "other algorithm", body: body, header: "sha1=" + strings.TrimPrefix(signature, SignaturePrefix),
+			secret: secret},
+		{name: "uppercase hex", body: body, header: SignaturePrefix + strings.ToUpper(
+			strings.TrimPrefix(signature, SignaturePrefix)), secret: secret},
+		{name: "missing", body: body, secret: secret},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := VerifyBatchSignature(tt.body, tt.header, tt.secret); got != tt.want {
+				t.Errorf("VerifyBatchSignature = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignedPostVerifies(t *testing.T) {
+	tests := []struct {
+		name             string
+		compressPayloads bool
+		signingSecret    []byte
+	}{
+		{name: "unsigned"},
+		{name: "signed", signingSecret: []byte("secret")},
+		// The signature covers the body as sent, so the receiver verifies it before decompressing.
+		{name: "signed and compressed", compressPayloads: true, signingSecret: []byte("secret")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roundTripper := &captureRoundTripper{statusCode: http.StatusOK}
+			wh := NewWebHandler("http://receiver", false, "", 0, WithRoundTripper(roundTripper))
+			wh.CompressPayloads = tt.compressPayloads
+			wh.SigningSecret = tt.signingSecret
+
+			if err := wh.HandleEntryBatch(newTestEntries(3, 5)); err != nil {
+				t.Fatalf("HandleEntryBatch: %v", err)
+			}
+			header := roundTripper.requests[0].Header.Get(SignatureHeader)
+			if len(tt.signingSecret) == 0 {
+				if header != "" {
+					t.Errorf("%s = %q, want none without a SigningSecret", SignatureHeader, header)
+				}
+				return
+			}
+			if !VerifyBatchSignature(roundTripper.bodies[0], header, tt.signingSecret) {
+				t.Errorf("%s = %q doesn't verify against the body as sent", SignatureHeader, header)
+			}
+			if VerifyBatchSignature(roundTripper.bodies[0], header, []byte("other secret")) {
+				t.Errorf("%s = %q verifies with the wrong secret", SignatureHeader, header)
+			}
+		})
+	}
+}
@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/deso-protocol/state-consumer/consumer"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+const (
+	// HandlerTypeTCP is the handler type of TCPHandler.
+	HandlerTypeTCP = "tcp"
+
+	// TCPFrameHeaderSize is the size of the length prefix of every frame.
+	TCPFrameHeaderSize = 4
+	// MaxTCPFramePayloadSize is the largest payload a frame may hold. WriteTCPFrame doesn't write larger frames, and
+	// ReadTCPFrame rejects a length prefix over it before allocating the payload, so that a corrupt or hostile peer
+	// can't make the receiver allocate up to 4GiB.
+	MaxTCPFramePayloadSize = 256 << 20
+
+	// tcpSendAttempts is the number of times a frame is written before the batch fails.
+	tcpSendAttempts = 3
+	// tcpRetryInterval is the wait between write attempts.
+	tcpRetryInterval = time.Second
+	// tcpDialTimeout bounds establishing the connection.
+	tcpDialTimeout = 10 * time.Second
+	// tcpWriteTimeout bounds writing a single frame.
+	tcpWriteTimeout = 30 * time.Second
+)
+
+// TCPHandler is a handler that writes every batch as a frame over a persistent TCP connection, for trusted
+// internal links where HTTP and WebSocket overhead isn't wanted.
+//
+// A frame is a 4 byte big-endian unsigned length, of at most MaxTCPFramePayloadSize, followed by that many bytes of
+// payload. The payload is the batch
+// as a JSON array of entries, as sent by WebHandler without any entry transforms. Frames follow each other on the
+// connection with nothing in between, and the receiver reads them with ReadTCPFrame. Nothing is sent back, so a
+// batch is acknowledged once its frame is written to the connection, and a receiver that fails mid-stream loses the
+// frames that were written but not yet read. The connection is re-established after a failed write.
+type TCPHandler struct {
+	// Addr is the host:port the handler connects to.
+	Addr string
+
+	// Params are the params of the network the handler is consuming. They default to mainnet.
+	Params *lib.DeSoParams
+
+	// MinBlockHeight is the minimum block height required before sending any data.
+	MinBlockHeight uint64
+
+	// conn is the connection to Addr once it is established.
+	conn net.Conn
+
+	// txnErr is the first write error since the current transaction was initiated.
+	txnErr error
+}
+
+var _ DataHandler = (*TCPHandler)(nil)
+
+// ErrTCPFrameTooLarge is wrapped by the error of a frame whose payload is over MaxTCPFramePayloadSize.
+var ErrTCPFrameTooLarge = errors.New("TCP frame is too large")
+
+// NewTCPHandler returns a handler that writes frames to addr.
+func NewTCPHandler(addr string, params *lib.DeSoParams) (*TCPHandler, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, errors.Wrapf(err, "NewTCPHandler: invalid address %s", addr)
+	}
+	return &TCPHandler{
+		Addr:   addr,
+		Params: params,
+	}, nil
+}
+
+// newTCPHandlerFromConfig creates a TCPHandler from the TCP_ADDR config.
+func newTCPHandlerFromConfig(params *lib.DeSoParams, getConfig ConfigGetter) (DataHandler, error) {
+	th, err := NewTCPHandler(getConfig("TCP_ADDR"), params)
+	if err != nil {
+		return nil, err
+	}
+	if th.MinBlockHeight, err = getMinBlockHeightConfig(getConfig); err != nil {
+		return nil, err
+	}
+	return th, nil
+}
+
+// WriteTCPFrame writes the payload to w as a single frame.
+func WriteTCPFrame(w io.Writer, payload []byte) error {
+	if len(payload) > MaxTCPFramePayloadSize {
+		return errors.Wrapf(ErrTCPFrameTooLarge, "WriteTCPFrame: payload of %d bytes is over the %d byte limit",
+			len(payload), MaxTCPFramePayloadSize)
+	}
+	frame := make([]byte, TCPFrameHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(len(payload)))
+	copy(frame[TCPFrameHeaderSize:], payload)
+	if _, err := w.Write(frame); err != nil {
+		return errors.Wrap(err, "WriteTCPFrame: failed to write frame")
+	}
+	return nil
+}
+
+// ReadTCPFrame reads a single frame from r and returns its payload. It returns io.EOF if r ends before the frame
+// starts, io.ErrUnexpectedEOF if r ends within the frame, and an error wrapping ErrTCPFrameTooLarge, without reading
+// the payload, if the frame is over MaxTCPFramePayloadSize. The stream can't be read any further after an error.
+func ReadTCPFrame(r io.Reader) ([]byte, error) {
+	var header [TCPFrameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	payloadSize := binary.BigEndian.Uint32(header[:])
+	if payloadSize > MaxTCPFramePayloadSize {
+		return nil, errors.Wrapf(ErrTCPFrameTooLarge, "ReadTCPFrame: frame of %d bytes is over the %d byte limit",
+			payloadSize, MaxTCPFramePayloadSize)
+	}
+	payload := make([]byte, payloadSize)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return payload, nil
+}
+
+func (th *TCPHandler) CommitTransaction() error {
+	// Every frame is written synchronously, so all that's left is to surface a failed write so the caller rolls
+	// back.
+	err := th.txnErr
+	th.txnErr = nil
+	if err != nil {
+		return errors.Wrap(err, "TCPHandler.CommitTransaction: batch was not delivered")
+	}
+	return nil
+}
+
+func (th *TCPHandler) GetParams() *lib.DeSoParams {
+	// Default to mainnet if no params were configured.
+	if th.Params == nil {
+		return &lib.DeSoMainnetParams
+	}
+	return th.Params
+}
+
+func (th *TCPHandler) HandleSyncEvent(syncEvent consumer.SyncEvent) error {
+	// No sync event handling needed.
+	return nil
+}
+
+func (th *TCPHandler) InitiateTransaction() error {
+	th.txnErr = nil
+	return nil
+}
+
+func (th *TCPHandler) RollbackTransaction() error {
+	// Written frames can't be recalled, so just reset the transaction.
+	th.txnErr = nil
+	return nil
+}
+
+// HandleEntryBatch writes the batch as a single frame.
+// If the block height of the first entry is below MinBlockHeight, the batch is skipped.
+func (th *TCPHandler) HandleEntryBatch(batchedEntries []*lib.StateChangeEntry) error {
+	if len(batchedEntries) == 0 {
+		return fmt.Errorf("TCPHandler.HandleEntryBatch: no entries to send")
+	}
+	if batchedEntries[0].BlockHeight < th.MinBlockHeight {
+		return nil
+	}
+
+	err := th.sendBatch(batchedEntries)
+	if err != nil && th.txnErr == nil {
+		th.txnErr = err
+	}
+	return err
+}
+
+// Close closes the connection, if one is established.
+func (th *TCPHandler) Close() error {
+	if th.conn == nil {
+		return nil
+	}
+	err := th.conn.Close()
+	th.conn = nil
+	return err
+}
+
+// sendBatch writes the batch as a frame, reconnecting and retrying if the write fails.
+func (th *TCPHandler) sendBatch(batchedEntries []*lib.StateChangeEntry) error {
+	payload, err := json.Marshal(batchedEntries)
+	if err != nil {
+		return errors.Wrap(err, "TCPHandler.sendBatch: failed to marshal batch")
+	}
+
+	for attempt := 1; ; attempt++ {
+		err = th.writeFrame(payload)
+		if err == nil || attempt == tcpSendAttempts {
+			return err
+		}
+		glog.Errorf("TCPHandler.sendBatch: write attempt %d failed, reconnecting: %v", attempt, err)
+		time.Sleep(tcpRetryInterval)
+	}
+}
+
+// writeFrame writes the payload as a frame, establishing the connection if needed. The connection is dropped if
+// the write fails, since part of the frame may have been written.
+func (th *TCPHandler) writeFrame(payload []byte) error {
+	if th.conn == nil {
+		conn, err := net.DialTimeout("tcp", th.Addr, tcpDialTimeout)
+		if err != nil {
+			return errors.Wrapf(err, "TCPHandler.writeFrame: failed to connect to %s", th.Addr)
+		}
+		th.conn = conn
+	}
+
+	if err := th.conn.SetWriteDeadline(time.Now().Add(tcpWriteTimeout)); err != nil {
+		th.Close()
+		return errors.Wrap(err, "TCPHandler.writeFrame: failed to set write deadline")
+	}
+	if err := WriteTCPFrame(th.conn, payload); err != nil {
+		th.Close()
+		return errors.Wrapf(err, "TCPHandler.writeFrame: failed to write to %s", th.Addr)
+	}
+	return nil
+}
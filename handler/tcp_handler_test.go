@@ -0,0 +1,202 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestTCPFrameRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		payloads [][]byte
+	}{
+		{name: "single frame", payloads: [][]byte{[]byte(`[{"BlockHeight":1}]`)}},
+		{name: "empty payload", payloads: [][]byte{{}}},
+		{name: "consecutive frames", payloads: [][]byte{[]byte("first"), {}, []byte("third")}},
+		{name: "large frame", payloads: [][]byte{bytes.Repeat([]byte("x"), 1<<20)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stream bytes.Buffer
+			wantSize := 0
+			for _, payload := range tt.payloads {
+				if err := WriteTCPFrame(&stream, payload); err != nil {
+					t.Fatalf("WriteTCPFrame: %v", err)
+				}
+				wantSize += TCPFrameHeaderSize + len(payload)
+			}
+			if stream.Len() != wantSize {
+				t.Errorf("frames are %d bytes, want %d", stream.Len(), wantSize)
+			}
+
+			for ii, wantPayload := range tt.payloads {
+				payload, err := ReadTCPFrame(&stream)
+				if err != nil {
+					t.Fatalf("ReadTCPFrame(%d): %v", ii, err)
+				}
+				if !bytes.Equal(payload, wantPayload) {
+					t.Errorf("frame %d holds %d bytes, want %d", ii, len(payload), len(wantPayload))
+				}
+			}
+			if _, err := ReadTCPFrame(&stream); err != io.EOF {
+				t.Errorf("ReadTCPFrame at the end = %v, want io.EOF", err)
+			}
+		})
+	}
+}
+
+func TestReadTCPFrameTruncated(t *testing.T) {
+	tests := []struct {
+		name    string
+		stream  []byte
+		wantErr error
+	}{
+		{name: "empty", stream: nil, wantErr: io.EOF},
+		{name: "within the header", stream: []byte{0, 0}, wantErr: io.ErrUnexpectedEOF},
+		{name: "header only", stream: []byte{0, 0, 0, 3}, wantErr: io.ErrUnexpectedEOF},
+		{name: "within the payload", stream: []byte{0, 0, 0, 3, 'a', 'b'}, wantErr: io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ReadTCPFrame(bytes.NewReader(tt.stream)); err != tt.wantErr {
+				t.Errorf("ReadTCPFrame = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReadTCPFrameSizeLimit(t *testing.T) {
+	tests := []struct {
+		name        string
+		payloadSize uint32
+		wantErr     error
+	}{
+		// Only the length prefix is sent, so a frame within the limit fails on the missing payload instead.
+		{name: "at the limit", payloadSize: MaxTCPFramePayloadSize, wantErr: io.ErrUnexpectedEOF},
+		{name: "over the limit", payloadSize: MaxTCPFramePayloadSize + 1, wantErr: ErrTCPFrameTooLarge},
+		{name: "largest length prefix", payloadSize: math.MaxUint32, wantErr: ErrTCPFrameTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := binary.BigEndian.AppendUint32(nil, tt.payloadSize)
+			if _, err := ReadTCPFrame(bytes.NewReader(header)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("ReadTCPFrame = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWriteTCPFrameSizeLimit(t *testing.T) {
+	var stream bytes.Buffer
+	err := WriteTCPFrame(&stream, make([]byte, MaxTCPFramePayloadSize+1))
+	if !errors.Is(err, ErrTCPFrameTooLarge) {
+		t.Errorf("WriteTCPFrame = %v, want ErrTCPFrameTooLarge", err)
+	}
+	if stream.Len() != 0 {
+		t.Errorf("WriteTCPFrame wrote %d bytes of a frame over the limit", stream.Len())
+	}
+}
+
+// newTCPStubServer starts a server that reads frames from the first connection and returns its address and the
+// frames it reads.
+func newTCPStubServer(t *testing.T) (string, <-chan []byte) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	frames := make(chan []byte, 10)
+	go func() {
+		defer close(frames)
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			payload, err := ReadTCPFrame(conn)
+			if err != nil {
+				return
+			}
+			frames <- payload
+		}
+	}()
+	return listener.Addr().String(), frames
+}
+
+func TestTCPHandlerStubServer(t *testing.T) {
+	tests := []struct {
+		name           string
+		minBlockHeight uint64
+		batches        [][]uint64
+		wantHeights    [][]uint64
+	}{
+		{name: "every batch", batches: [][]uint64{{1, 1}, {2}, {3, 4, 5}},
+			wantHeights: [][]uint64{{1, 1}, {2}, {3, 4, 5}}},
+		{name: "below the min block height", minBlockHeight: 2, batches: [][]uint64{{1, 2}, {2}, {3}},
+			wantHeights: [][]uint64{{2}, {3}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, frames := newTCPStubServer(t)
+			th, err := NewTCPHandler(addr, nil)
+			if err != nil {
+				t.Fatalf("NewTCPHandler: %v", err)
+			}
+			th.MinBlockHeight = tt.minBlockHeight
+
+			if err = th.InitiateTransaction(); err != nil {
+				t.Fatalf("InitiateTransaction: %v", err)
+			}
+			for _, heights := range tt.batches {
+				if err = th.HandleEntryBatch(newTestHeightBatch(heights...)); err != nil {
+					t.Fatalf("HandleEntryBatch(%v): %v", heights, err)
+				}
+			}
+			if err = th.CommitTransaction(); err != nil {
+				t.Fatalf("CommitTransaction: %v", err)
+			}
+
+			// Every batch arrives as one frame on a single connection, in order.
+			var heights [][]uint64
+			for range tt.wantHeights {
+				select {
+				case payload := <-frames:
+					var entries []struct{ BlockHeight uint64 }
+					if err = json.Unmarshal(payload, &entries); err != nil {
+						t.Fatalf("frame %s isn't a JSON array of entries: %v", payload, err)
+					}
+					var frameHeights []uint64
+					for _, entry := range entries {
+						frameHeights = append(frameHeights, entry.BlockHeight)
+					}
+					heights = append(heights, frameHeights)
+				case <-time.After(5 * time.Second):
+					t.Fatalf("server received %v, want %v", heights, tt.wantHeights)
+				}
+			}
+			if !reflect.DeepEqual(heights, tt.wantHeights) {
+				t.Errorf("server received %v, want %v", heights, tt.wantHeights)
+			}
+
+			// Closing the connection ends the stream at a frame boundary.
+			if err = th.Close(); err != nil {
+				t.Errorf("Close: %v", err)
+			}
+			if payload, open := <-frames; open {
+				t.Errorf("server received an unexpected frame %s", payload)
+			}
+		})
+	}
+}
@@ -0,0 +1,388 @@
+// Package testserver provides a receiver for the batches sent by WebHandler, for end-to-end testing of the send
+// path. It also documents the contract a receiving server is expected to implement: batches are POSTed as a JSON
+// array of state change entries, optionally compressed with gzip or zstd and signed, sent over WebSocket either
+// as a bare array or as a sequenced batch message, or written by TCPHandler as length-prefixed frames.
+package testserver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// BatchPath is the path HTTP batches are POSTed to.
+	BatchPath = "/batch"
+	// StreamPath is the path of the WebSocket endpoint.
+	StreamPath = "/stream"
+
+	TransportHTTP      = "http"
+	TransportWebSocket = "websocket"
+	TransportTCP       = "tcp"
+
+	// DefaultSignatureHeader is the header checked for the signature of HTTP batches when a signing secret is set.
+	DefaultSignatureHeader = "X-Signature"
+)
+
+// requiredEntryFields are the fields every received entry must have. Fields are matched regardless of their
+// casing style, so entries sent with any FieldNameStyle are accepted.
+var requiredEntryFields = []string{"OperationType", "EncoderType", "KeyBytes"}
+
+// Batch is a batch received by the server.
+type Batch struct {
+	Transport string
+	Header    http.Header
+	// Body is the batch as received, after decompression.
+	Body []byte
+	// Seq is the sequence number of WebSocket batch messages, or zero for bare batches.
+	Seq uint64
+	// Entries are the decoded entries of the batch.
+	Entries []map[string]interface{}
+}
+
+// Server is an HTTP and WebSocket receiver that validates and records every batch it receives. Failures can be
+// simulated by queueing status codes with FailNext or by closing WebSocket connections with CloseNextConnection.
+type Server struct {
+	// SigningSecret, when set, requires every HTTP batch to carry "sha256=" followed by the hex encoded HMAC-SHA256
+	// of its body in SignatureHeader.
+	SigningSecret   []byte
+	SignatureHeader string
+
+	httpServer  *httptest.Server
+	tcpListener net.Listener
+
+	mtx               sync.Mutex
+	batches           []Batch
+	validationErrs    []error
+	failStatusCodes   []int
+	closeNextWSConns  int
+	requestsReceived  int
+	websocketUpgrader websocket.Upgrader
+}
+
+// New starts a server listening on a local port. Close it when the test is done.
+func New() *Server {
+	server := &Server{
+		SignatureHeader:   DefaultSignatureHeader,
+		websocketUpgrader: websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(BatchPath, server.serveBatch)
+	mux.HandleFunc(StreamPath, server.serveStream)
+	server.httpServer = httptest.NewServer(mux)
+
+	// Listen for TCP frames on a port of its own, next to the HTTP server.
+	tcpListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("testserver: failed to listen for TCP frames: %v", err))
+	}
+	server.tcpListener = tcpListener
+	go server.acceptTCP()
+	return server
+}
+
+// URL returns the URL to use as a WebHandler's EndpointURL.
+func (server *Server) URL() string {
+	return server.httpServer.URL + BatchPath
+}
+
+// WSURL returns the URL to use as a WebHandler's WSURL.
+func (server *Server) WSURL() string {
+	return "ws" + strings.TrimPrefix(server.httpServer.URL, "http") + StreamPath
+}
+
+// TCPAddr returns the address to use as a TCPHandler's Addr.
+func (server *Server) TCPAddr() string {
+	return server.tcpListener.Addr().String()
+}
+
+// Close shuts the server down, closing any open connections.
+func (server *Server) Close() {
+	server.httpServer.CloseClientConnections()
+	server.httpServer.Close()
+	server.tcpListener.Close()
+}
+
+// FailNext makes the server answer the next count HTTP batches with statusCode instead of recording them.
+func (server *Server) FailNext(statusCode int, count int) {
+	server.mtx.Lock()
+	defer server.mtx.Unlock()
+	for ii := 0; ii < count; ii++ {
+		server.failStatusCodes = append(server.failStatusCodes, statusCode)
+	}
+}
+
+// CloseNextConnection makes the server close the WebSocket connection that receives the next message, without
+// recording the message.
+func (server *Server) CloseNextConnection() {
+	server.mtx.Lock()
+	defer server.mtx.Unlock()
+	server.closeNextWSConns++
+}
+
+// Batches returns every valid batch received, in order.
+func (server *Server) Batches() []Batch {
+	server.mtx.Lock()
+	defer server.mtx.Unlock()
+	return append([]Batch{}, server.batches...)
+}
+
+// Entries returns the entries of every valid batch received, in order.
+func (server *Server) Entries() []map[string]interface{} {
+	var entries []map[string]interface{}
+	for _, batch := range server.Batches() {
+		entries = append(entries, batch.Entries...)
+	}
+	return entries
+}
+
+// ValidationErrors returns the reasons every invalid batch was rejected, in order.
+func (server *Server) ValidationErrors() []error {
+	server.mtx.Lock()
+	defer server.mtx.Unlock()
+	return append([]error{}, server.validationErrs...)
+}
+
+// RequestsReceived returns the number of HTTP batch requests received, including failed and invalid ones.
+func (server *Server) RequestsReceived() int {
+	server.mtx.Lock()
+	defer server.mtx.Unlock()
+	return server.requestsReceived
+}
+
+func (server *Server) serveBatch(w http.ResponseWriter, r *http.Request) {
+	server.mtx.Lock()
+	server.requestsReceived++
+	var failStatusCode int
+	if len(server.failStatusCodes) > 0 {
+		failStatusCode = server.failStatusCodes[0]
+		server.failStatusCodes = server.failStatusCodes[1:]
+	}
+	server.mtx.Unlock()
+
+	if failStatusCode != 0 {
+		w.WriteHeader(failStatusCode)
+		return
+	}
+
+	batch, err := server.readHTTPBatch(r)
+	if err != nil {
+		server.recordValidationError(err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	server.recordBatch(batch)
+	w.WriteHeader(http.StatusOK)
+}
+
+// readHTTPBatch checks the method, signature and encoding of the request and decodes its batch.
+func (server *Server) readHTTPBatch(r *http.Request) (*Batch, error) {
+	if r.Method != http.MethodPost {
+		return nil, fmt.Errorf("Server.readHTTPBatch: expected POST, got %s", r.Method)
+	}
+	if contentType := r.Header.Get("Content-Type"); !strings.HasPrefix(contentType, "application/json") {
+		return nil, fmt.Errorf("Server.readHTTPBatch: expected a JSON content type, got %s", contentType)
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Server.readHTTPBatch: failed to read body: %v", err)
+	}
+	// The signature covers the body as sent, before decompression.
+	if len(server.SigningSecret) > 0 {
+		mac := hmac.New(sha256.New, server.SigningSecret)
+		mac.Write(body)
+		expectedSignature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		if !hmac.Equal([]byte(r.Header.Get(server.SignatureHeader)), []byte(expectedSignature)) {
+			return nil, fmt.Errorf("Server.readHTTPBatch: invalid signature in %s", server.SignatureHeader)
+		}
+	}
+	switch r.Header.Get("Content-Encoding") {
+	case "gzip":
+		gzipReader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("Server.readHTTPBatch: invalid gzip body: %v", err)
+		}
+		if body, err = io.ReadAll(gzipReader); err != nil {
+			return nil, fmt.Errorf("Server.readHTTPBatch: invalid gzip body: %v", err)
+		}
+	case "zstd":
+		zstdDecoder, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, fmt.Errorf("Server.readHTTPBatch: failed to create zstd decoder: %v", err)
+		}
+		defer zstdDecoder.Close()
+		if body, err = zstdDecoder.DecodeAll(body, nil); err != nil {
+			return nil, fmt.Errorf("Server.readHTTPBatch: invalid zstd body: %v", err)
+		}
+	}
+
+	entries, err := decodeEntries(body)
+	if err != nil {
+		return nil, err
+	}
+	return &Batch{Transport: TransportHTTP, Header: r.Header.Clone(), Body: body, Entries: entries}, nil
+}
+
+func (server *Server) serveStream(w http.ResponseWriter, r *http.Request) {
+	conn, err := server.websocketUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		server.recordValidationError(fmt.Errorf("Server.serveStream: failed to upgrade connection: %v", err))
+		return
+	}
+	defer conn.Close()
+
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		server.mtx.Lock()
+		closeConn := server.closeNextWSConns > 0
+		if closeConn {
+			server.closeNextWSConns--
+		}
+		server.mtx.Unlock()
+		if closeConn {
+			return
+		}
+
+		batch, err := decodeWSBatch(data)
+		if err != nil {
+			server.recordValidationError(err)
+			continue
+		}
+		batch.Header = r.Header.Clone()
+		server.recordBatch(batch)
+	}
+}
+
+func (server *Server) acceptTCP() {
+	for {
+		conn, err := server.tcpListener.Accept()
+		if err != nil {
+			return
+		}
+		go server.serveTCP(conn)
+	}
+}
+
+// maxTCPFramePayloadSize is the largest payload of a TCP frame, as limited by handler.MaxTCPFramePayloadSize.
+const maxTCPFramePayloadSize = 256 << 20
+
+// serveTCP reads frames from the connection until it is closed. Every frame is a 4 byte big-endian length followed
+// by a payload of that many bytes, which holds a batch as a JSON array of entries.
+func (server *Server) serveTCP(conn net.Conn) {
+	defer conn.Close()
+
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			return
+		}
+		payloadSize := binary.BigEndian.Uint32(header[:])
+		if payloadSize > maxTCPFramePayloadSize {
+			server.recordValidationError(fmt.Errorf("Server.serveTCP: frame of %d bytes is over the %d byte limit",
+				payloadSize, maxTCPFramePayloadSize))
+			return
+		}
+		payload := make([]byte, payloadSize)
+		if _, err := io.ReadFull(conn, payload); err != nil {
+			server.recordValidationError(fmt.Errorf("Server.serveTCP: connection closed within a frame: %v", err))
+			return
+		}
+
+		entries, err := decodeEntries(payload)
+		if err != nil {
+			server.recordValidationError(err)
+			continue
+		}
+		server.recordBatch(&Batch{Transport: TransportTCP, Body: payload, Entries: entries})
+	}
+}
+
+// decodeWSBatch decodes a WebSocket message, which is either a bare batch or a sequenced batch message.
+func decodeWSBatch(data []byte) (*Batch, error) {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		var message struct {
+			Type    string          `json:"type"`
+			Seq     uint64          `json:"seq"`
+			Entries json.RawMessage `json:"entries"`
+		}
+		if err := json.Unmarshal(data, &message); err != nil {
+			return nil, fmt.Errorf("Server.decodeWSBatch: invalid message: %v", err)
+		}
+		if message.Type != "batch" {
+			return nil, fmt.Errorf("Server.decodeWSBatch: unexpected message type %s", message.Type)
+		}
+		entries, err := decodeEntries(message.Entries)
+		if err != nil {
+			return nil, err
+		}
+		return &Batch{Transport: TransportWebSocket, Body: data, Seq: message.Seq, Entries: entries}, nil
+	}
+
+	entries, err := decodeEntries(data)
+	if err != nil {
+		return nil, err
+	}
+	return &Batch{Transport: TransportWebSocket, Body: data, Entries: entries}, nil
+}
+
+// decodeEntries decodes a batch and checks that it's a non-empty array of entries with the required fields.
+func decodeEntries(data []byte) ([]map[string]interface{}, error) {
+	var entries []map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&entries); err != nil {
+		return nil, fmt.Errorf("decodeEntries: batch is not an array of entries: %v", err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("decodeEntries: batch is empty")
+	}
+
+	for ii, entry := range entries {
+		fieldNames := make(map[string]bool, len(entry))
+		for field := range entry {
+			fieldNames[normalizeFieldName(field)] = true
+		}
+		for _, field := range requiredEntryFields {
+			if !fieldNames[normalizeFieldName(field)] {
+				return nil, fmt.Errorf("decodeEntries: entry %d is missing field %s", ii, field)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// normalizeFieldName makes field names comparable regardless of their casing style.
+func normalizeFieldName(field string) string {
+	return strings.ToLower(strings.ReplaceAll(field, "_", ""))
+}
+
+func (server *Server) recordBatch(batch *Batch) {
+	server.mtx.Lock()
+	defer server.mtx.Unlock()
+	server.batches = append(server.batches, *batch)
+}
+
+func (server *Server) recordValidationError(err error) {
+	server.mtx.Lock()
+	defer server.mtx.Unlock()
+	server.validationErrs = append(server.validationErrs, err)
+}
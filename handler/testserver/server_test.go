@@ -0,0 +1,238 @@
+package testserver_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/deso-protocol/postgres-data-handler/handler"
+	"github.com/deso-protocol/postgres-data-handler/handler/testserver"
+)
+
+// newEntries returns count like entry upserts at the block height.
+func newEntries(count int, blockHeight uint64) []*lib.StateChangeEntry {
+	entries := make([]*lib.StateChangeEntry, count)
+	for ii := range entries {
+		entries[ii] = &lib.StateChangeEntry{
+			OperationType: lib.DbOperationTypeUpsert,
+			KeyBytes:      []byte(fmt.Sprintf("key-%d-%d", blockHeight, ii)),
+			Encoder:       &lib.LikeEntry{LikerPubKey: []byte("liker")},
+			EncoderType:   lib.EncoderTypeLikeEntry,
+			BlockHeight:   blockHeight,
+		}
+	}
+	return entries
+}
+
+func newServer(t *testing.T) *testserver.Server {
+	t.Helper()
+	server := testserver.New()
+	t.Cleanup(server.Close)
+	return server
+}
+
+// waitForBatches waits until the server has recorded count batches.
+func waitForBatches(t *testing.T, server *testserver.Server, count int) []testserver.Batch {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		batches := server.Batches()
+		if len(batches) >= count {
+			return batches
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server recorded %d batches, want %d", len(batches), count)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWebHandlerHTTP(t *testing.T) {
+	tests := []struct {
+		name string
+		// configure sets up the handler and the server for the case.
+		configure        func(wh *handler.WebHandler, server *testserver.Server)
+		wantEncoding     string
+		wantSignatureErr bool
+	}{
+		{name: "plain", configure: func(wh *handler.WebHandler, server *testserver.Server) {}},
+		{name: "gzip", wantEncoding: "gzip", configure: func(wh *handler.WebHandler, server *testserver.Server) {
+			wh.CompressPayloads = true
+		}},
+		{name: "zstd", wantEncoding: "zstd", configure: func(wh *handler.WebHandler, server *testserver.Server) {
+			wh.CompressPayloads = true
+			wh.CompressionAlgorithm = handler.CompressionAlgorithmZstd
+		}},
+		{name: "signed", configure: func(wh *handler.WebHandler, server *testserver.Server) {
+			wh.SigningSecret = []byte("secret")
+			server.SigningSecret = []byte("secret")
+		}},
+		{name: "signed and compressed", wantEncoding: "gzip",
+			configure: func(wh *handler.WebHandler, server *testserver.Server) {
+				wh.CompressPayloads = true
+				wh.SigningSecret = []byte("secret")
+				server.SigningSecret = []byte("secret")
+			}},
+		{name: "wrong signature", wantSignatureErr: true,
+			configure: func(wh *handler.WebHandler, server *testserver.Server) {
+				wh.SigningSecret = []byte("other secret")
+				server.SigningSecret = []byte("secret")
+			}},
+		{name: "unsigned", wantSignatureErr: true, configure: func(wh *handler.WebHandler, server *testserver.Server) {
+			server.SigningSecret = []byte("secret")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newServer(t)
+			wh := handler.NewWebHandler(server.URL(), false, "", 0)
+			wh.MaxDeliveryAttempts = 1
+			tt.configure(wh, server)
+
+			err := wh.HandleEntryBatch(newEntries(3, 5))
+			if tt.wantSignatureErr {
+				if err == nil {
+					t.Error("expected a batch with an invalid signature to fail")
+				}
+				if len(server.ValidationErrors()) != 1 || len(server.Batches()) != 0 {
+					t.Errorf("server recorded %d batches and errors %v, want a single signature error",
+						len(server.Batches()), server.ValidationErrors())
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("HandleEntryBatch: %v", err)
+			}
+			batches := server.Batches()
+			if len(batches) != 1 || len(batches[0].Entries) != 3 {
+				t.Fatalf("server recorded %v, want a batch of 3 entries", batches)
+			}
+			if encoding := batches[0].Header.Get("Content-Encoding"); encoding != tt.wantEncoding {
+				t.Errorf("Content-Encoding = %q, want %q", encoding, tt.wantEncoding)
+			}
+			if errs := server.ValidationErrors(); len(errs) != 0 {
+				t.Errorf("unexpected validation errors %v", errs)
+			}
+		})
+	}
+}
+
+func TestWebHandlerInjectedStatusCodes(t *testing.T) {
+	tests := []struct {
+		name         string
+		statusCode   int
+		failCount    int
+		wantErr      bool
+		wantRequests int
+	}{
+		{name: "retried 503s", statusCode: http.StatusServiceUnavailable, failCount: 2, wantRequests: 3},
+		{name: "retried 429", statusCode: http.StatusTooManyRequests, failCount: 1, wantRequests: 2},
+		{name: "too many 500s", statusCode: http.StatusInternalServerError, failCount: 5, wantErr: true,
+			wantRequests: 3},
+		{name: "400 isn't retried", statusCode: http.StatusBadRequest, failCount: 1, wantErr: true, wantRequests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newServer(t)
+			server.FailNext(tt.statusCode, tt.failCount)
+			wh := handler.NewWebHandler(server.URL(), false, "", 0)
+			wh.MaxDeliveryAttempts = 1
+			wh.MaxRetries = 2
+			wh.BaseBackoff = time.Millisecond
+
+			err := wh.HandleEntryBatch(newEntries(1, 1))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("HandleEntryBatch = %v, wantErr %v", err, tt.wantErr)
+			}
+			if requests := server.RequestsReceived(); requests != tt.wantRequests {
+				t.Errorf("server received %d requests, want %d", requests, tt.wantRequests)
+			}
+			wantBatches := 1
+			if tt.wantErr {
+				wantBatches = 0
+			}
+			if len(server.Batches()) != wantBatches {
+				t.Errorf("server recorded %d batches, want %d", len(server.Batches()), wantBatches)
+			}
+		})
+	}
+}
+
+func TestWebHandlerWebSocket(t *testing.T) {
+	tests := []struct {
+		name             string
+		replayBufferSize int
+		wantSeqs         []uint64
+	}{
+		{name: "bare batches", wantSeqs: []uint64{0, 0, 0}},
+		{name: "sequenced batches", replayBufferSize: 10, wantSeqs: []uint64{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newServer(t)
+			wh := handler.NewWebHandler("", true, server.WSURL(), 0, handler.WithAuthToken("token"))
+			wh.WSReplayBufferSize = tt.replayBufferSize
+
+			for height := uint64(1); height <= 3; height++ {
+				if err := wh.HandleEntryBatch(newEntries(2, height)); err != nil {
+					t.Fatalf("HandleEntryBatch(%d): %v", height, err)
+				}
+			}
+			batches := waitForBatches(t, server, 3)
+			for ii, batch := range batches {
+				if batch.Transport != testserver.TransportWebSocket || batch.Seq != tt.wantSeqs[ii] ||
+					len(batch.Entries) != 2 {
+					t.Errorf("batch %d = %s with seq %d and %d entries, want websocket with seq %d and 2 entries", ii,
+						batch.Transport, batch.Seq, len(batch.Entries), tt.wantSeqs[ii])
+				}
+				if authorization := batch.Header.Get("Authorization"); authorization != "Bearer token" {
+					t.Errorf("batch %d was received on a connection with Authorization %q", ii, authorization)
+				}
+			}
+			if err := wh.Close(); err != nil {
+				t.Errorf("Close: %v", err)
+			}
+		})
+	}
+}
+
+func TestWebHandlerWebSocketDroppedConnection(t *testing.T) {
+	server := newServer(t)
+	wh := handler.NewWebHandler("", true, server.WSURL(), 0)
+	wh.DeliverySemantics = handler.DeliveryAtMostOnce
+
+	// The server drops the connection on the first batch, which is lost under at-most-once delivery.
+	server.CloseNextConnection()
+	if err := wh.HandleEntryBatch(newEntries(1, 1)); err != nil {
+		t.Fatalf("HandleEntryBatch(1): %v", err)
+	}
+
+	// The handler notices the dropped connection on a later write and reconnects for the batches after it.
+	height := uint64(2)
+	for ; len(server.Batches()) == 0; height++ {
+		if height > 50 {
+			t.Fatal("the handler didn't reconnect after the connection was dropped")
+		}
+		if err := wh.HandleEntryBatch(newEntries(1, height)); err != nil {
+			t.Fatalf("HandleEntryBatch(%d): %v", height, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := wh.HandleEntryBatch(newEntries(1, height)); err != nil {
+		t.Fatalf("HandleEntryBatch(%d): %v", height, err)
+	}
+
+	batches := waitForBatches(t, server, 2)
+	if fmt.Sprint(batches[0].Entries[0]["BlockHeight"]) == "1" {
+		t.Error("the batch sent on the dropped connection was recorded")
+	}
+	// Every batch after the reconnection is received.
+	if last := batches[len(batches)-1].Entries[0]["BlockHeight"]; fmt.Sprint(last) != fmt.Sprint(height) {
+		t.Errorf("last batch is at height %v, want %d", last, height)
+	}
+	if err := wh.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/golang/glog"
+)
+
+// DefaultWarmShutdownTimeout is how long Close waits for the in-progress block to complete when no
+// WarmShutdownTimeout is configured.
+const DefaultWarmShutdownTimeout = 30 * time.Second
+
+// warmShutdownState tracks the block being received, so that Close can stop at a block boundary.
+type warmShutdownState struct {
+	mtx sync.Mutex
+	// openHeight is the height of the last entry received. Its block is complete once an entry at a greater
+	// height is received.
+	openHeight uint64
+	blockOpen  bool
+	// closing is set by Close, after which no entries past the open block are sent.
+	closing bool
+	// boundaryReached is closed once the open block is complete after closing is set.
+	boundaryReached chan struct{}
+}
+
+// handleEntryBatchAtBlockBoundary sends the batch, unless Close is waiting for a block boundary, in which case only
+// the entries of the in-progress block are sent. Once an entry of a later block is received, Close is released and
+// the batch fails, so that the consumer doesn't commit the entries that weren't sent.
+func (wh *WebHandler) handleEntryBatchAtBlockBoundary(batchedEntries []*lib.StateChangeEntry) error {
+	batchedEntries, atBoundary := wh.cutAtBlockBoundary(batchedEntries)
+	var err error
+	if len(batchedEntries) > 0 {
+		err = wh.handleEntryBatch(batchedEntries)
+	}
+	if atBoundary {
+		wh.warmShutdown.mtx.Lock()
+		select {
+		case <-wh.warmShutdown.boundaryReached:
+		default:
+			close(wh.warmShutdown.boundaryReached)
+		}
+		openHeight := wh.warmShutdown.openHeight
+		wh.warmShutdown.mtx.Unlock()
+		if err == nil {
+			err = fmt.Errorf("WebHandler.handleEntryBatchAtBlockBoundary: handler is shutting down after block %d",
+				openHeight)
+		}
+	}
+	return err
+}
+
+// cutAtBlockBoundary returns the entries of the batch that belong to the in-progress block, and whether the batch
+// reaches past it. Until Close is called, every entry belongs to the in-progress block.
+func (wh *WebHandler) cutAtBlockBoundary(batchedEntries []*lib.StateChangeEntry) ([]*lib.StateChangeEntry, bool) {
+	wh.warmShutdown.mtx.Lock()
+	defer wh.warmShutdown.mtx.Unlock()
+
+	if !wh.warmShutdown.closing {
+		wh.warmShutdown.openHeight = batchedEntries[len(batchedEntries)-1].BlockHeight
+		wh.warmShutdown.blockOpen = true
+		return batchedEntries, false
+	}
+	if !wh.warmShutdown.blockOpen {
+		return nil, true
+	}
+	for ii, entry := range batchedEntries {
+		if entry.BlockHeight > wh.warmShutdown.openHeight {
+			return batchedEntries[:ii], true
+		}
+	}
+	return batchedEntries, false
+}
+
+// waitForBlockBoundary stops sending entries past the in-progress block and waits until the block is complete or
+// WarmShutdownTimeout elapses. A block is only known to be complete once an entry of a later block is received,
+// so the wait times out if the chain doesn't advance.
+func (wh *WebHandler) waitForBlockBoundary() {
+	wh.warmShutdown.mtx.Lock()
+	if wh.warmShutdown.closing {
+		wh.warmShutdown.mtx.Unlock()
+		return
+	}
+	wh.warmShutdown.closing = true
+	wh.warmShutdown.boundaryReached = make(chan struct{})
+	blockOpen := wh.warmShutdown.blockOpen
+	openHeight := wh.warmShutdown.openHeight
+	boundaryReached := wh.warmShutdown.boundaryReached
+	wh.warmShutdown.mtx.Unlock()
+
+	if !blockOpen {
+		return
+	}
+	timeout := wh.WarmShutdownTimeout
+	if timeout <= 0 {
+		timeout = DefaultWarmShutdownTimeout
+	}
+	select {
+	case <-boundaryReached:
+		glog.Infof("WebHandler.waitForBlockBoundary: stopped after block %d", openHeight)
+	case <-time.After(timeout):
+		glog.Errorf("WebHandler.waitForBlockBoundary: timed out after %v waiting for block %d to complete",
+			timeout, openHeight)
+	}
+}
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWarmShutdown(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		// before are the batches sent before Close, and during the batches sent while Close waits.
+		before [][]uint64
+		during [][]uint64
+		// wantWait is whether Close waits for the batches sent during it.
+		wantWait    bool
+		wantHeights [][]uint64
+	}{
+		{
+			name:        "block completes in a later batch",
+			before:      [][]uint64{{4, 5}},
+			during:      [][]uint64{{5, 5}, {5, 6, 6}},
+			wantWait:    true,
+			wantHeights: [][]uint64{{4, 5}, {5, 5}, {5}},
+		},
+		{
+			name:        "next batch starts a new block",
+			before:      [][]uint64{{5, 5}},
+			during:      [][]uint64{{6}},
+			wantWait:    true,
+			wantHeights: [][]uint64{{5, 5}},
+		},
+		{
+			name: "no block open",
+		},
+		{
+			name:        "chain doesn't advance",
+			timeout:     50 * time.Millisecond,
+			before:      [][]uint64{{5}},
+			wantHeights: [][]uint64{{5}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newRecordingServer(t)
+			wh := NewWebHandler(server.URL, false, "", 0)
+			wh.MaxDeliveryAttempts = 1
+			wh.WarmShutdown = true
+			wh.WarmShutdownTimeout = tt.timeout
+			for _, heights := range tt.before {
+				if err := wh.HandleEntryBatch(newTestHeightBatch(heights...)); err != nil {
+					t.Fatalf("HandleEntryBatch(%v): %v", heights, err)
+				}
+			}
+
+			closed := make(chan error, 1)
+			go func() {
+				closed <- wh.Close()
+			}()
+			if tt.wantWait {
+				select {
+				case err := <-closed:
+					t.Fatalf("Close returned %v mid-block", err)
+				case <-time.After(50 * time.Millisecond):
+				}
+			}
+			for ii, heights := range tt.during {
+				// The batch that reaches past the block fails, so that the consumer doesn't commit the entries that
+				// weren't sent.
+				wantErr := ii == len(tt.during)-1
+				if err := wh.HandleEntryBatch(newTestHeightBatch(heights...)); (err != nil) != wantErr {
+					t.Fatalf("HandleEntryBatch(%v) = %v, wantErr %v", heights, err, wantErr)
+				}
+			}
+			select {
+			case err := <-closed:
+				if err != nil {
+					t.Errorf("Close: %v", err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Close didn't return once the block was complete")
+			}
+
+			// No entry past the block is sent after Close.
+			if err := wh.HandleEntryBatch(newTestHeightBatch(10)); err == nil {
+				t.Error("expected a batch after Close to fail")
+			}
+			if heights := server.batchHeights(); !reflect.DeepEqual(heights, tt.wantHeights) {
+				t.Errorf("server received %v, want %v", heights, tt.wantHeights)
+			}
+		})
+	}
+}
@@ -2,42 +2,421 @@ package handler
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/deso-protocol/core/lib"
 	"github.com/deso-protocol/state-consumer/consumer"
+	"github.com/golang/glog"
 	"github.com/gorilla/websocket"
+	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/pkg/errors"
 )
 
+// DefaultBatchTimeout is the deadline of the HTTP POST of a batch when no PerBatchTimeout is configured.
+const DefaultBatchTimeout = 30 * time.Second
+
+// ErrHTTPTimeout is wrapped by the error of an HTTP POST that didn't complete within its deadline, so that callers
+// can tell a slow or hung endpoint from one that responded with an error, which is an HTTPStatusError.
+var ErrHTTPTimeout = errors.New("HTTP request timed out")
+
+// HTTPStatusError is the error of an HTTP POST that was answered with a status other than 200 OK.
+type HTTPStatusError struct {
+	URL        string
+	StatusCode int
+	// Body is the start of the response body, up to maxErrorBodyBytes, which usually explains the status.
+	Body string
+}
+
+func (err *HTTPStatusError) Error() string {
+	if err.Body == "" {
+		return fmt.Sprintf("unexpected HTTP status code %d from %s", err.StatusCode, err.URL)
+	}
+	return fmt.Sprintf("unexpected HTTP status code %d from %s: %s", err.StatusCode, err.URL, err.Body)
+}
+
+// maxErrorBodyBytes is the most of the response body of a failed HTTP POST that is kept in its HTTPStatusError.
+const maxErrorBodyBytes = 4 << 10
+
+// DefaultWSWriteTimeout is the deadline of a WebSocket write when no WSWriteTimeout is configured.
+const DefaultWSWriteTimeout = 10 * time.Second
+
 // WebHandler is a handler for sending blockchain entries over HTTP or WebSocket.
 type WebHandler struct {
 	// EndpointURL is the URL to which JSON data will be sent via HTTP POST.
 	EndpointURL string
+	// EndpointQueryParams are query params added to EndpointURL for every batch POST, by name. Their values can
+	// hold the placeholders QueryPlaceholderMinHeight, QueryPlaceholderMaxHeight and QueryPlaceholderCount, e.g.
+	// {"from": "{minHeight}", "to": "{maxHeight}"}. When a batch is split into several POSTs, the placeholders
+	// describe the entries of each one, except for splits by MaxRequestBytes or MaxPayloadBytes, which share the
+	// values of the entries they were split from.
+	EndpointQueryParams map[string]string
+	// MaxArrayItems, when non-zero, is the maximum number of entries in the JSON array of a single HTTP POST.
+	// Larger batches are split into several POSTs.
+	MaxArrayItems int
+	// MaxRequestBytes, when non-zero, is the maximum size of the JSON array of a single HTTP POST, before
+	// compression. Larger batches are split on entry boundaries into several POSTs, each a JSON array of its own,
+	// and a single entry that is larger on its own is handled as configured by OversizeEntryPolicy.
+	MaxRequestBytes int
+	// MaxPayloadBytes, when non-zero, splits batches like MaxRequestBytes, with the smaller of the two applying.
+	MaxPayloadBytes int
+	// OversizeEntryPolicy is OversizeEntryPolicyFail (the default), OversizeEntryPolicySendAnyway,
+	// OversizeEntryPolicyDrop or OversizeEntryPolicyTruncateFields.
+	OversizeEntryPolicy string
+	// PerBatchTimeout returns the deadline of the HTTP POST of a batch with the given number of entries, so that
+	// large catch-up batches can be given longer. It defaults to DefaultBatchTimeout for every batch.
+	PerBatchTimeout func(entryCount int) time.Duration
+	// FanOutURLs, when set, are independent subscribers that each receive every batch via HTTP POST, instead of
+	// EndpointURL. Each has its own queue of FanOutQueueSize batches, sent by its own goroutine with its own retries
+	// and dead-letter files, so a slow or failing subscriber doesn't hold up the others. Batches are transformed
+	// once for all subscribers, so they can't be combined with PatchCacheSize.
+	FanOutURLs []string
+	// FanOutQueueSize is the number of batches queued for each fan-out subscriber. It defaults to
+	// DefaultFanOutQueueSize.
+	FanOutQueueSize int
+	// fanOutTargets are the queues and workers of FanOutURLs. They are started on first use.
+	fanOutTargets     []*fanOutTarget
+	fanOutTargetsOnce sync.Once
+
+	// CompressPayloads compresses the payloads of HTTP POSTs with CompressionAlgorithm, and sets their
+	// Content-Encoding header accordingly.
+	CompressPayloads bool
+	// CompressionEnabled compresses the payloads of HTTP POSTs like CompressPayloads, either of them enabling it.
+	CompressionEnabled bool
+	// CompressionAlgorithm is CompressionAlgorithmGzip (the default) or CompressionAlgorithmZstd.
+	CompressionAlgorithm string
+	// CompressionThreshold is the size in bytes a payload must exceed to be compressed when compression is enabled.
+	// Smaller payloads are sent uncompressed, without a Content-Encoding header, since compressing them saves little
+	// and costs CPU. Zero compresses every payload.
+	CompressionThreshold int
+	// MaxRequestsPerSecond, when non-zero, is the most HTTP POSTs sent per second, counting retries and fan-out
+	// POSTs, so that a catching up handler doesn't exceed the rate the endpoint allows. POSTs over the rate wait for
+	// their turn.
+	MaxRequestsPerSecond float64
+	// Burst is the number of HTTP POSTs that may be sent at once before MaxRequestsPerSecond applies. It defaults to
+	// MaxRequestsPerSecond rounded up.
+	Burst int
+	// rateLimiter limits the rate of HTTP POSTs when MaxRequestsPerSecond is set.
+	rateLimiter rateLimiterState
+	// FailureThreshold, when non-zero, is the number of batches in a row that must fail to reach EndpointURL for the
+	// circuit breaker to open and fail the next batches without sending them. See pushBatchThroughCircuitBreaker.
+	FailureThreshold int
+	// OpenDuration is how long the circuit breaker stays open before a batch is sent to probe the endpoint. It
+	// defaults to DefaultCircuitOpenDuration.
+	OpenDuration time.Duration
+	// circuitBreaker tracks the failures of batches sent to EndpointURL when FailureThreshold is set.
+	circuitBreaker circuitBreakerState
+	// AuthToken, when set, is sent as a Bearer token in the Authorization header of every HTTP POST and of the dial of
+	// the WSURL connection.
+	AuthToken string
+	// SigningSecret, when set, is the key of the HMAC-SHA256 signature sent in SignatureHeader with every HTTP POST, so
+	// that receivers can check with VerifyBatchSignature that a batch came from the handler unaltered.
+	SigningSecret []byte
+	// MaxRetries is the number of times an HTTP POST to EndpointURL that fails with a transient error, i.e. a timeout,
+	// a connection error or a 5xx or 429 status, is sent again before the batch fails. Other errors are not retried.
+	MaxRetries int
+	// BaseBackoff is the wait before the first retry of an HTTP POST, which doubles after every retry and is jittered.
+	// It defaults to DefaultBaseBackoff.
+	BaseBackoff time.Duration
+	// sendCtx is the context of HTTP POSTs and of the waits between retries, set with WithContext or SetContext.
+	sendCtx atomic.Pointer[context.Context]
+	// httpClient is the client used for HTTP POSTs. Its transport can be overridden with WithRoundTripper.
+	httpClient *http.Client
 
 	// UseWebSocket determines whether data should be sent via WebSocket.
 	UseWebSocket bool
 	// WSURL is the URL used for the WebSocket connection.
 	WSURL string
 
-	// wsConn holds the WebSocket connection once it is established.
-	wsConn *websocket.Conn
+	// wsPeer holds the connection to WSURL once it is established.
+	wsPeer *wsSubscriber
+	// wsPending is the last batch that was sequenced and broadcast but couldn't be written to WSURL. When the batch
+	// is attempted again, only this message is written to WSURL, so that subscribers don't receive the batch twice
+	// under different sequence numbers.
+	wsPending *wsPendingBatch
+	// wsPeerMtx guards wsPeer and wsPending, and serializes the batches sent over WebSocket. It is acquired before
+	// wsStreamMtx, and WSURL is dialed while only it is held, so that clients can subscribe meanwhile.
+	wsPeerMtx sync.Mutex
+	// WSWriteTimeout is the deadline of every WebSocket write, so that a peer that stops reading fails the write
+	// rather than blocking it forever. A write that times out drops the connection, and the next batch reconnects.
+	// It defaults to DefaultWSWriteTimeout.
+	WSWriteTimeout time.Duration
+	// WSMaxReconnectAttempts, when set along with both WSURL and EndpointURL, makes WebSocket the primary transport
+	// and EndpointURL its fallback: after that many batches in a row fail over WebSocket, batches are sent over HTTP
+	// until WebSocket is restored. See sendBatchWithFailover.
+	WSMaxReconnectAttempts int
+	// WSRestoreInterval is how often WebSocket is tried again while failed over to HTTP. It defaults to
+	// DefaultWSRestoreInterval.
+	WSRestoreInterval time.Duration
+	// wsFailover tracks the WebSocket failures when WSMaxReconnectAttempts is set.
+	wsFailover wsFailoverState
+
+	// WSReplayBufferSize is the number of most recent WebSocket batches kept for replay. When non-zero, batches
+	// are sent as sequenced WSBatchMessage envelopes and clients can resume from a sequence number.
+	WSReplayBufferSize int
+	// replayBuffer holds the most recent WebSocket batches. It is created on first use.
+	replayBuffer *replayBuffer
+	// wsSubscribers are the clients connected to the handler's WebSocket server.
+	wsSubscribers map[*wsSubscriber]struct{}
+	// wsStreamMtx guards replayBuffer and wsSubscribers, so that batches are sequenced, buffered and broadcast in
+	// the order they are sent.
+	wsStreamMtx sync.Mutex
+	// wsClosed is set by Close, after which batches are no longer sent over WebSocket.
+	wsClosed atomic.Bool
 
 	// MinBlockHeight is the minimum block height required before sending any data.
 	MinBlockHeight uint64
+	// Middleware is run, in order, around every attempt to send a batch. See SendMiddleware.
+	Middleware []SendMiddleware
+	// BackfillFromHeight, when non-zero, is the height from which entries are sent. Combined with a consumer
+	// progress directory from BackfillProgressDir, the handler replays the state changes from this height and then
+	// continues with live state changes.
+	BackfillFromHeight uint64
+	// backfillStarted is set once the first entry at or above BackfillFromHeight is sent.
+	backfillStarted atomic.Bool
+
+	// ShardCount, when greater than one, splits the stream into that many shards by the hash of each entry's key,
+	// and only the entries of shard ShardIndex are forwarded. Running ShardCount processes, one per shard index,
+	// partitions the stream between them.
+	ShardCount uint32
+	// ShardIndex is the shard forwarded by this handler, from 0 to ShardCount-1.
+	ShardIndex uint32
+
+	// Params are the params of the network the handler is consuming. They default to mainnet.
+	Params *lib.DeSoParams
+	// NetworkPrefix overrides the network name that prefixes keys, topics and stream names written to external
+	// systems. When empty, the name of the network from Params is used.
+	NetworkPrefix string
+
+	// RedactFields lists entry and extra data keys whose values are replaced with RedactedPlaceholder before
+	// sending. Unlike removing the keys, this keeps the shape of the payload stable for downstream schemas.
+	RedactFields []string
+	// PassthroughFields lists entry and extra data byte fields that already hold encoded or compressed text,
+	// such as base64 blobs. They are sent as the text they hold instead of being base64 encoded again.
+	PassthroughFields []string
+	// ProjectFields, when set, lists the only fields sent for each entry, dropping every other field. Nested fields
+	// are given as dot separated paths, such as Encoder.PostHash. Fields are named as emitted by the core encoders,
+	// before FieldNameStyle is applied.
+	ProjectFields []string
+	// PatchCacheSize is the number of keys whose last sent state is remembered. When non-zero, upserts of a
+	// remembered key are sent as an RFC 6902 JSON Patch under EncoderPatch instead of the full entry. Receivers
+	// must have seen the full entry of a key to apply its patches.
+	PatchCacheSize int
+	// patchCache holds the last sent state of the most recently sent keys. It is created on first use.
+	patchCache *lru.Cache[string, map[string]interface{}]
+
+	// ValidationSchema, when set, validates every entry, after the field transforms, before it is sent.
+	ValidationSchema *JSONSchema
+	// ValidationPolicy is what happens to entries that don't conform to ValidationSchema: ValidationPolicyFail
+	// (the default) fails the batch, and ValidationPolicyDrop logs and drops them.
+	ValidationPolicy string
+
+	// EnumFormat is how the OperationType and EncoderType of entries are sent: EnumFormatNumeric (the default),
+	// EnumFormatName or EnumFormatBoth.
+	EnumFormat string
+
+	// WrapWithOpMetadata sends every entry, after the other transforms, in an envelope of the form
+	// {"op": "insert", "seq": 1, "processed_at": "2006-01-02T15:04:05Z", "entry": {...}}. The op is insert, update
+	// or delete, seq increases with every entry sent, and processed_at is when the batch was marshaled. A batch that
+	// is sent again, e.g. on retry, gets new sequence numbers, so they can have gaps.
+	WrapWithOpMetadata bool
+	// SeqStatePath is the file the last sequence number is persisted to under WrapWithOpMetadata, so that sequence
+	// numbers keep increasing across restarts. Without it, they restart at 1.
+	SeqStatePath string
+	// opSeq assigns the sequence numbers of WrapWithOpMetadata.
+	opSeq opSequence
+
+	// FieldNameStyle is the casing of the field names in sent entries: FieldNameStylePascal (the default, as
+	// emitted by the core encoders), FieldNameStyleSnake or FieldNameStyleCamel.
+	FieldNameStyle string
+
+	// DeliverySemantics is DeliveryAtMostOnce or DeliveryAtLeastOnce (the default). See their docs for the
+	// guarantees of each.
+	DeliverySemantics string
+	// MaxDeliveryAttempts is the number of times a batch is sent under DeliveryAtLeastOnce before it is
+	// dead-lettered or fails. It defaults to DefaultMaxDeliveryAttempts.
+	MaxDeliveryAttempts int
+	// DeadLetterDir, when set, is where batches that exhaust their delivery attempts under DeliveryAtLeastOnce
+	// are written, along with their DeadLetterMetadata, so that the consumer can move on without losing them. They
+	// can be sent again with ReplayDeadLetter.
+	DeadLetterDir string
+	// RetryQueueSize, when non-zero, is the number of batches that fail delivery which are held in memory and retried
+	// in order in the background, so that the consumer can move on while the endpoint is briefly down. See
+	// deliverOrQueueBatch.
+	RetryQueueSize int
+	// DropOnFull fails batches that don't fit in the retry queue instead of waiting for room.
+	DropOnFull bool
+	// retryQueue holds the batches retried in the background when RetryQueueSize is set.
+	retryQueue retryQueueState
+
+	// BatchTTL, when non-zero, is how long a batch can wait in the pause buffer, a fan-out queue or the retry queue.
+	// Older batches are dropped with a logged count instead of sent late, so that a real-time receiver recovering from
+	// an outage doesn't process stale data.
+	BatchTTL time.Duration
+
+	// MaxMemoryBytes, when non-zero, is the approximate memory budget shared by the paused batches, the coalesced
+	// updates and the WebSocket replay buffer. Once it is reached, memory is shed as configured by
+	// MemoryShedPolicy, except for the replay buffer, which always evicts its oldest batches.
+	MaxMemoryBytes int64
+	// MemoryShedPolicy is MemoryPolicyBlock (the default), MemoryPolicyDropOldest or MemoryPolicySpill.
+	MemoryShedPolicy string
+	// SpillDir is where paused batches are spilled to under MemoryPolicySpill.
+	SpillDir string
+
+	// WarmShutdown makes Close stop at a block boundary: it waits up to WarmShutdownTimeout for the entries of the
+	// in-progress block to be sent, and entries of later blocks are not sent.
+	WarmShutdown bool
+	// WarmShutdownTimeout defaults to DefaultWarmShutdownTimeout.
+	WarmShutdownTimeout time.Duration
+	// warmShutdown tracks the in-progress block.
+	warmShutdown warmShutdownState
+
+	// CoalesceWindow, when non-zero, coalesces rapid updates of the same key: only the latest update of each key is
+	// held, and the held updates are sent once per window, dropping intermediate states. Deletes are sent
+	// immediately and cancel the held update of their key. Held updates are acknowledged to the consumer before
+	// they are sent, so updates held when the process stops are lost.
+	CoalesceWindow time.Duration
+	// coalesce holds the latest update of each key until the next flush.
+	coalesce coalesceState
+
+	// PauseMode is what HandleEntryBatch does while the handler is paused: PauseModeBlock (the default) or
+	// PauseModeBuffer.
+	PauseMode string
+	// PauseBufferSize is the number of batches held while paused under PauseModeBuffer. It defaults to
+	// DefaultPauseBufferSize.
+	PauseBufferSize int
+	// pause holds whether the handler is paused by the admin endpoints and the batches buffered while it is.
+	pause pauseState
+
+	// HeartbeatInterval is how often RunHeartbeat sends a heartbeat. Heartbeats are disabled when it is zero.
+	HeartbeatInterval time.Duration
+	// HeartbeatAlways sends a heartbeat every interval, even while batches are being sent. By default, heartbeats
+	// are only sent when no batch was sent during the last interval.
+	HeartbeatAlways bool
+	// lastSentAt is the time, in unix nanoseconds, the last batch was sent.
+	lastSentAt atomic.Int64
+
+	// metrics holds the counters exposed by the metrics server and the admin /status endpoint.
+	metrics webHandlerMetrics
+}
+
+var _ DataHandler = (*WebHandler)(nil)
+
+// WebHandlerOption configures optional behavior of a WebHandler.
+type WebHandlerOption func(wh *WebHandler)
+
+// WithRoundTripper overrides the transport used for HTTP POSTs, e.g. to capture requests in tests or to wrap
+// the default transport with tracing or metrics.
+func WithRoundTripper(roundTripper http.RoundTripper) WebHandlerOption {
+	return func(wh *WebHandler) {
+		wh.httpClient.Transport = roundTripper
+	}
+}
+
+// WithHTTPTimeout sets the deadline of every HTTP POST, from establishing the connection to reading the response,
+// regardless of the size of the batch. It overrides DefaultBatchTimeout, and is itself overridden by a PerBatchTimeout
+// set later.
+func WithHTTPTimeout(timeout time.Duration) WebHandlerOption {
+	return func(wh *WebHandler) {
+		wh.PerBatchTimeout = func(int) time.Duration { return timeout }
+	}
+}
+
+// WithAuthToken sets the AuthToken of the handler.
+func WithAuthToken(token string) WebHandlerOption {
+	return func(wh *WebHandler) {
+		wh.AuthToken = token
+	}
+}
+
+// WithContext sets the context of the handler's sends. See SetContext.
+func WithContext(ctx context.Context) WebHandlerOption {
+	return func(wh *WebHandler) {
+		wh.SetContext(ctx)
+	}
+}
+
+// TransportTimeouts are the timeouts of the stages of an HTTP POST, so that connection establishment can be tuned
+// separately from the whole round trip, which is bounded by PerBatchTimeout. Zero values keep the defaults of
+// http.DefaultTransport.
+type TransportTimeouts struct {
+	// DialTimeout bounds establishing the TCP connection.
+	DialTimeout time.Duration
+	// TLSHandshakeTimeout bounds the TLS handshake.
+	TLSHandshakeTimeout time.Duration
+	// ResponseHeaderTimeout bounds the wait for the response headers once the request has been written.
+	ResponseHeaderTimeout time.Duration
+}
+
+// WithTransportTimeouts sets the timeouts of the transport used for HTTP POSTs. It replaces any transport set by an
+// earlier WithRoundTripper.
+func WithTransportTimeouts(timeouts TransportTimeouts) WebHandlerOption {
+	return func(wh *WebHandler) {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		if timeouts.DialTimeout > 0 {
+			dialer := &net.Dialer{Timeout: timeouts.DialTimeout, KeepAlive: 30 * time.Second}
+			transport.DialContext = dialer.DialContext
+		}
+		if timeouts.TLSHandshakeTimeout > 0 {
+			transport.TLSHandshakeTimeout = timeouts.TLSHandshakeTimeout
+		}
+		if timeouts.ResponseHeaderTimeout > 0 {
+			transport.ResponseHeaderTimeout = timeouts.ResponseHeaderTimeout
+		}
+		wh.httpClient.Transport = transport
+	}
 }
 
 // NewWebHandler returns a new instance of WebHandler.
 // The minBlockHeight parameter specifies the minimum block height from which data should be sent.
-func NewWebHandler(endpointURL string, useWebSocket bool, wsURL string, minBlockHeight uint64) *WebHandler {
-	return &WebHandler{
+func NewWebHandler(endpointURL string, useWebSocket bool, wsURL string, minBlockHeight uint64, opts ...WebHandlerOption) *WebHandler {
+	wh := &WebHandler{
 		EndpointURL:    endpointURL,
+		httpClient:     &http.Client{},
 		UseWebSocket:   useWebSocket,
 		WSURL:          wsURL,
 		MinBlockHeight: minBlockHeight,
 	}
+	for _, opt := range opts {
+		opt(wh)
+	}
+	return wh
+}
+
+// SetContext sets the context of the handler's sends, since the consumer doesn't pass one to HandleEntryBatch.
+// Cancelling it aborts the HTTP POSTs in flight and the waits between retries, which then fail with an error wrapping
+// context.Canceled, and fails every later send the same way. It defaults to context.Background().
+func (wh *WebHandler) SetContext(ctx context.Context) {
+	wh.sendCtx.Store(&ctx)
+}
+
+// context returns the context of the handler's sends.
+func (wh *WebHandler) context() context.Context {
+	if ctx := wh.sendCtx.Load(); ctx != nil {
+		return *ctx
+	}
+	return context.Background()
+}
+
+// sleep waits for the duration, returning early with an error wrapping the context's error if the context of the
+// handler's sends is done first.
+func (wh *WebHandler) sleep(duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-wh.context().Done():
+		return errors.Wrap(wh.context().Err(), "WebHandler.sleep: send context is done")
+	}
 }
 
 // No-op implementations for database/transaction related methods
@@ -48,8 +427,11 @@ func (wh *WebHandler) CommitTransaction() error {
 }
 
 func (wh *WebHandler) GetParams() *lib.DeSoParams {
-	// Return default parameters (or nil) if not used.
-	return &lib.DeSoMainnetParams
+	// Default to mainnet if no params were configured.
+	if wh.Params == nil {
+		return &lib.DeSoMainnetParams
+	}
+	return wh.Params
 }
 
 func (wh *WebHandler) HandleSyncEvent(syncEvent consumer.SyncEvent) error {
@@ -73,65 +455,351 @@ func (wh *WebHandler) HandleEntryBatch(batchedEntries []*lib.StateChangeEntry) e
 	if len(batchedEntries) == 0 {
 		return fmt.Errorf("WebHandler.HandleEntryBatch: no entries to send")
 	}
+	if wh.WarmShutdown {
+		return wh.handleEntryBatchAtBlockBoundary(batchedEntries)
+	}
+	return wh.handleEntryBatch(batchedEntries)
+}
+
+// handleEntryBatch filters the batch and sends what is left of it.
+func (wh *WebHandler) handleEntryBatch(batchedEntries []*lib.StateChangeEntry) error {
+
+	// Drop the entries below the backfill height.
+	batchedEntries = wh.filterBackfillEntries(batchedEntries)
+	if len(batchedEntries) == 0 {
+		wh.metrics.batchesSkipped.Add(1)
+		return nil
+	}
 
 	// Check block height: if the first entry is below the minimum threshold, skip sending.
 	if batchedEntries[0].BlockHeight < wh.MinBlockHeight {
+		wh.metrics.batchesSkipped.Add(1)
+		return nil
+	}
+
+	// Only forward the entries of this process's shard.
+	batchedEntries, err := wh.filterShardEntries(batchedEntries)
+	if err != nil {
+		return errors.Wrap(err, "WebHandler.HandleEntryBatch: failed to filter shard entries")
+	}
+	if len(batchedEntries) == 0 {
+		wh.metrics.batchesSkipped.Add(1)
+		return nil
+	}
+
+	// Hold updates until the next flush of the coalesce window, and only send deletes now.
+	if wh.CoalesceWindow > 0 {
+		batchedEntries = wh.coalesceEntries(batchedEntries)
+		if len(batchedEntries) == 0 {
+			return nil
+		}
+	}
+
+	return wh.forwardBatch(batchedEntries)
+}
+
+// forwardBatch delivers the batch, or queues it for retry if RetryQueueSize is set, unless sending is paused, in
+// which case the batch is held as configured by PauseMode.
+func (wh *WebHandler) forwardBatch(batchedEntries []*lib.StateChangeEntry) error {
+	if wh.holdWhilePaused(batchedEntries) {
 		return nil
 	}
+	if wh.RetryQueueSize > 0 {
+		return wh.deliverOrQueueBatch(batchedEntries)
+	}
+	return wh.deliverBatch(batchedEntries)
+}
+
+// sendBatch sends the batch over the configured transport. The batchID identifies the batch across its delivery
+// attempts, for the WebSocket transport. It is nil for a batch that is only attempted once.
+func (wh *WebHandler) sendBatch(batchID *lib.StateChangeEntry, batchedEntries []*lib.StateChangeEntry) error {
+	// Queue the batch for every fan-out target if any are configured.
+	if len(wh.FanOutURLs) > 0 {
+		return wh.fanOutBatch(batchedEntries)
+	}
+
+	// Prefer WebSocket, failing over to HTTP, if both are configured for failover.
+	if wh.wsFailoverEnabled() {
+		return wh.sendBatchWithFailover(batchID, batchedEntries)
+	}
 
 	// Send via HTTP if an endpoint URL is configured.
 	if wh.EndpointURL != "" {
+		if wh.FailureThreshold > 0 {
+			return wh.pushBatchThroughCircuitBreaker(batchedEntries)
+		}
 		return wh.pushBatchToEndpoint(batchedEntries)
 	}
 
 	// Otherwise, if WebSocket mode is enabled, send via WebSocket.
 	if wh.UseWebSocket {
-		return wh.sendBatchOverWebSocket(batchedEntries)
+		return wh.sendBatchOverWebSocket(batchID, batchedEntries)
 	}
 
-	return fmt.Errorf("WebHandler.HandleEntryBatch: no endpoint configured")
+	return fmt.Errorf("WebHandler.sendBatch: no endpoint configured")
 }
 
-// pushBatchToEndpoint marshals the batch of entries to JSON and sends them via an HTTP POST.
+// pushBatchToEndpoint marshals the batch of entries to JSON and sends them via an HTTP POST. If MaxArrayItems is
+// set, a larger batch is split into several POSTs of at most MaxArrayItems entries, sent in order. Sending stops at
+// the first chunk that fails, so the chunks before it may have been received when an error is returned.
 func (wh *WebHandler) pushBatchToEndpoint(batchedEntries []*lib.StateChangeEntry) error {
-	jsonData, err := json.Marshal(batchedEntries)
+	if wh.MaxArrayItems > 0 && len(batchedEntries) > wh.MaxArrayItems {
+		for start := 0; start < len(batchedEntries); start += wh.MaxArrayItems {
+			end := min(start+wh.MaxArrayItems, len(batchedEntries))
+			if err := wh.pushChunkToEndpoint(batchedEntries[start:end]); err != nil {
+				return errors.Wrapf(err, "WebHandler.pushBatchToEndpoint: failed to send entries %d to %d", start, end)
+			}
+		}
+		return nil
+	}
+	return wh.pushChunkToEndpoint(batchedEntries)
+}
+
+// pushChunkToEndpoint marshals the entries to JSON and sends them via a single HTTP POST, or several if they exceed
+// MaxRequestBytes or MaxPayloadBytes.
+func (wh *WebHandler) pushChunkToEndpoint(batchedEntries []*lib.StateChangeEntry) error {
+	jsonData, err := wh.marshalBatch(batchedEntries)
+	if err != nil {
+		return errors.Wrap(err, "WebHandler.pushChunkToEndpoint: failed to marshal batch")
+	}
+	requests, err := wh.splitRequest(jsonData)
 	if err != nil {
-		return errors.Wrap(err, "WebHandler.pushBatchToEndpoint: failed to marshal batch")
+		return errors.Wrap(err, "WebHandler.pushChunkToEndpoint: failed to split batch")
 	}
+	endpointURL, err := wh.batchEndpointURL(batchedEntries)
+	if err != nil {
+		return err
+	}
+
+	for ii, request := range requests {
+		if err = wh.postToURLWithRetries(endpointURL, request, wh.batchTimeout(len(batchedEntries))); err != nil {
+			if len(requests) == 1 {
+				return err
+			}
+			return errors.Wrapf(err, "WebHandler.pushChunkToEndpoint: failed to send request %d of %d", ii+1, len(requests))
+		}
+	}
+	return nil
+}
 
-	resp, err := http.Post(wh.EndpointURL, "application/json", bytes.NewBuffer(jsonData))
+// postToEndpoint sends the JSON payload to EndpointURL via an HTTP POST that is cancelled after timeout.
+func (wh *WebHandler) postToEndpoint(jsonData []byte, timeout time.Duration) error {
+	return wh.postToURL(wh.EndpointURL, jsonData, timeout)
+}
+
+// postToURL sends the JSON payload to url via an HTTP POST that is cancelled after timeout. If MaxRequestsPerSecond
+// is set, it first waits for the rate limit, which doesn't count towards the timeout.
+func (wh *WebHandler) postToURL(url string, jsonData []byte, timeout time.Duration) error {
+	if err := wh.waitForRateLimit(); err != nil {
+		return errors.Wrapf(err, "WebHandler.postToURL: HTTP POST to %s was not sent", url)
+	}
+
+	payload, contentEncoding, err := wh.compressPayload(jsonData)
+	if err != nil {
+		return errors.Wrap(err, "WebHandler.postToURL: failed to compress payload")
+	}
+
+	ctx, cancel := context.WithTimeout(wh.context(), timeout)
+	defer cancel()
+	ctx = httptrace.WithClientTrace(ctx, wh.connectionTrace())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(payload))
+	if err != nil {
+		return errors.Wrap(err, "WebHandler.postToURL: failed to create request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if contentEncoding != "" {
+		req.Header.Set("Content-Encoding", contentEncoding)
+	}
+	if wh.AuthToken != "" {
+		req.Header.Set("Authorization", wh.authorizationHeader())
+	}
+	if len(wh.SigningSecret) > 0 {
+		req.Header.Set(SignatureHeader, SignBatch(payload, wh.SigningSecret))
+	}
+
+	resp, err := wh.getHTTPClient().Do(req)
 	if err != nil {
-		return errors.Wrapf(err, "WebHandler.pushBatchToEndpoint: failed to send HTTP POST to %s", wh.EndpointURL)
+		if errors.Is(err, context.Canceled) {
+			return errors.Wrapf(err, "WebHandler.postToURL: HTTP POST to %s was cancelled", url)
+		}
+		if isTimeoutError(err) {
+			return errors.Wrapf(ErrHTTPTimeout, "WebHandler.postToURL: HTTP POST to %s did not complete within %s: %v",
+				url, timeout, err)
+		}
+		return errors.Wrapf(err, "WebHandler.postToURL: failed to send HTTP POST to %s", url)
 	}
-	defer resp.Body.Close()
+	defer drainAndClose(resp.Body)
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("WebHandler.pushBatchToEndpoint: unexpected HTTP status code %d", resp.StatusCode)
+		// The rest of the body is drained when it is closed.
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+		return errors.Wrap(&HTTPStatusError{
+			URL:        url,
+			StatusCode: resp.StatusCode,
+			Body:       strings.TrimSpace(string(body)),
+		}, "WebHandler.postToURL")
 	}
 
 	return nil
 }
 
+// wsPendingBatch is a batch message that was sequenced and broadcast, but not yet written to WSURL.
+type wsPendingBatch struct {
+	batchID *lib.StateChangeEntry
+	message []byte
+}
+
 // sendBatchOverWebSocket marshals the batch of entries to JSON and sends it over WebSocket.
-func (wh *WebHandler) sendBatchOverWebSocket(batchedEntries []*lib.StateChangeEntry) error {
-	// Establish a WebSocket connection if needed.
-	if wh.wsConn == nil {
-		var err error
-		wh.wsConn, _, err = websocket.DefaultDialer.Dial(wh.WSURL, nil)
+// The batch is sent to the configured WSURL as well as to every client connected to the handler's WebSocket server.
+//
+// A batch is sequenced, buffered for replay and broadcast to the clients once, on its first attempt, even if it
+// can't be written to WSURL. Its later attempts, which have the same batchID, only write it to WSURL again, with the
+// same sequence number. A batch that is given up on, and is followed by another one, is never written to WSURL.
+func (wh *WebHandler) sendBatchOverWebSocket(batchID *lib.StateChangeEntry, batchedEntries []*lib.StateChangeEntry) error {
+	if wh.wsClosed.Load() {
+		wh.metrics.batchesDroppedOnShutdown.Add(1)
+		return fmt.Errorf("WebHandler.sendBatchOverWebSocket: handler is closed")
+	}
+
+	wh.wsPeerMtx.Lock()
+	defer wh.wsPeerMtx.Unlock()
+
+	// Establish a WebSocket connection if needed, before locking the stream, as dialing can take a while.
+	var dialErr error
+	if wh.WSURL != "" && wh.wsPeer == nil {
+		dialErr = wh.dialWSPeer()
+	}
+
+	wh.wsStreamMtx.Lock()
+	defer wh.wsStreamMtx.Unlock()
+
+	// Close may have finished while waiting for the lock, in which case the connections are already closed.
+	if wh.wsClosed.Load() {
+		wh.metrics.batchesDroppedOnShutdown.Add(1)
+		return fmt.Errorf("WebHandler.sendBatchOverWebSocket: handler is closed")
+	}
+
+	pending := wh.wsPending
+	if pending == nil || batchID == nil || pending.batchID != batchID {
+		if pending != nil {
+			glog.Errorf("WebHandler.sendBatchOverWebSocket: gave up on writing a batch of height %d to %s",
+				pending.batchID.BlockHeight, wh.WSURL)
+			wh.wsPending = nil
+		}
+
+		jsonData, err := wh.marshalBatch(batchedEntries)
 		if err != nil {
-			return errors.Wrapf(err, "WebHandler.sendBatchOverWebSocket: failed to establish connection to %s", wh.WSURL)
+			return errors.Wrap(err, "WebHandler.sendBatchOverWebSocket: failed to marshal batch")
 		}
-	}
 
-	jsonData, err := json.Marshal(batchedEntries)
-	if err != nil {
-		return errors.Wrap(err, "WebHandler.sendBatchOverWebSocket: failed to marshal batch")
+		// Assign a sequence number to the batch if replay is enabled.
+		message := jsonData
+		if replayBuffer := wh.getReplayBuffer(); replayBuffer != nil {
+			message, err = replayBuffer.push(jsonData)
+			if err != nil {
+				return errors.Wrap(err, "WebHandler.sendBatchOverWebSocket: failed to buffer batch")
+			}
+		}
+
+		wh.broadcast(message)
+
+		if wh.WSURL == "" {
+			return nil
+		}
+		pending = &wsPendingBatch{batchID: batchID, message: message}
+		if batchID != nil {
+			wh.wsPending = pending
+		}
 	}
 
-	err = wh.wsConn.WriteMessage(websocket.TextMessage, jsonData)
-	if err != nil {
+	if dialErr != nil {
+		return errors.Wrap(dialErr, "WebHandler.sendBatchOverWebSocket")
+	}
+	if err := wh.wsPeer.write(pending.message); err != nil {
+		// Drop the connection so that the next attempt reconnects.
+		wh.dropWSPeer()
 		return errors.Wrap(err, "WebHandler.sendBatchOverWebSocket: failed to write websocket message")
 	}
+	wh.wsPending = nil
+
+	return nil
+}
 
+// dialWSPeer connects to WSURL and listens for the resume requests of the peer. The caller must hold wsPeerMtx.
+func (wh *WebHandler) dialWSPeer() error {
+	var header http.Header
+	if wh.AuthToken != "" {
+		header = http.Header{"Authorization": []string{wh.authorizationHeader()}}
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(wh.WSURL, header)
+	if err != nil {
+		return errors.Wrapf(err, "WebHandler.dialWSPeer: failed to establish connection to %s", wh.WSURL)
+	}
+	peer := &wsSubscriber{conn: conn, writeTimeout: wh.wsWriteTimeout()}
+	wh.wsPeer = peer
+	// Listen for resume requests from the peer.
+	go wh.readControlMessages(conn, peer.write)
 	return nil
 }
+
+// dropWSPeer closes the connection to WSURL, so that the next batch reconnects. The caller must hold wsPeerMtx.
+func (wh *WebHandler) dropWSPeer() {
+	wh.wsPeer.conn.Close()
+	wh.wsPeer = nil
+}
+
+// getReplayBuffer returns the replay buffer, creating it if replay is enabled. The caller must hold wsStreamMtx.
+func (wh *WebHandler) getReplayBuffer() *replayBuffer {
+	if wh.replayBuffer == nil && wh.WSReplayBufferSize > 0 {
+		wh.replayBuffer = newReplayBuffer(wh.WSReplayBufferSize, &wh.metrics.memoryBytes, wh.MaxMemoryBytes)
+	}
+	return wh.replayBuffer
+}
+
+// wsWriteTimeout returns the configured WebSocket write timeout, defaulting to DefaultWSWriteTimeout.
+func (wh *WebHandler) wsWriteTimeout() time.Duration {
+	if wh.WSWriteTimeout <= 0 {
+		return DefaultWSWriteTimeout
+	}
+	return wh.WSWriteTimeout
+}
+
+// writeWSMessage writes a text message to the connection, failing if it isn't written within the timeout.
+func writeWSMessage(conn *websocket.Conn, data []byte, timeout time.Duration) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+		return errors.Wrap(err, "writeWSMessage: failed to set write deadline")
+	}
+	return conn.WriteMessage(websocket.TextMessage, data)
+}
+
+// authorizationHeader returns the value of the Authorization header carrying AuthToken.
+func (wh *WebHandler) authorizationHeader() string {
+	return "Bearer " + wh.AuthToken
+}
+
+// isTimeoutError returns whether the error is from a request that ran out of time, either because its deadline
+// passed or because one of the TransportTimeouts fired.
+func isTimeoutError(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// getHTTPClient returns the client used for HTTP POSTs, falling back to the default client for handlers that
+// were not created with NewWebHandler.
+func (wh *WebHandler) getHTTPClient() *http.Client {
+	if wh.httpClient == nil {
+		return http.DefaultClient
+	}
+	return wh.httpClient
+}
+
+// batchTimeout returns the deadline of the HTTP POST of a batch with the given number of entries.
+func (wh *WebHandler) batchTimeout(entryCount int) time.Duration {
+	if wh.PerBatchTimeout == nil {
+		return DefaultBatchTimeout
+	}
+	return wh.PerBatchTimeout(entryCount)
+}
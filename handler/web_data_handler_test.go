@@ -0,0 +1,470 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+)
+
+// captureRoundTripper records every request, and answers it with statusCode or fails it with err.
+type captureRoundTripper struct {
+	statusCode int
+	err        error
+
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func (roundTripper *captureRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body.Close()
+	roundTripper.requests = append(roundTripper.requests, req)
+	roundTripper.bodies = append(roundTripper.bodies, body)
+	if roundTripper.err != nil {
+		return nil, roundTripper.err
+	}
+	return &http.Response{
+		StatusCode: roundTripper.statusCode,
+		Body:       io.NopCloser(bytes.NewReader(nil)),
+		Header:     http.Header{},
+		Request:    req,
+	}, nil
+}
+
+func TestWithRoundTripper(t *testing.T) {
+	// The endpoint can't be resolved, so only the injected round tripper can answer.
+	const endpointURL = "http://endpoint.invalid/batches"
+	tests := []struct {
+		name         string
+		statusCode   int
+		err          error
+		wantErr      bool
+		wantRequests int
+	}{
+		{name: "accepted", statusCode: http.StatusOK, wantRequests: 1},
+		{name: "rejected", statusCode: http.StatusBadRequest, wantErr: true, wantRequests: 1},
+		{name: "retried", statusCode: http.StatusServiceUnavailable, wantErr: true, wantRequests: 2},
+		{name: "transport error", err: errors.New("connection refused"), wantErr: true, wantRequests: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roundTripper := &captureRoundTripper{statusCode: tt.statusCode, err: tt.err}
+			wh := NewWebHandler(endpointURL, false, "", 0, WithRoundTripper(roundTripper), WithAuthToken("token"))
+			wh.MaxDeliveryAttempts = 1
+			wh.MaxRetries = 1
+			wh.BaseBackoff = time.Millisecond
+
+			err := wh.HandleEntryBatch(newTestEntries(2, 7))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("HandleEntryBatch = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(roundTripper.requests) != tt.wantRequests {
+				t.Fatalf("round tripper received %d requests, want %d", len(roundTripper.requests), tt.wantRequests)
+			}
+
+			req := roundTripper.requests[0]
+			if req.Method != http.MethodPost || req.URL.String() != endpointURL {
+				t.Errorf("request = %s %s, want POST %s", req.Method, req.URL, endpointURL)
+			}
+			if contentType := req.Header.Get("Content-Type"); contentType != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", contentType)
+			}
+			if authorization := req.Header.Get("Authorization"); authorization != "Bearer token" {
+				t.Errorf("Authorization = %q, want the bearer token", authorization)
+			}
+			entries := decodeTestBatch(t, roundTripper.bodies[0])
+			if len(entries) != 2 || entries[0]["BlockHeight"].(json.Number) != "7" {
+				t.Errorf("request body = %s, want both entries at height 7", roundTripper.bodies[0])
+			}
+		})
+	}
+}
+
+func TestAuthToken(t *testing.T) {
+	tests := []struct {
+		name              string
+		webSocket         bool
+		authToken         string
+		wantAuthorization string
+	}{
+		{name: "HTTP without a token"},
+		{name: "HTTP with a token", authToken: "secret-token", wantAuthorization: "Bearer secret-token"},
+		{name: "WebSocket without a token", webSocket: true},
+		{name: "WebSocket with a token", webSocket: true, authToken: "secret-token",
+			wantAuthorization: "Bearer secret-token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t)
+			wh := newTestWebHandler(server, WithAuthToken(tt.authToken))
+			if tt.webSocket {
+				wh = NewWebHandler("", true, server.WSURL(), 0, WithAuthToken(tt.authToken))
+			}
+			wh.MaxDeliveryAttempts = 1
+			t.Cleanup(func() { wh.Close() })
+
+			if err := wh.HandleEntryBatch(newTestEntries(1, 1)); err != nil {
+				t.Fatalf("HandleEntryBatch: %v", err)
+			}
+			waitFor(t, "the batch to be received", func() bool { return len(server.Batches()) == 1 })
+			header := server.Batches()[0].Header
+			if authorization := header.Get("Authorization"); authorization != tt.wantAuthorization {
+				t.Errorf("Authorization = %q, want %q", authorization, tt.wantAuthorization)
+			}
+			if _, exists := header["Authorization"]; exists && tt.authToken == "" {
+				t.Error("expected no Authorization header without an AuthToken")
+			}
+		})
+	}
+}
+
+func TestHTTPStatusErrorBody(t *testing.T) {
+	const validationBody = `{"error":"entry 3 is missing KeyBytes"}`
+	tests := []struct {
+		name         string
+		statusCode   int
+		responseBody string
+		wantBody     string
+		wantMessage  string
+	}{
+		{name: "422 with a body", statusCode: http.StatusUnprocessableEntity, responseBody: validationBody + "\n",
+			wantBody: validationBody, wantMessage: "unexpected HTTP status code 422 from %s: " + validationBody},
+		{name: "without a body", statusCode: http.StatusBadRequest,
+			wantMessage: "unexpected HTTP status code 400 from %s"},
+		{name: "blank body", statusCode: http.StatusBadRequest, responseBody: " \n",
+			wantMessage: "unexpected HTTP status code 400 from %s"},
+		{name: "long body", statusCode: http.StatusUnprocessableEntity,
+			responseBody: strings.Repeat("x", 2*maxErrorBodyBytes), wantBody: strings.Repeat("x", maxErrorBodyBytes),
+			wantMessage: "unexpected HTTP status code 422 from %s: " + strings.Repeat("x", maxErrorBodyBytes)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statusCode)
+				io.WriteString(w, tt.responseBody)
+			}))
+			t.Cleanup(server.Close)
+			wh := NewWebHandler(server.URL, false, "", 0)
+			wh.MaxDeliveryAttempts = 1
+
+			err := wh.HandleEntryBatch(newTestEntries(1, 1))
+			var statusErr *HTTPStatusError
+			if !errors.As(err, &statusErr) {
+				t.Fatalf("HandleEntryBatch = %v, want an HTTPStatusError", err)
+			}
+			if statusErr.StatusCode != tt.statusCode || statusErr.Body != tt.wantBody {
+				t.Errorf("HTTPStatusError has status %d and body %q, want %d and %q", statusErr.StatusCode,
+					statusErr.Body, tt.statusCode, tt.wantBody)
+			}
+			if wantMessage := fmt.Sprintf(tt.wantMessage, server.URL); statusErr.Error() != wantMessage {
+				t.Errorf("HTTPStatusError = %q, want %q", statusErr.Error(), wantMessage)
+			}
+			if !strings.Contains(err.Error(), statusErr.Error()) {
+				t.Errorf("HandleEntryBatch = %q, want it to contain %q", err, statusErr.Error())
+			}
+		})
+	}
+}
+
+// newSlowServer starts a server that answers every request after delay, or once the client gives up on it.
+func newSlowServer(t *testing.T, delay time.Duration) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The server only notices the client going away once the body is read.
+		io.Copy(io.Discard, r.Body)
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestPerBatchTimeout(t *testing.T) {
+	// Batches of more than 10 entries are given longer to send.
+	perBatchTimeout := func(entryCount int) time.Duration {
+		if entryCount > 10 {
+			return time.Second
+		}
+		return 50 * time.Millisecond
+	}
+	tests := []struct {
+		name        string
+		entryCount  int
+		serverDelay time.Duration
+		wantTimeout bool
+	}{
+		{name: "small batch on time", entryCount: 1, serverDelay: 0},
+		{name: "small batch too slow", entryCount: 1, serverDelay: 500 * time.Millisecond, wantTimeout: true},
+		{name: "large batch given longer", entryCount: 20, serverDelay: 200 * time.Millisecond},
+		{name: "large batch too slow", entryCount: 20, serverDelay: 3 * time.Second, wantTimeout: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newSlowServer(t, tt.serverDelay)
+			wh := NewWebHandler(server.URL, false, "", 0)
+			wh.PerBatchTimeout = perBatchTimeout
+			wh.MaxDeliveryAttempts = 1
+			wh.MaxRetries = 0
+
+			startTime := time.Now()
+			err := wh.HandleEntryBatch(newTestEntries(tt.entryCount, 1))
+			elapsed := time.Since(startTime)
+			if tt.wantTimeout != errors.Is(err, ErrHTTPTimeout) {
+				t.Errorf("HandleEntryBatch = %v, want timeout %v", err, tt.wantTimeout)
+			}
+			// A batch that times out is cancelled promptly rather than waiting for the server.
+			if timeout := perBatchTimeout(tt.entryCount); tt.wantTimeout && elapsed > timeout+500*time.Millisecond {
+				t.Errorf("HandleEntryBatch returned after %s, long after the %s timeout", elapsed, timeout)
+			}
+		})
+	}
+}
+
+func TestWithHTTPTimeoutCancelsSlowServer(t *testing.T) {
+	server := newSlowServer(t, 5*time.Second)
+	wh := NewWebHandler(server.URL, false, "", 0, WithHTTPTimeout(50*time.Millisecond))
+	wh.MaxDeliveryAttempts = 1
+	wh.MaxRetries = 0
+
+	startTime := time.Now()
+	if err := wh.HandleEntryBatch(newTestEntries(1, 1)); !errors.Is(err, ErrHTTPTimeout) {
+		t.Errorf("HandleEntryBatch = %v, want ErrHTTPTimeout", err)
+	}
+	if elapsed := time.Since(startTime); elapsed > time.Second {
+		t.Errorf("HandleEntryBatch returned after %s, long after the timeout", elapsed)
+	}
+}
+
+func TestMaxArrayItems(t *testing.T) {
+	tests := []struct {
+		name          string
+		count         int
+		maxArrayItems int
+		statusCode    int64
+		wantCounts    []int
+		wantRequests  int64
+	}{
+		{name: "disabled", count: 1200, wantCounts: []int{1200}, wantRequests: 1},
+		{name: "split", count: 1200, maxArrayItems: 500, wantCounts: []int{500, 500, 200}, wantRequests: 3},
+		{name: "exactly at the limit", count: 500, maxArrayItems: 500, wantCounts: []int{500}, wantRequests: 1},
+		{name: "one over the limit", count: 501, maxArrayItems: 500, wantCounts: []int{500, 1}, wantRequests: 2},
+		{name: "stops at the first failed chunk", count: 1200, maxArrayItems: 500,
+			statusCode: http.StatusServiceUnavailable, wantRequests: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newRecordingServer(t)
+			if tt.statusCode != 0 {
+				server.statusCode.Store(tt.statusCode)
+			}
+			wh := NewWebHandler(server.URL, false, "", 0)
+			wh.MaxDeliveryAttempts = 1
+			wh.MaxRetries = 0
+			wh.MaxArrayItems = tt.maxArrayItems
+
+			var heights []uint64
+			for height := uint64(1); height <= uint64(tt.count); height++ {
+				heights = append(heights, height)
+			}
+			err := wh.HandleEntryBatch(newTestHeightBatch(heights...))
+			if (err != nil) != (tt.statusCode != 0) {
+				t.Fatalf("HandleEntryBatch = %v, wantErr %v", err, tt.statusCode != 0)
+			}
+			if requests := server.requests.Load(); requests != tt.wantRequests {
+				t.Errorf("server received %d requests, want %d", requests, tt.wantRequests)
+			}
+
+			// The chunks hold every entry once, in order.
+			var counts []int
+			var receivedHeights []uint64
+			for _, chunk := range server.batchHeights() {
+				counts = append(counts, len(chunk))
+				receivedHeights = append(receivedHeights, chunk...)
+			}
+			if !reflect.DeepEqual(counts, tt.wantCounts) {
+				t.Errorf("entries per POST = %v, want %v", counts, tt.wantCounts)
+			}
+			if tt.wantCounts != nil && !reflect.DeepEqual(receivedHeights, heights) {
+				t.Error("expected the chunks to hold every entry once, in order")
+			}
+		})
+	}
+}
+
+func TestWithTransportTimeouts(t *testing.T) {
+	timeouts := TransportTimeouts{
+		DialTimeout:           time.Second,
+		TLSHandshakeTimeout:   2 * time.Second,
+		ResponseHeaderTimeout: 3 * time.Second,
+	}
+	wh := NewWebHandler("", false, "", 0, WithTransportTimeouts(timeouts))
+	transport, isTransport := wh.httpClient.Transport.(*http.Transport)
+	if !isTransport {
+		t.Fatalf("transport is %T, want *http.Transport", wh.httpClient.Transport)
+	}
+	if transport == http.DefaultTransport {
+		t.Error("expected a copy of the default transport rather than the shared one")
+	}
+	if transport.DialContext == nil {
+		t.Error("expected the transport to dial with DialTimeout")
+	}
+	if transport.TLSHandshakeTimeout != timeouts.TLSHandshakeTimeout {
+		t.Errorf("TLSHandshakeTimeout = %s, want %s", transport.TLSHandshakeTimeout, timeouts.TLSHandshakeTimeout)
+	}
+	if transport.ResponseHeaderTimeout != timeouts.ResponseHeaderTimeout {
+		t.Errorf("ResponseHeaderTimeout = %s, want %s", transport.ResponseHeaderTimeout,
+			timeouts.ResponseHeaderTimeout)
+	}
+
+	// Zero timeouts keep the defaults.
+	wh = NewWebHandler("", false, "", 0, WithTransportTimeouts(TransportTimeouts{}))
+	transport = wh.httpClient.Transport.(*http.Transport)
+	defaultTransport := http.DefaultTransport.(*http.Transport)
+	if transport.TLSHandshakeTimeout != defaultTransport.TLSHandshakeTimeout ||
+		transport.ResponseHeaderTimeout != defaultTransport.ResponseHeaderTimeout {
+		t.Errorf("zero timeouts set TLSHandshakeTimeout %s and ResponseHeaderTimeout %s, want the defaults",
+			transport.TLSHandshakeTimeout, transport.ResponseHeaderTimeout)
+	}
+}
+
+func TestResponseHeaderTimeout(t *testing.T) {
+	tests := []struct {
+		name                  string
+		headerDelay           time.Duration
+		responseHeaderTimeout time.Duration
+		wantTimeout           bool
+	}{
+		{name: "slow headers", headerDelay: 5 * time.Second, responseHeaderTimeout: 50 * time.Millisecond,
+			wantTimeout: true},
+		{name: "headers within the timeout", headerDelay: 50 * time.Millisecond, responseHeaderTimeout: time.Second},
+		{name: "no timeout", headerDelay: 50 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newSlowServer(t, tt.headerDelay)
+			wh := NewWebHandler(server.URL, false, "", 0,
+				WithTransportTimeouts(TransportTimeouts{ResponseHeaderTimeout: tt.responseHeaderTimeout}))
+			wh.MaxDeliveryAttempts = 1
+			wh.MaxRetries = 0
+
+			startTime := time.Now()
+			err := wh.HandleEntryBatch(newTestEntries(1, 1))
+			if tt.wantTimeout != errors.Is(err, ErrHTTPTimeout) {
+				t.Errorf("HandleEntryBatch = %v, wantTimeout %v", err, tt.wantTimeout)
+			}
+			if !tt.wantTimeout && err != nil {
+				t.Errorf("HandleEntryBatch: %v", err)
+			}
+			// The POST fails at the header timeout rather than waiting for the slow server.
+			if elapsed := time.Since(startTime); tt.wantTimeout && elapsed > time.Second {
+				t.Errorf("HandleEntryBatch returned after %s, long after the response header timeout", elapsed)
+			}
+		})
+	}
+}
+
+// newNonReadingWSServer starts a WebSocket server that accepts connections and never reads from them, and returns
+// its URL and the number of connections it accepted.
+func newNonReadingWSServer(t *testing.T) (string, *atomic.Int64) {
+	t.Helper()
+	var connections atomic.Int64
+	release := make(chan struct{})
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		connections.Add(1)
+		<-release
+	}))
+	// Release the connections before the server waits for them to close.
+	t.Cleanup(server.Close)
+	t.Cleanup(func() { close(release) })
+	return "ws" + strings.TrimPrefix(server.URL, "http"), &connections
+}
+
+func TestWSWriteTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		// subscriber connects the non-reading peer as a stream subscriber rather than as WSURL.
+		subscriber bool
+	}{
+		{name: "peer"},
+		{name: "stream subscriber", subscriber: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var wsURL string
+			var connections *atomic.Int64
+			if !tt.subscriber {
+				wsURL, connections = newNonReadingWSServer(t)
+			}
+			wh := NewWebHandler("", true, wsURL, 0)
+			wh.WSWriteTimeout = 50 * time.Millisecond
+			wh.MaxDeliveryAttempts = 1
+			if tt.subscriber {
+				dialTestStream(t, wh, "")
+			}
+
+			// Send large batches until the peer's receive buffers fill up and a write times out.
+			var err error
+			for height := uint64(1); ; height++ {
+				if height > 200 {
+					t.Fatal("no write timed out although the peer doesn't read")
+				}
+				entry := testKeyedEntry(strings.Repeat("x", 1<<20), height)
+				startTime := time.Now()
+				err = wh.HandleEntryBatch([]*lib.StateChangeEntry{entry})
+				if elapsed := time.Since(startTime); elapsed > 2*time.Second {
+					t.Fatalf("HandleEntryBatch(%d) hung for %s, long after the write timeout", height, elapsed)
+				}
+				wh.wsStreamMtx.Lock()
+				subscriberCount := len(wh.wsSubscribers)
+				wh.wsStreamMtx.Unlock()
+				if err != nil || (tt.subscriber && subscriberCount == 0) {
+					break
+				}
+			}
+
+			if tt.subscriber {
+				// A subscriber that stops reading is dropped without failing the batch.
+				if err != nil {
+					t.Errorf("HandleEntryBatch = %v, want the subscriber dropped without an error", err)
+				}
+				return
+			}
+			if !isTimeoutError(err) {
+				t.Errorf("HandleEntryBatch = %v, want a timeout error", err)
+			}
+			// The timed out connection is dropped, and the next batch reconnects.
+			wh.wsPeerMtx.Lock()
+			peerDropped := wh.wsPeer == nil
+			wh.wsPeerMtx.Unlock()
+			if !peerDropped {
+				t.Error("expected the connection to be dropped after the write timed out")
+			}
+			if err = wh.HandleEntryBatch(newTestEntries(1, 1)); err != nil {
+				t.Errorf("HandleEntryBatch after the timeout: %v", err)
+			}
+			waitFor(t, "the handler to reconnect", func() bool { return connections.Load() == 2 })
+		})
+	}
+}
@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"sync"
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+)
+
+// Transports reported as the active transport by the admin /status endpoint.
+const (
+	TransportHTTP      = "http"
+	TransportWebSocket = "websocket"
+	TransportFanOut    = "fan_out"
+)
+
+// DefaultWSRestoreInterval is how often a handler that failed over to HTTP tries WebSocket again, unless
+// WSRestoreInterval is set.
+const DefaultWSRestoreInterval = time.Minute
+
+// wsFailoverState tracks the WebSocket failures of a handler that fails over to HTTP.
+type wsFailoverState struct {
+	mtx sync.Mutex
+	// consecutiveFailures is the number of batches that failed over WebSocket since the last one that was sent.
+	consecutiveFailures int
+	// failedOver is set while batches are sent over HTTP.
+	failedOver bool
+	// lastRestoreAttempt is when a batch was last sent over WebSocket while failed over, or when the handler
+	// failed over.
+	lastRestoreAttempt time.Time
+}
+
+// wsFailoverEnabled returns whether WebSocket is the primary transport, with EndpointURL as the fallback. This is the
+// case when WSMaxReconnectAttempts is set along with both WSURL and EndpointURL. Otherwise, EndpointURL is preferred
+// whenever it is set.
+func (wh *WebHandler) wsFailoverEnabled() bool {
+	return wh.WSMaxReconnectAttempts > 0 && wh.UseWebSocket && wh.WSURL != "" && wh.EndpointURL != ""
+}
+
+// wsRestoreInterval returns the configured WebSocket restore interval, defaulting to DefaultWSRestoreInterval.
+func (wh *WebHandler) wsRestoreInterval() time.Duration {
+	if wh.WSRestoreInterval <= 0 {
+		return DefaultWSRestoreInterval
+	}
+	return wh.WSRestoreInterval
+}
+
+// sendBatchWithFailover sends the batch over WebSocket, or over HTTP once WebSocket has failed.
+//
+// A batch that fails over WebSocket fails as usual, until WSMaxReconnectAttempts batches in a row have failed. The
+// batch that reaches the limit, and every batch after it, is then sent to EndpointURL instead. Once every
+// WSRestoreInterval, the next batch is tried over WebSocket first, reconnecting as needed. If it is sent, WebSocket
+// is restored and the following batches are sent over it again. If not, the batch is sent over HTTP and the next try
+// waits for another interval.
+//
+// While failed over, batches are only sent to EndpointURL, so clients of the handler's WebSocket server don't receive
+// them, and a batch that fails a restore attempt may have been received by those clients before it is sent over HTTP.
+func (wh *WebHandler) sendBatchWithFailover(batchID *lib.StateChangeEntry, batchedEntries []*lib.StateChangeEntry) error {
+	if !wh.wsFailover.shouldTryWebSocket(wh.wsRestoreInterval()) {
+		return wh.pushBatchToEndpoint(batchedEntries)
+	}
+
+	wsErr := wh.sendBatchOverWebSocket(batchID, batchedEntries)
+	if wsErr == nil {
+		if wh.wsFailover.recordSuccess() {
+			wh.metrics.wsRestores.Add(1)
+			glog.Infof("WebHandler.sendBatchWithFailover: WebSocket connection to %s restored", wh.WSURL)
+		}
+		return nil
+	}
+	// Batches rejected because the handler is closed aren't WebSocket failures.
+	if wh.wsClosed.Load() {
+		return wsErr
+	}
+
+	failedOver, justFailedOver := wh.wsFailover.recordFailure(wh.WSMaxReconnectAttempts)
+	if !failedOver {
+		return wsErr
+	}
+	if justFailedOver {
+		wh.metrics.wsFailovers.Add(1)
+		glog.Errorf("WebHandler.sendBatchWithFailover: failing over to %s after %d WebSocket failures in a row: %v",
+			wh.EndpointURL, wh.WSMaxReconnectAttempts, wsErr)
+	}
+	if err := wh.pushBatchToEndpoint(batchedEntries); err != nil {
+		return errors.Wrapf(err, "WebHandler.sendBatchWithFailover: failed over WebSocket (%v) and over HTTP", wsErr)
+	}
+	return nil
+}
+
+// activeTransport returns the transport batches are currently sent over.
+func (wh *WebHandler) activeTransport() string {
+	switch {
+	case len(wh.FanOutURLs) > 0:
+		return TransportFanOut
+	case wh.wsFailoverEnabled():
+		if wh.wsFailover.isFailedOver() {
+			return TransportHTTP
+		}
+		return TransportWebSocket
+	case wh.EndpointURL != "":
+		return TransportHTTP
+	case wh.UseWebSocket:
+		return TransportWebSocket
+	}
+	return ""
+}
+
+// shouldTryWebSocket returns whether the next batch should be sent over WebSocket: always, unless failed over, in
+// which case only once every restoreInterval.
+func (state *wsFailoverState) shouldTryWebSocket(restoreInterval time.Duration) bool {
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+
+	if !state.failedOver {
+		return true
+	}
+	if time.Since(state.lastRestoreAttempt) < restoreInterval {
+		return false
+	}
+	// Start the next interval now, so that batches sent while this one is tried go over HTTP.
+	state.lastRestoreAttempt = time.Now()
+	return true
+}
+
+// recordSuccess resets the failure count after a batch is sent over WebSocket, and returns whether the handler was
+// failed over until now.
+func (state *wsFailoverState) recordSuccess() bool {
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+
+	wasFailedOver := state.failedOver
+	state.consecutiveFailures = 0
+	state.failedOver = false
+	return wasFailedOver
+}
+
+// recordFailure counts a batch that failed over WebSocket, and returns whether the handler is failed over and whether
+// this failure is the one that made it fail over.
+func (state *wsFailoverState) recordFailure(maxReconnectAttempts int) (bool, bool) {
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+
+	state.consecutiveFailures++
+	if state.failedOver {
+		return true, false
+	}
+	if state.consecutiveFailures < maxReconnectAttempts {
+		return false, false
+	}
+	state.failedOver = true
+	state.lastRestoreAttempt = time.Now()
+	return true, true
+}
+
+// isFailedOver returns whether batches are currently sent over HTTP.
+func (state *wsFailoverState) isFailedOver() bool {
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+	return state.failedOver
+}
+
+// failures returns the number of batches that failed over WebSocket since the last one that was sent.
+func (state *wsFailoverState) failures() int {
+	state.mtx.Lock()
+	defer state.mtx.Unlock()
+	return state.consecutiveFailures
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/deso-protocol/postgres-data-handler/handler/testserver"
+)
+
+// receivedTransports returns the transport and height of every batch the server received, e.g. "http 3".
+func receivedTransports(server *testserver.Server) []string {
+	var transports []string
+	for _, batch := range server.Batches() {
+		transports = append(transports, fmt.Sprintf("%s %v", batch.Transport, batch.Entries[0]["BlockHeight"]))
+	}
+	return transports
+}
+
+func TestWSFailover(t *testing.T) {
+	tests := []struct {
+		name                 string
+		maxReconnectAttempts int
+		// wantErrs are whether each of the five batches fails.
+		wantErrs       []bool
+		wantTransports []string
+	}{
+		{
+			name:                 "first failure",
+			maxReconnectAttempts: 1,
+			wantErrs:             []bool{false, false, false, false, false},
+			wantTransports:       []string{"http 1", "http 2", "http 3", "http 4", "http 5"},
+		},
+		{
+			name:                 "third failure",
+			maxReconnectAttempts: 3,
+			wantErrs:             []bool{true, true, false, false, false},
+			wantTransports:       []string{"http 3", "http 4", "http 5"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newTestServer(t)
+			wh := NewWebHandler(server.URL(), true, closedWSURL(t), 0)
+			wh.MaxDeliveryAttempts = 1
+			wh.WSMaxReconnectAttempts = tt.maxReconnectAttempts
+			if transport := wh.Status().ActiveTransport; transport != TransportWebSocket {
+				t.Errorf("active transport = %s before any failure, want %s", transport, TransportWebSocket)
+			}
+
+			for ii, wantErr := range tt.wantErrs {
+				height := uint64(ii + 1)
+				if err := wh.HandleEntryBatch(newTestEntries(1, height)); (err != nil) != wantErr {
+					t.Fatalf("HandleEntryBatch(%d) = %v, wantErr %v", height, err, wantErr)
+				}
+			}
+			if transports := receivedTransports(server); !reflect.DeepEqual(transports, tt.wantTransports) {
+				t.Errorf("server received %v, want %v", transports, tt.wantTransports)
+			}
+			status := wh.Status()
+			if status.ActiveTransport != TransportHTTP {
+				t.Errorf("active transport = %s after failing over, want %s", status.ActiveTransport, TransportHTTP)
+			}
+			if status.WSFailures != tt.maxReconnectAttempts {
+				t.Errorf("WSFailures = %d, want %d", status.WSFailures, tt.maxReconnectAttempts)
+			}
+			if failovers := wh.metrics.wsFailovers.Load(); failovers != 1 {
+				t.Errorf("wsFailovers = %d, want 1", failovers)
+			}
+		})
+	}
+}
+
+func TestWSFailoverRestore(t *testing.T) {
+	server := newTestServer(t)
+	wh := NewWebHandler(server.URL(), true, closedWSURL(t), 0)
+	wh.MaxDeliveryAttempts = 1
+	wh.WSMaxReconnectAttempts = 1
+	wh.WSRestoreInterval = 100 * time.Millisecond
+
+	steps := []struct {
+		name string
+		// wait is how long to wait before the batch, and restoreWS points WSURL at a working server.
+		wait      time.Duration
+		restoreWS bool
+		// wantTransport is the transport the batch is received over.
+		wantTransport string
+	}{
+		{name: "failing over", wantTransport: testserver.TransportHTTP},
+		{name: "within the restore interval", restoreWS: true, wantTransport: testserver.TransportHTTP},
+		{name: "restored", wait: 150 * time.Millisecond, wantTransport: testserver.TransportWebSocket},
+		{name: "after the restore", wantTransport: testserver.TransportWebSocket},
+	}
+	for ii, step := range steps {
+		time.Sleep(step.wait)
+		if step.restoreWS {
+			wh.WSURL = server.WSURL()
+		}
+		height := uint64(ii + 1)
+		if err := wh.HandleEntryBatch(newTestEntries(1, height)); err != nil {
+			t.Fatalf("%s: HandleEntryBatch(%d): %v", step.name, height, err)
+		}
+		want := fmt.Sprintf("%s %d", step.wantTransport, height)
+		waitFor(t, fmt.Sprintf("batch %d", height), func() bool { return len(server.Batches()) > ii })
+		transports := receivedTransports(server)
+		if transports[ii] != want {
+			t.Errorf("%s: server received %s, want %s", step.name, transports[ii], want)
+		}
+	}
+	if transport := wh.Status().ActiveTransport; transport != TransportWebSocket {
+		t.Errorf("active transport = %s after the restore, want %s", transport, TransportWebSocket)
+	}
+	if restores := wh.metrics.wsRestores.Load(); restores != 1 {
+		t.Errorf("wsRestores = %d, want 1", restores)
+	}
+	if err := wh.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+)
+
+const (
+	// wsShutdownTimeout is how long Close waits for an in-flight WebSocket write to finish.
+	wsShutdownTimeout = 5 * time.Second
+	// wsCloseFrameTimeout is the write deadline of the close frame sent to each peer.
+	wsCloseFrameTimeout = time.Second
+)
+
+// Close gracefully shuts down the WebSocket stream. Under WarmShutdown, it first waits for the in-progress block to
+// be sent. It then sends the updates held for the coalesce window, stops accepting new batches, waits up to
+// wsShutdownTimeout for the batch that is currently being written to finish, and then sends a close frame to the
+// WSURL peer and to every connected subscriber. Batches rejected while shutting down are logged. Batches queued for
+// retry are sent before the WebSocket stream is closed, and an error is returned if any of them can't be.
+func (wh *WebHandler) Close() error {
+	// Finish the in-progress block before stopping, if configured.
+	if wh.WarmShutdown {
+		wh.waitForBlockBoundary()
+	}
+	// Send the updates held for the coalesce window before the connections are closed.
+	if wh.CoalesceWindow > 0 {
+		wh.flushCoalescedEntries()
+	}
+	// Make a last attempt to send the batches queued for retry.
+	drainErr := wh.drainRetryQueue()
+	wh.wsClosed.Store(true)
+
+	// Batches are written while holding wsPeerMtx, so acquiring it means the in-flight write has finished.
+	done := make(chan struct{})
+	go func() {
+		wh.wsPeerMtx.Lock()
+		defer wh.wsPeerMtx.Unlock()
+		wh.wsStreamMtx.Lock()
+		defer wh.wsStreamMtx.Unlock()
+		wh.closeWebSocketConnections()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(wsShutdownTimeout):
+		return fmt.Errorf("WebHandler.Close: timed out waiting for in-flight WebSocket write after %v", wsShutdownTimeout)
+	}
+
+	if dropped := wh.metrics.batchesDroppedOnShutdown.Load(); dropped > 0 {
+		glog.Infof("WebHandler.Close: dropped %d batches received during shutdown", dropped)
+	}
+	if drainErr != nil {
+		return errors.Wrap(drainErr, "WebHandler.Close: failed to drain retry queue")
+	}
+	return nil
+}
+
+// closeWebSocketConnections sends a close frame to the WSURL peer and every subscriber and closes their
+// connections. The caller must hold wsPeerMtx and wsStreamMtx.
+func (wh *WebHandler) closeWebSocketConnections() {
+	closeMessage := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "shutting down")
+	deadline := time.Now().Add(wsCloseFrameTimeout)
+
+	if wh.wsPeer != nil {
+		wh.wsPeer.writeMtx.Lock()
+		if err := wh.wsPeer.conn.WriteControl(websocket.CloseMessage, closeMessage, deadline); err != nil {
+			glog.Errorf("WebHandler.closeWebSocketConnections: failed to send close frame to %s: %v", wh.WSURL, err)
+		}
+		wh.wsPeer.writeMtx.Unlock()
+		wh.dropWSPeer()
+	}
+	wh.wsPending = nil
+
+	for sub := range wh.wsSubscribers {
+		sub.writeMtx.Lock()
+		if err := sub.conn.WriteControl(websocket.CloseMessage, closeMessage, deadline); err != nil {
+			glog.Errorf("WebHandler.closeWebSocketConnections: failed to send close frame to subscriber: %v", err)
+		}
+		sub.writeMtx.Unlock()
+		sub.conn.Close()
+		delete(wh.wsSubscribers, sub)
+	}
+}
@@ -0,0 +1,274 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+)
+
+const (
+	// WSMessageTypeBatch is the message type of a sequenced batch of entries.
+	WSMessageTypeBatch = "batch"
+	// WSMessageTypeGap is sent when a client asks to resume from a sequence number that has already been
+	// evicted from the replay buffer. The client should trigger a full resync when it receives one.
+	WSMessageTypeGap = "gap"
+	// WSMessageTypeResume is sent by a client to ask the handler to replay batches starting at ResumeSeq.
+	WSMessageTypeResume = "resume"
+
+	// ResumeSeqQueryParam is the query parameter used to pass the resume sequence number when connecting
+	// to the handler's WebSocket server.
+	ResumeSeqQueryParam = "resume_seq"
+)
+
+// WSBatchMessage is the envelope used for batches sent over WebSocket when a replay buffer is configured.
+type WSBatchMessage struct {
+	Type    string          `json:"type"`
+	Seq     uint64          `json:"seq"`
+	Entries json.RawMessage `json:"entries"`
+}
+
+// WSGapMessage tells the client that the batches it asked for are no longer buffered.
+type WSGapMessage struct {
+	Type         string `json:"type"`
+	RequestedSeq uint64 `json:"requested_seq"`
+	// OldestSeq is the oldest sequence number that is still buffered, or zero if the buffer is empty.
+	OldestSeq uint64 `json:"oldest_seq"`
+	// NextSeq is the sequence number that will be assigned to the next batch.
+	NextSeq uint64 `json:"next_seq"`
+}
+
+// WSControlMessage is a message sent by a client to the handler.
+type WSControlMessage struct {
+	Type      string `json:"type"`
+	ResumeSeq uint64 `json:"resume_seq"`
+}
+
+// replayFrame is a serialized batch message held in the replay buffer.
+type replayFrame struct {
+	seq  uint64
+	data []byte
+}
+
+// replayBuffer is a fixed size ring buffer of the most recently sent batch messages. Every batch pushed
+// into the buffer is assigned the next sequence number, starting at 1.
+type replayBuffer struct {
+	mtx     sync.Mutex
+	frames  []replayFrame
+	start   int
+	count   int
+	nextSeq uint64
+
+	// memoryBytes accounts for the buffered frames in the handler's memory budget. Once maxMemoryBytes is
+	// reached, the oldest frames are evicted early.
+	memoryBytes    *atomic.Int64
+	maxMemoryBytes int64
+}
+
+func newReplayBuffer(size int, memoryBytes *atomic.Int64, maxMemoryBytes int64) *replayBuffer {
+	return &replayBuffer{
+		frames:         make([]replayFrame, size),
+		nextSeq:        1,
+		memoryBytes:    memoryBytes,
+		maxMemoryBytes: maxMemoryBytes,
+	}
+}
+
+// push assigns a sequence number to the batch, stores the framed message and returns it.
+func (rb *replayBuffer) push(entries json.RawMessage) ([]byte, error) {
+	rb.mtx.Lock()
+	defer rb.mtx.Unlock()
+
+	data, err := json.Marshal(&WSBatchMessage{
+		Type:    WSMessageTypeBatch,
+		Seq:     rb.nextSeq,
+		Entries: entries,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "replayBuffer.push: failed to marshal batch message")
+	}
+
+	// Once the buffer is full, or the frame doesn't fit in the memory budget, evict the oldest frames.
+	for rb.count == len(rb.frames) ||
+		(rb.count > 0 && exceedsMemoryBudget(rb.memoryBytes, rb.maxMemoryBytes, int64(len(data)))) {
+		rb.memoryBytes.Add(-int64(len(rb.frames[rb.start].data)))
+		rb.frames[rb.start] = replayFrame{}
+		rb.start = (rb.start + 1) % len(rb.frames)
+		rb.count--
+	}
+	index := (rb.start + rb.count) % len(rb.frames)
+	rb.count++
+	rb.frames[index] = replayFrame{seq: rb.nextSeq, data: data}
+	rb.memoryBytes.Add(int64(len(data)))
+	rb.nextSeq++
+
+	return data, nil
+}
+
+// since returns the buffered messages starting at resumeSeq. If any of the requested batches have
+// already been evicted, a gap message is returned instead.
+func (rb *replayBuffer) since(resumeSeq uint64) (frames [][]byte, gap *WSGapMessage) {
+	rb.mtx.Lock()
+	defer rb.mtx.Unlock()
+
+	// Sequence numbers start at 1, so resuming from 0 means resuming from the beginning.
+	if resumeSeq == 0 {
+		resumeSeq = 1
+	}
+	// The client is already caught up.
+	if resumeSeq >= rb.nextSeq {
+		return nil, nil
+	}
+
+	var oldestSeq uint64
+	if rb.count > 0 {
+		oldestSeq = rb.frames[rb.start].seq
+	}
+	if rb.count == 0 || resumeSeq < oldestSeq {
+		return nil, &WSGapMessage{
+			Type:         WSMessageTypeGap,
+			RequestedSeq: resumeSeq,
+			OldestSeq:    oldestSeq,
+			NextSeq:      rb.nextSeq,
+		}
+	}
+
+	for ii := int(resumeSeq - oldestSeq); ii < rb.count; ii++ {
+		frames = append(frames, rb.frames[(rb.start+ii)%len(rb.frames)].data)
+	}
+	return frames, nil
+}
+
+// wsSubscriber is a client connected to the handler's WebSocket server, or the WSURL peer.
+type wsSubscriber struct {
+	conn *websocket.Conn
+	// writeTimeout is the deadline of every write, so that a client that stops reading is dropped.
+	writeTimeout time.Duration
+	// writeMtx serializes writes, as gorilla connections support only one concurrent writer.
+	writeMtx sync.Mutex
+}
+
+func (sub *wsSubscriber) write(data []byte) error {
+	sub.writeMtx.Lock()
+	defer sub.writeMtx.Unlock()
+	return writeWSMessage(sub.conn, data, sub.writeTimeout)
+}
+
+// replayTo writes the buffered batches starting at resumeSeq using the given write function, or a gap
+// message if they are no longer available. The caller must hold wsStreamMtx and replay must be enabled.
+func (wh *WebHandler) replayTo(write func([]byte) error, resumeSeq uint64) error {
+	frames, gap := wh.getReplayBuffer().since(resumeSeq)
+	if gap != nil {
+		glog.Infof("WebHandler.replayTo: Requested seq %d is no longer buffered, sending gap", resumeSeq)
+		data, err := json.Marshal(gap)
+		if err != nil {
+			return errors.Wrap(err, "WebHandler.replayTo: failed to marshal gap message")
+		}
+		return write(data)
+	}
+
+	for _, frame := range frames {
+		if err := write(frame); err != nil {
+			return errors.Wrap(err, "WebHandler.replayTo: failed to write buffered batch")
+		}
+	}
+	return nil
+}
+
+// ListenAndServeWebSocket runs a WebSocket server on addr that streams batches to every connected client.
+// Clients may pass the resume_seq query parameter to replay buffered batches starting at that sequence.
+func (wh *WebHandler) ListenAndServeWebSocket(addr string) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stream", wh.ServeWebSocketStream)
+	return http.ListenAndServe(addr, mux)
+}
+
+// ServeWebSocketStream upgrades the request to a WebSocket connection and subscribes it to the stream.
+func (wh *WebHandler) ServeWebSocketStream(w http.ResponseWriter, r *http.Request) {
+	var resumeSeq uint64
+	hasResumeSeq := false
+	if resumeSeqParam := r.URL.Query().Get(ResumeSeqQueryParam); resumeSeqParam != "" {
+		var err error
+		resumeSeq, err = strconv.ParseUint(resumeSeqParam, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid resume_seq", http.StatusBadRequest)
+			return
+		}
+		hasResumeSeq = true
+	}
+
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		glog.Errorf("WebHandler.ServeWebSocketStream: failed to upgrade connection: %v", err)
+		return
+	}
+	sub := &wsSubscriber{conn: conn, writeTimeout: wh.wsWriteTimeout()}
+
+	// Hold the stream lock while replaying so that no live batch is sent between the replay and subscription.
+	wh.wsStreamMtx.Lock()
+	if hasResumeSeq && wh.getReplayBuffer() != nil {
+		if err = wh.replayTo(sub.write, resumeSeq); err != nil {
+			wh.wsStreamMtx.Unlock()
+			glog.Errorf("WebHandler.ServeWebSocketStream: failed to replay batches: %v", err)
+			conn.Close()
+			return
+		}
+	}
+	if wh.wsSubscribers == nil {
+		wh.wsSubscribers = make(map[*wsSubscriber]struct{})
+	}
+	wh.wsSubscribers[sub] = struct{}{}
+	wh.wsStreamMtx.Unlock()
+
+	// Read until the client disconnects, handling resume requests along the way.
+	wh.readControlMessages(conn, sub.write)
+
+	wh.wsStreamMtx.Lock()
+	delete(wh.wsSubscribers, sub)
+	wh.wsStreamMtx.Unlock()
+	conn.Close()
+}
+
+// broadcast writes the message to every connected subscriber, dropping those that fail.
+// The caller must hold wsStreamMtx.
+func (wh *WebHandler) broadcast(data []byte) {
+	for sub := range wh.wsSubscribers {
+		if err := sub.write(data); err != nil {
+			glog.Errorf("WebHandler.broadcast: dropping subscriber: %v", err)
+			sub.conn.Close()
+			delete(wh.wsSubscribers, sub)
+		}
+	}
+}
+
+// readControlMessages reads messages from the peer until the connection fails, replaying buffered
+// batches whenever the peer sends a resume message.
+func (wh *WebHandler) readControlMessages(conn *websocket.Conn, write func([]byte) error) {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var msg WSControlMessage
+		if err = json.Unmarshal(data, &msg); err != nil || msg.Type != WSMessageTypeResume {
+			continue
+		}
+		wh.wsStreamMtx.Lock()
+		if wh.getReplayBuffer() != nil {
+			err = wh.replayTo(write, msg.ResumeSeq)
+		}
+		wh.wsStreamMtx.Unlock()
+		if err != nil {
+			glog.Errorf("WebHandler.readControlMessages: failed to replay batches: %v", err)
+			return
+		}
+	}
+}
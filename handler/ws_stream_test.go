@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func TestReplayBufferSince(t *testing.T) {
+	var memoryBytes atomic.Int64
+	rb := newReplayBuffer(3, &memoryBytes, 0)
+	for ii := 1; ii <= 5; ii++ {
+		if _, err := rb.push(json.RawMessage(fmt.Sprintf("[%d]", ii))); err != nil {
+			t.Fatalf("push: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		resumeSeq uint64
+		wantSeqs  []uint64
+		wantGap   *WSGapMessage
+	}{
+		{name: "latest", resumeSeq: 5, wantSeqs: []uint64{5}},
+		{name: "middle", resumeSeq: 4, wantSeqs: []uint64{4, 5}},
+		{name: "oldest buffered", resumeSeq: 3, wantSeqs: []uint64{3, 4, 5}},
+		{name: "caught up", resumeSeq: 6},
+		{name: "ahead", resumeSeq: 10},
+		{
+			name:      "evicted",
+			resumeSeq: 2,
+			wantGap:   &WSGapMessage{Type: WSMessageTypeGap, RequestedSeq: 2, OldestSeq: 3, NextSeq: 6},
+		},
+		{
+			name:      "from the beginning",
+			resumeSeq: 0,
+			wantGap:   &WSGapMessage{Type: WSMessageTypeGap, RequestedSeq: 1, OldestSeq: 3, NextSeq: 6},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			frames, gap := rb.since(tt.resumeSeq)
+			if !reflect.DeepEqual(gap, tt.wantGap) {
+				t.Fatalf("gap = %+v, want %+v", gap, tt.wantGap)
+			}
+			var seqs []uint64
+			for _, frame := range frames {
+				var message WSBatchMessage
+				if err := json.Unmarshal(frame, &message); err != nil {
+					t.Fatalf("failed to decode frame: %v", err)
+				}
+				if message.Type != WSMessageTypeBatch || string(message.Entries) != fmt.Sprintf("[%d]", message.Seq) {
+					t.Errorf("frame %s doesn't hold batch %d", frame, message.Seq)
+				}
+				seqs = append(seqs, message.Seq)
+			}
+			if !reflect.DeepEqual(seqs, tt.wantSeqs) {
+				t.Errorf("seqs = %v, want %v", seqs, tt.wantSeqs)
+			}
+		})
+	}
+}
+
+func TestServeWebSocketStreamResume(t *testing.T) {
+	tests := []struct {
+		name string
+		// query is the query of the connection, and control a message the client sends once connected.
+		query    string
+		control  *WSControlMessage
+		wantSeqs []uint64
+		wantGap  *WSGapMessage
+	}{
+		{name: "resume_seq query", query: ResumeSeqQueryParam + "=4", wantSeqs: []uint64{4, 5}},
+		{name: "resume message", control: &WSControlMessage{Type: WSMessageTypeResume, ResumeSeq: 3},
+			wantSeqs: []uint64{3, 4, 5}},
+		{name: "caught up", query: ResumeSeqQueryParam + "=6"},
+		{name: "gap from query", query: ResumeSeqQueryParam + "=1",
+			wantGap: &WSGapMessage{Type: WSMessageTypeGap, RequestedSeq: 1, OldestSeq: 3, NextSeq: 6}},
+		{name: "gap from message", control: &WSControlMessage{Type: WSMessageTypeResume, ResumeSeq: 2},
+			wantGap: &WSGapMessage{Type: WSMessageTypeGap, RequestedSeq: 2, OldestSeq: 3, NextSeq: 6}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wh := NewWebHandler("", true, "", 0)
+			wh.WSReplayBufferSize = 3
+			for height := uint64(1); height <= 5; height++ {
+				if err := wh.HandleEntryBatch(newTestEntries(1, height)); err != nil {
+					t.Fatalf("HandleEntryBatch: %v", err)
+				}
+			}
+
+			conn := dialTestStream(t, wh, tt.query)
+			if tt.control != nil {
+				if err := conn.WriteJSON(tt.control); err != nil {
+					t.Fatalf("failed to send control message: %v", err)
+				}
+			}
+
+			if tt.wantGap != nil {
+				message := readStreamMessage(t, conn)
+				gap := &WSGapMessage{Type: message.Type, RequestedSeq: message.RequestedSeq,
+					OldestSeq: message.OldestSeq, NextSeq: message.NextSeq}
+				if !reflect.DeepEqual(gap, tt.wantGap) {
+					t.Fatalf("gap = %+v, want %+v", gap, tt.wantGap)
+				}
+			}
+			for _, wantSeq := range tt.wantSeqs {
+				if message := readStreamMessage(t, conn); message.Type != WSMessageTypeBatch || message.Seq != wantSeq {
+					t.Fatalf("got %s message with seq %d, want batch %d", message.Type, message.Seq, wantSeq)
+				}
+			}
+
+			// The replayed batches are followed by the live ones.
+			if err := wh.HandleEntryBatch(newTestEntries(1, 6)); err != nil {
+				t.Fatalf("HandleEntryBatch: %v", err)
+			}
+			if message := readStreamMessage(t, conn); message.Type != WSMessageTypeBatch || message.Seq != 6 {
+				t.Fatalf("got %s message with seq %d, want live batch 6", message.Type, message.Seq)
+			}
+		})
+	}
+}
+
+func TestSendBatchOverWebSocketRetriesWithSameSeq(t *testing.T) {
+	server := newTestServer(t)
+	wh := NewWebHandler("", true, closedWSURL(t), 0)
+	wh.WSReplayBufferSize = 10
+	conn := dialTestStream(t, wh, "")
+
+	// The batch is sequenced and broadcast on its first attempt, even though WSURL can't be reached, and not again
+	// on its retries.
+	batch := newTestEntries(2, 10)
+	for attempt := 1; attempt <= 2; attempt++ {
+		if err := wh.sendBatchOnce(batch); err == nil {
+			t.Fatalf("attempt %d: expected an error dialing WSURL", attempt)
+		}
+	}
+	if message := readStreamMessage(t, conn); message.Seq != 1 {
+		t.Fatalf("subscriber got seq %d, want 1", message.Seq)
+	}
+
+	// Once WSURL is reachable, the batch is written to it with the seq it was broadcast with.
+	wh.WSURL = server.WSURL()
+	if err := wh.sendBatchOnce(batch); err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if err := wh.sendBatchOnce(newTestEntries(1, 11)); err != nil {
+		t.Fatalf("next batch: %v", err)
+	}
+	waitFor(t, "batches at WSURL", func() bool { return len(server.Batches()) == 2 })
+
+	for ii, batch := range server.Batches() {
+		if wantSeq := uint64(ii + 1); batch.Seq != wantSeq {
+			t.Errorf("WSURL batch %d has seq %d, want %d", ii, batch.Seq, wantSeq)
+		}
+	}
+	if message := readStreamMessage(t, conn); message.Seq != 2 {
+		t.Errorf("subscriber got seq %d after the retries, want 2", message.Seq)
+	}
+	if err := wh.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func TestSendBatchOverWebSocketGivesUpOnPendingBatch(t *testing.T) {
+	server := newTestServer(t)
+	wh := NewWebHandler("", true, closedWSURL(t), 0)
+	wh.WSReplayBufferSize = 10
+
+	if err := wh.sendBatchOnce(newTestEntries(1, 10)); err == nil {
+		t.Fatal("expected an error dialing WSURL")
+	}
+	// A different batch replaces the one that couldn't be written, which is never written to WSURL.
+	wh.WSURL = server.WSURL()
+	if err := wh.sendBatchOnce(newTestEntries(1, 11)); err != nil {
+		t.Fatalf("next batch: %v", err)
+	}
+	waitFor(t, "batch at WSURL", func() bool { return len(server.Batches()) == 1 })
+	if batch := server.Batches()[0]; batch.Seq != 2 || batch.Entries[0]["BlockHeight"] != json.Number("11") {
+		t.Errorf("WSURL got seq %d at height %v, want seq 2 at height 11", batch.Seq, batch.Entries[0]["BlockHeight"])
+	}
+	if err := wh.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
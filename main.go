@@ -1,7 +1,15 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/deso-protocol/core/lib"
 	"github.com/deso-protocol/postgres-data-handler/handler"
@@ -14,6 +22,10 @@ import (
 	"gopkg.in/DataDog/dd-trace-go.v1/profiler"
 )
 
+// selfTest makes the process send a synthetic batch through the configured handler, report whether it was
+// delivered and exit, instead of syncing.
+var selfTest = flag.Bool("selftest", false, "send a synthetic batch through the configured handler and exit")
+
 func main() {
 	// Initialize flags and get config values.
 	setupFlags()
@@ -86,21 +98,187 @@ func main() {
 
 	// Create the WebHandler with your desired transport settings and minimum block height.
 	// For HTTP transport:
-	webHandler := handler.NewWebHandler("https://nftz-deso-front-martijnvanhalen-nftzzone.vercel.app/api/webhandler", false, "", minBlockHeight)
+	transportTimeouts := handler.TransportTimeouts{
+		DialTimeout:           viper.GetDuration("DIAL_TIMEOUT"),
+		TLSHandshakeTimeout:   viper.GetDuration("TLS_HANDSHAKE_TIMEOUT"),
+		ResponseHeaderTimeout: viper.GetDuration("RESPONSE_HEADER_TIMEOUT"),
+	}
+	webHandler := handler.NewWebHandler("https://nftz-deso-front-martijnvanhalen-nftzzone.vercel.app/api/webhandler", false, "", minBlockHeight,
+		handler.WithTransportTimeouts(transportTimeouts))
 	// For WebSocket, set useWebSocket to true and provide the WS URL:
 	// webHandler := handler.NewWebHandler("", true, "wss://your-ws-endpoint.example.com/stream", minBlockHeight)
+	webHandler.Params = params
+	webHandler.NetworkPrefix = viper.GetString("NETWORK_PREFIX")
+	webHandler.WSReplayBufferSize = viper.GetInt("WS_REPLAY_BUFFER_SIZE")
+	webHandler.WSWriteTimeout = viper.GetDuration("WS_WRITE_TIMEOUT")
+	// For WebSocket with the HTTP endpoint as a fallback, set WS_URL and WS_MAX_RECONNECT_ATTEMPTS.
+	if wsURL := viper.GetString("WS_URL"); wsURL != "" {
+		webHandler.UseWebSocket = true
+		webHandler.WSURL = wsURL
+	}
+	webHandler.WSMaxReconnectAttempts = viper.GetInt("WS_MAX_RECONNECT_ATTEMPTS")
+	webHandler.WSRestoreInterval = viper.GetDuration("WS_RESTORE_INTERVAL")
+	webHandler.RedactFields = getStringList("REDACT_FIELDS")
+	webHandler.PassthroughFields = getStringList("PASSTHROUGH_FIELDS")
+	webHandler.ProjectFields = getStringList("PROJECT_FIELDS")
+	webHandler.PatchCacheSize = viper.GetInt("PATCH_CACHE_SIZE")
+	webHandler.EnumFormat = viper.GetString("ENUM_FORMAT")
+	webHandler.FieldNameStyle = viper.GetString("FIELD_NAME_STYLE")
+	webHandler.WrapWithOpMetadata = viper.GetBool("WRAP_WITH_OP_METADATA")
+	webHandler.SeqStatePath = viper.GetString("SEQ_STATE_PATH")
+	// Replay the state changes from the backfill height before following live state changes, if configured.
+	if backfillFromHeight := viper.GetUint64("BACKFILL_FROM_HEIGHT"); backfillFromHeight > 0 {
+		webHandler.BackfillFromHeight = backfillFromHeight
+		consumerProgressDir = handler.BackfillProgressDir(consumerProgressDir, backfillFromHeight)
+		glog.Infof("Backfilling from height %d with consumer progress in %s", backfillFromHeight, consumerProgressDir)
+	}
+	webHandler.FanOutURLs = getStringList("FAN_OUT_URLS")
+	webHandler.FanOutQueueSize = viper.GetInt("FAN_OUT_QUEUE_SIZE")
+	webHandler.EndpointQueryParams = getStringMap("ENDPOINT_QUERY_PARAMS")
+	webHandler.MaxArrayItems = viper.GetInt("MAX_ARRAY_ITEMS")
+	webHandler.MaxRequestBytes = viper.GetInt("MAX_REQUEST_BYTES")
+	webHandler.MaxPayloadBytes = viper.GetInt("MAX_PAYLOAD_BYTES")
+	webHandler.OversizeEntryPolicy = viper.GetString("OVERSIZE_ENTRY_POLICY")
+	// Bound the whole round trip of every HTTP POST, if configured.
+	if requestTimeout := viper.GetDuration("REQUEST_TIMEOUT"); requestTimeout > 0 {
+		webHandler.PerBatchTimeout = func(int) time.Duration { return requestTimeout }
+	}
+	webHandler.AuthToken = viper.GetString("AUTH_TOKEN")
+	if signingSecret := viper.GetString("SIGNING_SECRET"); signingSecret != "" {
+		webHandler.SigningSecret = []byte(signingSecret)
+	}
+	webHandler.MaxRequestsPerSecond = viper.GetFloat64("MAX_REQUESTS_PER_SECOND")
+	webHandler.Burst = viper.GetInt("RATE_LIMIT_BURST")
+	webHandler.FailureThreshold = viper.GetInt("CIRCUIT_FAILURE_THRESHOLD")
+	webHandler.OpenDuration = viper.GetDuration("CIRCUIT_OPEN_DURATION")
+	webHandler.MaxRetries = viper.GetInt("MAX_RETRIES")
+	webHandler.BaseBackoff = viper.GetDuration("BASE_BACKOFF")
+	webHandler.CompressPayloads = viper.GetBool("COMPRESS_PAYLOADS")
+	webHandler.CompressionEnabled = viper.GetBool("COMPRESSION_ENABLED")
+	webHandler.CompressionAlgorithm = viper.GetString("COMPRESSION_ALGORITHM")
+	webHandler.CompressionThreshold = viper.GetInt("COMPRESSION_THRESHOLD")
+	webHandler.ShardCount = viper.GetUint32("SHARD_COUNT")
+	webHandler.ShardIndex = viper.GetUint32("SHARD_INDEX")
+	webHandler.MaxMemoryBytes = viper.GetInt64("MAX_MEMORY_BYTES")
+	webHandler.MemoryShedPolicy = viper.GetString("MEMORY_SHED_POLICY")
+	webHandler.SpillDir = viper.GetString("SPILL_DIR")
+	webHandler.WarmShutdown = viper.GetBool("WARM_SHUTDOWN")
+	webHandler.WarmShutdownTimeout = viper.GetDuration("WARM_SHUTDOWN_TIMEOUT")
+	webHandler.CoalesceWindow = viper.GetDuration("COALESCE_WINDOW")
+	webHandler.PauseMode = viper.GetString("PAUSE_MODE")
+	webHandler.PauseBufferSize = viper.GetInt("PAUSE_BUFFER_SIZE")
+	webHandler.BatchTTL = viper.GetDuration("BATCH_TTL")
+	webHandler.DeliverySemantics = viper.GetString("DELIVERY_SEMANTICS")
+	webHandler.MaxDeliveryAttempts = viper.GetInt("MAX_DELIVERY_ATTEMPTS")
+	webHandler.DeadLetterDir = viper.GetString("DEAD_LETTER_DIR")
+	webHandler.RetryQueueSize = viper.GetInt("RETRY_QUEUE_SIZE")
+	webHandler.DropOnFull = viper.GetBool("RETRY_QUEUE_DROP_ON_FULL")
+	glog.Infof("Web handler delivery semantics: %s", webHandler.Status().DeliverySemantics)
+	if validationSchemaPath := viper.GetString("VALIDATION_SCHEMA_PATH"); validationSchemaPath != "" {
+		validationSchema, err := handler.LoadJSONSchema(validationSchemaPath)
+		if err != nil {
+			glog.Fatalf("Error loading validation schema: %v", err)
+		}
+		webHandler.ValidationSchema = validationSchema
+		webHandler.ValidationPolicy = viper.GetString("VALIDATION_POLICY")
+	}
+
+	// Serve the WebSocket stream to connecting clients, if configured.
+	if wsListenAddr := viper.GetString("WS_LISTEN_ADDR"); wsListenAddr != "" {
+		go func() {
+			glog.Fatal(webHandler.ListenAndServeWebSocket(wsListenAddr))
+		}()
+	}
+
+	// Start the internal HTTP servers. Each one is disabled when its address is empty.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	err := handler.StartInternalServers(ctx,
+		handler.InternalServer{Name: "metrics", Addr: viper.GetString("METRICS_ADDR"), Handler: webHandler.MetricsHandler()},
+		handler.InternalServer{Name: "health", Addr: viper.GetString("HEALTH_ADDR"), Handler: webHandler.HealthHandler()},
+		handler.InternalServer{Name: "admin", Addr: viper.GetString("ADMIN_ADDR"), Handler: webHandler.AdminHandler()},
+	)
+	if err != nil {
+		glog.Fatal(err)
+	}
+
+	// Keep the endpoint from flagging the stream as dead during quiet periods, if configured.
+	webHandler.HeartbeatInterval = viper.GetDuration("HEARTBEAT_INTERVAL")
+	webHandler.HeartbeatAlways = viper.GetBool("HEARTBEAT_ALWAYS")
+	go webHandler.RunHeartbeat(ctx)
+
+	// Abort the sends still in flight once the shutdown has had SHUTDOWN_TIMEOUT to flush them.
+	sendCtx, cancelSends := context.WithCancel(context.Background())
+	webHandler.SetContext(sendCtx)
+	shutdownTimeout := viper.GetDuration("SHUTDOWN_TIMEOUT")
+	if shutdownTimeout == 0 {
+		shutdownTimeout = 10 * time.Second
+	}
+
+	// Send to the web handler unless another handler type is configured.
+	var dataHandler handler.DataHandler = webHandler
+	if handlerType := viper.GetString("HANDLER_TYPE"); handlerType != "" && handlerType != handler.HandlerTypeWeb {
+		dataHandler, err = handler.NewDataHandler(handlerType, params, viper.GetString)
+		if err != nil {
+			glog.Fatal(err)
+		}
+	}
+
+	// Flush the WebSocket stream, close the other handler, if any, and exit once a shutdown signal is received.
+	go func() {
+		<-ctx.Done()
+		time.AfterFunc(shutdownTimeout, cancelSends)
+		if err := webHandler.Close(); err != nil {
+			glog.Error(err)
+		}
+		if closer, ok := dataHandler.(io.Closer); ok && dataHandler != webHandler {
+			if err := closer.Close(); err != nil {
+				glog.Error(err)
+			}
+		}
+		glog.Flush()
+		os.Exit(0)
+	}()
+
+	// Check that the handler can deliver before syncing, if requested. The batch must clear both the web handler's
+	// and the other handlers' minimum block height.
+	if *selfTest {
+		result := handler.RunSelfTest(dataHandler, max(minBlockHeight, viper.GetUint64("MIN_BLOCK_HEIGHT")))
+		if result.Err != nil {
+			glog.Errorf("Self-test FAILED after %v: %v", result.Duration, result.Err)
+			glog.Flush()
+			os.Exit(1)
+		}
+		glog.Infof("Self-test PASSED: delivered %d entries in %v", result.EntryCount, result.Duration)
+		glog.Flush()
+		os.Exit(0)
+	}
+
+	// Wait for the state change directory, which may be on a network mount that is temporarily unavailable. Setting
+	// STATE_CHANGE_DIR_RETRIES to 0 checks it once, without retrying.
+	stateChangeDirRetries := 5
+	if viper.IsSet("STATE_CHANGE_DIR_RETRIES") {
+		stateChangeDirRetries = viper.GetInt("STATE_CHANGE_DIR_RETRIES")
+	}
+	stateChangeDirRetryBackoff := viper.GetDuration("STATE_CHANGE_DIR_RETRY_BACKOFF")
+	if stateChangeDirRetryBackoff == 0 {
+		stateChangeDirRetryBackoff = 2 * time.Second
+	}
+	if err = waitForDir(stateChangeDir, stateChangeDirRetries, stateChangeDirRetryBackoff); err != nil {
+		glog.Fatal(err)
+	}
 
 	// ... state change directory, consumer progress directory, batch bytes, thread limit, syncMempool, etc. ...
-	// Pass webHandler to the consumer.
+	// Pass the data handler to the consumer.
 	stateSyncerConsumer := &consumer.StateSyncerConsumer{}
-	err := stateSyncerConsumer.InitializeAndRun(
+	err = stateSyncerConsumer.InitializeAndRun(
 		stateChangeDir,
 		consumerProgressDir,
 		batchBytes,
 		threadLimit,
 		syncMempool,
 
-		webHandler,
+		dataHandler,
 	)
 
 	if err != nil {
@@ -168,3 +346,53 @@ func getConfigValues() (stateChangeDir string, consumerProgressDir string, batch
 
 	return stateChangeDir, consumerProgressDir, batchBytes, threadLimit, logQueries, explorerStatistics, datadogProfiler, isTestnet, isRegtest, isAcceleratedRegtest, syncMempool
 }
+
+// getStringList returns the comma separated config value as a list, ignoring empty items.
+func getStringList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(viper.GetString(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// getStringMap returns the comma separated name=value pairs of the config value as a map, ignoring items without a
+// name.
+func getStringMap(key string) map[string]string {
+	values := make(map[string]string)
+	for _, item := range getStringList(key) {
+		name, value, _ := strings.Cut(item, "=")
+		if name = strings.TrimSpace(name); name != "" {
+			values[name] = strings.TrimSpace(value)
+		}
+	}
+	return values
+}
+
+// waitForDir checks that dir is a readable directory, retrying up to retries times, with a backoff that starts at
+// backoff and doubles after each attempt, before giving up.
+func waitForDir(dir string, retries int, backoff time.Duration) error {
+	err := checkDirReadable(dir)
+	for attempt := 1; err != nil && attempt <= retries; attempt++ {
+		glog.Errorf("waitForDir: %v, retrying in %v (%d of %d)", err, backoff, attempt, retries)
+		time.Sleep(backoff)
+		backoff *= 2
+		err = checkDirReadable(dir)
+	}
+	return err
+}
+
+// checkDirReadable returns an error if dir doesn't exist, isn't a directory or can't be listed.
+func checkDirReadable(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("checkDirReadable: failed to open %s: %v", dir, err)
+	}
+	defer file.Close()
+	if _, err = file.Readdirnames(1); err != nil && err != io.EOF {
+		return fmt.Errorf("checkDirReadable: failed to read %s: %v", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWaitForDir(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup prepares the path before waitForDir is called.
+		setup func(t *testing.T, path string)
+		// appearAfter, when non-zero, creates the directory that long after waitForDir is called.
+		appearAfter time.Duration
+		retries     int
+		wantErr     string
+	}{
+		{name: "present", setup: func(t *testing.T, path string) {
+			if err := os.Mkdir(path, 0755); err != nil {
+				t.Fatalf("failed to create directory: %v", err)
+			}
+		}},
+		{name: "appears before the retries run out", appearAfter: 50 * time.Millisecond, retries: 10},
+		{name: "never appears", retries: 3, wantErr: "failed to open"},
+		{name: "missing without retries", wantErr: "failed to open"},
+		{name: "not a directory", retries: 1, wantErr: "failed to read", setup: func(t *testing.T, path string) {
+			if err := os.WriteFile(path, []byte("state"), 0644); err != nil {
+				t.Fatalf("failed to create file: %v", err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "state-changes")
+			if tt.setup != nil {
+				tt.setup(t, path)
+			}
+			if tt.appearAfter > 0 {
+				appeared := time.AfterFunc(tt.appearAfter, func() { os.Mkdir(path, 0755) })
+				defer appeared.Stop()
+			}
+
+			err := waitForDir(path, tt.retries, 5*time.Millisecond)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("waitForDir = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("waitForDir = %v, want an error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWaitForDirBacksOff(t *testing.T) {
+	// Three retries wait 10, 20 and 40 milliseconds before giving up.
+	startTime := time.Now()
+	if err := waitForDir(filepath.Join(t.TempDir(), "missing"), 3, 10*time.Millisecond); err == nil {
+		t.Fatal("expected an error for a directory that never appears")
+	}
+	if elapsed := time.Since(startTime); elapsed < 70*time.Millisecond {
+		t.Errorf("waitForDir gave up after %s, before its 70ms of backoff", elapsed)
+	}
+}
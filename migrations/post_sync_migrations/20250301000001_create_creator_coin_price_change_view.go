@@ -0,0 +1,70 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// The price 24h ago is derived from the net DESO locked diff of the creator coin transactions in the window.
+// Since coins in circulation scale with the cube root of DESO locked on the bonding curve, the price ratio
+// between now and 24h ago is (deso_locked_now / deso_locked_then) ^ (2/3). Coins with no trades in the
+// window have a null price change.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_creator_coin_price_change_1_d AS
+			with locked_diffs as (
+				select base64_to_base58(txn_meta ->> 'ProfilePublicKey')                as public_key,
+					   sum((tx_index_metadata ->> 'DESOLockedNanosDiff')::BIGINT) as deso_locked_nanos_diff,
+					   count(*)                                                   as trade_count
+				from transaction_partition_11
+				where timestamp > NOW() - INTERVAL '1 day'
+				  and tx_index_metadata ? 'DESOLockedNanosDiff'
+				group by base64_to_base58(txn_meta ->> 'ProfilePublicKey')
+			),
+			price_changes as (
+				select pe.public_key,
+					   pe.username,
+					   pe.coin_price_deso_nanos                                    as current_price_deso_nanos,
+					   coalesce(ld.trade_count, 0)                                 as trade_count_1_d,
+					   case
+						   when ld.public_key is null then null
+						   when pe.deso_locked_nanos - ld.deso_locked_nanos_diff <= 0 then null
+						   else (power(pe.deso_locked_nanos::NUMERIC /
+									   (pe.deso_locked_nanos - ld.deso_locked_nanos_diff)::NUMERIC,
+									   2.0 / 3) - 1) * 100
+						   end                                                     as price_change_percent_1_d
+				from profile_entry pe
+				left join locked_diffs ld on ld.public_key = pe.public_key
+				where pe.cc_coins_in_circulation_nanos > 0
+			)
+			select price_changes.*,
+				   row_number() OVER (order by price_change_percent_1_d desc nulls last, public_key) as id
+			from price_changes;
+
+			CREATE UNIQUE INDEX statistic_creator_coin_price_change_1_d_unique_index ON statistic_creator_coin_price_change_1_d (public_key);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_creator_coin_price_change_1_d;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,71 @@
+package post_sync_migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/uptrace/bun"
+)
+
+var dexTvlDashboardStatistics = []dashboardStatistic{
+	{View: "statistic_dex_tvl", Column: "tvl_deso_nanos", Alias: "dex_tvl_deso_nanos"},
+}
+
+// statistic_dex_tvl values the coins committed to every open DAO coin limit order in DESO nanos.
+// Asks (operation_type 1) commit their quantity of the selling coin, while bids (operation_type 2) commit
+// quantity * exchange rate of the selling coin. The numeric quantity and exchange rate columns are derived from
+// their hex representations by hex_to_numeric. Orders selling DESO are valued directly, and orders selling a DAO
+// coin are valued at the coin's DESO market price from dao_coin_limit_order_bid_asks.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, fmt.Sprintf(`
+			CREATE MATERIALIZED VIEW statistic_dex_tvl AS
+			with committed_orders as (
+				select selling_dao_coin_creator_pkid,
+					   case
+						   when operation_type = 1 then quantity_to_fill_in_base_units_numeric
+						   else quantity_to_fill_in_base_units_numeric *
+								scaled_exchange_rate_coins_to_sell_per_coin_to_buy_numeric / 1e38
+						   end as selling_quantity
+				from dao_coin_limit_order_entry
+			)
+			select coalesce(sum(case
+						   when co.selling_dao_coin_creator_pkid = 'BC1YLbnP7rndL92x7DbLp6bkUpCgKmgoHgz7xEbwhgHTps3ZrXA6LtQ'
+							   then co.selling_quantity
+						   else co.selling_quantity * bid_asks.market_price / 1e9
+						   end), 0) as tvl_deso_nanos,
+				   count(*) as open_order_count,
+				   0 as id
+			from committed_orders co
+			left join dao_coin_limit_order_bid_asks bid_asks
+				on co.selling_dao_coin_creator_pkid = bid_asks.selling_creator_pkid
+				and bid_asks.buying_creator_pkid = 'BC1YLbnP7rndL92x7DbLp6bkUpCgKmgoHgz7xEbwhgHTps3ZrXA6LtQ';
+
+			CREATE UNIQUE INDEX statistic_dex_tvl_unique_index ON statistic_dex_tvl (id);
+			%v
+		`, buildStatisticDashboardView(dexTvlDashboardStatistics...)))
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(fmt.Sprintf(`
+			DROP VIEW IF EXISTS statistic_dashboard;
+			DROP MATERIALIZED VIEW IF EXISTS statistic_dex_tvl;
+			%v
+		`, buildStatisticDashboardView()))
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
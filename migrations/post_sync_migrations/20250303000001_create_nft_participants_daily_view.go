@@ -0,0 +1,66 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// NFT sales happen either through an accepted bid (transaction_partition_17), where the seller sends the
+// transaction and the buyer is recorded as the NFTBidderPublicKeyBase58Check affected public key, or through a
+// buy-now bid (transaction_partition_18), where the buyer sends the transaction and the seller is the NFT's
+// owner. NFT transfers are not sales, so their participants are not counted.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_nft_participants_daily AS
+			with nft_sales as (
+				select date_trunc('day', txn.timestamp) as day,
+					   apk.public_key                    as buyer_public_key,
+					   txn.public_key                    as seller_public_key
+				from transaction_partition_17 txn
+				join affected_public_key apk
+					on txn.transaction_hash = apk.transaction_hash
+				where apk.metadata = 'NFTBidderPublicKeyBase58Check'
+				  and txn.timestamp > NOW() - INTERVAL '30 days'
+				union all
+				select date_trunc('day', timestamp)                  as day,
+					   public_key                                    as buyer_public_key,
+					   tx_index_metadata ->> 'OwnerPublicKeyBase58Check' as seller_public_key
+				from transaction_partition_18
+				where tx_index_metadata ->> 'IsBuyNowBid' = 'true'
+				  and timestamp > NOW() - INTERVAL '30 days'
+			)
+			select day,
+				   count(distinct buyer_public_key)  as unique_buyers,
+				   count(distinct seller_public_key) as unique_sellers,
+				   count(*)                          as sale_count,
+				   row_number() OVER (order by day)  as id
+			from nft_sales
+			group by day;
+
+			CREATE UNIQUE INDEX statistic_nft_participants_daily_unique_index ON statistic_nft_participants_daily (day);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_nft_participants_daily;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
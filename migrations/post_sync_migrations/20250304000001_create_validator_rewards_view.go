@@ -0,0 +1,60 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Block producer identity comes from the outputs of the block reward transaction (txn type 1) at the start of
+// every block. The block reward transaction has no sender, and its outputs pay the block reward, including
+// the block's transaction fees, to the public key of the miner under PoW or the block proposer under PoS.
+// Staking rewards paid to validators and their stakers are tracked separately in stake_reward.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_validator_rewards_30_d AS
+			with block_rewards as (
+				select output ->> 'public_key'                  as public_key,
+					   sum((output ->> 'amount_nanos')::BIGINT) as reward_nanos,
+					   count(distinct t.block_height)           as block_count
+				from transaction_partition_01 t,
+					 jsonb_array_elements(t.outputs) as output
+				where t.timestamp > NOW() - INTERVAL '30 days'
+				group by output ->> 'public_key'
+				order by reward_nanos desc
+				limit 100
+			)
+			select br.public_key,
+				   pe.username,
+				   br.reward_nanos,
+				   br.block_count,
+				   row_number() OVER (order by br.reward_nanos desc, br.public_key) as id
+			from block_rewards br
+			left join profile_entry pe on pe.public_key = br.public_key;
+
+			CREATE UNIQUE INDEX statistic_validator_rewards_30_d_unique_index ON statistic_validator_rewards_30_d (public_key);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_validator_rewards_30_d;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,60 @@
+package post_sync_migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/uptrace/bun"
+)
+
+var churnedWalletsDashboardStatistics = append(append([]dashboardStatistic{}, dexTvlDashboardStatistics...),
+	dashboardStatistic{View: "statistic_churned_wallets_30_d", Column: "count", Alias: "churned_wallets_30_d"},
+)
+
+// A wallet has churned if it sent a transaction in the 30 to 60 day window but none in the last 30 days.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, fmt.Sprintf(`
+			CREATE MATERIALIZED VIEW statistic_churned_wallets_30_d AS
+			with wallet_activity as (
+				select t.public_key,
+					   bool_or(b.timestamp > NOW() - INTERVAL '30 days') as active_recently
+				from transaction_partitioned t
+				join block b
+				on t.block_hash = b.block_hash
+				where b.timestamp > NOW() - INTERVAL '60 days'
+				  and t.public_key is not null
+				group by t.public_key
+			)
+			select count(*), 0 as id
+			from wallet_activity
+			where not active_recently;
+
+			CREATE UNIQUE INDEX statistic_churned_wallets_30_d_unique_index ON statistic_churned_wallets_30_d (id);
+			%v
+		`, buildStatisticDashboardView(churnedWalletsDashboardStatistics...)))
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(fmt.Sprintf(`
+			DROP VIEW IF EXISTS statistic_dashboard;
+			DROP MATERIALIZED VIEW IF EXISTS statistic_churned_wallets_30_d;
+			%v
+		`, buildStatisticDashboardView(dexTvlDashboardStatistics...)))
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,57 @@
+package post_sync_migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/uptrace/bun"
+)
+
+var txnsPerActiveWalletDashboardStatistics = append(append([]dashboardStatistic{}, churnedWalletsDashboardStatistics...),
+	dashboardStatistic{View: "statistic_txns_per_active_wallet_30_d", Column: "avg", Alias: "txns_per_active_wallet_30_d"},
+)
+
+// The average is taken over the wallets that sent at least one transaction in the last 30 days, so it measures
+// how active the active wallets are rather than how many wallets are active.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, fmt.Sprintf(`
+			CREATE MATERIALIZED VIEW statistic_txns_per_active_wallet_30_d AS
+			select coalesce(count(*)::NUMERIC / nullif(count(distinct t.public_key), 0), 0) as avg,
+				   count(*)                                                                  as txn_count,
+				   count(distinct t.public_key)                                              as active_wallet_count,
+				   0                                                                         as id
+			from transaction_partitioned t
+			join block b
+			on t.block_hash = b.block_hash
+			where b.timestamp > NOW() - INTERVAL '30 days'
+			  and t.public_key is not null;
+
+			CREATE UNIQUE INDEX statistic_txns_per_active_wallet_30_d_unique_index ON statistic_txns_per_active_wallet_30_d (id);
+			%v
+		`, buildStatisticDashboardView(txnsPerActiveWalletDashboardStatistics...)))
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(fmt.Sprintf(`
+			DROP VIEW IF EXISTS statistic_dashboard;
+			DROP MATERIALIZED VIEW IF EXISTS statistic_txns_per_active_wallet_30_d;
+			%v
+		`, buildStatisticDashboardView(churnedWalletsDashboardStatistics...)))
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
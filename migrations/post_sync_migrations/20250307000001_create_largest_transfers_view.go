@@ -0,0 +1,82 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Basic transfers are txn type 2 and live in transaction_partition_02. The amount of a transfer is the sum of its
+// outputs to public keys other than the sender, which excludes the change output back to the sender, and the
+// receiver is the public key of the largest of those outputs.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_largest_transfers_7_d AS
+			with transfer_outputs as (
+				select t.transaction_hash,
+					   t.public_key                             as sender_public_key,
+					   output ->> 'public_key'                  as receiver_public_key,
+					   (output ->> 'amount_nanos')::BIGINT      as amount_nanos,
+					   t.block_height,
+					   t.timestamp
+				from transaction_partition_02 t,
+					 jsonb_array_elements(t.outputs) as output
+				where t.timestamp > NOW() - INTERVAL '7 days'
+				  and output ->> 'public_key' != t.public_key
+			),
+			transfers as (
+				select distinct on (transaction_hash)
+					   transaction_hash,
+					   sender_public_key,
+					   receiver_public_key,
+					   sum(amount_nanos) OVER (partition by transaction_hash) as amount_nanos,
+					   block_height,
+					   timestamp
+				from transfer_outputs
+				order by transaction_hash, amount_nanos desc
+			),
+			largest_transfers as (
+				select *
+				from transfers
+				order by amount_nanos desc
+				limit 100
+			)
+			select lt.transaction_hash,
+				   lt.sender_public_key,
+				   sender.username                                                   as sender_username,
+				   lt.receiver_public_key,
+				   receiver.username                                                 as receiver_username,
+				   lt.amount_nanos,
+				   lt.block_height,
+				   lt.timestamp,
+				   row_number() OVER (order by lt.amount_nanos desc, lt.transaction_hash) as id
+			from largest_transfers lt
+			left join profile_entry sender on sender.public_key = lt.sender_public_key
+			left join profile_entry receiver on receiver.public_key = lt.receiver_public_key;
+
+			CREATE UNIQUE INDEX statistic_largest_transfers_7_d_unique_index ON statistic_largest_transfers_7_d (transaction_hash);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_largest_transfers_7_d;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,54 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Diamonds are basic transfers (transaction_partition_02) whose tx index metadata has a DiamondLevel. Every level
+// from 1 to 6 gets a row, even if no diamonds of that level were given in the window.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_diamond_level_distribution_30_d AS
+			with diamond_counts as (
+				select (tx_index_metadata ->> 'DiamondLevel')::INT as diamond_level,
+					   count(*)                                     as diamond_count
+				from transaction_partition_02
+				where timestamp > NOW() - INTERVAL '30 days'
+				  and tx_index_metadata ->> 'DiamondLevel' is not null
+				  and (tx_index_metadata ->> 'DiamondLevel')::INT > 0
+				group by (tx_index_metadata ->> 'DiamondLevel')::INT
+			)
+			select levels.diamond_level,
+				   coalesce(dc.diamond_count, 0)                        as diamond_count,
+				   row_number() OVER (order by levels.diamond_level)    as id
+			from generate_series(1, 6) as levels(diamond_level)
+			left join diamond_counts dc on dc.diamond_level = levels.diamond_level;
+
+			CREATE UNIQUE INDEX statistic_diamond_level_distribution_30_d_unique_index ON statistic_diamond_level_distribution_30_d (diamond_level);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_diamond_level_distribution_30_d;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
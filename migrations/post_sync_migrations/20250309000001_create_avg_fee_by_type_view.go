@@ -0,0 +1,48 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Zero-fee transactions, such as block rewards and the inner transactions of atomic transactions, are excluded
+// so that they don't drag down the average fee of their type.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_avg_fee_by_type_1_d AS
+			select txn_type,
+				   avg(fee_nanos)                        as avg_fee_nanos,
+				   count(*)                              as txn_count,
+				   row_number() OVER (order by txn_type) as id
+			from transaction_partitioned
+			where timestamp > NOW() - INTERVAL '1 day'
+			  and fee_nanos > 0
+			group by txn_type;
+
+			CREATE UNIQUE INDEX statistic_avg_fee_by_type_1_d_unique_index ON statistic_avg_fee_by_type_1_d (txn_type);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_avg_fee_by_type_1_d;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,59 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Every hour of every day of the week gets a row, even if there were no transactions in it, so the view always
+// has 7 * 24 = 168 rows. Days of the week follow Postgres' dow, where 0 is Sunday.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_activity_heatmap AS
+			with activity as (
+				select extract(dow from b.timestamp)::INT  as day_of_week,
+					   extract(hour from b.timestamp)::INT as hour_of_day,
+					   count(*)                            as txn_count
+				from transaction_partitioned t
+				join block b
+				on t.block_hash = b.block_hash
+				where b.timestamp > NOW() - INTERVAL '30 days'
+				group by extract(dow from b.timestamp)::INT, extract(hour from b.timestamp)::INT
+			)
+			select days.day_of_week,
+				   hours.hour_of_day,
+				   coalesce(a.txn_count, 0)                                     as txn_count,
+				   row_number() OVER (order by days.day_of_week, hours.hour_of_day) as id
+			from generate_series(0, 6) as days(day_of_week)
+			cross join generate_series(0, 23) as hours(hour_of_day)
+			left join activity a
+				on a.day_of_week = days.day_of_week
+				and a.hour_of_day = hours.hour_of_day;
+
+			CREATE UNIQUE INDEX statistic_activity_heatmap_unique_index ON statistic_activity_heatmap (day_of_week, hour_of_day);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_activity_heatmap;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
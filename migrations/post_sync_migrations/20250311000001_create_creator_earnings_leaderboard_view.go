@@ -0,0 +1,112 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// statistic_creator_earnings_leaderboard sums three sources of earnings over the last 30 days, all in DESO nanos:
+//   - Creator coin royalties: the part of a buy's DeSoToSellNanos that isn't locked in the bonding curve, as in
+//     statistic_profile_cc_royalties.
+//   - NFT sales: the bid amount of accepted bids and buy-now bids, credited to the seller, as in
+//     statistic_profile_nft_bid_sales and statistic_profile_nft_buy_now_sales. Secondary sales are credited to
+//     the reseller rather than the original creator, and NFT royalties are not included.
+//   - Diamonds received: each diamond level is valued at its nominal DESO amount, as in
+//     statistic_profile_diamond_earnings, rather than at the USD based amount that was actually transferred.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_creator_earnings_leaderboard AS
+			with cc_earnings as (
+				select base64_to_base58(txn_meta ->> 'ProfilePublicKey')          as public_key,
+					   sum((tx_index_metadata ->> 'DeSoToSellNanos')::BIGINT -
+						   (tx_index_metadata ->> 'DESOLockedNanosDiff')::BIGINT) as earnings_nanos
+				from transaction_partition_11
+				where timestamp > NOW() - INTERVAL '30 days'
+				  and tx_index_metadata ->> 'OperationType' = 'buy'
+				  and (tx_index_metadata ->> 'DeSoToSellNanos')::BIGINT > (tx_index_metadata ->> 'DESOLockedNanosDiff')::BIGINT
+				group by base64_to_base58(txn_meta ->> 'ProfilePublicKey')
+			),
+			nft_earnings as (
+				select public_key, sum(earnings_nanos) as earnings_nanos
+				from (
+					select public_key,
+						   (tx_index_metadata ->> 'BidAmountNanos')::BIGINT as earnings_nanos
+					from transaction_partition_17
+					where timestamp > NOW() - INTERVAL '30 days'
+					union all
+					select tx_index_metadata ->> 'OwnerPublicKeyBase58Check' as public_key,
+						   (tx_index_metadata ->> 'BidAmountNanos')::BIGINT  as earnings_nanos
+					from transaction_partition_18
+					where timestamp > NOW() - INTERVAL '30 days'
+					  and tx_index_metadata ->> 'IsBuyNowBid' = 'true'
+				) nft_sales
+				group by public_key
+			),
+			diamond_earnings as (
+				select pe.poster_public_key as public_key,
+					   sum(case
+							   when (t.tx_index_metadata ->> 'DiamondLevel')::INT = 1 then 50000
+							   when (t.tx_index_metadata ->> 'DiamondLevel')::INT = 2 then 500000
+							   when (t.tx_index_metadata ->> 'DiamondLevel')::INT = 3 then 5000000
+							   when (t.tx_index_metadata ->> 'DiamondLevel')::INT = 4 then 50000000
+							   when (t.tx_index_metadata ->> 'DiamondLevel')::INT = 5 then 500000000
+							   when (t.tx_index_metadata ->> 'DiamondLevel')::INT = 6 then 5000000000
+							   when (t.tx_index_metadata ->> 'DiamondLevel')::INT = 7 then 50000000000
+							   when (t.tx_index_metadata ->> 'DiamondLevel')::INT = 8 then 450000000000
+							   else 0 END) as earnings_nanos
+				from transaction_partition_02 t
+				join post_entry pe on t.tx_index_metadata ->> 'PostHashHex' = pe.post_hash
+				where t.timestamp > NOW() - INTERVAL '30 days'
+				  and t.tx_index_metadata ->> 'DiamondLevel' is not null
+				group by pe.poster_public_key
+			),
+			earnings as (
+				select coalesce(cc.public_key, nft.public_key, d.public_key) as public_key,
+					   coalesce(cc.earnings_nanos, 0)                         as cc_earnings_nanos,
+					   coalesce(nft.earnings_nanos, 0)                        as nft_earnings_nanos,
+					   coalesce(d.earnings_nanos, 0)                          as diamond_earnings_nanos,
+					   coalesce(cc.earnings_nanos, 0) + coalesce(nft.earnings_nanos, 0) +
+					   coalesce(d.earnings_nanos, 0)                          as total_earnings_nanos
+				from cc_earnings cc
+				full outer join nft_earnings nft on nft.public_key = cc.public_key
+				full outer join diamond_earnings d on d.public_key = coalesce(cc.public_key, nft.public_key)
+				order by total_earnings_nanos desc
+				limit 100
+			)
+			select e.public_key,
+				   pe.username,
+				   e.cc_earnings_nanos,
+				   e.nft_earnings_nanos,
+				   e.diamond_earnings_nanos,
+				   e.total_earnings_nanos,
+				   row_number() OVER (order by e.total_earnings_nanos desc, e.public_key) as id
+			from earnings e
+			left join profile_entry pe on pe.public_key = e.public_key;
+
+			CREATE UNIQUE INDEX statistic_creator_earnings_leaderboard_unique_index ON statistic_creator_earnings_leaderboard (public_key);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_creator_earnings_leaderboard;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,46 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// statistic_pending_txn_by_type breaks statistic_txn_count_pending down by transaction type. Like it, mempool
+// transactions are the ones with an empty block hash.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_pending_txn_by_type AS
+			select txn_type,
+				   count(*)                              as count,
+				   row_number() OVER (order by txn_type) as id
+			from transaction
+			where block_hash = ''
+			group by txn_type;
+
+			CREATE UNIQUE INDEX statistic_pending_txn_by_type_unique_index ON statistic_pending_txn_by_type (txn_type);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_pending_txn_by_type;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
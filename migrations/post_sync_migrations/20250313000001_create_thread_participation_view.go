@@ -0,0 +1,85 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// A thread is a top level post from the last 30 days together with all of its replies, followed down the
+// parent_post_hash chain. Participants are the distinct public keys that replied anywhere in the thread, not
+// counting the thread's author, and threads are bucketed by their participant count. Every bucket gets a row,
+// even if no thread falls into it.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_thread_participation_30_d AS
+			with recursive thread_posts as (
+				select post_hash          as thread_post_hash,
+					   poster_public_key  as thread_poster_public_key,
+					   post_hash,
+					   poster_public_key
+				from post_entry
+				where parent_post_hash is null
+				  and reposted_post_hash is null
+				  and timestamp > NOW() - INTERVAL '30 days'
+				union all
+				select tp.thread_post_hash,
+					   tp.thread_poster_public_key,
+					   reply.post_hash,
+					   reply.poster_public_key
+				from post_entry reply
+				join thread_posts tp on reply.parent_post_hash = tp.post_hash
+			),
+			thread_participants as (
+				select thread_post_hash,
+					   count(distinct poster_public_key)
+					   filter (where poster_public_key != thread_poster_public_key) as participant_count
+				from thread_posts
+				group by thread_post_hash
+			),
+			buckets(bucket, min_participants, max_participants) as (
+				values ('0', 0, 0),
+					   ('1', 1, 1),
+					   ('2-5', 2, 5),
+					   ('6-10', 6, 10),
+					   ('11-25', 11, 25),
+					   ('26-50', 26, 50),
+					   ('51+', 51, null)
+			)
+			select b.bucket,
+				   b.min_participants,
+				   b.max_participants,
+				   count(tp.thread_post_hash)                      as thread_count,
+				   row_number() OVER (order by b.min_participants) as id
+			from buckets b
+			left join thread_participants tp
+				on tp.participant_count >= b.min_participants
+				and (b.max_participants is null or tp.participant_count <= b.max_participants)
+			group by b.bucket, b.min_participants, b.max_participants;
+
+			CREATE UNIQUE INDEX statistic_thread_participation_30_d_unique_index ON statistic_thread_participation_30_d (bucket);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_thread_participation_30_d;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,66 @@
+package post_sync_migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/uptrace/bun"
+)
+
+var creatorCoinLockupDashboardStatistics = append(append([]dashboardStatistic{}, txnsPerActiveWalletDashboardStatistics...),
+	dashboardStatistic{View: "statistic_creator_coin_lockup", Column: "locked_base_units", Alias: "creator_coin_locked_base_units"},
+	dashboardStatistic{View: "statistic_creator_coin_lockup", Column: "unlocked_base_units", Alias: "creator_coin_unlocked_base_units"},
+)
+
+// Lockups apply to the creator's DAO coin, so the supply in circulation is the sum of every profile's DAO coins in
+// circulation. Locking coins moves them from a balance entry into a locked balance entry without changing the
+// supply, so the unlocked supply is the supply in circulation minus the locked balances. Locked DESO, whose
+// profile is the zero PKID, is excluded.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, fmt.Sprintf(`
+			CREATE MATERIALIZED VIEW statistic_creator_coin_lockup AS
+			with circulating_supply as (
+				select coalesce(sum(hex_to_numeric(dao_coins_in_circulation_nanos_hex)), 0) as base_units
+				from profile_entry
+			),
+			locked_supply as (
+				select coalesce(sum(balance_base_units), 0) as base_units
+				from locked_balance_entry
+				where profile_pkid != 'BC1YLbnP7rndL92x7DbLp6bkUpCgKmgoHgz7xEbwhgHTps3ZrXA6LtQ'
+			)
+			select circulating_supply.base_units                             as circulating_base_units,
+				   locked_supply.base_units                                  as locked_base_units,
+				   greatest(circulating_supply.base_units - locked_supply.base_units, 0) as unlocked_base_units,
+				   0                                                         as id
+			from circulating_supply
+			cross join locked_supply;
+
+			CREATE UNIQUE INDEX statistic_creator_coin_lockup_unique_index ON statistic_creator_coin_lockup (id);
+			%v
+		`, buildStatisticDashboardView(creatorCoinLockupDashboardStatistics...)))
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(fmt.Sprintf(`
+			DROP VIEW IF EXISTS statistic_dashboard;
+			DROP MATERIALIZED VIEW IF EXISTS statistic_creator_coin_lockup;
+			%v
+		`, buildStatisticDashboardView(txnsPerActiveWalletDashboardStatistics...)))
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
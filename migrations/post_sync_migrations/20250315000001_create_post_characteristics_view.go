@@ -0,0 +1,58 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Posts include comments but exclude hidden posts and plain reposts, which have no body of their own. A post has
+// an image or video if its image_urls or video_urls are non-empty, and embedded videos in the EmbedVideoURL extra
+// data are counted as videos too.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_post_characteristics_30_d AS
+			with posts as (
+				select coalesce(char_length(body), 0)                              as body_length,
+					   coalesce(cardinality(image_urls), 0) > 0                    as has_image,
+					   coalesce(cardinality(video_urls), 0) > 0
+						   or coalesce(extra_data ->> 'EmbedVideoURL', '') != '' as has_video
+				from post_entry
+				where timestamp > NOW() - INTERVAL '30 days'
+				  and not coalesce(is_hidden, false)
+				  and (reposted_post_hash is null or is_quoted_repost)
+			)
+			select count(*)                                                                     as post_count,
+				   coalesce(avg(body_length), 0)                                                as avg_body_length,
+				   coalesce(avg(case when has_image then 1 else 0 end), 0)                      as image_rate,
+				   coalesce(avg(case when has_video then 1 else 0 end), 0)                      as video_rate,
+				   coalesce(avg(case when has_image or has_video then 1 else 0 end), 0)         as media_rate,
+				   0                                                                            as id
+			from posts;
+
+			CREATE UNIQUE INDEX statistic_post_characteristics_30_d_unique_index ON statistic_post_characteristics_30_d (id);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_post_characteristics_30_d;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,60 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// A poster is new on a day if their first ever post, comment or quote repost was made in the 7 days up to and
+// including that day, and returning otherwise.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_new_vs_returning_posters_daily AS
+			with first_posts as (
+				select poster_public_key, min(timestamp) as first_post_timestamp
+				from post_entry
+				where reposted_post_hash is null or is_quoted_repost
+				group by poster_public_key
+			),
+			daily_posters as (
+				select distinct date_trunc('day', timestamp) as day, poster_public_key
+				from post_entry
+				where timestamp > NOW() - INTERVAL '30 days'
+				  and (reposted_post_hash is null or is_quoted_repost)
+			)
+			select dp.day,
+				   count(*) filter (where fp.first_post_timestamp >= dp.day - INTERVAL '6 days') as new_poster_count,
+				   count(*) filter (where fp.first_post_timestamp < dp.day - INTERVAL '6 days')  as returning_poster_count,
+				   count(*)                                                                       as poster_count,
+				   row_number() OVER (order by dp.day)                                            as id
+			from daily_posters dp
+			join first_posts fp on fp.poster_public_key = dp.poster_public_key
+			group by dp.day;
+
+			CREATE UNIQUE INDEX statistic_new_vs_returning_posters_daily_unique_index ON statistic_new_vs_returning_posters_daily (day);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_new_vs_returning_posters_daily;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
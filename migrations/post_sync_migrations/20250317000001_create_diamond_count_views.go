@@ -0,0 +1,67 @@
+package post_sync_migrations
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/uptrace/bun"
+)
+
+var diamondCountDashboardStatistics = append(append([]dashboardStatistic{}, creatorCoinLockupDashboardStatistics...),
+	dashboardStatistic{View: "statistic_diamond_count_all", Column: "count", Alias: "diamond_count_all"},
+	dashboardStatistic{View: "statistic_diamond_count_30_d", Column: "count", Alias: "diamond_count_30_d"},
+)
+
+// Diamonds are basic transfers (transaction_partition_02) with a DiamondLevel in their tx index metadata.
+// get_transaction_count(2) estimates the size of the whole basic transfer partition rather than just the
+// diamonds, so both views count the diamond transactions directly, using the DiamondLevel index.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_diamond_count_all AS
+			select count(*) as count, 0 as id from transaction_partition_02
+			where tx_index_metadata ->> 'DiamondLevel' is not null;
+
+			CREATE UNIQUE INDEX statistic_diamond_count_all_unique_index ON statistic_diamond_count_all (id);
+		`)
+		if err != nil {
+			return err
+		}
+
+		err = RunMigrationWithRetries(db, fmt.Sprintf(`
+			CREATE MATERIALIZED VIEW statistic_diamond_count_30_d AS
+			select count(*) as count, 0 as id from transaction_partition_02 t
+			join block b
+			on t.block_hash = b.block_hash
+			where b.timestamp > NOW() - INTERVAL '30 days'
+			and t.tx_index_metadata ->> 'DiamondLevel' is not null;
+
+			CREATE UNIQUE INDEX statistic_diamond_count_30_d_unique_index ON statistic_diamond_count_30_d (id);
+			%v
+		`, buildStatisticDashboardView(diamondCountDashboardStatistics...)))
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(fmt.Sprintf(`
+			DROP VIEW IF EXISTS statistic_dashboard;
+			DROP MATERIALIZED VIEW IF EXISTS statistic_diamond_count_30_d;
+			DROP MATERIALIZED VIEW IF EXISTS statistic_diamond_count_all;
+			%v
+		`, buildStatisticDashboardView(creatorCoinLockupDashboardStatistics...)))
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,58 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Holders are the distinct PKIDs with a non-zero creator coin balance (is_dao_coin = false) in a creator's coin.
+// The creator's own holding is not counted.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_creator_coin_holder_leaderboard AS
+			with holder_counts as (
+				select creator_pkid,
+					   count(distinct hodler_pkid) as holder_count
+				from balance_entry
+				where not is_dao_coin
+				  and balance_nanos > 0
+				  and hodler_pkid != creator_pkid
+				group by creator_pkid
+				order by holder_count desc
+				limit 100
+			)
+			select hc.creator_pkid,
+				   pe.public_key,
+				   pe.username,
+				   hc.holder_count,
+				   row_number() OVER (order by hc.holder_count desc, hc.creator_pkid) as id
+			from holder_counts hc
+			left join profile_entry pe on pe.pkid = hc.creator_pkid;
+
+			CREATE UNIQUE INDEX statistic_creator_coin_holder_leaderboard_unique_index ON statistic_creator_coin_holder_leaderboard (creator_pkid);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_creator_coin_holder_leaderboard;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
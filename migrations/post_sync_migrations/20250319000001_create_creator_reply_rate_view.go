@@ -0,0 +1,72 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// The reply rate is the fraction of comments made on a creator's posts in the last 30 days, by other users, that
+// the creator replied to directly. Only creators with at least 10 such comments are ranked.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_creator_reply_rate_30_d AS
+			with comments as (
+				select parent.poster_public_key as creator_public_key,
+					   comment.post_hash,
+					   exists (
+						   select 1
+						   from post_entry reply
+						   where reply.parent_post_hash = comment.post_hash
+							 and reply.poster_public_key = parent.poster_public_key
+					   ) as replied
+				from post_entry comment
+				join post_entry parent on parent.post_hash = comment.parent_post_hash
+				where comment.timestamp > now() - interval '30 days'
+				  and comment.poster_public_key != parent.poster_public_key
+			), reply_rates as (
+				select creator_public_key,
+					   count(*) as comment_count,
+					   count(*) filter (where replied) as replied_count,
+					   count(*) filter (where replied)::numeric / count(*) as reply_rate
+				from comments
+				group by creator_public_key
+				having count(*) >= 10
+				order by reply_rate desc, comment_count desc
+				limit 100
+			)
+			select rr.creator_public_key,
+				   pe.username,
+				   rr.comment_count,
+				   rr.replied_count,
+				   rr.reply_rate,
+				   row_number() OVER (order by rr.reply_rate desc, rr.comment_count desc, rr.creator_public_key) as id
+			from reply_rates rr
+			left join profile_entry pe on pe.public_key = rr.creator_public_key;
+
+			CREATE UNIQUE INDEX statistic_creator_reply_rate_30_d_unique_index ON statistic_creator_reply_rate_30_d (creator_public_key);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_creator_reply_rate_30_d;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
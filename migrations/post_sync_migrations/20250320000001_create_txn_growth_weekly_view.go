@@ -0,0 +1,65 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Growth is computed over the last 12 complete weeks, so that the partial current week doesn't read as a decline.
+// The first week has no previous week and its growth is null, as is the growth of a week following a week with no
+// transactions.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_txn_growth_weekly AS
+			with weekly_counts as (
+				select date_trunc('week', b.timestamp) as week,
+					   count(*)                        as txn_count
+				from transaction_partitioned t
+				join block b
+				on t.block_hash = b.block_hash
+				where b.timestamp >= date_trunc('week', now()) - interval '12 weeks'
+				  and b.timestamp < date_trunc('week', now())
+				group by date_trunc('week', b.timestamp)
+			), weeks as (
+				select weeks.week,
+					   coalesce(wc.txn_count, 0) as txn_count
+				from generate_series(date_trunc('week', now()) - interval '12 weeks',
+									 date_trunc('week', now()) - interval '1 week',
+									 interval '1 week') as weeks(week)
+				left join weekly_counts wc on wc.week = weeks.week
+			)
+			select week,
+				   txn_count,
+				   lag(txn_count) OVER (order by week) as previous_txn_count,
+				   round((txn_count - lag(txn_count) OVER (order by week)) * 100.0 /
+						 nullif(lag(txn_count) OVER (order by week), 0), 2) as growth_percent,
+				   row_number() OVER (order by week) as id
+			from weeks;
+
+			CREATE UNIQUE INDEX statistic_txn_growth_weekly_unique_index ON statistic_txn_growth_weekly (week);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_txn_growth_weekly;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,81 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Royalties are read from the NFTRoyaltiesMetadata of accepted bids and buy-now bids, as in
+// statistic_profile_nft_bid_royalty_earnings and statistic_profile_nft_buy_now_royalty_earnings, and are in DESO
+// nanos. Both the creator royalty and additional DESO royalties are credited to their recipients. Creator coin
+// royalties are not included, since they are added to the creator's coin rather than paid to the creator.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_nft_royalty_earnings_30_d AS
+			with nft_sales as (
+				select tx_index_metadata -> 'NFTRoyaltiesMetadata' as royalties
+				from transaction_partition_17
+				where timestamp > NOW() - INTERVAL '30 days'
+				union all
+				select tx_index_metadata -> 'NFTRoyaltiesMetadata' as royalties
+				from transaction_partition_18
+				where timestamp > NOW() - INTERVAL '30 days'
+				  and tx_index_metadata ->> 'IsBuyNowBid' = 'true'
+			), royalty_payouts as (
+				select royalties ->> 'CreatorPublicKeyBase58Check' as public_key,
+					   (royalties ->> 'CreatorRoyaltyNanos')::BIGINT as royalty_nanos,
+					   0::BIGINT                                     as additional_royalty_nanos
+				from nft_sales
+				where (royalties ->> 'CreatorRoyaltyNanos')::BIGINT > 0
+				union all
+				select additional_royalties.key           as public_key,
+					   0::BIGINT                          as royalty_nanos,
+					   additional_royalties.value::BIGINT as additional_royalty_nanos
+				from nft_sales,
+					 jsonb_each_text(royalties -> 'AdditionalDESORoyaltiesMap') as additional_royalties
+			), royalty_earnings as (
+				select public_key,
+					   sum(royalty_nanos)                            as royalty_nanos,
+					   sum(additional_royalty_nanos)                 as additional_royalty_nanos,
+					   sum(royalty_nanos + additional_royalty_nanos) as total_royalty_nanos
+				from royalty_payouts
+				group by public_key
+				order by total_royalty_nanos desc
+				limit 100
+			)
+			select re.public_key,
+				   pe.username,
+				   re.royalty_nanos,
+				   re.additional_royalty_nanos,
+				   re.total_royalty_nanos,
+				   row_number() OVER (order by re.total_royalty_nanos desc, re.public_key) as id
+			from royalty_earnings re
+			left join profile_entry pe on pe.public_key = re.public_key;
+
+			CREATE UNIQUE INDEX statistic_nft_royalty_earnings_30_d_unique_index ON statistic_nft_royalty_earnings_30_d (public_key);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_nft_royalty_earnings_30_d;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,55 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// A wallet's age is the time since its first transaction. Wallets are bucketed by age, and every bucket gets a row,
+// even if no wallet falls into it.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_account_age_distribution AS
+			with buckets(bucket, min_age, max_age) as (
+				values ('<1w', interval '0', interval '1 week'),
+					   ('1w-1m', interval '1 week', interval '1 month'),
+					   ('1m-6m', interval '1 month', interval '6 months'),
+					   ('6m-1y', interval '6 months', interval '1 year'),
+					   ('1y+', interval '1 year', null)
+			)
+			select b.bucket,
+				   count(pkft.public_key)                 as wallet_count,
+				   row_number() OVER (order by b.min_age) as id
+			from buckets b
+			left join public_key_first_transaction pkft
+				on now() - pkft.timestamp >= b.min_age
+				and (b.max_age is null or now() - pkft.timestamp < b.max_age)
+			group by b.bucket, b.min_age;
+
+			CREATE UNIQUE INDEX statistic_account_age_distribution_unique_index ON statistic_account_age_distribution (bucket);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_account_age_distribution;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,49 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// A transaction is attributed to the app, or node, that submitted it through the Node key of its extra data.
+// Transactions without one are not attributed to any app.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_multi_app_users_30_d AS
+			select count(*) as count, 0 as id
+			from (
+				select public_key
+				from transaction_partitioned
+				where timestamp > NOW() - INTERVAL '30 days'
+				  and coalesce(extra_data ->> 'Node', '') != ''
+				group by public_key
+				having count(distinct extra_data ->> 'Node') > 1
+			) multi_app_users;
+
+			CREATE UNIQUE INDEX statistic_multi_app_users_30_d_unique_index ON statistic_multi_app_users_30_d (id);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_multi_app_users_30_d;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,56 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Bids are NFTBid transactions, which are in transaction_partition_18 (transaction_partition_16 holds UpdateNFT
+// transactions). Each serial number of an NFT is auctioned separately, so bids are counted per post hash and serial
+// number. Bids of zero nanos cancel a previous bid and are not counted.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_avg_bids_per_nft_30_d AS
+			with bid_counts as (
+				select tx_index_metadata ->> 'NFTPostHashHex' as nft_post_hash,
+					   tx_index_metadata ->> 'SerialNumber'   as serial_number,
+					   count(*)                               as bid_count
+				from transaction_partition_18
+				where timestamp > NOW() - INTERVAL '30 days'
+				  and (tx_index_metadata ->> 'BidAmountNanos')::BIGINT > 0
+				group by tx_index_metadata ->> 'NFTPostHashHex', tx_index_metadata ->> 'SerialNumber'
+			)
+			select coalesce(avg(bid_count), 0) as avg,
+				   coalesce(min(bid_count), 0) as min,
+				   coalesce(max(bid_count), 0) as max,
+				   count(*)                    as nft_count,
+				   0                           as id
+			from bid_counts;
+
+			CREATE UNIQUE INDEX statistic_avg_bids_per_nft_30_d_unique_index ON statistic_avg_bids_per_nft_30_d (id);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_avg_bids_per_nft_30_d;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,57 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Posts are never deleted from post_entry; a post is removed by hiding it, which sets is_hidden. Posts are bucketed
+// by the day they were created, and the removal rate of a day is the fraction of that day's posts that are hidden
+// now. Every day gets a row, even if no post was created on it.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_post_removal_rate_daily AS
+			with daily_posts as (
+				select date_trunc('day', timestamp)      as day,
+					   count(*)                          as post_count,
+					   count(*) filter (where is_hidden) as removed_count
+				from post_entry
+				where timestamp >= date_trunc('day', NOW()) - INTERVAL '29 days'
+				group by date_trunc('day', timestamp)
+			)
+			select days.day,
+				   coalesce(dp.post_count, 0)                                         as post_count,
+				   coalesce(dp.removed_count, 0)                                      as removed_count,
+				   coalesce(dp.removed_count::numeric / nullif(dp.post_count, 0), 0) as removal_rate,
+				   row_number() OVER (order by days.day)                              as id
+			from generate_series(date_trunc('day', NOW()) - INTERVAL '29 days', date_trunc('day', NOW()),
+								 INTERVAL '1 day') as days(day)
+			left join daily_posts dp on dp.day = days.day;
+
+			CREATE UNIQUE INDEX statistic_post_removal_rate_daily_unique_index ON statistic_post_removal_rate_daily (day);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_post_removal_rate_daily;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,58 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Hashtags are extracted from the bodies of posts made in the last 7 days and are compared case insensitively. A
+// hashtag used several times in the same post is counted once for that post.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_trending_hashtags_7_d AS
+			with post_hashtags as (
+				select distinct pe.post_hash,
+								lower(hashtag[1]) as hashtag
+				from post_entry pe,
+					 regexp_matches(pe.body, '#([[:alnum:]_]+)', 'g') as hashtag
+				where pe.timestamp > NOW() - INTERVAL '7 days'
+			), hashtag_counts as (
+				select hashtag,
+					   count(*) as post_count
+				from post_hashtags
+				group by hashtag
+				order by post_count desc
+				limit 100
+			)
+			select hashtag,
+				   post_count,
+				   row_number() OVER (order by post_count desc, hashtag) as id
+			from hashtag_counts;
+
+			CREATE UNIQUE INDEX statistic_trending_hashtags_7_d_unique_index ON statistic_trending_hashtags_7_d (hashtag);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_trending_hashtags_7_d;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,66 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Volume is the DESO moved by the outputs of transactions of every type to public keys other than the sender,
+// which excludes change outputs, and is attributed to the sending wallet. Shares are fractions of the total volume
+// of the last 30 days, and the top 1% and 10% are of the wallets that sent any volume, rounded up.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_volume_concentration_30_d AS
+			with wallet_volumes as (
+				select t.public_key,
+					   sum((output ->> 'amount_nanos')::NUMERIC) as volume_nanos
+				from transaction_partitioned t
+				join block b
+				on t.block_hash = b.block_hash,
+					 jsonb_array_elements(t.outputs) as output
+				where b.timestamp > NOW() - INTERVAL '30 days'
+				  and output ->> 'public_key' != t.public_key
+				group by t.public_key
+			), ranked_wallets as (
+				select volume_nanos,
+					   row_number() OVER (order by volume_nanos desc) as rank,
+					   count(*) OVER ()                               as wallet_count
+				from wallet_volumes
+			)
+			select coalesce(sum(volume_nanos), 0)                                                       as total_volume_nanos,
+				   count(*)                                                                             as wallet_count,
+				   coalesce(sum(volume_nanos) filter (where rank <= ceil(wallet_count * 0.01)), 0) /
+				   nullif(sum(volume_nanos), 0)                                                         as top_1_percent_share,
+				   coalesce(sum(volume_nanos) filter (where rank <= ceil(wallet_count * 0.1)), 0) /
+				   nullif(sum(volume_nanos), 0)                                                         as top_10_percent_share,
+				   coalesce(sum(volume_nanos) filter (where rank <= 100), 0) / nullif(sum(volume_nanos), 0) as top_100_share,
+				   0                                                                                    as id
+			from ranked_wallets;
+
+			CREATE UNIQUE INDEX statistic_volume_concentration_30_d_unique_index ON statistic_volume_concentration_30_d (id);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_volume_concentration_30_d;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
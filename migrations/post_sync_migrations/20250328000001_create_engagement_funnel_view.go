@@ -0,0 +1,80 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Views aren't recorded on chain, so the top of the funnel is the top-level posts created in the last 30 days,
+// excluding reposts. Each later stage counts those posts that received at least one of its signal, at any time
+// since they were created:
+//   - Liked: a like transaction (transaction_partition_10) that isn't an unlike.
+//   - Commented: a post in post_entry whose parent is the post.
+//   - Reposted: a post in post_entry that reposts the post, including quote reposts.
+//
+// The total number of likes, comments and reposts of those posts is included as well.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_engagement_funnel_30_d AS
+			with posts as (
+				select post_hash
+				from post_entry
+				where timestamp > NOW() - INTERVAL '30 days'
+				  and parent_post_hash is null
+				  and reposted_post_hash is null
+			), likes as (
+				select p.post_hash, count(*) as like_count
+				from posts p
+				join transaction_partition_10 t
+				on t.tx_index_metadata ->> 'PostHashHex' = p.post_hash
+				where t.tx_index_metadata ->> 'IsUnlike' = 'false'
+				group by p.post_hash
+			), comments as (
+				select p.post_hash, count(*) as comment_count
+				from posts p
+				join post_entry c
+				on c.parent_post_hash = p.post_hash
+				group by p.post_hash
+			), reposts as (
+				select p.post_hash, count(*) as repost_count
+				from posts p
+				join post_entry r
+				on r.reposted_post_hash = p.post_hash
+				group by p.post_hash
+			)
+			select (select count(*) from posts)                           as post_count,
+				   (select count(*) from likes)                           as liked_post_count,
+				   (select count(*) from comments)                        as commented_post_count,
+				   (select count(*) from reposts)                         as reposted_post_count,
+				   (select coalesce(sum(like_count), 0) from likes)       as like_count,
+				   (select coalesce(sum(comment_count), 0) from comments) as comment_count,
+				   (select coalesce(sum(repost_count), 0) from reposts)   as repost_count,
+				   0                                                      as id;
+
+			CREATE UNIQUE INDEX statistic_engagement_funnel_30_d_unique_index ON statistic_engagement_funnel_30_d (id);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_engagement_funnel_30_d;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,60 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// A user's cadence is the median interval, in seconds, between consecutive transactions they sent in the last 30
+// days. Users with a single transaction have no interval and are not counted. The view reports the median cadence
+// across users, along with its 25th and 75th percentiles.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_user_txn_cadence_30_d AS
+			with txn_intervals as (
+				select public_key,
+					   extract(epoch from timestamp - lag(timestamp) OVER (partition by public_key order by timestamp))
+						   as interval_seconds
+				from transaction_partitioned
+				where timestamp > NOW() - INTERVAL '30 days'
+			), user_cadences as (
+				select public_key,
+					   percentile_cont(0.5) within group (order by interval_seconds) as median_interval_seconds
+				from txn_intervals
+				where interval_seconds is not null
+				group by public_key
+			)
+			select count(*)                                                              as user_count,
+				   percentile_cont(0.25) within group (order by median_interval_seconds) as p25_interval_seconds,
+				   percentile_cont(0.5) within group (order by median_interval_seconds)  as median_interval_seconds,
+				   percentile_cont(0.75) within group (order by median_interval_seconds) as p75_interval_seconds,
+				   0                                                                     as id
+			from user_cadences;
+
+			CREATE UNIQUE INDEX statistic_user_txn_cadence_30_d_unique_index ON statistic_user_txn_cadence_30_d (id);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_user_txn_cadence_30_d;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
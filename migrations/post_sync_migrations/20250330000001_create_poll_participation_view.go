@@ -0,0 +1,64 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Poll votes are post associations of type POLL_RESPONSE, the convention DeSo apps use for polls: the association's
+// post is the poll, its value is the chosen option and its transactor is the voter. Votes are read from
+// post_association_entry, so votes that were later deleted are not counted, and a vote is dated by the block it
+// was created in. Only votes made in the last 30 days are counted.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_poll_participation_30_d AS
+			with poll_votes as (
+				select pae.post_hash,
+					   count(distinct pae.transactor_pkid)   as voter_count,
+					   count(*)                              as vote_count,
+					   count(distinct pae.association_value) as option_count
+				from post_association_entry pae
+				join block b
+				on b.height = pae.block_height
+				where pae.association_type = 'POLL_RESPONSE'
+				  and b.timestamp > NOW() - INTERVAL '30 days'
+				group by pae.post_hash
+				order by voter_count desc, vote_count desc
+				limit 100
+			)
+			select pv.post_hash,
+				   pe.poster_public_key,
+				   pv.voter_count,
+				   pv.vote_count,
+				   pv.option_count,
+				   row_number() OVER (order by pv.voter_count desc, pv.vote_count desc, pv.post_hash) as id
+			from poll_votes pv
+			left join post_entry pe on pe.post_hash = pv.post_hash;
+
+			CREATE UNIQUE INDEX statistic_poll_participation_30_d_unique_index ON statistic_poll_participation_30_d (post_hash);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_poll_participation_30_d;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
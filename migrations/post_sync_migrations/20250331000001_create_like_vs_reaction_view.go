@@ -0,0 +1,63 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Likes are like transactions (transaction_partition_10) that aren't unlikes, and reactions are post associations
+// of type REACTION (transaction_partition_29). Every day gets a row, even if there was no like or reaction on it.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_like_vs_reaction_daily AS
+			with daily_likes as (
+				select date_trunc('day', timestamp) as day,
+					   count(*)                     as like_count
+				from transaction_partition_10
+				where timestamp >= date_trunc('day', NOW()) - INTERVAL '29 days'
+				  and tx_index_metadata ->> 'IsUnlike' = 'false'
+				group by date_trunc('day', timestamp)
+			), daily_reactions as (
+				select date_trunc('day', timestamp) as day,
+					   count(*)                     as reaction_count
+				from transaction_partition_29
+				where timestamp >= date_trunc('day', NOW()) - INTERVAL '29 days'
+				  and tx_index_metadata ->> 'AssociationType' = 'REACTION'
+				group by date_trunc('day', timestamp)
+			)
+			select days.day,
+				   coalesce(dl.like_count, 0)            as like_count,
+				   coalesce(dr.reaction_count, 0)        as reaction_count,
+				   row_number() OVER (order by days.day) as id
+			from generate_series(date_trunc('day', NOW()) - INTERVAL '29 days', date_trunc('day', NOW()),
+								 INTERVAL '1 day') as days(day)
+			left join daily_likes dl on dl.day = days.day
+			left join daily_reactions dr on dr.day = days.day;
+
+			CREATE UNIQUE INDEX statistic_like_vs_reaction_daily_unique_index ON statistic_like_vs_reaction_daily (day);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_like_vs_reaction_daily;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,68 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Materialized views can't take parameters, so the threshold is read from the statistic_parameter table when the
+// view is refreshed. Accounts are listed if they sent more than high_rate_accounts_min_txn_count transactions in
+// the last 24 hours, which defaults to 1000 and can be changed with an UPDATE that takes effect on the next refresh.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE TABLE statistic_parameter (
+				name  VARCHAR PRIMARY KEY,
+				value NUMERIC NOT NULL
+			);
+			comment on table statistic_parameter IS E'@omit';
+
+			INSERT INTO statistic_parameter (name, value) VALUES ('high_rate_accounts_min_txn_count', 1000);
+
+			CREATE MATERIALIZED VIEW statistic_high_rate_accounts_1_d AS
+			with account_txn_counts as (
+				select public_key,
+					   count(*) as txn_count
+				from transaction_partitioned
+				where timestamp > NOW() - INTERVAL '24 hours'
+				group by public_key
+				having count(*) > (select value
+								   from statistic_parameter
+								   where name = 'high_rate_accounts_min_txn_count')
+				order by txn_count desc
+				limit 100
+			)
+			select atc.public_key,
+				   pe.username,
+				   atc.txn_count,
+				   row_number() OVER (order by atc.txn_count desc, atc.public_key) as id
+			from account_txn_counts atc
+			left join profile_entry pe on pe.public_key = atc.public_key;
+
+			CREATE UNIQUE INDEX statistic_high_rate_accounts_1_d_unique_index ON statistic_high_rate_accounts_1_d (public_key);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_high_rate_accounts_1_d;
+			DROP TABLE IF EXISTS statistic_parameter;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
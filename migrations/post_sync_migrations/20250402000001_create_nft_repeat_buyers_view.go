@@ -0,0 +1,65 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Purchases are read as in statistic_nft_participants_daily: accepted bids (transaction_partition_17), whose
+// buyer is the NFTBidderPublicKeyBase58Check affected public key, and buy-now bids (transaction_partition_18), whose
+// buyer sends the transaction. Repeat buyers made more than one purchase in the last 30 days.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_nft_repeat_buyers_30_d AS
+			with nft_purchases as (
+				select apk.public_key as buyer_public_key
+				from transaction_partition_17 txn
+				join affected_public_key apk
+					on txn.transaction_hash = apk.transaction_hash
+				where apk.metadata = 'NFTBidderPublicKeyBase58Check'
+				  and txn.timestamp > NOW() - INTERVAL '30 days'
+				union all
+				select public_key as buyer_public_key
+				from transaction_partition_18
+				where tx_index_metadata ->> 'IsBuyNowBid' = 'true'
+				  and timestamp > NOW() - INTERVAL '30 days'
+			), buyer_purchases as (
+				select buyer_public_key,
+					   count(*) as purchase_count
+				from nft_purchases
+				group by buyer_public_key
+			)
+			select count(*)                                                                 as buyer_count,
+				   count(*) filter (where purchase_count > 1)                               as repeat_buyer_count,
+				   coalesce(count(*) filter (where purchase_count > 1)::numeric / nullif(count(*), 0), 0)
+																							as repeat_buyer_ratio,
+				   0                                                                        as id
+			from buyer_purchases;
+
+			CREATE UNIQUE INDEX statistic_nft_repeat_buyers_30_d_unique_index ON statistic_nft_repeat_buyers_30_d (id);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_nft_repeat_buyers_30_d;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,55 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// A profile's founder reward is the creator_basis_points of its creator coin, where 10000 is 100%. Profiles are
+// bucketed by founder reward, and every bucket gets a row, even if no profile falls into it.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_founder_reward_distribution AS
+			with buckets(bucket, min_basis_points, max_basis_points) as (
+				values ('0%', 0, 1),
+					   ('0-10%', 1, 1001),
+					   ('10-25%', 1001, 2501),
+					   ('25-50%', 2501, 5001),
+					   ('50-100%', 5001, null)
+			)
+			select b.bucket,
+				   count(pe.pkid)                                  as profile_count,
+				   row_number() OVER (order by b.min_basis_points) as id
+			from buckets b
+			left join profile_entry pe
+				on pe.creator_basis_points >= b.min_basis_points
+				and (b.max_basis_points is null or pe.creator_basis_points < b.max_basis_points)
+			group by b.bucket, b.min_basis_points;
+
+			CREATE UNIQUE INDEX statistic_founder_reward_distribution_unique_index ON statistic_founder_reward_distribution (id);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_founder_reward_distribution;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,50 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// With the n wallet balances x_1 <= ... <= x_n, the Gini coefficient is 2 * sum(i * x_i) / (n * sum(x_i)) - (n + 1) / n,
+// from 0 when every wallet holds the same balance to almost 1 when a single wallet holds everything.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_balance_gini AS
+			with ranked_balances as (
+				select balance_nanos::numeric                   as balance_nanos,
+					   row_number() OVER (order by balance_nanos) as rank
+				from deso_balance_entry
+			)
+			select coalesce(2 * sum(rank * balance_nanos) / nullif(count(*) * sum(balance_nanos), 0)
+								- (count(*) + 1)::numeric / nullif(count(*), 0), 0) as gini_coefficient,
+				   count(*)                                                        as wallet_count,
+				   0                                                               as id
+			from ranked_balances;
+
+			CREATE UNIQUE INDEX statistic_balance_gini_unique_index ON statistic_balance_gini (id);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_balance_gini;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,62 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Diamonds are basic transfers (transaction_partition_02) with a DiamondLevel, sent to the poster of the post they
+// reference. Each sender that diamonded a creator in the last 30 days is counted once per creator, as a repeat tipper
+// if they had diamonded that creator before the last 30 days, and as a first-time tipper otherwise.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_tipper_loyalty_30_d AS
+			with diamonds as (
+				select t.public_key         as sender_public_key,
+					   pe.poster_public_key as creator_public_key,
+					   t.timestamp
+				from transaction_partition_02 t
+				join post_entry pe on t.tx_index_metadata ->> 'PostHashHex' = pe.post_hash
+				where t.tx_index_metadata ->> 'DiamondLevel' is not null
+			), tippers as (
+				select sender_public_key,
+					   creator_public_key,
+					   bool_or(timestamp <= NOW() - INTERVAL '30 days') as tipped_before
+				from diamonds
+				group by sender_public_key, creator_public_key
+				having max(timestamp) > NOW() - INTERVAL '30 days'
+			)
+			select count(*) filter (where not tipped_before)                              as first_time_tipper_count,
+				   count(*) filter (where tipped_before)                                  as repeat_tipper_count,
+				   coalesce(count(*) filter (where tipped_before)::numeric / nullif(count(*), 0), 0)
+																						  as repeat_tipper_ratio,
+				   0                                                                      as id
+			from tippers;
+
+			CREATE UNIQUE INDEX statistic_tipper_loyalty_30_d_unique_index ON statistic_tipper_loyalty_30_d (id);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_tipper_loyalty_30_d;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,76 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Users are grouped into monthly cohorts by their first transaction. A user's followers at their first post are
+// the current followers (follow_entry) whose latest follow transaction (transaction_partition_09) to the user came
+// no later than the user's first post. Followers who have since unfollowed aren't counted, since follow_entry only
+// holds current follows. Users who never posted are left out. follow_entry holds PKIDs, which differ from the public
+// keys of accounts that swapped identities, so they are mapped to public keys through the wallet table, which pairs
+// every PKID with its public key from pkid_entry.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_followers_at_first_post AS
+			with first_posts as (
+				select poster_public_key as public_key,
+					   min(timestamp)    as first_post_at
+				from post_entry
+				group by poster_public_key
+			), follow_txns as (
+				select public_key                                          as follower_public_key,
+					   base64_to_base58(txn_meta ->> 'FollowedPublicKey') as followed_public_key,
+					   max(timestamp)                                      as followed_at
+				from transaction_partition_09
+				where coalesce((txn_meta ->> 'IsUnfollow')::BOOLEAN, false) = false
+				group by public_key, base64_to_base58(txn_meta ->> 'FollowedPublicKey')
+			), followers_at_first_post as (
+				select fp.public_key,
+					   count(ft.follower_public_key) as follower_count
+				from first_posts fp
+				left join wallet followed on followed.public_key = fp.public_key
+				left join follow_entry fe on fe.followed_pkid = followed.pkid
+				left join wallet follower on follower.pkid = fe.follower_pkid
+				left join follow_txns ft
+					on ft.follower_public_key = follower.public_key
+					and ft.followed_public_key = fp.public_key
+					and ft.followed_at <= fp.first_post_at
+				group by fp.public_key
+			)
+			select date_trunc('month', pkft.timestamp)                              as cohort_month,
+				   count(*)                                                         as user_count,
+				   avg(f.follower_count)                                            as avg_follower_count,
+				   row_number() OVER (order by date_trunc('month', pkft.timestamp)) as id
+			from followers_at_first_post f
+			join public_key_first_transaction pkft on pkft.public_key = f.public_key
+			group by date_trunc('month', pkft.timestamp);
+
+			CREATE UNIQUE INDEX statistic_followers_at_first_post_unique_index ON statistic_followers_at_first_post (cohort_month);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_followers_at_first_post;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
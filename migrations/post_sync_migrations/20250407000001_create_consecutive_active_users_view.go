@@ -0,0 +1,77 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// A user's streak is the number of consecutive complete months, counting back from the last one, in which they sent
+// a transaction. Each row counts the users whose streak is at least months long, for every length up to
+// consecutive_active_users_months in the statistic_parameter table, which defaults to 12.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			INSERT INTO statistic_parameter (name, value) VALUES ('consecutive_active_users_months', 12);
+
+			CREATE MATERIALIZED VIEW statistic_consecutive_active_users AS
+			with params as (
+				select value::INT as month_count
+				from statistic_parameter
+				where name = 'consecutive_active_users_months'
+			), months as (
+				select date_trunc('month', NOW()) - make_interval(months => months_ago) as month,
+					   months_ago
+				from params, generate_series(1, params.month_count) as months_ago
+			), active_months as (
+				select distinct public_key,
+								date_trunc('month', timestamp) as month
+				from transaction_partitioned
+				where timestamp >= (select min(month) from months)
+				  and timestamp < date_trunc('month', NOW())
+			), ranked_months as (
+				select am.public_key,
+					   m.months_ago,
+					   row_number() OVER (partition by am.public_key order by m.months_ago) as month_rank
+				from active_months am
+				join months m on m.month = am.month
+			), streaks as (
+				-- The months of a streak are the ones active since the last complete month without a gap.
+				select public_key,
+					   count(*) filter (where month_rank = months_ago) as streak_length
+				from ranked_months
+				group by public_key
+			)
+			select lengths.months,
+				   count(s.public_key)                         as user_count,
+				   row_number() OVER (order by lengths.months) as id
+			from (select generate_series(1, month_count) as months from params) lengths
+			left join streaks s on s.streak_length >= lengths.months
+			group by lengths.months;
+
+			CREATE UNIQUE INDEX statistic_consecutive_active_users_unique_index ON statistic_consecutive_active_users (id);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_consecutive_active_users;
+			DELETE FROM statistic_parameter WHERE name = 'consecutive_active_users_months';
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
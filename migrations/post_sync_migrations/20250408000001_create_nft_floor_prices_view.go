@@ -0,0 +1,62 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// A collection is the set of serial numbers of an NFT post. Its floor price is the lowest price a serial number is
+// currently listed at: its buy-now price if it can be bought now, and its minimum bid otherwise. Collections with no
+// serial number for sale have a null floor price.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_nft_floor_prices AS
+			with collections as (
+				select nft_post_hash,
+					   count(*)                            as serial_count,
+					   count(*) filter (where is_for_sale) as for_sale_count,
+					   min(case
+							   when is_buy_now then buy_now_price_nanos
+							   else min_bid_amount_nanos
+						   end) filter (where is_for_sale) as floor_price_nanos
+				from nft_entry
+				group by nft_post_hash
+			)
+			select c.nft_post_hash,
+				   pe.poster_public_key,
+				   pr.username,
+				   c.serial_count,
+				   c.for_sale_count,
+				   c.floor_price_nanos,
+				   row_number() OVER (order by c.floor_price_nanos nulls last, c.nft_post_hash) as id
+			from collections c
+			left join post_entry pe on pe.post_hash = c.nft_post_hash
+			left join profile_entry pr on pr.public_key = pe.poster_public_key;
+
+			CREATE UNIQUE INDEX statistic_nft_floor_prices_unique_index ON statistic_nft_floor_prices (nft_post_hash);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_nft_floor_prices;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
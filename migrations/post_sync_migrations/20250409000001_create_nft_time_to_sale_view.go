@@ -0,0 +1,63 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// An NFT is minted by a create NFT transaction (transaction_partition_15) and sold through an accepted bid
+// (transaction_partition_17). Each serial number whose first accepted bid was in the last 30 days counts once, with
+// the time from the mint of its post to that bid.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_nft_time_to_sale_30_d AS
+			with mints as (
+				select tx_index_metadata ->> 'NFTPostHashHex' as nft_post_hash,
+					   min(timestamp)                          as minted_at
+				from transaction_partition_15
+				group by tx_index_metadata ->> 'NFTPostHashHex'
+			), first_sales as (
+				select tx_index_metadata ->> 'NFTPostHashHex' as nft_post_hash,
+					   tx_index_metadata ->> 'SerialNumber'   as serial_number,
+					   min(timestamp)                          as sold_at
+				from transaction_partition_17
+				group by tx_index_metadata ->> 'NFTPostHashHex', tx_index_metadata ->> 'SerialNumber'
+				having min(timestamp) > NOW() - INTERVAL '30 days'
+			), times_to_sale as (
+				select fs.sold_at - m.minted_at as time_to_sale
+				from first_sales fs
+				join mints m on m.nft_post_hash = fs.nft_post_hash
+			)
+			select count(*)                                                  as sale_count,
+				   avg(time_to_sale)                                         as avg_time_to_sale,
+				   percentile_cont(0.5) WITHIN GROUP (order by time_to_sale) as median_time_to_sale,
+				   0                                                         as id
+			from times_to_sale;
+
+			CREATE UNIQUE INDEX statistic_nft_time_to_sale_30_d_unique_index ON statistic_nft_time_to_sale_30_d (id);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_nft_time_to_sale_30_d;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
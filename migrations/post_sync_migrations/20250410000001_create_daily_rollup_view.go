@@ -0,0 +1,99 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// statistic_daily_rollup has a row for each of the last 90 days, with the daily metrics BI tools most often need
+// side by side. The per-day views, such as statistic_txn_count_daily, only cover the last month, so each metric is
+// computed here with the same definition:
+//   - Transactions, new wallets and active wallets, as in statistic_txn_count_daily,
+//     statistic_new_wallet_count_daily and statistic_active_wallet_count_daily.
+//   - Posts, which exclude comments, reposts and blog posts, as in statistic_post_count.
+//   - Fees, the sum of transaction fees.
+//   - NFT volume, the bid amounts of accepted bids and buy-now bids, as in statistic_creator_earnings_leaderboard.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_daily_rollup AS
+			with days as (
+				select generate_series(current_date - 89, current_date, interval '1 day')::DATE as day
+			), txn_stats as (
+				select DATE(timestamp)             as day,
+					   count(*)                    as transaction_count,
+					   coalesce(sum(fee_nanos), 0) as fee_nanos,
+					   count(distinct public_key)  as active_wallet_count
+				from transaction_partitioned
+				where timestamp >= current_date - 89
+				group by DATE(timestamp)
+			), new_wallets as (
+				select DATE(timestamp) as day,
+					   count(*)        as new_wallet_count
+				from public_key_first_transaction
+				where timestamp >= current_date - 89
+				group by DATE(timestamp)
+			), posts as (
+				select DATE(timestamp) as day,
+					   count(*)        as post_count
+				from post_entry
+				where timestamp >= current_date - 89
+				  and parent_post_hash is null
+				  and reposted_post_hash is null
+				  and NOT (extra_data ? 'BlogDeltaRtfFormat')
+				group by DATE(timestamp)
+			), nft_sales as (
+				select DATE(timestamp)                                       as day,
+					   sum((tx_index_metadata ->> 'BidAmountNanos')::BIGINT) as nft_volume_nanos
+				from (
+					select timestamp, tx_index_metadata
+					from transaction_partition_17
+					where timestamp >= current_date - 89
+					union all
+					select timestamp, tx_index_metadata
+					from transaction_partition_18
+					where timestamp >= current_date - 89
+					  and tx_index_metadata ->> 'IsBuyNowBid' = 'true'
+				) sales
+				group by DATE(timestamp)
+			)
+			select d.day,
+				   coalesce(t.transaction_count, 0)   as transaction_count,
+				   coalesce(nw.new_wallet_count, 0)   as new_wallet_count,
+				   coalesce(t.active_wallet_count, 0) as active_wallet_count,
+				   coalesce(p.post_count, 0)          as post_count,
+				   coalesce(t.fee_nanos, 0)           as fee_nanos,
+				   coalesce(n.nft_volume_nanos, 0)    as nft_volume_nanos,
+				   row_number() OVER (order by d.day) as id
+			from days d
+			left join txn_stats t on t.day = d.day
+			left join new_wallets nw on nw.day = d.day
+			left join posts p on p.day = d.day
+			left join nft_sales n on n.day = d.day;
+
+			CREATE UNIQUE INDEX statistic_daily_rollup_unique_index ON statistic_daily_rollup (day);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_daily_rollup;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
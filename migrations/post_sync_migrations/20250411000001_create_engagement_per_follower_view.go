@@ -0,0 +1,86 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// A creator's engagement is the sum of the likes, comments, diamonds and reposts their posts received in the last 30
+// days, as counted by the statistic_social_leaderboard_* views, and is divided by their current number of
+// followers. Creators with fewer than engagement_per_follower_min_followers followers in the statistic_parameter
+// table, which defaults to 100, are left out, since a handful of followers makes the ratio meaningless. Followers
+// are counted by the PKID they follow in follow_entry, which is mapped to the creator's public key through wallet.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			INSERT INTO statistic_parameter (name, value) VALUES ('engagement_per_follower_min_followers', 100);
+
+			CREATE MATERIALIZED VIEW statistic_engagement_per_follower_leaderboard_30_d AS
+			with engagement as (
+				select poster_public_key,
+					   sum(count) as engagement_count
+				from (
+					select count, poster_public_key from statistic_social_leaderboard_likes
+					union all
+					select count, poster_public_key from statistic_social_leaderboard_comments
+					union all
+					select count, poster_public_key from statistic_social_leaderboard_diamonds
+					union all
+					select count, poster_public_key from statistic_social_leaderboard_reposts
+				) interactions
+				group by poster_public_key
+			), followers as (
+				select w.public_key,
+					   count(*) as follower_count
+				from follow_entry fe
+				join wallet w on w.pkid = fe.followed_pkid
+				group by w.public_key
+				having count(*) >= (select value
+									from statistic_parameter
+									where name = 'engagement_per_follower_min_followers')
+			), leaderboard as (
+				select e.poster_public_key,
+					   e.engagement_count,
+					   f.follower_count,
+					   e.engagement_count::numeric / f.follower_count as engagement_per_follower
+				from engagement e
+				join followers f on f.public_key = e.poster_public_key
+				order by engagement_per_follower desc, e.poster_public_key
+				limit 100
+			)
+			select l.poster_public_key,
+				   pe.username,
+				   l.engagement_count,
+				   l.follower_count,
+				   l.engagement_per_follower,
+				   row_number() OVER (order by l.engagement_per_follower desc, l.poster_public_key) as id
+			from leaderboard l
+			left join profile_entry pe on pe.public_key = l.poster_public_key;
+
+			CREATE UNIQUE INDEX statistic_engagement_per_follower_leaderboard_30_d_unique_index ON statistic_engagement_per_follower_leaderboard_30_d (poster_public_key);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_engagement_per_follower_leaderboard_30_d;
+			DELETE FROM statistic_parameter WHERE name = 'engagement_per_follower_min_followers';
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,60 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Every post_entry created in the last 30 days counts as a post, including comments and reposts. The top 1% is the
+// ceiling of 1% of the posters, so it holds at least one poster as soon as anyone has posted.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_posting_concentration_30_d AS
+			with poster_counts as (
+				select poster_public_key,
+					   count(*) as post_count
+				from post_entry
+				where timestamp > NOW() - INTERVAL '30 days'
+				group by poster_public_key
+			), ranked_posters as (
+				select post_count,
+					   row_number() OVER (order by post_count desc, poster_public_key) as rank,
+					   count(*) OVER ()                                                as poster_count
+				from poster_counts
+			)
+			select coalesce(sum(post_count), 0)                                            as post_count,
+				   count(*)                                                                as poster_count,
+				   coalesce(sum(post_count) filter (where rank <= ceil(poster_count * 0.01)), 0)::numeric
+					   / nullif(sum(post_count), 0)                                        as top_1_percent_share,
+				   coalesce(sum(post_count) filter (where rank <= 100), 0)::numeric
+					   / nullif(sum(post_count), 0)                                        as top_100_share,
+				   0                                                                       as id
+			from ranked_posters;
+
+			CREATE UNIQUE INDEX statistic_posting_concentration_30_d_unique_index ON statistic_posting_concentration_30_d (id);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_posting_concentration_30_d;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,71 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Diamonds are basic transfers (transaction_partition_02) with a DiamondLevel. Each level is valued at the same DESO
+// amount as in statistic_profile_diamond_earnings and statistic_creator_earnings_leaderboard: level 1 is 50,000 nanos,
+// every level up to 7 is worth ten times the one before, and level 8 is 450,000,000,000 nanos. A sender that upgrades
+// their diamond on a post only pays the difference with the level they had given it before, so that is the value
+// counted for the upgrade.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_diamond_value_30_d AS
+			with diamond_values (diamond_level, value_nanos) as (
+				values (1, 50000::bigint),
+					   (2, 500000::bigint),
+					   (3, 5000000::bigint),
+					   (4, 50000000::bigint),
+					   (5, 500000000::bigint),
+					   (6, 5000000000::bigint),
+					   (7, 50000000000::bigint),
+					   (8, 450000000000::bigint)
+			), diamonds as (
+				select (tx_index_metadata ->> 'DiamondLevel')::INT as diamond_level,
+					   coalesce(max((tx_index_metadata ->> 'DiamondLevel')::INT) OVER (
+						   partition by public_key, tx_index_metadata ->> 'PostHashHex'
+						   order by timestamp
+						   rows between unbounded preceding and 1 preceding), 0) as previous_level,
+					   timestamp
+				from transaction_partition_02
+				where tx_index_metadata ->> 'DiamondLevel' is not null
+				  and (tx_index_metadata ->> 'DiamondLevel')::INT > 0
+			)
+			select coalesce(sum(greatest(dv.value_nanos - coalesce(pv.value_nanos, 0), 0)), 0)      as total_value_nanos,
+				   coalesce(sum(greatest(dv.value_nanos - coalesce(pv.value_nanos, 0), 0)), 0) / 1e9 as total_value_deso,
+				   count(*)                                                                      as diamond_count,
+				   0                                                                             as id
+			from diamonds d
+			join diamond_values dv on dv.diamond_level = d.diamond_level
+			left join diamond_values pv on pv.diamond_level = d.previous_level
+			where d.timestamp > NOW() - INTERVAL '30 days';
+
+			CREATE UNIQUE INDEX statistic_diamond_value_30_d_unique_index ON statistic_diamond_value_30_d (id);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_diamond_value_30_d;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
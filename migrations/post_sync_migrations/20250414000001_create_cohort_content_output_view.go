@@ -0,0 +1,57 @@
+package post_sync_migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Users are grouped into weekly cohorts by their first transaction. Each row is the number of posts the users of a
+// cohort made in the given week since the cohort's week, where week 0 is the cohort's week itself. Weeks in which a
+// cohort made no posts have no row.
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+
+		err := RunMigrationWithRetries(db, `
+			CREATE MATERIALIZED VIEW statistic_cohort_content_output AS
+			with cohort_posts as (
+				select date_trunc('week', pkft.timestamp) as cohort_week,
+					   (date_trunc('week', pe.timestamp)::date - date_trunc('week', pkft.timestamp)::date) / 7
+														  as week_offset,
+					   pe.poster_public_key
+				from post_entry pe
+				join public_key_first_transaction pkft on pkft.public_key = pe.poster_public_key
+			)
+			select cohort_week,
+				   week_offset,
+				   count(*)                                              as post_count,
+				   count(distinct poster_public_key)                     as poster_count,
+				   row_number() OVER (order by cohort_week, week_offset) as id
+			from cohort_posts
+			where week_offset >= 0
+			group by cohort_week, week_offset;
+
+			CREATE UNIQUE INDEX statistic_cohort_content_output_unique_index ON statistic_cohort_content_output (cohort_week, week_offset);
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		if !calculateExplorerStatistics {
+			return nil
+		}
+		_, err := db.Exec(`
+			DROP MATERIALIZED VIEW IF EXISTS statistic_cohort_content_output;
+		`)
+		if err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/uptrace/bun"
 	"math"
+	"strings"
 	"time"
 )
 
@@ -76,6 +77,52 @@ var (
 		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY staking_summary", Ticker: time.NewTicker(1 * time.Minute)},
 		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY my_stake_summary", Ticker: time.NewTicker(1 * time.Minute)},
 		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY validator_stats", Ticker: time.NewTicker(1 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_creator_coin_price_change_1_d", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_dex_tvl", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_nft_participants_daily", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_validator_rewards_30_d", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_churned_wallets_30_d", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_txns_per_active_wallet_30_d", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_largest_transfers_7_d", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_diamond_level_distribution_30_d", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_avg_fee_by_type_1_d", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_activity_heatmap", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_creator_earnings_leaderboard", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_pending_txn_by_type", Ticker: time.NewTicker(2 * time.Second)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_thread_participation_30_d", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_creator_coin_lockup", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_post_characteristics_30_d", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_new_vs_returning_posters_daily", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_diamond_count_all", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_diamond_count_30_d", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_creator_coin_holder_leaderboard", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_creator_reply_rate_30_d", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_txn_growth_weekly", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_nft_royalty_earnings_30_d", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_account_age_distribution", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_multi_app_users_30_d", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_avg_bids_per_nft_30_d", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_post_removal_rate_daily", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_trending_hashtags_7_d", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_volume_concentration_30_d", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_engagement_funnel_30_d", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_user_txn_cadence_30_d", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_poll_participation_30_d", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_like_vs_reaction_daily", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_high_rate_accounts_1_d", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_nft_repeat_buyers_30_d", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_founder_reward_distribution", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_balance_gini", Ticker: time.NewTicker(1 * time.Hour)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_tipper_loyalty_30_d", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_followers_at_first_post", Ticker: time.NewTicker(1 * time.Hour)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_consecutive_active_users", Ticker: time.NewTicker(3 * time.Hour)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_nft_floor_prices", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_nft_time_to_sale_30_d", Ticker: time.NewTicker(15 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_daily_rollup", Ticker: time.NewTicker(1 * time.Hour)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_engagement_per_follower_leaderboard_30_d", Ticker: time.NewTicker(30 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_posting_concentration_30_d", Ticker: time.NewTicker(30 * time.Minute)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_diamond_value_30_d", Ticker: time.NewTicker(1 * time.Hour)},
+		{Query: "REFRESH MATERIALIZED VIEW CONCURRENTLY statistic_cohort_content_output", Ticker: time.NewTicker(3 * time.Hour)},
 	}
 )
 
@@ -129,3 +176,60 @@ func RefreshExplorerStatistics(db *bun.DB) {
 	// Wait indefinitely.
 	select {}
 }
+
+// dashboardStatistic is a column of a single row statistic view that is exposed on statistic_dashboard.
+type dashboardStatistic struct {
+	View   string
+	Column string
+	Alias  string
+}
+
+// baseDashboardStatistics are the columns of statistic_dashboard as created by buildStatisticsView.
+var baseDashboardStatistics = []dashboardStatistic{
+	{View: "statistic_txn_count_all", Column: "count", Alias: "txn_count_all"},
+	{View: "statistic_txn_count_30_d", Column: "count", Alias: "txn_count_30_d"},
+	{View: "statistic_wallet_count_all", Column: "count", Alias: "wallet_count_all"},
+	{View: "statistic_active_wallet_count_30_d", Column: "count", Alias: "active_wallet_count_30_d"},
+	{View: "statistic_new_wallet_count_30_d", Column: "count", Alias: "new_wallet_count_30_d"},
+	{View: "statistic_block_height_current", Column: "height", Alias: "block_height_current"},
+	{View: "statistic_txn_count_pending", Column: "count", Alias: "txn_count_pending"},
+	{View: "statistic_txn_fee_1_d", Column: "avg", Alias: "txn_fee_1_d"},
+	{View: "statistic_total_supply", Column: "sum", Alias: "total_supply"},
+	{View: "statistic_post_count", Column: "count", Alias: "post_count"},
+	{View: "statistic_post_longform_count", Column: "count", Alias: "post_longform_count"},
+	{View: "statistic_comment_count", Column: "count", Alias: "comment_count"},
+	{View: "statistic_repost_count", Column: "count", Alias: "repost_count"},
+	{View: "statistic_txn_count_creator_coin", Column: "count", Alias: "txn_count_creator_coin"},
+	{View: "statistic_txn_count_nft", Column: "count", Alias: "txn_count_nft"},
+	{View: "statistic_txn_count_dex", Column: "count", Alias: "txn_count_dex"},
+	{View: "statistic_txn_count_social", Column: "count", Alias: "txn_count_social"},
+	{View: "statistic_follow_count", Column: "count", Alias: "follow_count"},
+	{View: "statistic_message_count", Column: "count", Alias: "message_count"},
+}
+
+// buildStatisticDashboardView returns the query that recreates statistic_dashboard with the base statistics
+// followed by the given additional statistics. Each migration passes the full list of additional statistics
+// that should exist once it has run, so that its down migration can rebuild the previous dashboard.
+func buildStatisticDashboardView(additionalStatistics ...dashboardStatistic) string {
+	statistics := append(append([]dashboardStatistic{}, baseDashboardStatistics...), additionalStatistics...)
+
+	var columns, views []string
+	seenViews := make(map[string]bool)
+	for _, statistic := range statistics {
+		columns = append(columns, fmt.Sprintf("%s.%s as %s", statistic.View, statistic.Column, statistic.Alias))
+		if !seenViews[statistic.View] {
+			seenViews[statistic.View] = true
+			views = append(views, statistic.View)
+		}
+	}
+
+	return fmt.Sprintf(`
+			DROP VIEW IF EXISTS statistic_dashboard;
+			CREATE VIEW statistic_dashboard AS
+			SELECT
+				%s
+			FROM
+			%s;
+			comment on view statistic_dashboard is E'@name dashboardStat';
+`, strings.Join(columns, ",\n\t\t\t\t"), strings.Join(views, "\n\t\t\tCROSS JOIN\n\t\t\t"))
+}